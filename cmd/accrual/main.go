@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/accrualsystem"
+	"github.com/JSchatten/go-diploma/internal/config"
+	gzipMiddleaware "github.com/JSchatten/go-diploma/internal/gzip"
+	loggingMiddleware "github.com/JSchatten/go-diploma/internal/logging"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	logZero "github.com/rs/zerolog/log"
+)
+
+func main() {
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	logZero.Logger = logZero.Output(zerolog.ConsoleWriter{Out: log.Writer()})
+
+	cfg, err := config.InitAccrualFlags()
+	if err != nil {
+		logZero.Logger.Fatal().Err(err).Msg("Failed to init Accrual Flags")
+	}
+
+	// инициализация хранилища: без DATABASE_URI — в памяти
+	var store accrualsystem.Store
+	if cfg.DatabaseURI != "" {
+		ctxDB, cancelDB := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelDB()
+
+		store, err = accrualsystem.NewPSQLStore(ctxDB, cfg.DatabaseURI)
+		if err != nil {
+			logZero.Logger.Fatal().Err(err).Msg("Failed to connect to database")
+		}
+		logZero.Logger.Info().Msg("Database connected")
+	} else {
+		store = accrualsystem.NewMemoryStore()
+		logZero.Logger.Info().Msg("Using in-memory storage")
+	}
+	defer store.Close()
+
+	// gin
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.Use(gin.LoggerWithConfig(gin.LoggerConfig{
+		Output: io.Discard,
+	}))
+
+	router.Use(loggingMiddleware.LoggingMiddleware(logZero.Logger))
+	router.Use(gzipMiddleaware.GzipMiddleware())
+
+	accrualsystem.NewHandlers(store).Register(router, cfg.RequestsPerMinute)
+
+	srv := &http.Server{
+		Addr:    cfg.RunAddress,
+		Handler: router,
+	}
+
+	ctxApp, cancelApp := context.WithCancel(context.Background())
+	defer cancelApp()
+
+	g, ctxApp := errgroup.WithContext(ctxApp)
+
+	g.Go(func() error {
+		logZero.Logger.Info().Msgf("Accrual starting at %s", cfg.RunAddress)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logZero.Logger.Fatal().Err(err).Msg("Server failed to start")
+			return err
+		}
+		return nil
+	})
+
+	g.Go(func() error {
+		return accrualsystem.NewProcessor(store, cfg.ProcessingDelay).Run(ctxApp)
+	})
+
+	// Перехват сигналов завершения
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	logZero.Logger.Info().Msg("Shutting down...")
+	cancelApp()
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		logZero.Logger.Error().Err(err).Msg("Server shutdown failed")
+	}
+
+	// ждём обработчик заказов; отмена контекста при остановке — не ошибка
+	if err := g.Wait(); err != nil && !errors.Is(err, context.Canceled) {
+		logZero.Logger.Error().Err(err).Msg("Accrual stopped with error")
+	}
+
+	logZero.Logger.Info().Msg("Accrual exited gracefully")
+}
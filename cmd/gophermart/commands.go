@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/accrual"
+	"github.com/JSchatten/go-diploma/internal/archive"
+	"github.com/JSchatten/go-diploma/internal/config"
+	"github.com/JSchatten/go-diploma/internal/outbox"
+	"github.com/JSchatten/go-diploma/internal/storage"
+	logZero "github.com/rs/zerolog/log"
+)
+
+// runCommand выполняет команду обслуживания вместо запуска сервера
+func runCommand(name string, args []string) error {
+	switch name {
+	case "reconcile":
+		return runReconcile(args)
+	case "verify-balances":
+		return runVerifyBalances(args)
+	case "migrate":
+		return runMigrate(args)
+	case "replay-events":
+		return runReplayEvents(args)
+	case "archive":
+		return runArchive(args)
+	case "admin":
+		return runAdmin(args)
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
+
+// runReconcile сверяет начисления PROCESSED с accrual и пишет JSON-отчёт.
+// Прерывается по SIGINT/SIGTERM, уже найденные расхождения попадают в отчёт.
+func runReconcile(args []string) error {
+	cfg, err := config.InitReconcileFlags(args)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	ctxDB, cancelDB := context.WithTimeout(ctx, 5*time.Second)
+	defer cancelDB()
+
+	store, err := storage.NewStorage(ctxDB, cfg.DatabaseURI)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	reconciler, err := accrual.NewReconciler(store, accrual.Options{
+		Default: accrual.ProviderOptions{
+			Name:      accrual.DefaultProviderName,
+			URL:       cfg.AccrualSystemAddr,
+			RateLimit: cfg.RateLimit,
+		},
+		Providers: accrualProviders(cfg.AccrualProviders),
+		Routes:    accrualRoutes(cfg.AccrualRoutes),
+		BatchSize: cfg.BatchSize,
+	})
+	if err != nil {
+		return err
+	}
+	defer reconciler.Close()
+
+	report, runErr := reconciler.Run(ctx, cfg.Apply)
+	if err := writeReport(cfg.Output, report); err != nil {
+		return err
+	}
+
+	logZero.Logger.Info().
+		Int("checked", report.Checked).
+		Int("discrepancies", len(report.Discrepancies)).
+		Int("failures", len(report.Failures)).
+		Msg("Reconciliation finished")
+	return runErr
+}
+
+// runVerifyBalances сверяет user_balances с операциями по балансу и пишет JSON-отчёт.
+// Есть расхождения — команда завершается с ошибкой.
+func runVerifyBalances(args []string) error {
+	cfg, err := config.InitCommandFlags("verify-balances", args)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	ctxDB, cancelDB := context.WithTimeout(ctx, 5*time.Second)
+	defer cancelDB()
+
+	store, err := storage.NewStorage(ctxDB, cfg.DatabaseURI)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	drifts, err := store.VerifyBalances(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := writeReport(cfg.Output, drifts); err != nil {
+		return err
+	}
+
+	if len(drifts) > 0 {
+		return fmt.Errorf("%d user balances drifted from ledger", len(drifts))
+	}
+	logZero.Logger.Info().Msg("User balances match ledger")
+	return nil
+}
+
+// runAdmin выдаёт или снимает права администратора:
+// gophermart admin [-d DATABASE_URI] grant | revoke LOGIN...
+func runAdmin(args []string) error {
+	cfg, err := config.InitCommandFlags("admin", args)
+	if err != nil {
+		return err
+	}
+	if len(cfg.Args) < 2 {
+		return fmt.Errorf("usage: gophermart admin [-d DATABASE_URI] grant | revoke LOGIN...")
+	}
+
+	action, logins := cfg.Args[0], cfg.Args[1:]
+	var isAdmin bool
+	switch action {
+	case "grant":
+		isAdmin = true
+	case "revoke":
+		isAdmin = false
+	default:
+		return fmt.Errorf("unknown admin action %q", action)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	store, err := storage.NewStorage(ctx, cfg.DatabaseURI)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	for _, login := range logins {
+		if err := store.SetAdmin(ctx, login, isAdmin); err != nil {
+			return fmt.Errorf("%s admin %q: %w", action, login, err)
+		}
+		logZero.Logger.Info().Str("action", action).Str("login", login).Msg("Admin rights updated")
+	}
+	return nil
+}
+
+// schemaVersion вывод gophermart migrate version
+type schemaVersion struct {
+	Version uint `json:"version"`
+	Dirty   bool `json:"dirty"`
+	Latest  uint `json:"latest"` // последняя миграция в бинарнике
+}
+
+// runMigrate управляет схемой встроенными миграциями:
+// gophermart migrate [-d DATABASE_URI] up | down [N] | goto N | version | force N
+func runMigrate(args []string) error {
+	cfg, err := config.InitCommandFlags("migrate", args)
+	if err != nil {
+		return err
+	}
+	if len(cfg.Args) == 0 {
+		return fmt.Errorf("usage: gophermart migrate [-d DATABASE_URI] up | down [N] | goto N | version | force N")
+	}
+
+	m, err := storage.NewMigrations(cfg.DatabaseURI)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	action, params := cfg.Args[0], cfg.Args[1:]
+	switch action {
+	case "up":
+		err = m.Up()
+	case "down":
+		steps := 1
+		if len(params) > 0 {
+			steps, err = strconv.Atoi(params[0])
+			if err != nil || steps <= 0 {
+				return fmt.Errorf("migrate down: invalid number of steps %q", params[0])
+			}
+		}
+		err = m.Down(steps)
+	case "goto":
+		if len(params) != 1 {
+			return fmt.Errorf("usage: gophermart migrate goto N")
+		}
+		version, perr := strconv.ParseUint(params[0], 10, 0)
+		if perr != nil {
+			return fmt.Errorf("migrate goto: invalid version %q", params[0])
+		}
+		err = m.Goto(uint(version))
+	case "force":
+		if len(params) != 1 {
+			return fmt.Errorf("usage: gophermart migrate force N")
+		}
+		version, perr := strconv.Atoi(params[0])
+		if perr != nil || version < -1 {
+			return fmt.Errorf("migrate force: invalid version %q", params[0])
+		}
+		err = m.Force(version)
+	case "version":
+		version, dirty, err := m.Version()
+		if err != nil {
+			return err
+		}
+		return writeReport(cfg.Output, schemaVersion{Version: version, Dirty: dirty, Latest: m.Latest()})
+	default:
+		return fmt.Errorf("unknown migrate action %q", action)
+	}
+	if err != nil {
+		return err
+	}
+
+	version, dirty, err := m.Version()
+	if err != nil {
+		return err
+	}
+	logZero.Logger.Info().
+		Str("action", action).
+		Uint("version", version).
+		Bool("dirty", dirty).
+		Uint("latest", m.Latest()).
+		Msg("Migration done")
+	return nil
+}
+
+// runReplayEvents повторно доставляет получателю события outbox из диапазона id,
+// например после потери данных на его стороне. Смещения relay не меняются.
+func runReplayEvents(args []string) error {
+	cfg, err := config.InitReplayFlags(args)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	ctxDB, cancelDB := context.WithTimeout(ctx, 5*time.Second)
+	defer cancelDB()
+
+	store, err := storage.NewStorage(ctxDB, cfg.DatabaseURI)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	sink, err := outbox.NewSink(cfg.Sink)
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+
+	delivered, err := outbox.Replay(ctx, store, sink, cfg.From, cfg.To, cfg.BatchSize)
+	logZero.Logger.Info().
+		Str("sink", sink.Name()).
+		Int64("from", cfg.From).
+		Int64("to", cfg.To).
+		Int("delivered", delivered).
+		Msg("Events replayed")
+	return err
+}
+
+// runArchive выгружает месяцы журнала раньше -before в сжатые файлы и убирает их
+// из журнала, итоги месяцев остаются входящими остатками. Пишет JSON-отчёт
+// об архивированных месяцах, в том числе когда архивация прервалась на ошибке.
+func runArchive(args []string) error {
+	cfg, err := config.InitArchiveFlags(args)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	ctxDB, cancelDB := context.WithTimeout(ctx, 5*time.Second)
+	defer cancelDB()
+
+	store, err := storage.NewStorage(ctxDB, cfg.DatabaseURI)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	archived, runErr := archive.NewArchiver(store, cfg.Dir, cfg.BatchSize).Run(ctx, cfg.Before)
+	if err := writeReport(cfg.Output, archived); err != nil {
+		return err
+	}
+
+	logZero.Logger.Info().
+		Str("before", cfg.Before.Format("2006-01")).
+		Int("months", len(archived)).
+		Msg("Ledger archive finished")
+	return runErr
+}
+
+// writeReport пишет отчёт команды в JSON в файл или stdout
+func writeReport(path string, report any) error {
+	var out io.Writer = os.Stdout
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
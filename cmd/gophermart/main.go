@@ -2,21 +2,28 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"log"
 
 	"github.com/JSchatten/go-diploma/internal/accrual"
+	"github.com/JSchatten/go-diploma/internal/archive"
+	"github.com/JSchatten/go-diploma/internal/audit"
 	"github.com/JSchatten/go-diploma/internal/auth"
 	"github.com/JSchatten/go-diploma/internal/config"
 	gzipMiddleaware "github.com/JSchatten/go-diploma/internal/gzip"
 	"github.com/JSchatten/go-diploma/internal/handlers"
+	"github.com/JSchatten/go-diploma/internal/idempotency"
 	loggingMiddleware "github.com/JSchatten/go-diploma/internal/logging"
+	"github.com/JSchatten/go-diploma/internal/outbox"
 	"github.com/JSchatten/go-diploma/internal/service"
 	"github.com/JSchatten/go-diploma/internal/storage"
 	"golang.org/x/sync/errgroup"
@@ -30,6 +37,14 @@ func main() {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	logZero.Logger = logZero.Output(zerolog.ConsoleWriter{Out: log.Writer()})
 
+	// Команды обслуживания: gophermart <команда> [флаги]
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			logZero.Logger.Fatal().Err(err).Str("command", os.Args[1]).Msg("Command failed")
+		}
+		return
+	}
+
 	cfg, err := config.InitServerFlags()
 	if err != nil {
 		logZero.Logger.Fatal().Err(err).Msg("Failed to init Server Flags")
@@ -39,24 +54,65 @@ func main() {
 	ctxDB, cancelDB := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancelDB()
 
-	store, err := storage.NewPSQLStorage(ctxDB, cfg.DatabaseURI)
-	if err != nil {
-		logZero.Logger.Fatal().Err(err).Msg("Failed to connect to database")
+	var store storage.Storage
+	if cfg.MemoryStorage {
+		store = storage.NewMemoryStorage()
+		logZero.Logger.Warn().Msg("Using in-memory storage, data will be lost on exit")
+	} else {
+		store, err = storage.NewStorage(ctxDB, cfg.DatabaseURI)
+		if err != nil {
+			logZero.Logger.Fatal().Err(err).Msg("Failed to connect to database")
+		}
+
+		logZero.Logger.Info().Msg("Database connected")
+
+		if err := prepareSchema(ctxDB, store, cfg); err != nil {
+			logZero.Logger.Fatal().Err(err).Str("mode", cfg.MigrateMode).Msg("Database schema is not ready")
+		}
 	}
 	defer store.Close()
 
-	err = store.Migrate(ctxDB)
-	if err != nil {
-		logZero.Logger.Fatal().Err(err).Msg("Failed to connect to database")
+	// администраторы из настроек; незарегистрированные получат права при регистрации
+	if err := grantAdmins(ctxDB, store, cfg.AdminLogins); err != nil {
+		logZero.Logger.Fatal().Err(err).Msg("Failed to grant admin rights")
 	}
-	logZero.Logger.Info().Msg("Database connected")
+
+	// журнал аудита входов, операций с баллами и статусов заказов
+	auditLog := audit.NewRecorder(store)
 
 	// После инициализации store poller для accrual
-	accrualClient := accrual.NewClient(cfg.AccrualSystemAddr, store)
+	accrualPoller, err := accrual.NewPoller(store, accrual.Options{
+		Workers: cfg.PollWorkers,
+		Default: accrual.ProviderOptions{
+			Name:      accrual.DefaultProviderName,
+			URL:       cfg.AccrualSystemAddr,
+			RateLimit: cfg.PollRateLimit,
+
+			BreakerThreshold:   cfg.BreakerThreshold,
+			BreakerOpenTimeout: cfg.BreakerOpenTimeout,
+		},
+		Providers: accrualProviders(cfg.AccrualProviders),
+		Routes:    accrualRoutes(cfg.AccrualRoutes),
+
+		StuckAfter: cfg.StuckAfter,
+
+		Audit: auditLog,
+	})
+	if err != nil {
+		logZero.Logger.Fatal().Err(err).Msg("Failed to configure accrual providers")
+	}
+
+	// доставка доменных событий из outbox, без получателей события только копятся в БД
+	sinks, err := outbox.NewSinks(cfg.OutboxSinks)
+	if err != nil {
+		logZero.Logger.Fatal().Err(err).Msg("Failed to configure outbox sinks")
+	}
+	relay := outbox.NewRelay(store, sinks, 0)
+	defer relay.Close()
 
 	//
-	balanceService := service.NewBalanceService(store)
-	orderService := service.NewOrderService(store)
+	balanceService := service.NewBalanceService(store, auditLog)
+	orderService := service.NewOrderService(store, auditLog)
 
 	// gin
 	gin.SetMode(gin.ReleaseMode)
@@ -67,28 +123,51 @@ func main() {
 
 	router.RedirectFixedPath = false
 
+	router.Use(audit.Middleware())
 	router.Use(loggingMiddleware.LoggingMiddleware(logZero.Logger))
 	router.Use(gzipMiddleaware.GzipMiddleware())
 
-	authHandlers := auth.NewAuthHandlers(store, cfg.JwtKey)
+	authHandlers := auth.NewAuthHandlers(store, cfg.JwtKey, auditLog, cfg.AdminLogins)
+	idempotencyKeys := idempotency.New(store, cfg.IdempotencyTTL)
 
 	// public routes
 	router.GET("/", handlers.Hello())
 	router.GET("/live", handlers.Hello())
+	router.GET("/health", handlers.HealthHandler(accrualPoller))
 	router.POST("/api/user/register", authHandlers.RegisterHandler)
 	router.POST("/api/user/login", authHandlers.LoginHandler)
 
+	// push-уведомления accrual, опрос остаётся для заказов без push
+	var verifier *accrual.WebhookVerifier
+	if cfg.WebhookSecret != "" {
+		verifier = accrual.NewWebhookVerifier(cfg.WebhookSecret, store)
+		router.POST("/api/accrual/webhook", handlers.AccrualWebhookHandler(accrualPoller, verifier))
+	}
+
 	// protected routes
 	authorized := router.Group("/")
 	authorized.Use(authHandlers.AuthMiddleware)
 	{
 		authorized.GET("/protected", handlers.Hello())
-		authorized.POST("/api/user/orders", handlers.AddOrderHandler(orderService))
+		// ретраи клиента с тем же Idempotency-Key получают первый ответ
+		authorized.POST("/api/user/orders", idempotencyKeys.Middleware(), handlers.AddOrderHandler(orderService))
 		authorized.GET("/api/user/orders", handlers.GetOrdersHandler(orderService))
 		authorized.GET("/api/user/balance", handlers.GetBalanceHandler(balanceService))
-		authorized.POST("/api/user/balance/withdraw", handlers.WithdrawHandler(balanceService))
+		authorized.POST("/api/user/balance/withdraw", idempotencyKeys.Middleware(), handlers.WithdrawHandler(balanceService))
 		// GetWithdrawalsHandler простой, логика там минимальная и я бы оставил, но раз начали
 		authorized.GET("/api/user/withdrawals", handlers.GetWithdrawalsHandler(balanceService))
+		authorized.GET("/api/user/operations", handlers.GetOperationsHandler(balanceService))
+	}
+
+	// admin routes
+	admin := router.Group("/api/admin")
+	admin.Use(authHandlers.AuthMiddleware, authHandlers.AdminMiddleware)
+	{
+		admin.GET("/orders/stuck", handlers.GetStuckOrdersHandler(orderService))
+		admin.POST("/orders/:number/requeue", handlers.RequeueOrderHandler(orderService))
+		admin.POST("/orders/:number/status", handlers.ForceOrderStatusHandler(orderService))
+		admin.GET("/audit", handlers.GetAuditEventsHandler(auditLog))
+		admin.GET("/audit/verify", handlers.VerifyAuditChainHandler(auditLog))
 	}
 
 	// Запуск сервера в отдельной горутине
@@ -117,10 +196,32 @@ func main() {
 
 	// После  запуска сервера — запускаем poller
 	g.Go(func() error {
-		return accrualClient.StartPolling(ctxApp)
+		return accrualPoller.StartPolling(ctxApp)
 	})
 	logZero.Logger.Info().Msg("Poling accrual started")
 
+	if verifier != nil {
+		g.Go(func() error {
+			return verifier.StartCleanup(ctxApp)
+		})
+	}
+
+	g.Go(func() error {
+		return idempotencyKeys.StartCleanup(ctxApp)
+	})
+
+	// партиции журнала на месяцы вперёд
+	g.Go(func() error {
+		return archive.StartMaintenance(ctxApp, store)
+	})
+
+	if len(sinks) > 0 {
+		g.Go(func() error {
+			return relay.Start(ctxApp)
+		})
+		logZero.Logger.Info().Int("sinks", len(sinks)).Msg("Outbox relay started")
+	}
+
 	// // Перехват сигналов завершения
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -135,3 +236,66 @@ func main() {
 	logZero.Logger.Info().Msg("Application exited gracefully")
 
 }
+
+// prepareSchema готовит схему БД по режиму -migrate: применяет миграции или только
+// проверяет, что схема совпадает с бинарником
+func prepareSchema(ctx context.Context, store storage.Storage, cfg *config.ServerFlags) error {
+	switch cfg.MigrateMode {
+	case config.MigrateAuto:
+		return store.Migrate(ctx)
+	case config.MigrateRequire:
+		m, err := storage.NewMigrations(cfg.DatabaseURI)
+		if err != nil {
+			return err
+		}
+		defer m.Close()
+		return m.Check()
+	default:
+		logZero.Logger.Warn().Msg("Schema migrations skipped")
+		return nil
+	}
+}
+
+// grantAdmins выдаёт права администратора логинам из настроек. Пользователя ещё нет —
+// права выдаст регистрация, см. auth.NewAuthHandlers
+func grantAdmins(ctx context.Context, store storage.Storage, logins []string) error {
+	for _, login := range logins {
+		err := store.SetAdmin(ctx, login, true)
+		if errors.Is(err, storage.ErrUserNotFound) {
+			logZero.Logger.Info().Str("login", login).Msg("Admin is not registered yet, rights will be granted on registration")
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("grant admin %q: %w", login, err)
+		}
+		logZero.Logger.Info().Str("login", login).Msg("Admin rights granted")
+	}
+	return nil
+}
+
+// accrualProviders настройки дополнительных провайдеров из файла конфигурации
+func accrualProviders(cfgs []config.AccrualProviderConfig) []accrual.ProviderOptions {
+	opts := make([]accrual.ProviderOptions, 0, len(cfgs))
+	for _, p := range cfgs {
+		opts = append(opts, accrual.ProviderOptions{
+			Name:      p.Name,
+			Type:      p.Type,
+			URL:       p.URL,
+			Timeout:   time.Duration(p.Timeout),
+			RateLimit: p.RateLimit,
+
+			BreakerThreshold:   p.BreakerThreshold,
+			BreakerOpenTimeout: time.Duration(p.BreakerTimeout),
+		})
+	}
+	return opts
+}
+
+// accrualRoutes маршруты заказов к провайдерам из файла конфигурации
+func accrualRoutes(cfgs []config.AccrualRouteConfig) []accrual.Route {
+	routes := make([]accrual.Route, 0, len(cfgs))
+	for _, r := range cfgs {
+		routes = append(routes, accrual.Route{Prefix: r.Prefix, Length: r.Length, Provider: r.Provider})
+	}
+	return routes
+}
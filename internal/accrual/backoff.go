@@ -0,0 +1,26 @@
+package accrual
+
+import "time"
+
+const (
+	// BackoffBase задержка перед повторным опросом после первой попытки
+	BackoffBase = PollInterval
+	// BackoffMax верхняя граница задержки между опросами одного заказа
+	BackoffMax = 10 * time.Minute
+)
+
+// nextPollDelay экспоненциальная задержка: base * 2^(attempts-1), не больше BackoffMax
+func nextPollDelay(attempts int) time.Duration {
+	if attempts <= 0 {
+		return 0
+	}
+
+	d := BackoffBase
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= BackoffMax {
+			return BackoffMax
+		}
+	}
+	return d
+}
@@ -0,0 +1,27 @@
+package accrual
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextPollDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{0, 0},
+		{1, BackoffBase},
+		{2, 2 * BackoffBase},
+		{4, 8 * BackoffBase},
+		{10, 512 * BackoffBase},
+		{11, BackoffMax},
+		{1000, BackoffMax},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, nextPollDelay(tt.attempts), "attempts: %d", tt.attempts)
+	}
+}
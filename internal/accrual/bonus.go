@@ -0,0 +1,90 @@
+package accrual
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+	"github.com/go-resty/resty/v2"
+)
+
+// BonusProviderType партнёрское API бонусов: GET /v1/bonuses/{number}
+const BonusProviderType = "bonus"
+
+// BonusClient провайдер партнёрского API бонусов. Ответ:
+//
+//	{"order_id": "...", "state": "completed", "bonus_kopecks": 50000}
+//
+// Сумма приходит целым числом копеек, неизвестный заказ — 404.
+type BonusClient struct {
+	name   string
+	client *resty.Client
+	url    string
+}
+
+func NewBonusClient(opts ProviderOptions) *BonusClient {
+	return &BonusClient{
+		name:   opts.Name,
+		client: newHTTPClient(opts.Timeout),
+		url:    opts.URL,
+	}
+}
+
+func (c *BonusClient) Name() string {
+	return c.name
+}
+
+func (c *BonusClient) Close() {}
+
+// FetchOrderStatus запрашивает бонус по заказу
+func (c *BonusClient) FetchOrderStatus(ctx context.Context, orderNumber string) (models.Status, models.Money, error) {
+	url := fmt.Sprintf("%s/v1/bonuses/%s", c.url, orderNumber)
+
+	var response struct {
+		OrderID      string `json:"order_id"`
+		State        string `json:"state"`
+		BonusKopecks int64  `json:"bonus_kopecks"`
+	}
+
+	resp, err := c.client.R().
+		SetContext(ctx).
+		SetResult(&response).
+		Get(url)
+	if err := checkResponse(resp, err); err != nil {
+		return "", 0, err
+	}
+
+	if resp.StatusCode() == http.StatusNotFound {
+		return "", 0, ErrNotRegistered
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return "", 0, fmt.Errorf("unexpected status: %d", resp.StatusCode())
+	}
+
+	status, err := parseBonusState(response.State)
+	if err != nil {
+		return "", 0, err
+	}
+	if response.BonusKopecks < 0 {
+		return "", 0, fmt.Errorf("negative bonus from %s: %d", c.name, response.BonusKopecks)
+	}
+
+	return status, models.Money(response.BonusKopecks), nil
+}
+
+// parseBonusState переводит состояние бонуса партнёра в статус заказа
+func parseBonusState(s string) (models.Status, error) {
+	switch s {
+	case "accepted":
+		return models.NewStatus, nil
+	case "calculating":
+		return models.ProcessingStatus, nil
+	case "rejected":
+		return models.InvalidStatus, nil
+	case "completed":
+		return models.ProcessedStatus, nil
+	default:
+		return "", fmt.Errorf("invalid bonus state: %s", s)
+	}
+}
@@ -0,0 +1,99 @@
+package accrual
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBonusClient_FetchOrderStatus(t *testing.T) {
+	// заглушка партнёра: состояние бонуса по номеру заказа
+	bonuses := map[string]string{
+		"100": `{"order_id":"100","state":"accepted","bonus_kopecks":0}`,
+		"101": `{"order_id":"101","state":"calculating","bonus_kopecks":0}`,
+		"102": `{"order_id":"102","state":"rejected","bonus_kopecks":0}`,
+		"103": `{"order_id":"103","state":"completed","bonus_kopecks":50075}`,
+		"104": `{"order_id":"104","state":"lost","bonus_kopecks":0}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		number, ok := strings.CutPrefix(r.URL.Path, "/v1/bonuses/")
+		body, known := bonuses[number]
+		if !ok || !known {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	p, err := NewProvider(ProviderOptions{Name: "partner", Type: BonusProviderType, URL: srv.URL})
+	require.NoError(t, err)
+	defer p.Close()
+
+	tests := []struct {
+		order   string
+		status  models.Status
+		accrual models.Money
+	}{
+		{"100", models.NewStatus, 0},
+		{"101", models.ProcessingStatus, 0},
+		{"102", models.InvalidStatus, 0},
+		{"103", models.ProcessedStatus, 50075},
+	}
+	for _, tt := range tests {
+		status, accrual, err := p.FetchOrderStatus(context.Background(), tt.order)
+		require.NoError(t, err, "order: %s", tt.order)
+		assert.Equal(t, tt.status, status, "order: %s", tt.order)
+		assert.Equal(t, tt.accrual, accrual, "order: %s", tt.order)
+	}
+
+	_, _, err = p.FetchOrderStatus(context.Background(), "104")
+	assert.Error(t, err, "unknown state")
+
+	_, _, err = p.FetchOrderStatus(context.Background(), "999")
+	assert.ErrorIs(t, err, ErrNotRegistered)
+}
+
+func TestGuardedProvider_BreakerForAnyProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	for _, typ := range []string{DefaultProviderType, BonusProviderType} {
+		t.Run(typ, func(t *testing.T) {
+			p := Guard(newTypedClient(t, typ, srv.URL), ProviderOptions{BreakerThreshold: 1})
+			defer p.Close()
+
+			_, _, err := p.FetchOrderStatus(context.Background(), "100")
+			require.Error(t, err)
+			assert.NotErrorIs(t, err, ErrCircuitOpen)
+			assert.Equal(t, BreakerOpen, p.BreakerState())
+
+			_, _, err = p.FetchOrderStatus(context.Background(), "100")
+			assert.ErrorIs(t, err, ErrCircuitOpen)
+		})
+	}
+}
+
+// newTypedClient провайдер без обёртки с одной попыткой запроса
+func newTypedClient(t *testing.T, typ, url string) Provider {
+	opts := ProviderOptions{Name: typ, Type: typ, URL: url}
+	p := providerFactories[typ](opts)
+	switch c := p.(type) {
+	case *Client:
+		c.client.SetRetryCount(0)
+	case *BonusClient:
+		c.client.SetRetryCount(0)
+	default:
+		t.Fatalf("unexpected provider %T", p)
+	}
+	return p
+}
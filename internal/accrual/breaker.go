@@ -0,0 +1,136 @@
+package accrual
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultBreakerThreshold подряд неудачных запросов, после которых breaker размыкается
+	DefaultBreakerThreshold = 5
+	// DefaultBreakerOpenTimeout сколько breaker разомкнут до пробного запроса
+	DefaultBreakerOpenTimeout = 30 * time.Second
+)
+
+// ErrCircuitOpen accrual недоступен, запросы временно не отправляются
+var ErrCircuitOpen = errors.New("accrual circuit breaker is open")
+
+// BreakerState состояние circuit breaker
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // запросы идут как обычно
+	BreakerOpen                         // accrual считается недоступным
+	BreakerHalfOpen                     // пропускаем один пробный запрос
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// breaker circuit breaker вокруг HTTP-клиента accrual
+type breaker struct {
+	provider    string
+	mu          sync.Mutex
+	threshold   int
+	openTimeout time.Duration
+
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool // пробный запрос в half-open уже выполняется
+}
+
+func newBreaker(provider string, threshold int, openTimeout time.Duration) *breaker {
+	if threshold <= 0 {
+		threshold = DefaultBreakerThreshold
+	}
+	if openTimeout <= 0 {
+		openTimeout = DefaultBreakerOpenTimeout
+	}
+	return &breaker{provider: provider, threshold: threshold, openTimeout: openTimeout}
+}
+
+// allow можно ли сейчас отправить запрос.
+// По истечении openTimeout breaker переходит в half-open и пропускает один пробный запрос.
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerClosed:
+		return true
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < b.openTimeout {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		log.Info().Str("provider", b.provider).Msg("Accrual circuit breaker half-open, probing")
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return false
+}
+
+// ready разомкнутый breaker ещё не готов к пробному запросу — опрос можно пропустить
+func (b *breaker) ready(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state != BreakerOpen || now.Sub(b.openedAt) >= b.openTimeout
+}
+
+func (b *breaker) onSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != BreakerClosed {
+		log.Info().Str("provider", b.provider).Msg("Accrual circuit breaker closed")
+	}
+	b.state = BreakerClosed
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *breaker) onFailure(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		if b.state != BreakerOpen {
+			log.Warn().
+				Str("provider", b.provider).
+				Int("failures", b.failures).
+				Dur("open_timeout", b.openTimeout).
+				Msg("Accrual circuit breaker opened")
+		}
+		b.state = BreakerOpen
+		b.openedAt = now
+		b.probing = false
+	}
+}
+
+func (b *breaker) currentState() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
@@ -0,0 +1,55 @@
+package accrual
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker_OpensAfterThreshold(t *testing.T) {
+	b := newBreaker("test", 3, time.Minute)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		assert.True(t, b.allow(now))
+		b.onFailure(now)
+	}
+	assert.Equal(t, BreakerClosed, b.currentState())
+
+	// успех сбрасывает счётчик
+	b.onSuccess()
+	for i := 0; i < 3; i++ {
+		assert.True(t, b.allow(now))
+		b.onFailure(now)
+	}
+	assert.Equal(t, BreakerOpen, b.currentState())
+	assert.False(t, b.allow(now.Add(30*time.Second)))
+	assert.False(t, b.ready(now.Add(30*time.Second)))
+}
+
+func TestBreaker_HalfOpenProbe(t *testing.T) {
+	b := newBreaker("test", 1, time.Minute)
+	now := time.Now()
+
+	b.onFailure(now)
+	assert.Equal(t, BreakerOpen, b.currentState())
+
+	later := now.Add(time.Minute)
+	assert.True(t, b.ready(later))
+	assert.True(t, b.allow(later), "first probe must pass")
+	assert.Equal(t, BreakerHalfOpen, b.currentState())
+	assert.False(t, b.allow(later), "only one probe at a time")
+
+	// проба не удалась — снова open
+	b.onFailure(later)
+	assert.Equal(t, BreakerOpen, b.currentState())
+	assert.False(t, b.allow(later.Add(time.Second)))
+
+	// проба удалась — closed
+	evenLater := later.Add(time.Minute)
+	assert.True(t, b.allow(evenLater))
+	b.onSuccess()
+	assert.Equal(t, BreakerClosed, b.currentState())
+	assert.True(t, b.allow(evenLater))
+}
@@ -0,0 +1,147 @@
+package accrual
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+	"github.com/go-resty/resty/v2"
+)
+
+// HTTPTimeout таймаут запроса к accrual по умолчанию
+const HTTPTimeout = 5 * time.Second
+
+// ErrNotRegistered заказ не зарегистрирован в системе расчёта (ответ 204)
+var ErrNotRegistered = errors.New("order is not registered in accrual system")
+
+// Client провайдер для API accrual из SPECIFICATION.md: GET /api/orders/{number}.
+// Лимит запросов, пауза по 429 и circuit breaker — в GuardedProvider.
+type Client struct {
+	name       string
+	client     *resty.Client
+	accrualURL string
+}
+
+func NewClient(opts ProviderOptions) *Client {
+	if opts.Name == "" {
+		opts.Name = DefaultProviderName
+	}
+	return &Client{
+		name:       opts.Name,
+		client:     newHTTPClient(opts.Timeout),
+		accrualURL: opts.URL,
+	}
+}
+
+// newHTTPClient HTTP-клиент провайдера: повторяет сетевые ошибки и 5xx, но не 429
+func newHTTPClient(timeout time.Duration) *resty.Client {
+	if timeout <= 0 {
+		timeout = HTTPTimeout
+	}
+
+	client := resty.New()
+	client.SetTimeout(timeout)
+	client.SetRetryCount(3)
+	client.SetRetryWaitTime(1 * time.Second)
+	client.AddRetryCondition(func(r *resty.Response, err error) bool {
+		// 429 не повторяем: его обрабатывает throttle, иначе нас банят
+		return r.StatusCode() >= 500 || // Server error
+			err != nil // Network error
+	})
+	return client
+}
+
+// checkResponse общая для HTTP-провайдеров обработка ошибки запроса, 429 и 5xx
+func checkResponse(resp *resty.Response, err error) error {
+	if err != nil {
+		return &unavailableError{fmt.Errorf("request failed: %w", err)}
+	}
+	if resp.StatusCode() == http.StatusTooManyRequests {
+		wait, ok := parseRetryAfter(resp.Header().Get("Retry-After"), time.Now())
+		if !ok {
+			wait = DefaultRetryAfter
+		}
+		return &retryAfterError{wait: wait}
+	}
+	if resp.StatusCode() >= 500 {
+		return &unavailableError{fmt.Errorf("unexpected status: %d", resp.StatusCode())}
+	}
+	return nil
+}
+
+func (c *Client) Name() string {
+	return c.name
+}
+
+func (c *Client) Close() {}
+
+// FetchOrderStatus запрашивает статус заказа у accrual
+func (c *Client) FetchOrderStatus(ctx context.Context, orderNumber string) (models.Status, models.Money, error) {
+	url := fmt.Sprintf("%s/api/orders/%s", c.accrualURL, orderNumber)
+
+	var response struct {
+		Order   string      `json:"order"`
+		Status  string      `json:"status"`
+		Accrual json.Number `json:"accrual,omitempty"`
+	}
+
+	resp, err := c.client.R().
+		SetContext(ctx).
+		SetResult(&response).
+		Get(url)
+	if err := checkResponse(resp, err); err != nil {
+		return "", 0, err
+	}
+
+	if resp.StatusCode() == http.StatusNoContent {
+		return "", 0, ErrNotRegistered
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return "", 0, fmt.Errorf("unexpected status: %d", resp.StatusCode())
+	}
+
+	status, err := ParseStatus(response.Status)
+	if err != nil {
+		return "", 0, err
+	}
+
+	accrual, err := ParseAccrual(response.Accrual)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return status, accrual, nil
+}
+
+// ParseAccrual сумма начисления от accrual. Расчёт у accrual может дать больше
+// двух знаков после точки — округляем до копейки, половину от нуля.
+func ParseAccrual(n json.Number) (models.Money, error) {
+	if n == "" {
+		return 0, nil
+	}
+	m, err := models.ParseMoneyRounded(n.String())
+	if err != nil {
+		return 0, fmt.Errorf("invalid accrual from accrual system: %w", err)
+	}
+	return m, nil
+}
+
+// ParseStatus переводит статус accrual в статус заказа
+func ParseStatus(s string) (models.Status, error) {
+	status := models.Status(s)
+	// REGISTERED у accrual — это наш NEW: расчёт ещё не начат
+	if s == "REGISTERED" {
+		status = models.NewStatus
+	}
+
+	switch status {
+	case models.NewStatus, models.ProcessingStatus, models.InvalidStatus, models.ProcessedStatus:
+		return status, nil
+	default:
+		return "", fmt.Errorf("invalid status from accrual: %s", s)
+	}
+}
@@ -0,0 +1,105 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+)
+
+// unavailableError провайдер не ответил или ответил 5xx — отказ для circuit breaker
+type unavailableError struct {
+	err error
+}
+
+func (e *unavailableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *unavailableError) Unwrap() error {
+	return e.err
+}
+
+// retryAfterError провайдер попросил подождать (429), wait — сколько
+type retryAfterError struct {
+	wait time.Duration
+}
+
+func (e *retryAfterError) Error() string {
+	return ErrRateLimited.Error()
+}
+
+func (e *retryAfterError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// GuardedProvider обёртка над Provider с лимитом запросов, паузой по 429 и circuit breaker.
+// Одна на все реализации: провайдер только сообщает об отказе (unavailableError)
+// или просьбе подождать (retryAfterError), а решает, слать ли запросы, обёртка.
+type GuardedProvider struct {
+	provider Provider
+	throttle throttle
+	breaker  *breaker
+	limiter  *rateLimiter
+}
+
+// Guard оборачивает провайдер; берёт из opts настройки лимита и breaker
+func Guard(p Provider, opts ProviderOptions) *GuardedProvider {
+	return &GuardedProvider{
+		provider: p,
+		throttle: throttle{provider: p.Name()},
+		breaker:  newBreaker(p.Name(), opts.BreakerThreshold, opts.BreakerOpenTimeout),
+		limiter:  newRateLimiter(opts.RateLimit),
+	}
+}
+
+func (g *GuardedProvider) Name() string {
+	return g.provider.Name()
+}
+
+// Ready не на паузе после 429 и breaker готов пропустить запрос
+func (g *GuardedProvider) Ready(now time.Time) bool {
+	return g.throttle.remaining(now) == 0 && g.breaker.ready(now)
+}
+
+// BreakerState состояние circuit breaker, для health check
+func (g *GuardedProvider) BreakerState() BreakerState {
+	return g.breaker.currentState()
+}
+
+func (g *GuardedProvider) Close() {
+	g.limiter.stop()
+	g.provider.Close()
+}
+
+// FetchOrderStatus ждёт лимит запросов и запрашивает статус через circuit breaker.
+// Отказы провайдера размыкают breaker, остальные ответы считаются успехом.
+func (g *GuardedProvider) FetchOrderStatus(ctx context.Context, orderNumber string) (models.Status, models.Money, error) {
+	if err := g.limiter.wait(ctx); err != nil {
+		return "", 0, err
+	}
+	// пока ждали лимитер, провайдер мог попросить паузу
+	if g.throttle.remaining(time.Now()) > 0 {
+		return "", 0, ErrRateLimited
+	}
+	if !g.breaker.allow(time.Now()) {
+		return "", 0, ErrCircuitOpen
+	}
+
+	status, accrual, err := g.provider.FetchOrderStatus(ctx, orderNumber)
+
+	var unavailable *unavailableError
+	if errors.As(err, &unavailable) {
+		g.breaker.onFailure(time.Now())
+		return "", 0, unavailable.err
+	}
+	g.breaker.onSuccess()
+
+	var retry *retryAfterError
+	if errors.As(err, &retry) {
+		g.throttle.suspend(time.Now(), retry.wait)
+		return "", 0, ErrRateLimited
+	}
+	return status, accrual, err
+}
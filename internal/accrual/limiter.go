@@ -0,0 +1,38 @@
+package accrual
+
+import (
+	"context"
+	"time"
+)
+
+// rateLimiter общий для всех воркеров бюджет запросов в секунду
+type rateLimiter struct {
+	ticker *time.Ticker
+}
+
+// newRateLimiter rps <= 0 — без ограничения
+func newRateLimiter(rps int) *rateLimiter {
+	if rps <= 0 {
+		return &rateLimiter{}
+	}
+	return &rateLimiter{ticker: time.NewTicker(time.Second / time.Duration(rps))}
+}
+
+// wait блокирует до следующего разрешённого запроса
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l.ticker == nil {
+		return ctx.Err()
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-l.ticker.C:
+		return nil
+	}
+}
+
+func (l *rateLimiter) stop() {
+	if l.ticker != nil {
+		l.ticker.Stop()
+	}
+}
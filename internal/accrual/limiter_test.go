@@ -0,0 +1,47 @@
+package accrual
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter_Spacing(t *testing.T) {
+	const rps = 50
+	l := newRateLimiter(rps)
+	defer l.stop()
+
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		require.NoError(t, l.wait(ctx))
+	}
+	// 5 запросов при 50 rps — не раньше чем через 100 мс. Отдельные интервалы
+	// не проверяются: после запоздавшего тика следующий приходит раньше.
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 90*time.Millisecond)
+	assert.Less(t, elapsed, 500*time.Millisecond)
+}
+
+func TestRateLimiter_Unlimited(t *testing.T) {
+	l := newRateLimiter(0)
+	defer l.stop()
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, l.wait(context.Background()))
+	}
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestRateLimiter_Cancel(t *testing.T) {
+	l := newRateLimiter(1)
+	defer l.stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, l.wait(ctx), context.DeadlineExceeded)
+}
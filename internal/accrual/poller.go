@@ -2,142 +2,357 @@ package accrual
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"os"
+	"sync"
 	"time"
 
+	"github.com/JSchatten/go-diploma/internal/audit"
 	"github.com/JSchatten/go-diploma/internal/models"
 	"github.com/JSchatten/go-diploma/internal/storage"
-	"github.com/go-resty/resty/v2"
 	"github.com/rs/zerolog/log"
 )
 
 const (
-	PollInterval = 1 * time.Second
-	HTTPTimeout  = 5 * time.Second
+	PollInterval   = 1 * time.Second
+	DefaultWorkers = 4
+
+	DefaultBatchSize = 100
+	// DefaultLeaseTTL аренда пачки заказов; должна покрывать время её обработки
+	DefaultLeaseTTL = 1 * time.Minute
+
+	// StuckCheckInterval как часто искать зависшие заказы
+	StuckCheckInterval = 1 * time.Minute
+
+	// исполнители смены статуса в журнале аудита
+	pollerActor  = "accrual-poller"
+	webhookActor = "accrual-webhook"
 )
 
-type Client struct {
-	client     *resty.Client
-	accrualURL string
-	storage    storage.Storage
+// Options настройки опроса accrual
+type Options struct {
+	Workers int // количество воркеров на всех провайдеров, <= 0 — DefaultWorkers
+
+	Default   ProviderOptions   // провайдер для заказов без подходящего маршрута
+	Providers []ProviderOptions // дополнительные провайдеры
+	Routes    []Route           // выбор провайдера по номеру заказа, проверяются по порядку
+
+	BatchSize int           // сколько заказов брать в аренду за тик, <= 0 — DefaultBatchSize
+	LeaseTTL  time.Duration // срок аренды заказов, <= 0 — DefaultLeaseTTL
+
+	StuckAfter time.Duration // без окончательного статуса дольше — заказ завис, <= 0 — не проверять
+
+	Audit *audit.Recorder // журнал аудита смены статусов, nil — не писать
+}
+
+// Poller опрашивает провайдеров начислений по незавершённым заказам
+type Poller struct {
+	storage storage.Storage
+	router  *Router
+	opts    Options
+	// идентификатор экземпляра для аренды заказов
+	instanceID string
+
+	// заказы, которые сейчас в очереди или у воркера
+	inFlight sync.Map
 }
 
-func NewClient(accrualURL string, store storage.Storage) *Client {
-	client := resty.New()
-	client.SetTimeout(HTTPTimeout)
-	client.SetRetryCount(3)
-	client.SetRetryWaitTime(1 * time.Second)
-	client.AddRetryCondition(func(r *resty.Response, err error) bool {
-		return r.StatusCode() == 429 || // Too Many Requests
-			r.StatusCode() >= 500 || // Server error
-			err != nil // Network error
-	})
-
-	return &Client{
-		client:     client,
-		accrualURL: accrualURL,
+func NewPoller(store storage.Storage, opts Options) (*Poller, error) {
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultWorkers
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.LeaseTTL <= 0 {
+		opts.LeaseTTL = DefaultLeaseTTL
+	}
+
+	router, err := newRouterFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Poller{
 		storage:    store,
+		router:     router,
+		opts:       opts,
+		instanceID: newInstanceID(),
+	}, nil
+}
+
+// newRouterFromOptions создаёт провайдеров и маршрутизатор по настройкам
+func newRouterFromOptions(opts Options) (*Router, error) {
+	if opts.Default.Name == "" {
+		opts.Default.Name = DefaultProviderName
 	}
+
+	fallback, err := NewProvider(opts.Default)
+	if err != nil {
+		return nil, err
+	}
+	providers := make([]*GuardedProvider, 0, len(opts.Providers))
+	for _, po := range opts.Providers {
+		p, err := NewProvider(po)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+
+	return NewRouter(fallback, providers, opts.Routes)
+}
+
+// newInstanceID hostname-pid-случайный суффикс: уникален даже для реплик с одинаковым hostname
+func newInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// BreakerStates состояние circuit breaker каждого провайдера, для health check
+func (p *Poller) BreakerStates() map[string]BreakerState {
+	return p.router.BreakerStates()
 }
 
 // StartPolling запускает фоновый опрос статусов
-func (c *Client) StartPolling(ctx context.Context) error {
-	log.Info().Msg("Starting accrual status poller")
-
-	// ticker := time.NewTicker(PollInterval)
-	// defer ticker.Stop()
-
-	// for {
-	// 	select {
-	// 	case <-ctx.Done():
-	// 		log.Info().Msg("Shutting down accrual poller")
-	// 		return
-	// 	case <-ticker.C:
-	// 		c.pollOrders()
-	// 	}
-	// }
+func (p *Poller) StartPolling(ctx context.Context) error {
+	log.Info().
+		Str("instance", p.instanceID).
+		Int("workers", p.opts.Workers).
+		Int("providers", len(p.opts.Providers)+1).
+		Int("routes", len(p.opts.Routes)).
+		Msg("Starting accrual status poller")
+
+	defer p.router.Close()
+
+	// статусы, найденные опросом, в журнале аудита от имени poller
+	ctx = audit.WithActor(ctx, 0, pollerActor)
+
+	jobs := make(chan *models.BalanceOperation)
+	var wg sync.WaitGroup
+	for i := 0; i < p.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.worker(ctx, jobs)
+		}()
+	}
+	defer wg.Wait()
+	defer close(jobs)
+
 	ticker := time.NewTicker(PollInterval)
 	defer ticker.Stop()
 
+	// без проверки зависших канал остаётся nil и никогда не срабатывает
+	var stuckC <-chan time.Time
+	if p.opts.StuckAfter > 0 {
+		stuckTicker := time.NewTicker(StuckCheckInterval)
+		defer stuckTicker.Stop()
+		stuckC = stuckTicker.C
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
+		case <-stuckC:
+			p.markStuckOrders(ctx)
 		case <-ticker.C:
-			c.pollOrders()
+			// все провайдеры на паузе или с разомкнутым breaker — заказы в аренду не берём
+			if !p.router.AnyReady(time.Now()) {
+				log.Debug().Msg("No accrual provider is ready, skip tick")
+				continue
+			}
+			p.pollOrders(ctx, jobs)
 		}
 	}
 
 }
 
-// pollOrders находит заказы со статусом NEW и проверяет их у accrual
-func (c *Client) pollOrders() {
-	orders, err := c.storage.GetNewOrders(context.Background())
+// pollOrders берёт в аренду пачку незавершённых заказов (NEW, PROCESSING) и раздаёт их воркерам.
+// Заказ, который ещё в работе с прошлого тика, повторно не ставится.
+func (p *Poller) pollOrders(ctx context.Context, jobs chan<- *models.BalanceOperation) {
+	orders, err := p.storage.LeasePendingOrders(ctx, p.instanceID, p.opts.BatchSize, p.opts.LeaseTTL)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to get new orders")
+		log.Error().Err(err).Msg("Failed to get pending orders")
 		return
 	}
 
-	for _, order := range orders {
-		status, accrualAmount, err := c.fetchOrderStatus(order.OrderNumber)
-		if err != nil {
-			log.Warn().Err(err).Str("order", order.OrderNumber).Msg("Failed to fetch order status")
+	for i, order := range orders {
+		// провайдер заказа мог уйти на паузу, пока раздавали заказы: аренду отдаём,
+		// чтобы заказ не ждал её истечения
+		if !p.router.Route(order.OrderNumber).Ready(time.Now()) {
+			p.releaseLease(ctx, order)
 			continue
 		}
 
-		if err := c.storage.UpdateOrderStatus(
-			context.Background(),
-			order.OrderNumber,
-			status,
-			accrualAmount,
-		); err != nil {
-			log.Error().Err(err).Str("order", order.OrderNumber).Msg("Failed to update order status")
+		if _, busy := p.inFlight.LoadOrStore(order.OrderNumber, struct{}{}); busy {
 			continue
 		}
 
-		log.Info().
-			Str("order", order.OrderNumber).
-			Str("status", string(status)).
-			Float64("accrual", accrualAmount).
-			Msg("Order status updated")
+		select {
+		case <-ctx.Done():
+			p.inFlight.Delete(order.OrderNumber)
+			// остановка: нерозданные заказы сразу доступны другим экземплярам
+			for _, rest := range orders[i:] {
+				if _, busy := p.inFlight.Load(rest.OrderNumber); !busy {
+					p.releaseLease(ctx, rest)
+				}
+			}
+			return
+		case jobs <- order:
+		}
 	}
 }
 
-// fetchOrderStatus запрашивает статус у accrual-сервиса
-func (c *Client) fetchOrderStatus(orderNumber string) (models.Status, float64, error) {
-	url := fmt.Sprintf("%s/api/orders/%s", c.accrualURL, orderNumber)
+// markStuckOrders помечает заказы без окончательного статуса дольше StuckAfter,
+// дальше они не опрашиваются до ручного вмешательства
+func (p *Poller) markStuckOrders(ctx context.Context) {
+	orders, err := p.storage.MarkStuckOrders(ctx, p.opts.StuckAfter)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to mark stuck orders")
+		return
+	}
+
+	for _, order := range orders {
+		p.opts.Audit.Record(ctx, audit.Entry{
+			Action:    models.AuditOrderStuck,
+			SubjectID: order.UserID,
+			Subject:   order.OrderNumber,
+			After:     audit.OrderState{Status: order.Status, Reason: order.StuckReason},
+		})
+		log.Warn().
+			Str("order", order.OrderNumber).
+			Int64("user_id", order.UserID).
+			Str("status", string(order.Status)).
+			Time("uploaded_at", order.CreatedAt).
+			Int("poll_attempts", order.PollAttempts).
+			Str("last_error", order.LastPollError).
+			Msg("Order is stuck, polling stopped")
+	}
+}
 
-	var response struct {
-		Order   string  `json:"order"`
-		Status  string  `json:"status"`
-		Accrual float64 `json:"accrual,omitempty"`
+// worker опрашивает провайдеров по заказам из очереди
+func (p *Poller) worker(ctx context.Context, jobs <-chan *models.BalanceOperation) {
+	for order := range jobs {
+		p.processOrder(ctx, order)
+		p.inFlight.Delete(order.OrderNumber)
 	}
+}
 
-	resp, err := c.client.R().
-		SetResult(&response).
-		Get(url)
+// processOrder запрашивает статус одного заказа у его провайдера и сохраняет его
+func (p *Poller) processOrder(ctx context.Context, order *models.BalanceOperation) {
+	provider := p.router.Route(order.OrderNumber)
 
+	status, accrualAmount, err := provider.FetchOrderStatus(ctx, order.OrderNumber)
+	if errors.Is(err, ErrNotRegistered) {
+		// заказ может попасть в accrual позже загрузки к нам
+		status, err = models.NewStatus, nil
+	}
+	if errors.Is(err, ErrRateLimited) || errors.Is(err, ErrCircuitOpen) || ctx.Err() != nil {
+		// 429 и недоступный провайдер — общая пауза, а не проблема заказа: попытку не считаем,
+		// но аренду отдаём
+		p.releaseLease(ctx, order)
+		return
+	}
 	if err != nil {
-		return "", 0, fmt.Errorf("request failed: %w", err)
+		log.Warn().Err(err).Str("provider", provider.Name()).Str("order", order.OrderNumber).Msg("Failed to fetch order status")
+		p.schedulePoll(ctx, order, err)
+		return
 	}
 
-	// Обработка статусов
-	if resp.StatusCode() == 204 {
-		return models.NewStatus, 0, nil
+	if err := p.applyStatus(ctx, order, status, accrualAmount); err != nil {
+		p.schedulePoll(ctx, order, err)
+		return
 	}
 
-	if resp.StatusCode() != 200 {
-		return "", 0, fmt.Errorf("unexpected status: %d", resp.StatusCode())
+	// расчёт ещё идёт — следующий опрос с увеличенной задержкой
+	if !status.IsFinal() {
+		p.schedulePoll(ctx, order, nil)
 	}
+}
+
+// ApplyStatus сохраняет статус заказа, полученный push-уведомлением accrual
+func (p *Poller) ApplyStatus(ctx context.Context, orderNumber string, status models.Status, accrualAmount models.Money) error {
+	order := &models.BalanceOperation{OrderNumber: orderNumber}
+	if p.opts.Audit != nil {
+		// прежний статус и владелец для журнала аудита; нет заказа — ошибку вернёт обновление
+		if stored, err := p.storage.GetOrder(ctx, orderNumber); err == nil {
+			order = stored
+		}
+	}
+	return p.applyStatus(audit.WithActor(ctx, 0, webhookActor), order, status, accrualAmount)
+}
 
-	status := models.Status(response.Status)
+// applyStatus сохраняет статус заказа, полученный от accrual опросом или push-уведомлением.
+// Смена статуса пишется в журнал аудита, повтор того же статуса — нет.
+func (p *Poller) applyStatus(ctx context.Context, order *models.BalanceOperation, status models.Status, accrualAmount models.Money) error {
+	orderNumber := order.OrderNumber
+	if err := p.storage.UpdateOrderStatus(ctx, orderNumber, status, accrualAmount); err != nil {
+		if errors.Is(err, storage.ErrIllegalTransition) {
+			log.Warn().Err(err).Str("order", orderNumber).Msg("Rejected order status transition")
+		} else {
+			log.Error().Err(err).Str("order", orderNumber).Msg("Failed to update order status")
+		}
+		return err
+	}
+
+	log.Info().
+		Str("order", orderNumber).
+		Str("status", string(status)).
+		Str("accrual", accrualAmount.String()).
+		Msg("Order status updated")
 
-	switch status {
-	case models.NewStatus, models.ProcessingStatus, models.InvalidStatus, models.ProcessedStatus:
-		// OK
-	default:
-		return "", 0, fmt.Errorf("invalid status from accrual: %s", status)
+	if order.Status != status {
+		after := audit.OrderState{Status: status}
+		if status == models.ProcessedStatus {
+			after.Accrual = accrualAmount
+		}
+		p.opts.Audit.Record(ctx, audit.Entry{
+			Action:    models.AuditOrderStatusChanged,
+			SubjectID: order.UserID,
+			Subject:   orderNumber,
+			Before:    audit.OrderState{Status: order.Status},
+			After:     after,
+		})
+	}
+	return nil
+}
+
+// releaseLease снимает аренду заказа без попытки опроса. Работает и после отмены ctx:
+// при остановке аренда тоже должна освободиться.
+func (p *Poller) releaseLease(ctx context.Context, order *models.BalanceOperation) {
+	if err := p.storage.ReleaseOrderLease(context.WithoutCancel(ctx), order.OrderNumber, p.instanceID); err != nil {
+		log.Error().Err(err).Str("order", order.OrderNumber).Msg("Failed to release order lease")
+	}
+}
+
+// schedulePoll откладывает следующий опрос заказа по экспоненциальной задержке
+func (p *Poller) schedulePoll(ctx context.Context, order *models.BalanceOperation, pollErr error) {
+	attempts := order.PollAttempts + 1
+	next := time.Now().Add(nextPollDelay(attempts))
+
+	var lastErr string
+	if pollErr != nil {
+		lastErr = pollErr.Error()
+	}
+
+	if err := p.storage.SchedulePoll(ctx, order.OrderNumber, next, lastErr); err != nil {
+		log.Error().Err(err).Str("order", order.OrderNumber).Msg("Failed to schedule next poll")
+		return
 	}
 
-	return status, response.Accrual, nil
+	log.Debug().
+		Str("order", order.OrderNumber).
+		Int("attempts", attempts).
+		Time("next_poll_at", next).
+		Msg("Next poll scheduled")
 }
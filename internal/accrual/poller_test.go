@@ -0,0 +1,284 @@
+package accrual
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/audit"
+	"github.com/JSchatten/go-diploma/internal/models"
+	"github.com/JSchatten/go-diploma/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoller_ApplyStatusAudit(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	userID, err := store.SaveUser(ctx, "user", "hash")
+	require.NoError(t, err)
+	for _, number := range []string{"12345678903", "9278923470"} {
+		require.NoError(t, store.UploadOrder(ctx, &models.BalanceOperation{
+			UserID:      userID,
+			OrderNumber: number,
+			Status:      models.NewStatus,
+			ProcessedAt: time.Now(),
+		}))
+	}
+
+	poller, err := NewPoller(store, Options{
+		Default: ProviderOptions{Name: DefaultProviderName, URL: "http://localhost:8081"},
+		Audit:   audit.NewRecorder(store),
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, poller.ApplyStatus(ctx, "12345678903", models.ProcessedStatus, 50000))
+	// повтор того же статуса в журнал не попадает
+	require.NoError(t, poller.ApplyStatus(ctx, "9278923470", models.ProcessingStatus, 0))
+	require.NoError(t, poller.ApplyStatus(ctx, "9278923470", models.ProcessingStatus, 0))
+	assert.ErrorIs(t, poller.ApplyStatus(ctx, "346436439", models.ProcessedStatus, 100), storage.ErrOrderNotFound)
+
+	events, err := store.GetAuditEvents(ctx, models.AuditFilter{UserID: userID})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+
+	e := events[0]
+	assert.Equal(t, models.AuditOrderStatusChanged, e.Action)
+	assert.Equal(t, webhookActor, e.Actor)
+	assert.Zero(t, e.ActorID)
+	assert.Equal(t, "12345678903", e.Subject)
+	assert.JSONEq(t, `{"status":"NEW"}`, string(e.Before))
+	assert.JSONEq(t, `{"status":"PROCESSED","accrual":500}`, string(e.After))
+
+	assert.Equal(t, "9278923470", events[1].Subject)
+	assert.JSONEq(t, `{"status":"PROCESSING"}`, string(events[1].After))
+}
+
+// leaseAllStorage отдаёт в аренду все незавершённые заказы на каждом тике, как будто
+// аренда уже истекла: повторную постановку должен отсечь сам Poller
+type leaseAllStorage struct {
+	*storage.MemoryStorage
+	orders []*models.BalanceOperation
+}
+
+func (s *leaseAllStorage) LeasePendingOrders(context.Context, string, int, time.Duration) ([]*models.BalanceOperation, error) {
+	orders := make([]*models.BalanceOperation, 0, len(s.orders))
+	for _, o := range s.orders {
+		order := *o
+		orders = append(orders, &order)
+	}
+	return orders, nil
+}
+
+// blockingProvider считает запросы и держит каждый до release
+type blockingProvider struct {
+	release chan struct{}
+
+	mu        sync.Mutex
+	calls     map[string]int
+	active    int
+	maxActive int
+}
+
+func newBlockingProvider() *blockingProvider {
+	return &blockingProvider{release: make(chan struct{}), calls: make(map[string]int)}
+}
+
+func (p *blockingProvider) Name() string { return DefaultProviderName }
+func (p *blockingProvider) Close()       {}
+
+func (p *blockingProvider) FetchOrderStatus(ctx context.Context, orderNumber string) (models.Status, models.Money, error) {
+	p.mu.Lock()
+	p.calls[orderNumber]++
+	p.active++
+	p.maxActive = max(p.maxActive, p.active)
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		p.active--
+		p.mu.Unlock()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return "", 0, ctx.Err()
+	case <-p.release:
+		return models.ProcessingStatus, 0, nil
+	}
+}
+
+func (p *blockingProvider) stats() (calls map[string]int, active, maxActive int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return maps.Clone(p.calls), p.active, p.maxActive
+}
+
+// newTestPoller Poller с workers воркерами поверх заказов numbers
+func newTestPoller(t *testing.T, provider Provider, workers int, numbers ...string) *Poller {
+	t.Helper()
+	ctx := context.Background()
+	store := &leaseAllStorage{MemoryStorage: storage.NewMemoryStorage()}
+	userID, err := store.SaveUser(ctx, "user", "hash")
+	require.NoError(t, err)
+	for _, number := range numbers {
+		order := &models.BalanceOperation{UserID: userID, OrderNumber: number, Status: models.NewStatus, ProcessedAt: time.Now()}
+		require.NoError(t, store.UploadOrder(ctx, order))
+		store.orders = append(store.orders, order)
+	}
+
+	router, err := NewRouter(Guard(provider, ProviderOptions{}), nil, nil)
+	require.NoError(t, err)
+	return &Poller{
+		storage:    store,
+		router:     router,
+		opts:       Options{Workers: workers, BatchSize: DefaultBatchSize, LeaseTTL: DefaultLeaseTTL},
+		instanceID: "test",
+	}
+}
+
+// startWorkers запускает воркеры Poller; stop закрывает очередь и ждёт их
+func startWorkers(ctx context.Context, p *Poller) (jobs chan *models.BalanceOperation, stop func()) {
+	jobs = make(chan *models.BalanceOperation)
+	var wg sync.WaitGroup
+	for i := 0; i < p.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.worker(ctx, jobs)
+		}()
+	}
+	return jobs, func() {
+		close(jobs)
+		wg.Wait()
+	}
+}
+
+func TestPoller_InFlightSkipsQueuedOrders(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	provider := newBlockingProvider()
+	poller := newTestPoller(t, provider, 2, "12345678903")
+	jobs, stop := startWorkers(ctx, poller)
+	defer stop()
+
+	poller.pollOrders(ctx, jobs)
+	require.Eventually(t, func() bool {
+		_, active, _ := provider.stats()
+		return active == 1
+	}, time.Second, time.Millisecond)
+
+	// заказ ещё у воркера: следующие тики его не ставят, хотя свободный воркер есть
+	for i := 0; i < 3; i++ {
+		poller.pollOrders(ctx, jobs)
+	}
+	time.Sleep(20 * time.Millisecond)
+	calls, _, _ := provider.stats()
+	assert.Equal(t, 1, calls["12345678903"])
+
+	// воркер закончил — заказ снова можно ставить
+	provider.release <- struct{}{}
+	require.Eventually(t, func() bool {
+		_, busy := poller.inFlight.Load("12345678903")
+		return !busy
+	}, time.Second, time.Millisecond)
+
+	poller.pollOrders(ctx, jobs)
+	require.Eventually(t, func() bool {
+		calls, _, _ := provider.stats()
+		return calls["12345678903"] == 2
+	}, time.Second, time.Millisecond)
+	provider.release <- struct{}{}
+}
+
+func TestPoller_WorkersLimitConcurrency(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	const workers = 3
+	numbers := []string{"12345678903", "9278923470", "346436439", "2377225624", "4561261212", "79927398713", "49927398716"}
+	provider := newBlockingProvider()
+	poller := newTestPoller(t, provider, workers, numbers...)
+	jobs, stop := startWorkers(ctx, poller)
+
+	// pollOrders раздаёт заказы по мере освобождения воркеров
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		poller.pollOrders(ctx, jobs)
+	}()
+
+	// пока заказы есть, заняты все воркеры, но не больше
+	for released := 0; released < len(numbers); released++ {
+		want := min(workers, len(numbers)-released)
+		require.Eventually(t, func() bool {
+			_, active, _ := provider.stats()
+			return active == want
+		}, time.Second, time.Millisecond)
+		provider.release <- struct{}{}
+	}
+	<-done
+	stop()
+
+	calls, active, maxActive := provider.stats()
+	assert.Len(t, calls, len(numbers))
+	assert.Zero(t, active)
+	assert.Equal(t, workers, maxActive)
+}
+
+// pausedProvider на любой запрос отвечает 429
+type pausedProvider struct{}
+
+func (p *pausedProvider) Name() string { return DefaultProviderName }
+func (p *pausedProvider) Close()       {}
+
+func (p *pausedProvider) FetchOrderStatus(context.Context, string) (models.Status, models.Money, error) {
+	return "", 0, &retryAfterError{wait: time.Minute}
+}
+
+func TestPoller_ReleasesLeaseWithoutPolling(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		paused bool
+	}{
+		{"provider paused after lease", true},
+		{"rate limited on fetch", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			store := storage.NewMemoryStorage()
+			userID, err := store.SaveUser(ctx, "user", "hash")
+			require.NoError(t, err)
+			require.NoError(t, store.UploadOrder(ctx, &models.BalanceOperation{
+				UserID: userID, OrderNumber: "12345678903", Status: models.NewStatus, ProcessedAt: time.Now(),
+			}))
+
+			provider := Guard(&pausedProvider{}, ProviderOptions{})
+			if tt.paused {
+				provider.throttle.suspend(time.Now(), time.Minute)
+			}
+			router, err := NewRouter(provider, nil, nil)
+			require.NoError(t, err)
+			poller := &Poller{
+				storage:    store,
+				router:     router,
+				opts:       Options{Workers: 1, BatchSize: DefaultBatchSize, LeaseTTL: DefaultLeaseTTL},
+				instanceID: "test",
+			}
+			jobs, stop := startWorkers(ctx, poller)
+			poller.pollOrders(ctx, jobs)
+			stop()
+
+			// аренда снята сразу, попытка опроса не засчитана
+			leased, err := store.LeasePendingOrders(ctx, "other", 10, time.Minute)
+			require.NoError(t, err)
+			require.Len(t, leased, 1)
+			assert.Zero(t, leased[0].PollAttempts)
+		})
+	}
+}
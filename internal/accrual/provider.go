@@ -0,0 +1,149 @@
+package accrual
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+)
+
+const (
+	// DefaultProviderName провайдер из ACCRUAL_SYSTEM_ADDRESS, получает заказы без подходящего маршрута
+	DefaultProviderName = "default"
+	// DefaultProviderType API accrual из SPECIFICATION.md
+	DefaultProviderType = "accrual"
+)
+
+// Provider система расчёта начислений одного партнёра
+type Provider interface {
+	Name() string
+	// FetchOrderStatus статус заказа. ErrRateLimited и ErrCircuitOpen — провайдер
+	// временно недоступен, попытка опроса заказу не засчитывается.
+	// ErrNotRegistered — провайдер заказ ещё не знает.
+	//
+	// Реализация не считает отказы и не держит паузу сама: сбой сети и 5xx она
+	// возвращает как unavailableError, 429 — как retryAfterError, остальное
+	// делает GuardedProvider.
+	FetchOrderStatus(ctx context.Context, orderNumber string) (models.Status, models.Money, error)
+	Close()
+}
+
+// ProviderOptions настройки одного провайдера
+type ProviderOptions struct {
+	Name string
+	Type string // реализация, см. providerFactories; пусто — DefaultProviderType
+	URL  string
+
+	Timeout   time.Duration // таймаут запроса, <= 0 — HTTPTimeout
+	RateLimit int           // лимит запросов в секунду, <= 0 — без ограничения
+
+	BreakerThreshold   int           // неудач подряд до размыкания, <= 0 — DefaultBreakerThreshold
+	BreakerOpenTimeout time.Duration // время в open до пробы, <= 0 — DefaultBreakerOpenTimeout
+}
+
+// providerFactories реализации провайдеров по типу; партнёр с другим форматом
+// ответа добавляется сюда своей реализацией Provider
+var providerFactories = map[string]func(ProviderOptions) Provider{
+	DefaultProviderType: func(opts ProviderOptions) Provider { return NewClient(opts) },
+	BonusProviderType:   func(opts ProviderOptions) Provider { return NewBonusClient(opts) },
+}
+
+// NewProvider создаёт провайдер по типу и оборачивает его в GuardedProvider
+func NewProvider(opts ProviderOptions) (*GuardedProvider, error) {
+	if opts.Type == "" {
+		opts.Type = DefaultProviderType
+	}
+	factory, ok := providerFactories[opts.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown accrual provider type %q", opts.Type)
+	}
+	if opts.URL == "" {
+		return nil, fmt.Errorf("accrual provider %q: empty URL", opts.Name)
+	}
+	return Guard(factory(opts), opts), nil
+}
+
+// Route правило выбора провайдера по номеру заказа.
+// Заданные условия должны выполняться все: префикс и/или длина номера.
+type Route struct {
+	Prefix   string
+	Length   int
+	Provider string
+}
+
+func (r Route) matches(orderNumber string) bool {
+	if r.Prefix != "" && !strings.HasPrefix(orderNumber, r.Prefix) {
+		return false
+	}
+	if r.Length > 0 && len(orderNumber) != r.Length {
+		return false
+	}
+	return true
+}
+
+// Router выбирает провайдера по первому подходящему маршруту, иначе — провайдер по умолчанию
+type Router struct {
+	routes    []Route
+	providers map[string]*GuardedProvider
+	fallback  *GuardedProvider
+}
+
+func NewRouter(fallback *GuardedProvider, providers []*GuardedProvider, routes []Route) (*Router, error) {
+	r := &Router{
+		routes:    routes,
+		providers: map[string]*GuardedProvider{fallback.Name(): fallback},
+		fallback:  fallback,
+	}
+	for _, p := range providers {
+		if _, dup := r.providers[p.Name()]; dup {
+			return nil, fmt.Errorf("duplicate accrual provider %q", p.Name())
+		}
+		r.providers[p.Name()] = p
+	}
+	for _, route := range routes {
+		if route.Prefix == "" && route.Length <= 0 {
+			return nil, fmt.Errorf("route to %q: prefix or length required", route.Provider)
+		}
+		if _, ok := r.providers[route.Provider]; !ok {
+			return nil, fmt.Errorf("route to unknown accrual provider %q", route.Provider)
+		}
+	}
+	return r, nil
+}
+
+// Route провайдер для заказа
+func (r *Router) Route(orderNumber string) *GuardedProvider {
+	for _, route := range r.routes {
+		if route.matches(orderNumber) {
+			return r.providers[route.Provider]
+		}
+	}
+	return r.fallback
+}
+
+// AnyReady готов ли хоть один провайдер
+func (r *Router) AnyReady(now time.Time) bool {
+	for _, p := range r.providers {
+		if p.Ready(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// BreakerStates состояние circuit breaker каждого провайдера
+func (r *Router) BreakerStates() map[string]BreakerState {
+	states := make(map[string]BreakerState, len(r.providers))
+	for name, p := range r.providers {
+		states[name] = p.BreakerState()
+	}
+	return states
+}
+
+func (r *Router) Close() {
+	for _, p := range r.providers {
+		p.Close()
+	}
+}
@@ -0,0 +1,60 @@
+package accrual
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter_Route(t *testing.T) {
+	def := newTestProvider(ProviderOptions{Name: DefaultProviderName, URL: "http://default"})
+	partner := newTestProvider(ProviderOptions{Name: "partner", URL: "http://partner"})
+	short := newTestProvider(ProviderOptions{Name: "short", URL: "http://short"})
+
+	r, err := NewRouter(def, []*GuardedProvider{partner, short}, []Route{
+		{Prefix: "99", Length: 12, Provider: "partner"},
+		{Length: 8, Provider: "short"},
+	})
+	require.NoError(t, err)
+	defer r.Close()
+
+	tests := []struct {
+		order    string
+		expected string
+	}{
+		{"990000000001", "partner"},
+		{"99000000000", DefaultProviderName}, // префикс подходит, длина нет
+		{"12345678", "short"},
+		{"99123456", "short"},
+		{"12345678903", DefaultProviderName},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, r.Route(tt.order).Name(), "order: %s", tt.order)
+	}
+
+	assert.Len(t, r.BreakerStates(), 3)
+}
+
+func TestNewRouter_Errors(t *testing.T) {
+	def := newTestProvider(ProviderOptions{URL: "http://default"})
+	defer def.Close()
+
+	_, err := NewRouter(def, nil, []Route{{Prefix: "1", Provider: "missing"}})
+	assert.Error(t, err)
+
+	_, err = NewRouter(def, nil, []Route{{Provider: DefaultProviderName}})
+	assert.Error(t, err, "route without conditions")
+
+	_, err = NewRouter(def, []*GuardedProvider{newTestProvider(ProviderOptions{URL: "http://other"})}, nil)
+	assert.Error(t, err, "duplicate default name")
+
+	_, err = NewProvider(ProviderOptions{Name: "x", Type: "unknown", URL: "http://x"})
+	assert.Error(t, err)
+}
+
+// newTestProvider провайдер API accrual в обёртке, как его создаёт NewProvider
+func newTestProvider(opts ProviderOptions) *GuardedProvider {
+	return Guard(NewClient(opts), opts)
+}
@@ -0,0 +1,224 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+	"github.com/JSchatten/go-diploma/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+// DiscrepancyKind вид расхождения с accrual
+type DiscrepancyKind string
+
+const (
+	// DiscrepancyMissing accrual не знает заказ, у нас он PROCESSED
+	DiscrepancyMissing DiscrepancyKind = "missing"
+	// DiscrepancyStatus у accrual статус не PROCESSED
+	DiscrepancyStatus DiscrepancyKind = "status_mismatch"
+	// DiscrepancyAmount оба PROCESSED, начисления различаются
+	DiscrepancyAmount DiscrepancyKind = "amount_mismatch"
+)
+
+// Discrepancy расхождение по одному заказу
+type Discrepancy struct {
+	Kind         DiscrepancyKind `json:"kind"`
+	OperationID  int64           `json:"operation_id"`
+	Order        string          `json:"order"`
+	UserID       int64           `json:"user_id"`
+	Provider     string          `json:"provider"`
+	LocalStatus  models.Status   `json:"local_status"`
+	RemoteStatus models.Status   `json:"remote_status,omitempty"`
+	LocalAmount  models.Money    `json:"local_amount"`
+	RemoteAmount models.Money    `json:"remote_amount"`
+	// Corrected записана корректирующая операция на RemoteAmount - LocalAmount
+	Corrected bool   `json:"corrected"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ReconcileFailure заказ, который не удалось сверить
+type ReconcileFailure struct {
+	Order    string `json:"order"`
+	Provider string `json:"provider"`
+	Error    string `json:"error"`
+}
+
+// ReconcileReport результат сверки, сериализуется в JSON
+type ReconcileReport struct {
+	StartedAt     time.Time          `json:"started_at"`
+	FinishedAt    time.Time          `json:"finished_at"`
+	Apply         bool               `json:"apply"`
+	Checked       int                `json:"checked"`
+	Discrepancies []Discrepancy      `json:"discrepancies"`
+	Failures      []ReconcileFailure `json:"failures"`
+}
+
+// ReconcileMaxWait сколько сверка пережидает паузу по 429 и разомкнутый breaker
+// на один заказ, прежде чем записать его в несверенные
+const ReconcileMaxWait = 2 * time.Minute
+
+// Reconciler сверяет начисления PROCESSED с провайдерами начислений
+type Reconciler struct {
+	storage storage.Storage
+	router  *Router
+	opts    Options
+	maxWait time.Duration
+}
+
+// NewReconciler провайдеры и маршруты — как у Poller, размер пачки — Options.BatchSize
+func NewReconciler(store storage.Storage, opts Options) (*Reconciler, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+
+	router, err := newRouterFromOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reconciler{storage: store, router: router, opts: opts, maxWait: ReconcileMaxWait}, nil
+}
+
+func (r *Reconciler) Close() {
+	r.router.Close()
+}
+
+// Run проходит все начисления PROCESSED пачками по id и запрашивает каждое у провайдера.
+// С apply расхождения по сумме исправляются корректирующей операцией; пропавшие заказы
+// и смену статуса только отражает в отчёте — их разбирают вручную.
+func (r *Reconciler) Run(ctx context.Context, apply bool) (*ReconcileReport, error) {
+	report := &ReconcileReport{
+		StartedAt:     time.Now(),
+		Apply:         apply,
+		Discrepancies: []Discrepancy{},
+		Failures:      []ReconcileFailure{},
+	}
+
+	var afterID int64
+	for {
+		ops, err := r.storage.GetProcessedAccruals(ctx, afterID, r.opts.BatchSize)
+		if err != nil {
+			return report, fmt.Errorf("failed to get processed accruals: %w", err)
+		}
+		if len(ops) == 0 {
+			break
+		}
+
+		for _, op := range ops {
+			if err := r.reconcileOrder(ctx, op, apply, report); err != nil {
+				return report, err
+			}
+		}
+		afterID = ops[len(ops)-1].ID
+
+		log.Info().
+			Int("checked", report.Checked).
+			Int("discrepancies", len(report.Discrepancies)).
+			Int64("after_id", afterID).
+			Msg("Reconciliation batch done")
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// reconcileOrder сверяет один заказ; ошибка — только отмена контекста
+func (r *Reconciler) reconcileOrder(ctx context.Context, op *models.BalanceOperation, apply bool, report *ReconcileReport) error {
+	provider := r.router.Route(op.OrderNumber)
+
+	status, amount, err := r.fetch(ctx, provider, op.OrderNumber)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	report.Checked++
+
+	notRegistered := errors.Is(err, ErrNotRegistered)
+	if err != nil && !notRegistered {
+		report.Failures = append(report.Failures, ReconcileFailure{
+			Order:    op.OrderNumber,
+			Provider: provider.Name(),
+			Error:    err.Error(),
+		})
+		return nil
+	}
+
+	kind, ok := compareAccrual(op.Amount, notRegistered, status, amount)
+	if ok {
+		return nil
+	}
+
+	d := Discrepancy{
+		Kind:         kind,
+		OperationID:  op.ID,
+		Order:        op.OrderNumber,
+		UserID:       op.UserID,
+		Provider:     provider.Name(),
+		LocalStatus:  op.Status,
+		RemoteStatus: status,
+		LocalAmount:  op.Amount,
+		RemoteAmount: amount,
+	}
+
+	if apply && kind == DiscrepancyAmount {
+		delta := amount - op.Amount
+		reason := fmt.Sprintf("reconciliation: %s reports %s, stored %s", provider.Name(), amount, op.Amount)
+		if err := r.storage.AdjustAccrual(ctx, op.ID, delta, reason); err != nil {
+			d.Error = err.Error()
+		} else {
+			d.Corrected = true
+		}
+	}
+
+	log.Warn().
+		Str("kind", string(d.Kind)).
+		Str("order", d.Order).
+		Str("remote_status", string(d.RemoteStatus)).
+		Str("local_amount", d.LocalAmount.String()).
+		Str("remote_amount", d.RemoteAmount.String()).
+		Bool("corrected", d.Corrected).
+		Msg("Accrual discrepancy")
+
+	report.Discrepancies = append(report.Discrepancies, d)
+	return nil
+}
+
+// fetch запрашивает статус, пережидая паузу по 429 и разомкнутый breaker не дольше
+// maxWait: провайдер, который так и не ответил, не должен останавливать всю сверку
+func (r *Reconciler) fetch(ctx context.Context, provider Provider, orderNumber string) (models.Status, models.Money, error) {
+	deadline := time.Now().Add(r.maxWait)
+	for {
+		status, amount, err := provider.FetchOrderStatus(ctx, orderNumber)
+		if !errors.Is(err, ErrRateLimited) && !errors.Is(err, ErrCircuitOpen) {
+			return status, amount, err
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return "", 0, fmt.Errorf("gave up after %s: %w", r.maxWait, err)
+		}
+		t := time.NewTimer(min(PollInterval, wait))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return "", 0, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// compareAccrual сравнивает наше начисление PROCESSED с ответом провайдера
+func compareAccrual(local models.Money, notRegistered bool, remoteStatus models.Status, remote models.Money) (DiscrepancyKind, bool) {
+	switch {
+	case notRegistered:
+		return DiscrepancyMissing, false
+	case remoteStatus != models.ProcessedStatus:
+		return DiscrepancyStatus, false
+	case local != remote:
+		return DiscrepancyAmount, false
+	default:
+		return "", true
+	}
+}
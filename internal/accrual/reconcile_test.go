@@ -0,0 +1,70 @@
+package accrual
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+	"github.com/JSchatten/go-diploma/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareAccrual(t *testing.T) {
+	tests := []struct {
+		name          string
+		local         models.Money
+		notRegistered bool
+		remoteStatus  models.Status
+		remote        models.Money
+		kind          DiscrepancyKind
+		ok            bool
+	}{
+		{"match", 50000, false, models.ProcessedStatus, 50000, "", true},
+		{"amount", 50000, false, models.ProcessedStatus, 45050, DiscrepancyAmount, false},
+		{"one kopeck", 50000, false, models.ProcessedStatus, 50001, DiscrepancyAmount, false},
+		{"missing", 50000, true, "", 0, DiscrepancyMissing, false},
+		{"invalid", 50000, false, models.InvalidStatus, 0, DiscrepancyStatus, false},
+		{"processing", 50000, false, models.ProcessingStatus, 0, DiscrepancyStatus, false},
+	}
+
+	for _, tt := range tests {
+		kind, ok := compareAccrual(tt.local, tt.notRegistered, tt.remoteStatus, tt.remote)
+		assert.Equal(t, tt.kind, kind, tt.name)
+		assert.Equal(t, tt.ok, ok, tt.name)
+	}
+}
+
+func TestReconciler_GivesUpOnPausedProvider(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	store := storage.NewMemoryStorage()
+	userID, err := store.SaveUser(ctx, "user", "hash")
+	require.NoError(t, err)
+	for _, number := range []string{"12345678903", "79927398713"} {
+		require.NoError(t, store.UploadOrder(ctx, &models.BalanceOperation{
+			UserID: userID, OrderNumber: number, Status: models.NewStatus, ProcessedAt: time.Now(),
+		}))
+		require.NoError(t, store.UpdateOrderStatus(ctx, number, models.ProcessedStatus, 50000))
+	}
+
+	// провайдер отвечает только 429: каждый заказ ждём не дольше maxWait
+	router, err := NewRouter(Guard(&pausedProvider{}, ProviderOptions{}), nil, nil)
+	require.NoError(t, err)
+	r := &Reconciler{
+		storage: store,
+		router:  router,
+		opts:    Options{BatchSize: DefaultBatchSize},
+		maxWait: 10 * time.Millisecond,
+	}
+	defer r.Close()
+
+	report, err := r.Run(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Checked)
+	assert.Empty(t, report.Discrepancies)
+	require.Len(t, report.Failures, 2)
+	assert.Contains(t, report.Failures[0].Error, ErrRateLimited.Error())
+}
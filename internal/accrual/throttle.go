@@ -0,0 +1,86 @@
+package accrual
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultRetryAfter пауза, если accrual ответил 429 без корректного Retry-After
+const DefaultRetryAfter = 60 * time.Second
+
+// ErrRateLimited accrual попросил подождать (429 Too Many Requests)
+var ErrRateLimited = errors.New("accrual rate limit exceeded")
+
+// throttle общая для всего клиента пауза после 429
+type throttle struct {
+	provider string
+
+	mu    sync.Mutex
+	until time.Time
+}
+
+// suspend приостанавливает все запросы до now+d (паузу только продлеваем)
+func (t *throttle) suspend(now time.Time, d time.Duration) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	until := now.Add(d)
+	if until.After(t.until) {
+		t.until = until
+		log.Warn().
+			Str("provider", t.provider).
+			Dur("retry_after", d).
+			Time("until", until).
+			Msg("Accrual poller suspended")
+	}
+	return t.until
+}
+
+// remaining сколько ещё длится пауза; 0 — запросы разрешены.
+// При первом вызове после окончания паузы пишет в лог о возобновлении.
+func (t *throttle) remaining(now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.until.IsZero() {
+		return 0
+	}
+	if now.Before(t.until) {
+		return t.until.Sub(now)
+	}
+
+	log.Info().Str("provider", t.provider).Time("suspended_until", t.until).Msg("Accrual poller resumed")
+	t.until = time.Time{}
+	return 0
+}
+
+// parseRetryAfter разбирает Retry-After в обеих формах: секунды и HTTP-date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	d := at.Sub(now)
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
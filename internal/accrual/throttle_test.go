@@ -0,0 +1,76 @@
+package accrual
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"60", 60 * time.Second, true},
+		{" 5 ", 5 * time.Second, true},
+		{"0", 0, true},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, true}, // дата в прошлом
+		{"", 0, false},
+		{"-1", 0, false},
+		{"soon", 0, false},
+	}
+
+	for _, tt := range tests {
+		d, ok := parseRetryAfter(tt.value, now)
+		assert.Equal(t, tt.ok, ok, "value: %q", tt.value)
+		assert.Equal(t, tt.expected, d, "value: %q", tt.value)
+	}
+}
+
+func TestThrottle_SuspendAndResume(t *testing.T) {
+	var th throttle
+	now := time.Now()
+
+	assert.Zero(t, th.remaining(now))
+
+	th.suspend(now, time.Minute)
+	assert.Equal(t, time.Minute, th.remaining(now))
+
+	// более короткая пауза не сокращает текущую
+	th.suspend(now, time.Second)
+	assert.Equal(t, 30*time.Second, th.remaining(now.Add(30*time.Second)))
+
+	assert.Zero(t, th.remaining(now.Add(time.Minute)))
+	assert.Zero(t, th.remaining(now))
+}
+
+func TestFetchOrderStatus_TooManyRequests(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	c, err := NewProvider(ProviderOptions{URL: srv.URL})
+	require.NoError(t, err)
+	defer c.Close()
+
+	_, _, err = c.FetchOrderStatus(context.Background(), "12345678903")
+	require.ErrorIs(t, err, ErrRateLimited)
+	assert.Equal(t, 1, calls, "429 must not be retried")
+
+	wait := c.throttle.remaining(time.Now())
+	assert.Greater(t, wait, 59*time.Second)
+	assert.LessOrEqual(t, wait, 60*time.Second)
+}
@@ -0,0 +1,109 @@
+package accrual
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// SignatureHeader HMAC-SHA256 (hex) от "<timestamp>.<body>"
+	SignatureHeader = "X-Accrual-Signature"
+	// TimestampHeader unix-время отправки в секундах
+	TimestampHeader = "X-Accrual-Timestamp"
+
+	// WebhookTolerance допустимое расхождение времени отправки и приёма
+	WebhookTolerance = 5 * time.Minute
+	// WebhookCleanupInterval как часто удаляются истёкшие подписи
+	WebhookCleanupInterval = 10 * time.Minute
+)
+
+var (
+	ErrBadSignature = errors.New("invalid webhook signature")
+	ErrStaleRequest = errors.New("webhook timestamp is outside the tolerance window")
+	ErrReplay       = errors.New("webhook request already processed")
+)
+
+// Sign подпись тела push-уведомления, то же самое считает accrual
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookVerifier проверяет подпись и свежесть push-уведомлений.
+// Подписи принятых запросов хранятся в БД в течение окна допуска, поэтому повтор
+// отклоняется, даже если он пришёл на другой экземпляр.
+type WebhookVerifier struct {
+	secret    []byte
+	tolerance time.Duration
+	store     storage.Storage
+}
+
+func NewWebhookVerifier(secret string, store storage.Storage) *WebhookVerifier {
+	return &WebhookVerifier{
+		secret:    []byte(secret),
+		tolerance: WebhookTolerance,
+		store:     store,
+	}
+}
+
+// Verify проверяет запрос и запоминает его подпись. Ошибка хранилища возвращается как есть.
+// Если уведомление затем не применилось, подпись нужно освободить через Forget.
+func (v *WebhookVerifier) Verify(ctx context.Context, timestamp, signature string, body []byte, now time.Time) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrStaleRequest
+	}
+	sent := time.Unix(ts, 0)
+	if now.Sub(sent) > v.tolerance || sent.Sub(now) > v.tolerance {
+		return ErrStaleRequest
+	}
+
+	expected := Sign(v.secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrBadSignature
+	}
+
+	// после окна допуска запрос отклонит проверка времени, подпись можно забыть
+	err = v.store.SaveWebhookSignature(ctx, signature, sent.Add(v.tolerance))
+	if errors.Is(err, storage.ErrWebhookReplay) {
+		return ErrReplay
+	}
+	return err
+}
+
+// Forget освобождает подпись уведомления, которое не удалось применить,
+// чтобы повтор от accrual не был отклонён как ErrReplay
+func (v *WebhookVerifier) Forget(ctx context.Context, signature string) error {
+	return v.store.DeleteWebhookSignature(ctx, signature)
+}
+
+// StartCleanup удаляет истёкшие подписи раз в WebhookCleanupInterval до отмены ctx
+func (v *WebhookVerifier) StartCleanup(ctx context.Context) error {
+	ticker := time.NewTicker(WebhookCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			deleted, err := v.store.DeleteExpiredWebhookSignatures(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to delete expired webhook signatures")
+				continue
+			}
+			log.Debug().Int64("deleted", deleted).Msg("Expired webhook signatures deleted")
+		}
+	}
+}
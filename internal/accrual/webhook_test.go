@@ -0,0 +1,80 @@
+package accrual
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signatureStore подписи в памяти, как webhook_signatures
+type signatureStore struct {
+	storage.Storage
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func (s *signatureStore) SaveWebhookSignature(ctx context.Context, signature string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if until, ok := s.seen[signature]; ok && until.After(time.Now()) {
+		return storage.ErrWebhookReplay
+	}
+	s.seen[signature] = expiresAt
+	return nil
+}
+
+func (s *signatureStore) DeleteWebhookSignature(ctx context.Context, signature string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.seen, signature)
+	return nil
+}
+
+func TestWebhookVerifier(t *testing.T) {
+	ctx := context.Background()
+	store := &signatureStore{seen: make(map[string]time.Time)}
+	v := NewWebhookVerifier("secret", store)
+	now := time.Now()
+	ts := strconv.FormatInt(now.Unix(), 10)
+	body := []byte(`{"order":"12345678903","status":"PROCESSED","accrual":500}`)
+	sig := Sign([]byte("secret"), ts, body)
+
+	assert.NoError(t, v.Verify(ctx, ts, sig, body, now))
+	assert.ErrorIs(t, v.Verify(ctx, ts, sig, body, now), ErrReplay)
+	// подписи общие для экземпляров с одной БД
+	assert.ErrorIs(t, NewWebhookVerifier("secret", store).Verify(ctx, ts, sig, body, now), ErrReplay)
+
+	// чужой секрет и подменённое тело
+	assert.ErrorIs(t, v.Verify(ctx, ts, Sign([]byte("other"), ts, body), body, now), ErrBadSignature)
+	assert.ErrorIs(t, v.Verify(ctx, ts, sig, []byte(`{"order":"12345678903","status":"PROCESSED","accrual":5000}`), now), ErrBadSignature)
+
+	// старый запрос
+	old := strconv.FormatInt(now.Add(-time.Hour).Unix(), 10)
+	assert.ErrorIs(t, v.Verify(ctx, old, Sign([]byte("secret"), old, body), body, now), ErrStaleRequest)
+	assert.ErrorIs(t, v.Verify(ctx, "yesterday", sig, body, now), ErrStaleRequest)
+}
+
+func TestWebhookVerifier_Forget(t *testing.T) {
+	ctx := context.Background()
+	store := &signatureStore{seen: make(map[string]time.Time)}
+	v := NewWebhookVerifier("secret", store)
+	now := time.Now()
+	ts := strconv.FormatInt(now.Unix(), 10)
+	body := []byte(`{"order":"12345678903","status":"PROCESSED","accrual":500}`)
+	sig := Sign([]byte("secret"), ts, body)
+
+	// уведомление не применилось — повтор от accrual принимается
+	require.NoError(t, v.Verify(ctx, ts, sig, body, now))
+	require.NoError(t, v.Forget(ctx, sig))
+	assert.NoError(t, v.Verify(ctx, ts, sig, body, now))
+	assert.ErrorIs(t, v.Verify(ctx, ts, sig, body, now), ErrReplay)
+}
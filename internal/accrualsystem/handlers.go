@@ -0,0 +1,106 @@
+package accrualsystem
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/JSchatten/go-diploma/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+type Handlers struct {
+	store Store
+}
+
+func NewHandlers(store Store) *Handlers {
+	return &Handlers{store: store}
+}
+
+// Register вешает API accrual на роутер, limit — запросов в минуту к GET /api/orders/{number}
+func (h *Handlers) Register(router gin.IRouter, limit int) {
+	router.GET("/api/orders/:number", RateLimitMiddleware(limit), h.GetOrderHandler)
+	router.POST("/api/orders", h.RegisterOrderHandler)
+	router.POST("/api/goods", h.AddGoodHandler)
+}
+
+// GetOrderHandler GET /api/orders/{number}
+func (h *Handlers) GetOrderHandler(c *gin.Context) {
+	number := c.Param("number")
+
+	o, err := h.store.GetOrder(c.Request.Context(), number)
+	if err != nil {
+		if errors.Is(err, ErrOrderNotFound) {
+			c.Status(http.StatusNoContent)
+			return
+		}
+		log.Error().Err(err).Str("order", number).Msg("Failed to get order")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, OrderResponse{
+		Order:   o.Number,
+		Status:  o.Status,
+		Accrual: o.Accrual,
+	})
+}
+
+// RegisterOrderHandler POST /api/orders — регистрация заказа для расчёта
+func (h *Handlers) RegisterOrderHandler(c *gin.Context) {
+	var req RegisterOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if !utils.LuhnCheck(req.Order) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid order number"})
+		return
+	}
+
+	err := h.store.RegisterOrder(c.Request.Context(), &Order{
+		Number: req.Order,
+		Goods:  req.Goods,
+		Status: RegisteredStatus,
+	})
+	if err != nil {
+		if errors.Is(err, ErrOrderRegistered) {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "order already registered"})
+			return
+		}
+		log.Error().Err(err).Str("order", req.Order).Msg("Failed to register order")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	log.Info().Str("order", req.Order).Int("goods", len(req.Goods)).Msg("Order registered")
+	c.Status(http.StatusAccepted)
+}
+
+// AddGoodHandler POST /api/goods — новая механика вознаграждения
+func (h *Handlers) AddGoodHandler(c *gin.Context) {
+	var g Good
+	if err := c.ShouldBindJSON(&g); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if g.Match == "" || g.Reward <= 0 || (g.RewardType != RewardPercent && g.RewardType != RewardPoints) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if err := h.store.AddGood(c.Request.Context(), g); err != nil {
+		if errors.Is(err, ErrGoodExists) {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "match already exists"})
+			return
+		}
+		log.Error().Err(err).Str("match", g.Match).Msg("Failed to add good")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	log.Info().Str("match", g.Match).Str("reward", g.Reward.String()).Str("type", string(g.RewardType)).Msg("Reward added")
+	c.Status(http.StatusOK)
+}
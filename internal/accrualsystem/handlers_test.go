@@ -0,0 +1,114 @@
+package accrualsystem
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func doRequest(r http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAccrual_OrderLifecycle(t *testing.T) {
+	store := NewMemoryStore()
+	r := gin.New()
+	NewHandlers(store).Register(r, 0)
+
+	w := doRequest(r, http.MethodPost, "/api/goods", `{"match":"Bork","reward":10,"reward_type":"%"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(r, http.MethodPost, "/api/goods", `{"match":"Bork","reward":5,"reward_type":"pt"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = doRequest(r, http.MethodGet, "/api/orders/12345678903", "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = doRequest(r, http.MethodPost, "/api/orders",
+		`{"order":"12345678903","goods":[{"description":"Чайник Bork","price":7000}]}`)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	w = doRequest(r, http.MethodPost, "/api/orders", `{"order":"12345678903","goods":[]}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = doRequest(r, http.MethodPost, "/api/orders", `{"order":"12345678900","goods":[]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	getOrder := func() OrderResponse {
+		w := doRequest(r, http.MethodGet, "/api/orders/12345678903", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var resp OrderResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	assert.Equal(t, RegisteredStatus, getOrder().Status)
+
+	p := NewProcessor(store, time.Millisecond)
+	later := time.Now().Add(time.Second)
+
+	p.step(context.Background(), later)
+	assert.Equal(t, ProcessingStatus, getOrder().Status)
+
+	p.step(context.Background(), later.Add(time.Second))
+	resp := getOrder()
+	assert.Equal(t, ProcessedStatus, resp.Status)
+	assert.Equal(t, models.Money(70000), resp.Accrual)
+}
+
+func TestCalculateAccrual(t *testing.T) {
+	goods := []Good{
+		{Match: "Bork", Reward: 1000, RewardType: RewardPercent},
+		{Match: "LG", Reward: 5000, RewardType: RewardPoints},
+		{Match: "Philips", Reward: 750, RewardType: RewardPercent},
+	}
+
+	accrual, matched := CalculateAccrual([]Item{
+		{Description: "Чайник Bork", Price: 700000},
+		{Description: "Телевизор lg", Price: 10000000},
+		{Description: "Стол", Price: 300000},
+	}, goods)
+	assert.True(t, matched)
+	assert.Equal(t, models.Money(75000), accrual)
+
+	// 7.5% от 0.10 = 0.0075 → 0.01, от 0.06 = 0.0045 → 0.00: округление по каждому товару
+	accrual, matched = CalculateAccrual([]Item{
+		{Description: "Бритва Philips", Price: 10},
+		{Description: "Щётка Philips", Price: 6},
+	}, goods)
+	assert.True(t, matched)
+	assert.Equal(t, models.Money(1), accrual)
+
+	accrual, matched = CalculateAccrual([]Item{{Description: "Стол", Price: 300000}}, goods)
+	assert.False(t, matched)
+	assert.Zero(t, accrual)
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	r := gin.New()
+	r.GET("/api/orders/:number", RateLimitMiddleware(2), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	assert.Equal(t, http.StatusNoContent, doRequest(r, http.MethodGet, "/api/orders/1", "").Code)
+	assert.Equal(t, http.StatusNoContent, doRequest(r, http.MethodGet, "/api/orders/1", "").Code)
+
+	w := doRequest(r, http.MethodGet, "/api/orders/1", "")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "No more than 2 requests per minute allowed")
+}
@@ -0,0 +1,99 @@
+package accrualsystem
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+)
+
+// MemoryStore хранилище в памяти, данные теряются при перезапуске
+type MemoryStore struct {
+	mu     sync.RWMutex
+	goods  []Good
+	orders map[string]*Order
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{orders: make(map[string]*Order)}
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func (s *MemoryStore) AddGood(_ context.Context, g Good) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.goods {
+		if existing.Match == g.Match {
+			return ErrGoodExists
+		}
+	}
+	s.goods = append(s.goods, g)
+	return nil
+}
+
+func (s *MemoryStore) GetGoods(_ context.Context) ([]Good, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	goods := make([]Good, len(s.goods))
+	copy(goods, s.goods)
+	return goods, nil
+}
+
+func (s *MemoryStore) RegisterOrder(_ context.Context, o *Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.orders[o.Number]; ok {
+		return ErrOrderRegistered
+	}
+	stored := *o
+	stored.UpdatedAt = time.Now()
+	s.orders[o.Number] = &stored
+	return nil
+}
+
+func (s *MemoryStore) GetOrder(_ context.Context, number string) (*Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	o, ok := s.orders[number]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	res := *o
+	return &res, nil
+}
+
+func (s *MemoryStore) GetOrdersByStatus(_ context.Context, status Status) ([]*Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var res []*Order
+	for _, o := range s.orders {
+		if o.Status == status {
+			copied := *o
+			res = append(res, &copied)
+		}
+	}
+	return res, nil
+}
+
+func (s *MemoryStore) UpdateOrder(_ context.Context, number string, status Status, accrual models.Money) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[number]
+	if !ok {
+		return ErrOrderNotFound
+	}
+	o.Status = status
+	o.Accrual = accrual
+	o.UpdatedAt = time.Now()
+	return nil
+}
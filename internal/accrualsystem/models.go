@@ -0,0 +1,92 @@
+// Package accrualsystem — упрощённая замена внешней системы расчёта начислений
+// для локального запуска гофермарта целиком
+package accrualsystem
+
+import (
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+)
+
+// Status статус расчёта в терминах accrual (см. SPECIFICATION.md)
+type Status string
+
+const (
+	RegisteredStatus Status = "REGISTERED"
+	ProcessingStatus Status = "PROCESSING"
+	InvalidStatus    Status = "INVALID"
+	ProcessedStatus  Status = "PROCESSED"
+)
+
+// RewardType тип вознаграждения за товар
+type RewardType string
+
+const (
+	RewardPercent RewardType = "%"  // процент от цены товара
+	RewardPoints  RewardType = "pt" // фиксированное количество баллов
+)
+
+// Good механика вознаграждения: товары, в названии которых есть Match.
+// Reward — баллы для RewardPoints или процент для RewardPercent, оба с точностью до сотых.
+type Good struct {
+	Match      string       `json:"match"`
+	Reward     models.Money `json:"reward"`
+	RewardType RewardType   `json:"reward_type"`
+}
+
+// Item товар в составе заказа
+type Item struct {
+	Description string       `json:"description"`
+	Price       models.Money `json:"price"`
+}
+
+// Order зарегистрированный заказ
+type Order struct {
+	Number    string
+	Goods     []Item
+	Status    Status
+	Accrual   models.Money
+	UpdatedAt time.Time
+}
+
+// OrderResponse GET /api/orders/{number}
+type OrderResponse struct {
+	Order   string       `json:"order"`
+	Status  Status       `json:"status"`
+	Accrual models.Money `json:"accrual,omitempty"`
+}
+
+// RegisterOrderRequest POST /api/orders
+type RegisterOrderRequest struct {
+	Order string `json:"order"`
+	Goods []Item `json:"goods"`
+}
+
+// CalculateAccrual считает начисление по механикам в копейках.
+// Для каждого товара применяется первая подходящая механика.
+// Процент от цены округляется до копейки по каждому товару, половина — вверх.
+// matched=false — ни один товар не подошёл, заказ считается INVALID.
+func CalculateAccrual(items []Item, goods []Good) (accrual models.Money, matched bool) {
+	for _, item := range items {
+		for _, g := range goods {
+			if !containsFold(item.Description, g.Match) {
+				continue
+			}
+			matched = true
+			switch g.RewardType {
+			case RewardPercent:
+				accrual += percentOf(item.Price, g.Reward)
+			case RewardPoints:
+				accrual += g.Reward
+			}
+			break
+		}
+	}
+	return accrual, matched
+}
+
+// percentOf percent процентов от price. Оба значения в сотых долях,
+// поэтому произведение делится на 100 * 100
+func percentOf(price, percent models.Money) models.Money {
+	return (price*percent + 5000) / 10000
+}
@@ -0,0 +1,85 @@
+package accrualsystem
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Processor двигает заказы по статусам: REGISTERED -> PROCESSING -> PROCESSED/INVALID.
+// Каждый шаг занимает не меньше delay, чтобы клиент успел увидеть промежуточные статусы.
+type Processor struct {
+	store Store
+	delay time.Duration
+}
+
+func NewProcessor(store Store, delay time.Duration) *Processor {
+	return &Processor{store: store, delay: delay}
+}
+
+// Run обрабатывает заказы до отмены контекста
+func (p *Processor) Run(ctx context.Context) error {
+	log.Info().Dur("delay", p.delay).Msg("Starting accrual order processor")
+
+	ticker := time.NewTicker(p.delay)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			p.step(ctx, time.Now())
+		}
+	}
+}
+
+// step один шаг обработки: сначала считаем взятые в работу, потом берём новые
+func (p *Processor) step(ctx context.Context, now time.Time) {
+	processing, err := p.store.GetOrdersByStatus(ctx, ProcessingStatus)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get processing orders")
+		return
+	}
+
+	goods, err := p.store.GetGoods(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get goods")
+		return
+	}
+
+	for _, o := range processing {
+		if now.Sub(o.UpdatedAt) < p.delay {
+			continue
+		}
+
+		accrual, matched := CalculateAccrual(o.Goods, goods)
+		status := ProcessedStatus
+		if !matched {
+			status = InvalidStatus
+			accrual = 0
+		}
+
+		if err := p.store.UpdateOrder(ctx, o.Number, status, accrual); err != nil {
+			log.Error().Err(err).Str("order", o.Number).Msg("Failed to finish order")
+			continue
+		}
+		log.Info().Str("order", o.Number).Str("status", string(status)).Str("accrual", accrual.String()).Msg("Order calculated")
+	}
+
+	registered, err := p.store.GetOrdersByStatus(ctx, RegisteredStatus)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get registered orders")
+		return
+	}
+
+	for _, o := range registered {
+		if now.Sub(o.UpdatedAt) < p.delay {
+			continue
+		}
+		if err := p.store.UpdateOrder(ctx, o.Number, ProcessingStatus, 0); err != nil {
+			log.Error().Err(err).Str("order", o.Number).Msg("Failed to start processing order")
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package accrualsystem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Таблицы с префиксом accrual_, чтобы можно было делить базу с гофермартом
+// и не конфликтовать с его миграциями
+const psqlSchema = `
+CREATE TABLE IF NOT EXISTS accrual_goods (
+    match TEXT PRIMARY KEY,
+    reward DECIMAL(10,2) NOT NULL,
+    reward_type TEXT NOT NULL CHECK (reward_type IN ('%', 'pt'))
+);
+
+CREATE TABLE IF NOT EXISTS accrual_orders (
+    number TEXT PRIMARY KEY,
+    goods JSONB NOT NULL DEFAULT '[]',
+    status TEXT NOT NULL CHECK (status IN ('REGISTERED', 'PROCESSING', 'INVALID', 'PROCESSED')),
+    accrual DECIMAL(10,2) NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_accrual_orders_status ON accrual_orders(status);
+`
+
+// PSQLStore хранилище accrual в PostgreSQL
+type PSQLStore struct {
+	db *pgxpool.Pool
+}
+
+func NewPSQLStore(ctx context.Context, connString string) (*PSQLStore, error) {
+	pool, err := pgxpool.New(ctx, connString)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connection pool: %w", err)
+	}
+
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	if _, err := pool.Exec(ctx, psqlSchema); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to init schema: %w", err)
+	}
+
+	return &PSQLStore{db: pool}, nil
+}
+
+func (s *PSQLStore) Close() error {
+	s.db.Close()
+	return nil
+}
+
+func (s *PSQLStore) AddGood(ctx context.Context, g Good) error {
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO accrual_goods (match, reward, reward_type) VALUES ($1, $2, $3)
+	`, g.Match, g.Reward, string(g.RewardType))
+	if isUniqueViolation(err) {
+		return ErrGoodExists
+	}
+	return err
+}
+
+func (s *PSQLStore) GetGoods(ctx context.Context) ([]Good, error) {
+	rows, err := s.db.Query(ctx, `SELECT match, reward, reward_type FROM accrual_goods`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var goods []Good
+	for rows.Next() {
+		var g Good
+		var rewardType string
+		if err := rows.Scan(&g.Match, &g.Reward, &rewardType); err != nil {
+			return nil, err
+		}
+		g.RewardType = RewardType(rewardType)
+		goods = append(goods, g)
+	}
+	return goods, rows.Err()
+}
+
+func (s *PSQLStore) RegisterOrder(ctx context.Context, o *Order) error {
+	goods, err := json.Marshal(o.Goods)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(ctx, `
+		INSERT INTO accrual_orders (number, goods, status) VALUES ($1, $2, $3)
+	`, o.Number, goods, string(o.Status))
+	if isUniqueViolation(err) {
+		return ErrOrderRegistered
+	}
+	return err
+}
+
+func (s *PSQLStore) GetOrder(ctx context.Context, number string) (*Order, error) {
+	row := s.db.QueryRow(ctx, `
+		SELECT number, goods, status, accrual, updated_at FROM accrual_orders WHERE number = $1
+	`, number)
+
+	o, err := scanOrder(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrOrderNotFound
+	}
+	return o, err
+}
+
+func (s *PSQLStore) GetOrdersByStatus(ctx context.Context, status Status) ([]*Order, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT number, goods, status, accrual, updated_at FROM accrual_orders WHERE status = $1
+	`, string(status))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []*Order
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+	return orders, rows.Err()
+}
+
+func (s *PSQLStore) UpdateOrder(ctx context.Context, number string, status Status, accrual models.Money) error {
+	tag, err := s.db.Exec(ctx, `
+		UPDATE accrual_orders SET status = $1, accrual = $2, updated_at = NOW() WHERE number = $3
+	`, string(status), accrual, number)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrOrderNotFound
+	}
+	return nil
+}
+
+func scanOrder(row pgx.Row) (*Order, error) {
+	var o Order
+	var goods []byte
+	var status string
+	if err := row.Scan(&o.Number, &goods, &status, &o.Accrual, &o.UpdatedAt); err != nil {
+		return nil, err
+	}
+	o.Status = Status(status)
+	if err := json.Unmarshal(goods, &o.Goods); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
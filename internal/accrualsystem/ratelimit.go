@@ -0,0 +1,51 @@
+package accrualsystem
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitMiddleware не больше limit запросов в минуту на все клиенты (фиксированное окно).
+// Сверх лимита — 429 с Retry-After до начала следующего окна. limit <= 0 — без ограничения.
+func RateLimitMiddleware(limit int) gin.HandlerFunc {
+	if limit <= 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	var (
+		mu          sync.Mutex
+		windowStart time.Time
+		count       int
+	)
+
+	return func(c *gin.Context) {
+		now := time.Now()
+
+		mu.Lock()
+		if now.Sub(windowStart) >= time.Minute {
+			windowStart = now
+			count = 0
+		}
+		count++
+		exceeded := count > limit
+		retryAfter := windowStart.Add(time.Minute).Sub(now)
+		mu.Unlock()
+
+		if exceeded {
+			secs := int(math.Ceil(retryAfter.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(secs))
+			c.Header("Content-Type", "text/plain")
+			c.String(http.StatusTooManyRequests, fmt.Sprintf("No more than %d requests per minute allowed", limit))
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,34 @@
+package accrualsystem
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+)
+
+// Store хранилище accrual: в памяти или в PostgreSQL
+type Store interface {
+	Close() error
+
+	// Механики вознаграждения
+	AddGood(ctx context.Context, g Good) error
+	GetGoods(ctx context.Context) ([]Good, error)
+
+	// Заказы
+	RegisterOrder(ctx context.Context, o *Order) error
+	GetOrder(ctx context.Context, number string) (*Order, error)
+	GetOrdersByStatus(ctx context.Context, status Status) ([]*Order, error)
+	UpdateOrder(ctx context.Context, number string, status Status, accrual models.Money) error
+}
+
+var (
+	ErrGoodExists      = errors.New("reward for this match already exists")
+	ErrOrderRegistered = errors.New("order already registered")
+	ErrOrderNotFound   = errors.New("order not found")
+)
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
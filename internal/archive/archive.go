@@ -0,0 +1,184 @@
+package archive
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+	"github.com/JSchatten/go-diploma/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultBatchSize сколько записей журнала выгружается за раз
+	DefaultBatchSize = 1000
+	// PartitionsAhead на сколько месяцев вперёд сервер держит партиции журнала
+	PartitionsAhead = 3
+	// MaintenanceInterval как часто сервер проверяет партиции
+	MaintenanceInterval = 24 * time.Hour
+)
+
+// StartMaintenance создаёт партиции журнала на PartitionsAhead месяцев вперёд при запуске
+// и затем раз в MaintenanceInterval до отмены ctx. Ошибка только логируется: записи
+// без своей партиции попадают в партицию по умолчанию и не теряются.
+func StartMaintenance(ctx context.Context, store storage.Storage) error {
+	ensurePartitions(ctx, store)
+
+	ticker := time.NewTicker(MaintenanceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			ensurePartitions(ctx, store)
+		}
+	}
+}
+
+func ensurePartitions(ctx context.Context, store storage.Storage) {
+	created, err := store.EnsureLedgerPartitions(ctx, time.Now(), PartitionsAhead)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create ledger partitions")
+		return
+	}
+	if len(created) > 0 {
+		log.Info().Strs("partitions", created).Msg("Ledger partitions created")
+	}
+}
+
+// Archiver выгружает старые месяцы журнала в файлы и убирает их из журнала.
+// Месяц удаляется из журнала только после того, как его файл записан на диск.
+type Archiver struct {
+	store     storage.Storage
+	dir       string
+	batchSize int
+}
+
+// NewArchiver создаёт архиватор с файлами в dir, batchSize <= 0 — DefaultBatchSize
+func NewArchiver(store storage.Storage, dir string, batchSize int) *Archiver {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	return &Archiver{store: store, dir: dir, batchSize: batchSize}
+}
+
+// Run архивирует месяцы журнала раньше before по порядку, начиная с самого старого.
+// Текущий месяц архивировать нельзя. Возвращает уже заархивированные месяцы и при ошибке.
+func (a *Archiver) Run(ctx context.Context, before time.Time) ([]models.ArchivedMonth, error) {
+	now := time.Now().UTC()
+	if before.After(time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)) {
+		return nil, fmt.Errorf("archive cutoff %s is after the start of the current month", before.Format("2006-01"))
+	}
+
+	if err := os.MkdirAll(a.dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	months, err := a.store.GetLedgerMonths(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	archived := []models.ArchivedMonth{}
+	for _, month := range months {
+		if !month.Before(before) {
+			break
+		}
+		m, err := a.archiveMonth(ctx, month)
+		if err != nil {
+			return archived, fmt.Errorf("archive %s: %w", month.Format("2006-01"), err)
+		}
+		archived = append(archived, m)
+
+		log.Info().
+			Str("month", month.Format("2006-01")).
+			Int("entries", m.Entries).
+			Str("file", m.File).
+			Msg("Ledger month archived")
+	}
+	return archived, nil
+}
+
+// archiveMonth выгружает месяц и убирает его из журнала. Пустой месяц (заранее созданная
+// партиция) файла не получает. Журнал не принял архивацию — файл удаляется.
+func (a *Archiver) archiveMonth(ctx context.Context, month time.Time) (models.ArchivedMonth, error) {
+	result := models.ArchivedMonth{Month: month}
+
+	path := filepath.Join(a.dir, "ledger-"+month.Format("2006-01")+".ndjson.gz")
+	if _, err := os.Stat(path); err == nil {
+		return result, fmt.Errorf("file %s already exists", path)
+	}
+
+	entries, err := a.export(ctx, month, path)
+	if err != nil {
+		return result, err
+	}
+	result.Entries = entries
+	if entries > 0 {
+		result.File = path
+	}
+
+	if err := a.store.ArchiveLedgerMonth(ctx, month, entries); err != nil {
+		if entries > 0 {
+			err = errors.Join(err, os.Remove(path))
+		}
+		return result, err
+	}
+	return result, nil
+}
+
+// export пишет записи месяца в path построчно JSON в gzip и возвращает их число.
+// Файл пишется во временный и переименовывается после fsync, поэтому в path
+// оказывается только полная выгрузка.
+func (a *Archiver) export(ctx context.Context, month time.Time, path string) (int, error) {
+	f, err := os.CreateTemp(a.dir, ".ledger-*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	zw := gzip.NewWriter(f)
+	enc := json.NewEncoder(zw)
+
+	count := 0
+	var afterID int64
+	for {
+		entries, err := a.store.GetLedgerEntries(ctx, month, afterID, a.batchSize)
+		if err != nil {
+			return 0, err
+		}
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return 0, err
+			}
+		}
+		count += len(entries)
+		if len(entries) < a.batchSize {
+			break
+		}
+		afterID = entries[len(entries)-1].ID
+	}
+
+	if count == 0 {
+		return 0, nil
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	return count, os.Rename(f.Name(), path)
+}
@@ -0,0 +1,132 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+	"github.com/JSchatten/go-diploma/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readArchive записи из файла архива
+func readArchive(t *testing.T, path string) []models.LedgerEntry {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+
+	var entries []models.LedgerEntry
+	sc := bufio.NewScanner(zr)
+	for sc.Scan() {
+		var e models.LedgerEntry
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &e))
+		entries = append(entries, e)
+	}
+	require.NoError(t, sc.Err())
+	return entries
+}
+
+func TestArchiverRun(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	userID, err := store.SaveUser(ctx, "user", "hash")
+	require.NoError(t, err)
+
+	jan := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	mar := jan.AddDate(0, 2, 0)
+	ops := []*models.BalanceOperation{
+		{OrderNumber: "12345678903", Amount: 10000, OperationType: models.AccrualOp, Status: models.ProcessedStatus, ProcessedAt: jan.Add(time.Hour)},
+		{OrderNumber: "2377225624", Amount: -2500, OperationType: models.WithdrawalOp, Status: models.ProcessedStatus, ProcessedAt: jan.Add(48 * time.Hour)},
+		{OrderNumber: "9278923470", Amount: 3000, OperationType: models.AccrualOp, Status: models.ProcessedStatus, ProcessedAt: mar.Add(time.Hour)},
+	}
+	for _, op := range ops {
+		op.UserID = userID
+		require.NoError(t, store.CreateOperation(ctx, op))
+	}
+
+	dir := filepath.Join(t.TempDir(), "archive")
+	archiver := NewArchiver(store, dir, 1)
+
+	// март позже границы и остаётся в журнале
+	archived, err := archiver.Run(ctx, mar)
+	require.NoError(t, err)
+	require.Len(t, archived, 1)
+	assert.True(t, archived[0].Month.Equal(jan))
+	assert.Equal(t, 2, archived[0].Entries)
+	assert.Equal(t, filepath.Join(dir, "ledger-2024-01.ndjson.gz"), archived[0].File)
+
+	entries := readArchive(t, archived[0].File)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "12345678903", entries[0].OrderNumber)
+	assert.Equal(t, models.WithdrawalOp, entries[1].EntryType)
+	require.Len(t, entries[1].Postings, 2)
+	assert.Equal(t, userID, entries[1].Postings[0].UserID)
+	assert.Equal(t, models.Money(-2500), entries[1].Postings[0].Amount)
+
+	current, withdrawn, err := store.GetBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, models.Money(10500), current)
+	assert.Equal(t, models.Money(2500), withdrawn)
+	drifts, err := store.VerifyBalances(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+
+	months, err := store.GetLedgerMonths(ctx)
+	require.NoError(t, err)
+	require.Len(t, months, 1)
+	assert.True(t, months[0].Equal(mar))
+
+	// повторный запуск ничего не делает
+	archived, err = archiver.Run(ctx, mar)
+	require.NoError(t, err)
+	assert.Empty(t, archived)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	assert.Len(t, files, 1, "temporary files are removed")
+}
+
+func TestArchiverRunRejectsCurrentMonth(t *testing.T) {
+	archiver := NewArchiver(storage.NewMemoryStorage(), t.TempDir(), 0)
+
+	_, err := archiver.Run(context.Background(), time.Now().AddDate(0, 1, 0))
+	assert.Error(t, err)
+}
+
+func TestArchiverRunKeepsExistingFile(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	userID, err := store.SaveUser(ctx, "user", "hash")
+	require.NoError(t, err)
+
+	jan := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, store.CreateOperation(ctx, &models.BalanceOperation{
+		UserID: userID, OrderNumber: "12345678903", Amount: 10000,
+		OperationType: models.AccrualOp, Status: models.ProcessedStatus, ProcessedAt: jan,
+	}))
+
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "ledger-2024-01.ndjson.gz")
+	require.NoError(t, os.WriteFile(existing, []byte("old"), 0o644))
+
+	_, err = NewArchiver(store, dir, 0).Run(ctx, jan.AddDate(0, 1, 0))
+	require.Error(t, err)
+
+	data, err := os.ReadFile(existing)
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(data))
+	months, err := store.GetLedgerMonths(ctx)
+	require.NoError(t, err)
+	assert.Len(t, months, 1, "month stays in the ledger")
+}
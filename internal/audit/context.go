@@ -0,0 +1,80 @@
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// RequestIDHeader идентификатор запроса: берётся от клиента или прокси, иначе
+	// генерируется, и возвращается в ответе
+	RequestIDHeader = "X-Request-ID"
+	// MaxRequestIDLength более длинный идентификатор от клиента заменяется своим
+	MaxRequestIDLength = 128
+)
+
+// Request откуда пришёл запрос
+type Request struct {
+	IP        string
+	UserAgent string
+	RequestID string
+}
+
+// Actor кто выполняет действие: пользователь или компонент системы (ID = 0)
+type Actor struct {
+	ID   int64
+	Name string
+}
+
+type requestKey struct{}
+type actorKey struct{}
+
+// WithRequest контекст с данными запроса для записей аудита
+func WithRequest(ctx context.Context, r Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, r)
+}
+
+// WithActor контекст с исполнителем для записей аудита
+func WithActor(ctx context.Context, id int64, name string) context.Context {
+	return context.WithValue(ctx, actorKey{}, Actor{ID: id, Name: name})
+}
+
+// RequestFrom данные запроса из контекста, пусто — действие не из HTTP-запроса
+func RequestFrom(ctx context.Context) Request {
+	r, _ := ctx.Value(requestKey{}).(Request)
+	return r
+}
+
+// ActorFrom исполнитель из контекста
+func ActorFrom(ctx context.Context) (Actor, bool) {
+	a, ok := ctx.Value(actorKey{}).(Actor)
+	return a, ok
+}
+
+// Middleware кладёт в контекст запроса IP, User-Agent и идентификатор запроса.
+// Ставится первым, чтобы идентификатор был и в ответах на отклонённые запросы.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if requestID == "" || len(requestID) > MaxRequestIDLength {
+			requestID = newRequestID()
+		}
+		c.Header(RequestIDHeader, requestID)
+
+		c.Request = c.Request.WithContext(WithRequest(c.Request.Context(), Request{
+			IP:        c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			RequestID: requestID,
+		}))
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
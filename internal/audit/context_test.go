@@ -0,0 +1,56 @@
+package audit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+
+	var got Request
+	r.GET("/", func(c *gin.Context) {
+		got = RequestFrom(c.Request.Context())
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name      string
+		requestID string
+		keep      bool
+	}{
+		{"generated", "", false},
+		{"from client", "req-42", true},
+		{"too long", strings.Repeat("x", MaxRequestIDLength+1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("User-Agent", "curl/8.0")
+			req.RemoteAddr = "10.0.0.1:5000"
+			if tt.requestID != "" {
+				req.Header.Set(RequestIDHeader, tt.requestID)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, "10.0.0.1", got.IP)
+			assert.Equal(t, "curl/8.0", got.UserAgent)
+			assert.NotEmpty(t, got.RequestID)
+			assert.Equal(t, got.RequestID, w.Header().Get(RequestIDHeader))
+			if tt.keep {
+				assert.Equal(t, tt.requestID, got.RequestID)
+			} else {
+				assert.Len(t, got.RequestID, 32)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+	"github.com/JSchatten/go-diploma/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+// SystemActor исполнитель записи, если в контексте его нет
+const SystemActor = "system"
+
+// verifyBatchSize сколько записей читается за раз при проверке цепочки
+const verifyBatchSize = 1000
+
+// Entry что произошло; исполнитель и данные запроса берутся из контекста
+type Entry struct {
+	Action    models.AuditAction
+	SubjectID int64  // пользователь, чьих данных касается запись
+	Subject   string // объект: номер заказа, логин
+	Before    any    // состояние до, nil — нет
+	After     any    // состояние после, nil — нет
+}
+
+// OrderState заказ в Before и After записи
+type OrderState struct {
+	Status  models.Status `json:"status,omitempty"`
+	Accrual models.Money  `json:"accrual,omitempty"`
+	Reason  string        `json:"reason,omitempty"`
+}
+
+// Recorder пишет журнал аудита. Nil-Recorder ничего не пишет: так сервисы работают
+// в командах и тестах без журнала.
+type Recorder struct {
+	store storage.Storage
+}
+
+func NewRecorder(store storage.Storage) *Recorder {
+	return &Recorder{store: store}
+}
+
+// Record дописывает запись в журнал. Действие к этому моменту уже выполнено,
+// поэтому ошибка записи только логируется и не возвращается вызывающему.
+func (r *Recorder) Record(ctx context.Context, entry Entry) {
+	if r == nil {
+		return
+	}
+
+	event, err := newEvent(ctx, entry)
+	if err == nil {
+		err = r.store.AppendAuditEvent(ctx, event)
+	}
+	if err != nil {
+		log.Error().Err(err).
+			Str("action", string(entry.Action)).
+			Str("subject", entry.Subject).
+			Msg("Failed to write audit event")
+	}
+}
+
+func newEvent(ctx context.Context, entry Entry) (*models.AuditEvent, error) {
+	req := RequestFrom(ctx)
+	actor, ok := ActorFrom(ctx)
+	if !ok {
+		actor.Name = SystemActor
+	}
+
+	before, err := marshalState(entry.Before)
+	if err != nil {
+		return nil, err
+	}
+	after, err := marshalState(entry.After)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AuditEvent{
+		Action:    entry.Action,
+		ActorID:   actor.ID,
+		Actor:     actor.Name,
+		SubjectID: entry.SubjectID,
+		Subject:   entry.Subject,
+		IP:        req.IP,
+		UserAgent: req.UserAgent,
+		RequestID: req.RequestID,
+		Before:    before,
+		After:     after,
+	}, nil
+}
+
+func marshalState(v any) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+// Events записи журнала по фильтру
+func (r *Recorder) Events(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEvent, error) {
+	return r.store.GetAuditEvents(ctx, filter)
+}
+
+// Verify проверяет цепочку хэшей от первой записи до последней: каждая запись ссылается
+// на хэш предыдущей и её хэш сходится с полями. Изменённая или удалённая из середины
+// запись — первая несходящаяся в отчёте.
+func (r *Recorder) Verify(ctx context.Context) (*models.AuditChainReport, error) {
+	report := &models.AuditChainReport{Valid: true}
+
+	var prev *models.AuditEvent
+	for {
+		filter := models.AuditFilter{Limit: verifyBatchSize}
+		if prev != nil {
+			filter.AfterID = prev.ID
+		}
+		events, err := r.store.GetAuditEvents(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		if len(events) == 0 {
+			return report, nil
+		}
+
+		for _, e := range events {
+			prevHash := ""
+			if prev != nil {
+				prevHash = prev.Hash
+			}
+
+			switch {
+			case e.PrevHash != prevHash:
+				report.Valid, report.BrokenAt, report.Reason = false, e.ID, "prev_hash does not match previous event"
+			case e.ComputeHash() != e.Hash:
+				report.Valid, report.BrokenAt, report.Reason = false, e.ID, "hash does not match event fields"
+			}
+			if !report.Valid {
+				return report, nil
+			}
+
+			report.Checked++
+			prev = e
+		}
+	}
+}
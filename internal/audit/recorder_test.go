@@ -0,0 +1,115 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+	"github.com/JSchatten/go-diploma/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tamperedStorage отдаёт журнал с подменённой записью, как после правки в обход приложения
+type tamperedStorage struct {
+	storage.Storage
+	tamper func(e *models.AuditEvent)
+}
+
+func (s *tamperedStorage) GetAuditEvents(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEvent, error) {
+	events, err := s.Storage.GetAuditEvents(ctx, filter)
+	for _, e := range events {
+		s.tamper(e)
+	}
+	return events, err
+}
+
+func TestRecorder_Record(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	recorder := NewRecorder(store)
+
+	ctx := WithRequest(context.Background(), Request{IP: "10.0.0.1", UserAgent: "curl/8.0", RequestID: "req-1"})
+	recorder.Record(WithActor(ctx, 5, "admin"), Entry{
+		Action:    models.AuditOrderStatusForced,
+		SubjectID: 7,
+		Subject:   "12345678903",
+		Before:    OrderState{Status: models.NewStatus},
+		After:     OrderState{Status: models.InvalidStatus, Reason: "rejected"},
+	})
+	// без исполнителя в контексте — система
+	recorder.Record(context.Background(), Entry{Action: models.AuditOrderStuck, Subject: "9278923470"})
+
+	events, err := recorder.Events(context.Background(), models.AuditFilter{})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+
+	e := events[0]
+	assert.Equal(t, models.AuditOrderStatusForced, e.Action)
+	assert.Equal(t, int64(5), e.ActorID)
+	assert.Equal(t, "admin", e.Actor)
+	assert.Equal(t, int64(7), e.SubjectID)
+	assert.Equal(t, "12345678903", e.Subject)
+	assert.Equal(t, "10.0.0.1", e.IP)
+	assert.Equal(t, "curl/8.0", e.UserAgent)
+	assert.Equal(t, "req-1", e.RequestID)
+	assert.JSONEq(t, `{"status":"NEW"}`, string(e.Before))
+	assert.JSONEq(t, `{"status":"INVALID","reason":"rejected"}`, string(e.After))
+
+	assert.Zero(t, events[1].ActorID)
+	assert.Equal(t, SystemActor, events[1].Actor)
+	assert.Nil(t, events[1].Before)
+	assert.Equal(t, e.Hash, events[1].PrevHash)
+}
+
+func TestRecorder_NilIsNoop(t *testing.T) {
+	var recorder *Recorder
+	assert.NotPanics(t, func() {
+		recorder.Record(context.Background(), Entry{Action: models.AuditLoginSucceeded})
+	})
+}
+
+func TestRecorder_Verify(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx := context.Background()
+
+	report, err := NewRecorder(store).Verify(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &models.AuditChainReport{Valid: true}, report)
+
+	for _, login := range []string{"alice", "bob", "carol"} {
+		NewRecorder(store).Record(WithActor(ctx, 0, login), Entry{Action: models.AuditLoginFailed, Subject: login})
+	}
+
+	report, err = NewRecorder(store).Verify(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &models.AuditChainReport{Checked: 3, Valid: true}, report)
+
+	tests := []struct {
+		name     string
+		tamper   func(e *models.AuditEvent)
+		brokenAt int64
+	}{
+		{"changed field", func(e *models.AuditEvent) {
+			if e.ID == 2 {
+				e.After = json.RawMessage(`{"reason":"none"}`)
+			}
+		}, 2},
+		{"recomputed hash", func(e *models.AuditEvent) {
+			if e.ID == 2 {
+				e.Actor = "mallory"
+				e.Hash = e.ComputeHash()
+			}
+		}, 3}, // запись сходится сама с собой, но следующая ссылается на старый хэш
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := NewRecorder(&tamperedStorage{Storage: store, tamper: tt.tamper}).Verify(ctx)
+			require.NoError(t, err)
+			assert.False(t, report.Valid)
+			assert.Equal(t, tt.brokenAt, report.BrokenAt)
+			assert.Equal(t, int(tt.brokenAt)-1, report.Checked)
+			assert.NotEmpty(t, report.Reason)
+		})
+	}
+}
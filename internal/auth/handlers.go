@@ -5,6 +5,8 @@ import (
 
 	"github.com/rs/zerolog/log"
 
+	"github.com/JSchatten/go-diploma/internal/audit"
+	"github.com/JSchatten/go-diploma/internal/models"
 	"github.com/JSchatten/go-diploma/internal/storage"
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
@@ -13,10 +15,18 @@ import (
 type AuthHandlers struct {
 	storage storage.Storage
 	jwtKey  []byte
+	audit   *audit.Recorder
+	admins  map[string]bool // логины, получающие права администратора при регистрации
 }
 
-func NewAuthHandlers(storage storage.Storage, jwtSecret string) *AuthHandlers {
-	return &AuthHandlers{storage: storage, jwtKey: []byte(jwtSecret)}
+// NewAuthHandlers adminLogins — логины администраторов из настроек: ещё не
+// зарегистрированный получает права сразу при регистрации
+func NewAuthHandlers(storage storage.Storage, jwtSecret string, auditLog *audit.Recorder, adminLogins []string) *AuthHandlers {
+	admins := make(map[string]bool, len(adminLogins))
+	for _, login := range adminLogins {
+		admins[login] = true
+	}
+	return &AuthHandlers{storage: storage, jwtKey: []byte(jwtSecret), audit: auditLog, admins: admins}
 }
 
 // RegisterHandler регистрирует нового пользователя
@@ -52,6 +62,21 @@ func (h *AuthHandlers) RegisterHandler(c *gin.Context) {
 		return
 	}
 
+	h.audit.Record(audit.WithActor(c.Request.Context(), userID, req.Login), audit.Entry{
+		Action:    models.AuditUserRegistered,
+		SubjectID: userID,
+		Subject:   req.Login,
+	})
+
+	if h.admins[req.Login] {
+		// пользователь уже создан: без прав его можно сделать администратором командой admin
+		if err := h.storage.SetAdmin(c.Request.Context(), req.Login, true); err != nil {
+			log.Logger.Error().Err(err).Str("login", req.Login).Msg("Failed to grant admin rights")
+		} else {
+			log.Logger.Info().Str("login", req.Login).Msg("Admin rights granted on registration")
+		}
+	}
+
 	token, err := GenerateToken(userID, req.Login, h.jwtKey)
 	if err != nil {
 		log.Logger.Error().Err(err).Msg("Failed to generate token")
@@ -79,6 +104,7 @@ func (h *AuthHandlers) LoginHandler(c *gin.Context) {
 	userID, hash, err := h.storage.GetUserByLogin(c.Request.Context(), req.Login)
 	if err != nil {
 		if err == storage.ErrUserNotFound {
+			h.recordLoginFailed(c, 0, req.Login, "unknown login")
 			log.Logger.Warn().Err(err).Msg("Invalid credentials")
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 			return
@@ -90,6 +116,7 @@ func (h *AuthHandlers) LoginHandler(c *gin.Context) {
 
 	// Проверяем пароль
 	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)); err != nil {
+		h.recordLoginFailed(c, userID, req.Login, "wrong password")
 		log.Logger.Error().Err(err).Msg("Invalid credentials")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
@@ -102,6 +129,22 @@ func (h *AuthHandlers) LoginHandler(c *gin.Context) {
 		return
 	}
 
+	h.audit.Record(audit.WithActor(c.Request.Context(), userID, req.Login), audit.Entry{
+		Action:    models.AuditLoginSucceeded,
+		SubjectID: userID,
+		Subject:   req.Login,
+	})
+
 	c.Header("Authorization", "Bearer "+token)
 	c.JSON(http.StatusOK, gin.H{"message": "Login successful", "user_id": userID})
 }
+
+// recordLoginFailed неудачный вход: исполнитель — введённый логин, он не вошёл
+func (h *AuthHandlers) recordLoginFailed(c *gin.Context, userID int64, login, reason string) {
+	h.audit.Record(audit.WithActor(c.Request.Context(), 0, login), audit.Entry{
+		Action:    models.AuditLoginFailed,
+		SubjectID: userID,
+		Subject:   login,
+		After:     map[string]string{"reason": reason},
+	})
+}
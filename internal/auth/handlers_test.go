@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/JSchatten/go-diploma/internal/audit"
+	"github.com/JSchatten/go-diploma/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminLogins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := storage.NewMemoryStorage()
+	h := NewAuthHandlers(store, "secret", audit.NewRecorder(store), []string{"admin"})
+
+	r := gin.New()
+	r.POST("/api/user/register", h.RegisterHandler)
+	r.GET("/api/admin/ping", h.AuthMiddleware, h.AdminMiddleware, func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	// register регистрирует пользователя и возвращает заголовок Authorization
+	register := func(login string) string {
+		req := httptest.NewRequest(http.MethodPost, "/api/user/register",
+			strings.NewReader(`{"login":"`+login+`","password":"password"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Header().Get("Authorization")
+	}
+	ping := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/ping", nil)
+		req.Header.Set("Authorization", token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// логин из списка получает права при регистрации, остальные — нет
+	adminToken := register("admin")
+	userToken := register("user")
+	assert.Equal(t, http.StatusNoContent, ping(adminToken))
+	assert.Equal(t, http.StatusForbidden, ping(userToken))
+
+	// права проверяются на каждый запрос: выдача и отзыв действуют сразу
+	require.NoError(t, store.SetAdmin(context.Background(), "user", true))
+	assert.Equal(t, http.StatusNoContent, ping(userToken))
+	require.NoError(t, store.SetAdmin(context.Background(), "admin", false))
+	assert.Equal(t, http.StatusForbidden, ping(adminToken))
+}
@@ -1,10 +1,14 @@
 package auth
 
 import (
+	"errors"
 	"net/http"
 
+	"github.com/JSchatten/go-diploma/internal/audit"
+	"github.com/JSchatten/go-diploma/internal/storage"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog/log"
 )
 
 // AuthMiddleware проверяет JWT токен в заголовке Authorization
@@ -35,6 +39,36 @@ func (h *AuthHandlers) AuthMiddleware(c *gin.Context) {
 
 	c.Set("user_id", claims.UserID)
 	c.Set("login", claims.Login)
+	c.Request = c.Request.WithContext(audit.WithActor(c.Request.Context(), claims.UserID, claims.Login))
+
+	c.Next()
+}
+
+// AdminMiddleware пропускает только администраторов, ставится после AuthMiddleware.
+// Флаг читается из БД на каждый запрос, чтобы отзыв прав действовал сразу.
+func (h *AuthHandlers) AdminMiddleware(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization required"})
+		return
+	}
+
+	isAdmin, err := h.storage.IsAdmin(c.Request.Context(), userID.(int64))
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			return
+		}
+		log.Error().Err(err).Msg("Failed to check admin rights")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+		return
+	}
+
+	if !isAdmin {
+		log.Warn().Int64("user_id", userID.(int64)).Str("uri", c.Request.RequestURI).Msg("Admin access denied")
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+		return
+	}
 
 	c.Next()
 }
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// AccrualProviderConfig дополнительный провайдер начислений
+type AccrualProviderConfig struct {
+	Name             string   `json:"name"`
+	Type             string   `json:"type"` // accrual или bonus, пусто — API accrual из спецификации
+	URL              string   `json:"url"`
+	Timeout          Duration `json:"timeout"`
+	RateLimit        int      `json:"rate_limit"` // запросов в секунду, 0 — без лимита
+	BreakerThreshold int      `json:"breaker_threshold"`
+	BreakerTimeout   Duration `json:"breaker_timeout"`
+}
+
+// AccrualRouteConfig выбор провайдера по префиксу и/или длине номера заказа
+type AccrualRouteConfig struct {
+	Prefix   string `json:"prefix"`
+	Length   int    `json:"length"`
+	Provider string `json:"provider"`
+}
+
+// AccrualProvidersFile содержимое файла ACCRUAL_PROVIDERS_FILE
+type AccrualProvidersFile struct {
+	Providers []AccrualProviderConfig `json:"providers"`
+	Routes    []AccrualRouteConfig    `json:"routes"`
+}
+
+// Duration time.Duration в JSON строкой вида "3s"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// loadAccrualProviders читает таблицу провайдеров и маршрутов
+func loadAccrualProviders(path string) (*AccrualProvidersFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read accrual providers file: %w", err)
+	}
+
+	var f AccrualProvidersFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse accrual providers file: %w", err)
+	}
+
+	for _, p := range f.Providers {
+		if p.Name == "" || p.URL == "" {
+			return nil, fmt.Errorf("accrual provider requires name and url")
+		}
+	}
+	return &f, nil
+}
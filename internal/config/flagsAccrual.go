@@ -0,0 +1,87 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// AccrualFlags хранит конфигурацию запуска заглушки системы начислений
+type AccrualFlags struct {
+	RunAddress        string        // Адрес и порт запуска HTTP-сервера
+	DatabaseURI       string        // DSN PostgreSQL, пусто — хранение в памяти
+	RequestsPerMinute int           // Лимит GET /api/orders/{number} в минуту (0 — без лимита)
+	ProcessingDelay   time.Duration // Сколько заказ проводит в каждом промежуточном статусе
+}
+
+const (
+	defaultAccrualRunAddress      = "localhost:8081"
+	defaultAccrualProcessingDelay = 1 * time.Second
+)
+
+// InitAccrualFlags инициализирует флаги и переменные окружения accrual
+func InitAccrualFlags() (*AccrualFlags, error) {
+	var (
+		runAddr           = new(string)
+		databaseURI       = new(string)
+		requestsPerMinute = new(int)
+		processingDelay   = new(time.Duration)
+	)
+
+	// Установим значения по умолчанию
+	*runAddr = defaultAccrualRunAddress
+	*processingDelay = defaultAccrualProcessingDelay
+
+	// Читаем переменные окружения (имеют приоритет)
+	if v, exists := os.LookupEnv("RUN_ADDRESS"); exists {
+		*runAddr = v
+	}
+	if v, exists := os.LookupEnv("DATABASE_URI"); exists {
+		*databaseURI = v
+	}
+	if v, exists := os.LookupEnv("REQUESTS_PER_MINUTE"); exists {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REQUESTS_PER_MINUTE: %w", err)
+		}
+		*requestsPerMinute = n
+	}
+	if v, exists := os.LookupEnv("PROCESSING_DELAY"); exists {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PROCESSING_DELAY: %w", err)
+		}
+		*processingDelay = d
+	}
+
+	// Определяем флаги
+	flag.StringVar(runAddr, "a", *runAddr, fmt.Sprintf("Server address and port (default: %s)", defaultAccrualRunAddress))
+	flag.StringVar(databaseURI, "d", *databaseURI, "Database connection URI (empty - in-memory storage)")
+	flag.IntVar(requestsPerMinute, "l", *requestsPerMinute, "Max GET /api/orders/{number} requests per minute (0 - unlimited)")
+	flag.DurationVar(processingDelay, "p", *processingDelay, fmt.Sprintf("Time an order spends in each intermediate status (default: %s)", defaultAccrualProcessingDelay))
+
+	// Парсим флаги
+	flag.Parse()
+
+	if *runAddr == "" {
+		return nil, fmt.Errorf("flag -a (RUN_ADDRESS) requires a non-empty value")
+	}
+
+	if *requestsPerMinute < 0 {
+		return nil, fmt.Errorf("requests per minute (-l, REQUESTS_PER_MINUTE) must not be negative")
+	}
+
+	if *processingDelay <= 0 {
+		return nil, fmt.Errorf("processing delay (-p, PROCESSING_DELAY) must be positive")
+	}
+
+	// Собираем результат
+	return &AccrualFlags{
+		RunAddress:        *runAddr,
+		DatabaseURI:       *databaseURI,
+		RequestsPerMinute: *requestsPerMinute,
+		ProcessingDelay:   *processingDelay,
+	}, nil
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ArchiveFlags конфигурация команды gophermart archive
+type ArchiveFlags struct {
+	DatabaseURI string    // DSN PostgreSQL или sqlite://путь
+	Before      time.Time // Архивировать месяцы журнала раньше этого (начало месяца, UTC)
+	Dir         string    // Каталог файлов архива
+	BatchSize   int       // Сколько записей выгружать за раз
+	Output      string    // Файл отчёта, пусто — stdout
+}
+
+const (
+	defaultArchiveDir       = "ledger-archive"
+	defaultArchiveBatchSize = 1000
+	// defaultArchiveKeepMonths сколько последних месяцев остаётся в журнале по умолчанию
+	defaultArchiveKeepMonths = 12
+)
+
+// InitArchiveFlags разбирает аргументы команды archive
+func InitArchiveFlags(args []string) (*ArchiveFlags, error) {
+	var (
+		databaseURI = new(string)
+		before      = new(string)
+		dir         = new(string)
+		batchSize   = new(int)
+		output      = new(string)
+	)
+
+	// Установим значения по умолчанию
+	now := time.Now().UTC()
+	*before = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).
+		AddDate(0, -defaultArchiveKeepMonths, 0).Format("2006-01")
+	*dir = defaultArchiveDir
+	*batchSize = defaultArchiveBatchSize
+
+	// Читаем переменные окружения (имеют приоритет)
+	if v, exists := os.LookupEnv("DATABASE_URI"); exists {
+		*databaseURI = v
+	}
+
+	// Определяем флаги
+	fs := flag.NewFlagSet("archive", flag.ContinueOnError)
+	fs.StringVar(databaseURI, "d", *databaseURI, "Database connection URI")
+	fs.StringVar(before, "before", *before, fmt.Sprintf("Archive ledger months before YYYY-MM (default: %d months ago)", defaultArchiveKeepMonths))
+	fs.StringVar(dir, "dir", *dir, "Directory for archive files (default: "+defaultArchiveDir+")")
+	fs.IntVar(batchSize, "batch", *batchSize, fmt.Sprintf("Entries exported at once (default: %d)", defaultArchiveBatchSize))
+	fs.StringVar(output, "o", "", "Report file (default: stdout)")
+
+	// Парсим флаги
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *databaseURI == "" {
+		return nil, fmt.Errorf("flag -d (DATABASE_URI) requires a non-empty value")
+	}
+
+	cutoff, err := time.Parse("2006-01", *before)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -before %q, expected YYYY-MM", *before)
+	}
+
+	if *dir == "" {
+		return nil, fmt.Errorf("archive directory (-dir) requires a non-empty value")
+	}
+
+	if *batchSize <= 0 {
+		return nil, fmt.Errorf("batch size (-batch) must be positive")
+	}
+
+	// Собираем результат
+	return &ArchiveFlags{
+		DatabaseURI: *databaseURI,
+		Before:      cutoff,
+		Dir:         *dir,
+		BatchSize:   *batchSize,
+		Output:      *output,
+	}, nil
+}
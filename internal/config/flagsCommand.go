@@ -0,0 +1,48 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// CommandFlags конфигурация команд обслуживания, которым нужна только БД
+type CommandFlags struct {
+	DatabaseURI string   // DSN PostgreSQL или sqlite://путь
+	Output      string   // Файл отчёта, пусто — stdout
+	Args        []string // Аргументы после флагов
+}
+
+// InitCommandFlags разбирает аргументы команды name: -d (DATABASE_URI) и -o
+func InitCommandFlags(name string, args []string) (*CommandFlags, error) {
+	var (
+		databaseURI = new(string)
+		output      = new(string)
+	)
+
+	// Читаем переменные окружения (имеют приоритет)
+	if v, exists := os.LookupEnv("DATABASE_URI"); exists {
+		*databaseURI = v
+	}
+
+	// Определяем флаги
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(databaseURI, "d", *databaseURI, "Database connection URI")
+	fs.StringVar(output, "o", "", "Report file (default: stdout)")
+
+	// Парсим флаги
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *databaseURI == "" {
+		return nil, fmt.Errorf("flag -d (DATABASE_URI) requires a non-empty value")
+	}
+
+	// Собираем результат
+	return &CommandFlags{
+		DatabaseURI: *databaseURI,
+		Output:      *output,
+		Args:        fs.Args(),
+	}, nil
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// ReconcileFlags конфигурация команды gophermart reconcile
+type ReconcileFlags struct {
+	DatabaseURI       string // DSN для подключения к PostgreSQL
+	AccrualSystemAddr string // Адрес системы расчёта по умолчанию
+	RateLimit         int    // Лимит запросов к accrual по умолчанию в секунду (0 — без лимита)
+	BatchSize         int    // Сколько начислений читать из БД за раз
+	Apply             bool   // Записывать корректировки сумм, иначе только отчёт
+	Output            string // Файл отчёта, пусто — stdout
+
+	AccrualProviders []AccrualProviderConfig
+	AccrualRoutes    []AccrualRouteConfig
+}
+
+const defaultReconcileBatchSize = 100
+
+// InitReconcileFlags разбирает аргументы команды reconcile.
+// Провайдеры и маршруты — те же, что у сервера, чтобы заказ сверялся там, где опрашивался.
+func InitReconcileFlags(args []string) (*ReconcileFlags, error) {
+	var (
+		databaseURI       = new(string)
+		accrualSystemAddr = new(string)
+		providersFile     = new(string)
+		rateLimit         = new(int)
+		batchSize         = new(int)
+		apply             = new(bool)
+		output            = new(string)
+	)
+
+	// Установим значения по умолчанию
+	*accrualSystemAddr = defaultAccrualSystemURL
+	*batchSize = defaultReconcileBatchSize
+
+	// Читаем переменные окружения (имеют приоритет)
+	if v, exists := os.LookupEnv("DATABASE_URI"); exists {
+		*databaseURI = v
+	}
+	if v, exists := os.LookupEnv("ACCRUAL_SYSTEM_ADDRESS"); exists {
+		*accrualSystemAddr = v
+	}
+	if v, exists := os.LookupEnv("ACCRUAL_RATE_LIMIT"); exists {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ACCRUAL_RATE_LIMIT: %w", err)
+		}
+		*rateLimit = n
+	}
+	if v, exists := os.LookupEnv("ACCRUAL_PROVIDERS_FILE"); exists {
+		*providersFile = v
+	}
+
+	// Определяем флаги
+	fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	fs.StringVar(databaseURI, "d", *databaseURI, "Database connection URI")
+	fs.StringVar(accrualSystemAddr, "r", *accrualSystemAddr, fmt.Sprintf("Accrual system address (default: %s)", defaultAccrualSystemURL))
+	fs.StringVar(providersFile, "accrual-providers", *providersFile, "JSON file with extra accrual providers and routing by order number")
+	fs.IntVar(rateLimit, "rps", *rateLimit, "Default accrual provider requests per second (0 - unlimited)")
+	fs.IntVar(batchSize, "batch", *batchSize, fmt.Sprintf("Accruals read from database at once (default: %d)", defaultReconcileBatchSize))
+	fs.BoolVar(apply, "apply", false, "Write adjustment operations for amount mismatches")
+	fs.StringVar(output, "o", "", "Report file (default: stdout)")
+
+	// Парсим флаги
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *databaseURI == "" {
+		return nil, fmt.Errorf("flag -d (DATABASE_URI) requires a non-empty value")
+	}
+
+	if *accrualSystemAddr == "" {
+		return nil, fmt.Errorf("flag -r (ACCRUAL_SYSTEM_ADDRESS) requires a non-empty value")
+	}
+
+	if *rateLimit < 0 {
+		return nil, fmt.Errorf("accrual rate limit (-rps, ACCRUAL_RATE_LIMIT) must not be negative")
+	}
+
+	if *batchSize <= 0 {
+		return nil, fmt.Errorf("batch size (-batch) must be positive")
+	}
+
+	providers := &AccrualProvidersFile{}
+	if *providersFile != "" {
+		var err error
+		providers, err = loadAccrualProviders(*providersFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Собираем результат
+	return &ReconcileFlags{
+		DatabaseURI:       *databaseURI,
+		AccrualSystemAddr: *accrualSystemAddr,
+		RateLimit:         *rateLimit,
+		BatchSize:         *batchSize,
+		Apply:             *apply,
+		Output:            *output,
+
+		AccrualProviders: providers.Providers,
+		AccrualRoutes:    providers.Routes,
+	}, nil
+}
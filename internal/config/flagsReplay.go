@@ -0,0 +1,77 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// ReplayFlags конфигурация команды gophermart replay-events
+type ReplayFlags struct {
+	DatabaseURI string // DSN PostgreSQL или sqlite://путь
+	Sink        string // Получатель: stdout, file:путь, http(s)-URL
+	From        int64  // Первое событие
+	To          int64  // Последнее событие включительно, 0 — до последнего
+	BatchSize   int    // Сколько событий доставлять за раз
+}
+
+const defaultReplayBatchSize = 100
+
+// InitReplayFlags разбирает аргументы команды replay-events
+func InitReplayFlags(args []string) (*ReplayFlags, error) {
+	var (
+		databaseURI = new(string)
+		sink        = new(string)
+		from        = new(int64)
+		to          = new(int64)
+		batchSize   = new(int)
+	)
+
+	// Установим значения по умолчанию
+	*sink = "stdout"
+	*from = 1
+	*batchSize = defaultReplayBatchSize
+
+	// Читаем переменные окружения (имеют приоритет)
+	if v, exists := os.LookupEnv("DATABASE_URI"); exists {
+		*databaseURI = v
+	}
+
+	// Определяем флаги
+	fs := flag.NewFlagSet("replay-events", flag.ContinueOnError)
+	fs.StringVar(databaseURI, "d", *databaseURI, "Database connection URI")
+	fs.StringVar(sink, "sink", *sink, "Event sink: stdout, file:<path> or http(s) URL (default: stdout)")
+	fs.Int64Var(from, "from", *from, "First event id (default: 1)")
+	fs.Int64Var(to, "to", 0, "Last event id, inclusive (0 - up to the latest)")
+	fs.IntVar(batchSize, "batch", *batchSize, fmt.Sprintf("Events delivered at once (default: %d)", defaultReplayBatchSize))
+
+	// Парсим флаги
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *databaseURI == "" {
+		return nil, fmt.Errorf("flag -d (DATABASE_URI) requires a non-empty value")
+	}
+
+	if *from <= 0 {
+		return nil, fmt.Errorf("first event id (-from) must be positive")
+	}
+
+	if *to != 0 && *to < *from {
+		return nil, fmt.Errorf("last event id (-to) must not be less than -from")
+	}
+
+	if *batchSize <= 0 {
+		return nil, fmt.Errorf("batch size (-batch) must be positive")
+	}
+
+	// Собираем результат
+	return &ReplayFlags{
+		DatabaseURI: *databaseURI,
+		Sink:        *sink,
+		From:        *from,
+		To:          *to,
+		BatchSize:   *batchSize,
+	}, nil
+}
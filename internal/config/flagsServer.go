@@ -4,20 +4,57 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // ServerFlags хранит конфигурацию запуска сервера
 type ServerFlags struct {
 	RunAddress        string // Адрес и порт запуска HTTP-сервера
 	DatabaseURI       string // DSN для подключения к PostgreSQL
+	MemoryStorage     bool   // Хранить данные в памяти процесса вместо БД (для разработки и тестов)
+	MigrateMode       string // Миграции при запуске: MigrateAuto, MigrateRequire, MigrateSkip
 	AccrualSystemAddr string // Адрес внешней системы расчёта начислений
 	JwtKey            string // JWT
+	PollWorkers       int    // Количество воркеров опроса accrual
+	PollRateLimit     int    // Лимит запросов к accrual по умолчанию в секунду (0 — без лимита)
+
+	BreakerThreshold   int           // Неудачных запросов к accrual подряд до размыкания breaker
+	BreakerOpenTimeout time.Duration // Сколько breaker разомкнут до пробного запроса
+
+	WebhookSecret string // Секрет HMAC push-уведомлений accrual, пусто — только опрос
+
+	StuckAfter time.Duration // Заказ без окончательного статуса дольше — зависший (0 — не проверять)
+
+	IdempotencyTTL time.Duration // Сколько хранится ответ на запрос с Idempotency-Key
+
+	OutboxSinks []string // Получатели доменных событий: stdout, file:путь, http(s)-URL
+
+	AdminLogins []string // Логины администраторов: права выдаются при запуске и при регистрации
+
+	// Дополнительные провайдеры начислений и маршрутизация по номеру заказа
+	AccrualProviders []AccrualProviderConfig
+	AccrualRoutes    []AccrualRouteConfig
 }
 
+// Режимы миграций схемы при запуске сервера
+const (
+	MigrateAuto    = "auto"    // применить недостающие миграции
+	MigrateRequire = "require" // не применять, схема должна быть уже актуальной
+	MigrateSkip    = "skip"    // не трогать и не проверять схему
+)
+
 const (
 	defaultRunAddress       = "localhost:8080"
 	defaultAccrualSystemURL = "http://localhost:8081"
 	defaultJWTKey           = "super-secret-key-please-change-in-production"
+	defaultPollWorkers      = 4
+	defaultPollRateLimit    = 0
+	defaultBreakerThreshold = 5
+	defaultBreakerTimeout   = 30 * time.Second
+	defaultStuckAfter       = 72 * time.Hour
+	defaultIdempotencyTTL   = 24 * time.Hour
 )
 
 // InitServerFlags инициализирует флаги и переменные окружения
@@ -25,14 +62,33 @@ func InitServerFlags() (*ServerFlags, error) {
 	var (
 		runAddr           = new(string)
 		databaseURI       = new(string)
+		memoryStorage     = new(bool)
+		migrateMode       = new(string)
 		accrualSystemAddr = new(string)
 		jwtKey            = new(string)
+		pollWorkers       = new(int)
+		pollRateLimit     = new(int)
+		breakerThreshold  = new(int)
+		breakerTimeout    = new(time.Duration)
+		webhookSecret     = new(string)
+		stuckAfter        = new(time.Duration)
+		providersFile     = new(string)
+		idempotencyTTL    = new(time.Duration)
+		outboxSinks       = new(string)
+		adminLogins       = new(string)
 	)
 
 	// Установим значения по умолчанию
 	*runAddr = defaultRunAddress
+	*migrateMode = MigrateAuto
 	*accrualSystemAddr = defaultAccrualSystemURL
 	*jwtKey = defaultJWTKey
+	*pollWorkers = defaultPollWorkers
+	*pollRateLimit = defaultPollRateLimit
+	*breakerThreshold = defaultBreakerThreshold
+	*breakerTimeout = defaultBreakerTimeout
+	*stuckAfter = defaultStuckAfter
+	*idempotencyTTL = defaultIdempotencyTTL
 
 	// Читаем переменные окружения (имеют приоритет)
 	if v, exists := os.LookupEnv("RUN_ADDRESS"); exists {
@@ -41,18 +97,94 @@ func InitServerFlags() (*ServerFlags, error) {
 	if v, exists := os.LookupEnv("DATABASE_URI"); exists {
 		*databaseURI = v
 	}
+	if v, exists := os.LookupEnv("MEMORY_STORAGE"); exists {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MEMORY_STORAGE: %w", err)
+		}
+		*memoryStorage = b
+	}
+	if v, exists := os.LookupEnv("MIGRATE_MODE"); exists {
+		*migrateMode = v
+	}
 	if v, exists := os.LookupEnv("ACCRUAL_SYSTEM_ADDRESS"); exists {
 		*accrualSystemAddr = v
 	}
 	if v, exists := os.LookupEnv("JWT_KEY"); exists {
 		*jwtKey = v
 	}
+	if v, exists := os.LookupEnv("ACCRUAL_POLL_WORKERS"); exists {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ACCRUAL_POLL_WORKERS: %w", err)
+		}
+		*pollWorkers = n
+	}
+	if v, exists := os.LookupEnv("ACCRUAL_RATE_LIMIT"); exists {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ACCRUAL_RATE_LIMIT: %w", err)
+		}
+		*pollRateLimit = n
+	}
+	if v, exists := os.LookupEnv("ACCRUAL_BREAKER_THRESHOLD"); exists {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ACCRUAL_BREAKER_THRESHOLD: %w", err)
+		}
+		*breakerThreshold = n
+	}
+	if v, exists := os.LookupEnv("ACCRUAL_BREAKER_TIMEOUT"); exists {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ACCRUAL_BREAKER_TIMEOUT: %w", err)
+		}
+		*breakerTimeout = d
+	}
+	if v, exists := os.LookupEnv("ACCRUAL_WEBHOOK_SECRET"); exists {
+		*webhookSecret = v
+	}
+	if v, exists := os.LookupEnv("ACCRUAL_PROVIDERS_FILE"); exists {
+		*providersFile = v
+	}
+	if v, exists := os.LookupEnv("ACCRUAL_STUCK_AFTER"); exists {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ACCRUAL_STUCK_AFTER: %w", err)
+		}
+		*stuckAfter = d
+	}
+	if v, exists := os.LookupEnv("IDEMPOTENCY_TTL"); exists {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IDEMPOTENCY_TTL: %w", err)
+		}
+		*idempotencyTTL = d
+	}
+	if v, exists := os.LookupEnv("OUTBOX_SINKS"); exists {
+		*outboxSinks = v
+	}
+	if v, exists := os.LookupEnv("ADMIN_LOGINS"); exists {
+		*adminLogins = v
+	}
 
 	// Определяем флаги
 	flag.StringVar(runAddr, "a", *runAddr, fmt.Sprintf("Server address and port (default: %s)", defaultRunAddress))
 	flag.StringVar(databaseURI, "d", *databaseURI, "Database connection URI")
+	flag.BoolVar(memoryStorage, "memory", *memoryStorage, "Keep data in process memory instead of database, lost on restart")
+	flag.StringVar(migrateMode, "migrate", *migrateMode, fmt.Sprintf("Schema migrations on startup: %s, %s or %s (default: %s)", MigrateAuto, MigrateRequire, MigrateSkip, MigrateAuto))
 	flag.StringVar(accrualSystemAddr, "r", *accrualSystemAddr, fmt.Sprintf("Accrual system address (default: %s)", defaultAccrualSystemURL))
 	flag.StringVar(jwtKey, "j", *jwtKey, fmt.Sprintf("JWT secret for auth (default: %s), need to change!", defaultJWTKey))
+	flag.IntVar(pollWorkers, "w", *pollWorkers, fmt.Sprintf("Accrual poller workers (default: %d)", defaultPollWorkers))
+	flag.IntVar(pollRateLimit, "rps", *pollRateLimit, "Default accrual provider requests per second (0 - unlimited)")
+	flag.IntVar(breakerThreshold, "breaker-threshold", *breakerThreshold, fmt.Sprintf("Consecutive accrual failures to open circuit breaker (default: %d)", defaultBreakerThreshold))
+	flag.StringVar(providersFile, "accrual-providers", *providersFile, "JSON file with extra accrual providers and routing by order number")
+	flag.DurationVar(stuckAfter, "stuck-after", *stuckAfter, fmt.Sprintf("Mark order stuck without final status after this age, 0 - disabled (default: %s)", defaultStuckAfter))
+	flag.StringVar(webhookSecret, "webhook-secret", *webhookSecret, "HMAC secret for accrual push webhook (empty - webhook disabled)")
+	flag.DurationVar(idempotencyTTL, "idempotency-ttl", *idempotencyTTL, fmt.Sprintf("How long responses to requests with Idempotency-Key are kept (default: %s)", defaultIdempotencyTTL))
+	flag.StringVar(outboxSinks, "outbox-sinks", *outboxSinks, "Comma-separated domain event sinks: stdout, file:<path>, http(s) URL (empty - events are only stored)")
+	flag.StringVar(adminLogins, "admins", *adminLogins, "Comma-separated logins granted admin rights on startup and on registration")
+	flag.DurationVar(breakerTimeout, "breaker-timeout", *breakerTimeout, fmt.Sprintf("Time circuit breaker stays open before probing (default: %s)", defaultBreakerTimeout))
 
 	// Парсим флаги
 	flag.Parse()
@@ -85,11 +217,83 @@ func InitServerFlags() (*ServerFlags, error) {
 		return nil, fmt.Errorf("environment variable ACCRUAL_SYSTEM_ADDRESS is set but empty")
 	}
 
+	if !*memoryStorage && *databaseURI == "" {
+		return nil, fmt.Errorf("database URI (-d, DATABASE_URI) is required unless memory storage (-memory, MEMORY_STORAGE) is enabled")
+	}
+
+	switch *migrateMode {
+	case MigrateAuto, MigrateRequire, MigrateSkip:
+	default:
+		return nil, fmt.Errorf("migrate mode (-migrate, MIGRATE_MODE) must be %s, %s or %s, got %q", MigrateAuto, MigrateRequire, MigrateSkip, *migrateMode)
+	}
+
+	if *pollWorkers <= 0 {
+		return nil, fmt.Errorf("accrual poller workers (-w, ACCRUAL_POLL_WORKERS) must be positive")
+	}
+
+	if *pollRateLimit < 0 {
+		return nil, fmt.Errorf("accrual rate limit (-rps, ACCRUAL_RATE_LIMIT) must not be negative")
+	}
+
+	if *breakerThreshold <= 0 || *breakerTimeout <= 0 {
+		return nil, fmt.Errorf("circuit breaker threshold and timeout must be positive")
+	}
+
+	if *stuckAfter < 0 {
+		return nil, fmt.Errorf("stuck order age (-stuck-after, ACCRUAL_STUCK_AFTER) must not be negative")
+	}
+
+	if *idempotencyTTL <= 0 {
+		return nil, fmt.Errorf("idempotency key TTL (-idempotency-ttl, IDEMPOTENCY_TTL) must be positive")
+	}
+
+	sinks := splitList(*outboxSinks)
+
+	providers := &AccrualProvidersFile{}
+	if *providersFile != "" {
+		var err error
+		providers, err = loadAccrualProviders(*providersFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Собираем результат
 	return &ServerFlags{
 		RunAddress:        *runAddr,
 		DatabaseURI:       *databaseURI,
+		MemoryStorage:     *memoryStorage,
+		MigrateMode:       *migrateMode,
 		AccrualSystemAddr: *accrualSystemAddr,
 		JwtKey:            *jwtKey,
+		PollWorkers:       *pollWorkers,
+		PollRateLimit:     *pollRateLimit,
+
+		BreakerThreshold:   *breakerThreshold,
+		BreakerOpenTimeout: *breakerTimeout,
+
+		WebhookSecret: *webhookSecret,
+
+		StuckAfter: *stuckAfter,
+
+		IdempotencyTTL: *idempotencyTTL,
+
+		OutboxSinks: sinks,
+
+		AdminLogins: splitList(*adminLogins),
+
+		AccrualProviders: providers.Providers,
+		AccrualRoutes:    providers.Routes,
 	}, nil
 }
+
+// splitList значения через запятую без пробелов по краям и пустых
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
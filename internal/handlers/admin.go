@@ -0,0 +1,93 @@
+// internal/handlers/admin.go
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+	"github.com/JSchatten/go-diploma/internal/service"
+	"github.com/JSchatten/go-diploma/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// GetStuckOrdersHandler GET /api/admin/orders/stuck
+func GetStuckOrdersHandler(orderService *service.OrderService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orders, err := orderService.GetStuckOrders(c.Request.Context())
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to load stuck orders")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+			return
+		}
+
+		if len(orders) == 0 {
+			c.Header("Content-Type", "application/json")
+			c.Status(http.StatusNoContent)
+			return
+		}
+
+		c.JSON(http.StatusOK, orders)
+	}
+}
+
+// RequeueOrderHandler POST /api/admin/orders/{number}/requeue
+func RequeueOrderHandler(orderService *service.OrderService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		number := c.Param("number")
+
+		err := orderService.RequeueOrder(c.Request.Context(), number)
+		if err != nil {
+			if errors.Is(err, storage.ErrOrderNotFound) {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "stuck order not found"})
+				return
+			}
+			log.Error().Err(err).Str("order", number).Msg("Failed to requeue order")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+			return
+		}
+
+		log.Info().Int64("admin_id", c.GetInt64("user_id")).Str("order", number).Msg("Order requeued")
+		c.Status(http.StatusOK)
+	}
+}
+
+// ForceOrderStatusHandler POST /api/admin/orders/{number}/status
+func ForceOrderStatusHandler(orderService *service.OrderService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		number := c.Param("number")
+
+		var req models.ForceStatusRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		err := orderService.ForceOrderStatus(c.Request.Context(), number, req)
+		if err != nil {
+			switch {
+			case errors.Is(err, service.ErrInvalidForcedStatus),
+				errors.Is(err, service.ErrReasonRequired),
+				errors.Is(err, service.ErrInvalidSum):
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			case errors.Is(err, storage.ErrOrderNotFound):
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "order not found"})
+			case errors.Is(err, storage.ErrIllegalTransition):
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+			default:
+				log.Error().Err(err).Str("order", number).Msg("Failed to force order status")
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+			}
+			return
+		}
+
+		log.Warn().
+			Int64("admin_id", c.GetInt64("user_id")).
+			Str("order", number).
+			Str("status", string(req.Status)).
+			Str("reason", req.Reason).
+			Msg("Order status forced")
+		c.Status(http.StatusOK)
+	}
+}
@@ -0,0 +1,106 @@
+// internal/handlers/audit.go
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/JSchatten/go-diploma/internal/audit"
+	"github.com/JSchatten/go-diploma/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// GetAuditEventsHandler GET /api/admin/audit
+// Параметры: user_id (исполнитель или тот, кого касается запись), from и to (RFC3339),
+// limit и cursor. Записи по возрастанию id, следующая страница — в X-Next-Cursor.
+func GetAuditEventsHandler(auditLog *audit.Recorder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter, err := parseAuditFilter(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		events, err := auditLog.Events(c.Request.Context(), filter)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to load audit events")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+			return
+		}
+
+		if len(events) == 0 {
+			c.Header("Content-Type", "application/json")
+			c.Status(http.StatusNoContent)
+			return
+		}
+
+		if len(events) == filter.Limit {
+			setNextPage(c, strconv.FormatInt(events[len(events)-1].ID, 10))
+		}
+		c.JSON(http.StatusOK, events)
+	}
+}
+
+// VerifyAuditChainHandler GET /api/admin/audit/verify
+// Проверяет цепочку хэшей всего журнала; разорванная цепочка — 409 с отчётом.
+func VerifyAuditChainHandler(auditLog *audit.Recorder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report, err := auditLog.Verify(c.Request.Context())
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to verify audit chain")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+			return
+		}
+
+		if !report.Valid {
+			log.Error().Int64("broken_at", report.BrokenAt).Str("reason", report.Reason).Msg("Audit chain is broken")
+			c.JSON(http.StatusConflict, report)
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	}
+}
+
+// parseAuditFilter разбирает параметры журнала аудита; без limit — страница по MaxListLimit
+func parseAuditFilter(c *gin.Context) (models.AuditFilter, error) {
+	f := models.AuditFilter{Limit: MaxListLimit}
+
+	if v := c.Query("user_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			return f, fmt.Errorf("%w: user_id must be a positive integer", errInvalidListQuery)
+		}
+		f.UserID = id
+	}
+
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > MaxListLimit {
+			return f, fmt.Errorf("%w: limit must be between 1 and %d", errInvalidListQuery, MaxListLimit)
+		}
+		f.Limit = limit
+	}
+
+	if v := c.Query("cursor"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			return f, fmt.Errorf("%w: %v", errInvalidListQuery, models.ErrInvalidCursor)
+		}
+		f.AfterID = id
+	}
+
+	var err error
+	if f.From, err = parseListTime(c, "from"); err != nil {
+		return f, err
+	}
+	if f.To, err = parseListTime(c, "to"); err != nil {
+		return f, err
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return f, fmt.Errorf("%w: from must be before to", errInvalidListQuery)
+	}
+
+	return f, nil
+}
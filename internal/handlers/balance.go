@@ -25,7 +25,7 @@ func GetBalanceHandler(balanceService *service.BalanceService) gin.HandlerFunc {
 			return
 		}
 
-		log.Debug().Float64("current", current).Float64("withdrawn", withdrawn).Msg("Balance retrieved")
+		log.Debug().Str("current", current.String()).Str("withdrawn", withdrawn.String()).Msg("Balance retrieved")
 		c.JSON(http.StatusOK, gin.H{
 			"current":   current,
 			"withdrawn": withdrawn,
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/JSchatten/go-diploma/internal/accrual"
+	"github.com/gin-gonic/gin"
+)
+
+// HealthHandler состояние зависимостей. Разомкнутый breaker accrual не делает сервис
+// неработоспособным (API пользователей работает), поэтому статус ответа всегда 200,
+// а деградация видна в поле status. Деградацией считается breaker не closed у любого провайдера.
+func HealthHandler(poller *accrual.Poller) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		states := poller.BreakerStates()
+
+		status := "ok"
+		breakers := make(map[string]string, len(states))
+		for name, state := range states {
+			if state != accrual.BreakerClosed {
+				status = "degraded"
+			}
+			breakers[name] = state.String()
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": status,
+			// провайдер по умолчанию — прежнее поле ответа
+			"accrual_breaker":  breakers[accrual.DefaultProviderName],
+			"accrual_breakers": breakers,
+		})
+	}
+}
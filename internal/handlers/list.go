@@ -0,0 +1,107 @@
+// internal/handlers/list.go
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// MaxListLimit наибольший размер страницы списка
+const MaxListLimit = 1000
+
+var errInvalidListQuery = errors.New("invalid list query")
+
+var listStatuses = map[models.Status]bool{
+	models.NewStatus:        true,
+	models.ProcessingStatus: true,
+	models.InvalidStatus:    true,
+	models.ProcessedStatus:  true,
+}
+
+// parseListFilter разбирает параметры списка:
+// limit, cursor, status (через запятую или повтором), from и to (RFC3339), order=asc|desc.
+// Без параметров — весь список по возрастанию, как раньше.
+func parseListFilter(c *gin.Context) (models.ListFilter, error) {
+	var f models.ListFilter
+
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > MaxListLimit {
+			return f, fmt.Errorf("%w: limit must be between 1 and %d", errInvalidListQuery, MaxListLimit)
+		}
+		f.Limit = limit
+	}
+
+	if v := c.Query("cursor"); v != "" {
+		cursor, err := models.DecodeListCursor(v)
+		if err != nil {
+			return f, fmt.Errorf("%w: %v", errInvalidListQuery, err)
+		}
+		f.After = cursor
+	}
+
+	for _, v := range c.QueryArray("status") {
+		for _, s := range strings.Split(v, ",") {
+			status := models.Status(strings.ToUpper(strings.TrimSpace(s)))
+			if !listStatuses[status] {
+				return f, fmt.Errorf("%w: unknown status %q", errInvalidListQuery, s)
+			}
+			f.Statuses = append(f.Statuses, status)
+		}
+	}
+
+	var err error
+	if f.From, err = parseListTime(c, "from"); err != nil {
+		return f, err
+	}
+	if f.To, err = parseListTime(c, "to"); err != nil {
+		return f, err
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return f, fmt.Errorf("%w: from must be before to", errInvalidListQuery)
+	}
+
+	switch c.DefaultQuery("order", "asc") {
+	case "asc":
+	case "desc":
+		f.Desc = true
+	default:
+		return f, fmt.Errorf("%w: order must be asc or desc", errInvalidListQuery)
+	}
+
+	return f, nil
+}
+
+func parseListTime(c *gin.Context, name string) (time.Time, error) {
+	v := c.Query(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s must be RFC3339", errInvalidListQuery, name)
+	}
+	return t, nil
+}
+
+// setNextPage курсор следующей страницы: заголовок X-Next-Cursor и Link rel="next"
+// с теми же параметрами запроса
+func setNextPage(c *gin.Context, cursor string) {
+	if cursor == "" {
+		return
+	}
+
+	query := c.Request.URL.Query()
+	query.Set("cursor", cursor)
+	next := *c.Request.URL
+	next.RawQuery = query.Encode()
+
+	c.Header("X-Next-Cursor", cursor)
+	c.Header("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+}
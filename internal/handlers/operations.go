@@ -0,0 +1,47 @@
+// internal/handlers/operations.go
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/JSchatten/go-diploma/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// GetOperationsHandler история всех операций по счёту с балансом после каждой
+func GetOperationsHandler(balanceService *service.BalanceService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			log.Warn().Msg("User not authenticated")
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		filter, err := parseListFilter(c)
+		if err != nil {
+			log.Debug().Err(err).Msg("Invalid operations query")
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		operations, next, err := balanceService.GetOperations(c.Request.Context(), userID.(int64), filter)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to load operations")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+			return
+		}
+
+		if len(operations) == 0 {
+			log.Debug().Int64("user_id", userID.(int64)).Msg("No operations found")
+			c.Header("Content-Type", "application/json")
+			c.Status(http.StatusNoContent)
+			return
+		}
+
+		log.Info().Int("count", len(operations)).Msg("Operations retrieved")
+		setNextPage(c, next)
+		c.JSON(http.StatusOK, operations)
+	}
+}
@@ -65,7 +65,14 @@ func GetOrdersHandler(orderService *service.OrderService) gin.HandlerFunc {
 			return
 		}
 
-		orders, err := orderService.GetOrders(c.Request.Context(), userID.(int64))
+		filter, err := parseListFilter(c)
+		if err != nil {
+			log.Debug().Err(err).Msg("Invalid orders query")
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		orders, next, err := orderService.GetOrders(c.Request.Context(), userID.(int64), filter)
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to load orders")
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
@@ -80,6 +87,7 @@ func GetOrdersHandler(orderService *service.OrderService) gin.HandlerFunc {
 		}
 
 		log.Info().Int("count", len(orders)).Msg("Orders retrieved")
+		setNextPage(c, next)
 		c.JSON(http.StatusOK, orders)
 	}
 }
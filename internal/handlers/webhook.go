@@ -0,0 +1,101 @@
+// internal/handlers/webhook.go
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/accrual"
+	"github.com/JSchatten/go-diploma/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// AccrualWebhookHandler принимает push-уведомления accrual о статусе заказа.
+// Запрос подписан HMAC по общему секрету, см. accrual.Sign.
+func AccrualWebhookHandler(poller *accrual.Poller, verifier *accrual.WebhookVerifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			log.Warn().Err(err).Msg("Cannot read webhook body")
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "cannot read request body"})
+			return
+		}
+
+		signature := c.GetHeader(accrual.SignatureHeader)
+		err = verifier.Verify(
+			c.Request.Context(),
+			c.GetHeader(accrual.TimestampHeader),
+			signature,
+			body,
+			time.Now(),
+		)
+		switch {
+		case errors.Is(err, accrual.ErrBadSignature), errors.Is(err, accrual.ErrStaleRequest), errors.Is(err, accrual.ErrReplay):
+			log.Warn().Err(err).Str("ip", c.ClientIP()).Msg("Rejected accrual webhook")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			log.Error().Err(err).Msg("Failed to verify accrual webhook")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+			return
+		}
+
+		// подпись уже запомнена: не применили уведомление — освобождаем её,
+		// иначе повтор от accrual получит 401 и статус заказа потеряется
+		applied := false
+		defer func() {
+			if applied {
+				return
+			}
+			if err := verifier.Forget(context.WithoutCancel(c.Request.Context()), signature); err != nil {
+				log.Error().Err(err).Msg("Failed to forget accrual webhook signature")
+			}
+		}()
+
+		var req struct {
+			Order   string      `json:"order"`
+			Status  string      `json:"status"`
+			Accrual json.Number `json:"accrual"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil || req.Order == "" {
+			log.Warn().Err(err).Msg("Invalid webhook payload")
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		status, err := accrual.ParseStatus(req.Status)
+		if err != nil {
+			log.Warn().Err(err).Str("order", req.Order).Msg("Invalid webhook status")
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		amount, err := accrual.ParseAccrual(req.Accrual)
+		if err != nil {
+			log.Warn().Err(err).Str("order", req.Order).Msg("Invalid webhook accrual")
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		err = poller.ApplyStatus(c.Request.Context(), req.Order, status, amount)
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrOrderNotFound):
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "order not found"})
+			case errors.Is(err, storage.ErrIllegalTransition):
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+			default:
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+			}
+			return
+		}
+
+		applied = true
+		c.Status(http.StatusOK)
+	}
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/accrual"
+	"github.com/JSchatten/go-diploma/internal/models"
+	"github.com/JSchatten/go-diploma/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccrualWebhookHandler_RetryAfterFailure(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	poller, err := accrual.NewPoller(store, accrual.Options{
+		Default: accrual.ProviderOptions{Name: accrual.DefaultProviderName, URL: "http://localhost:8081"},
+	})
+	require.NoError(t, err)
+
+	r := gin.New()
+	r.POST("/api/accrual/webhook", AccrualWebhookHandler(poller, accrual.NewWebhookVerifier("secret", store)))
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	body := `{"order":"12345678903","status":"PROCESSED","accrual":500}`
+	send := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/api/accrual/webhook", strings.NewReader(body))
+		req.Header.Set(accrual.TimestampHeader, ts)
+		req.Header.Set(accrual.SignatureHeader, accrual.Sign([]byte("secret"), ts, []byte(body)))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// заказ ещё не загружен: уведомление не применилось
+	assert.Equal(t, http.StatusNotFound, send())
+
+	userID, err := store.SaveUser(ctx, "user", "hash")
+	require.NoError(t, err)
+	require.NoError(t, store.UploadOrder(ctx, &models.BalanceOperation{
+		UserID:      userID,
+		OrderNumber: "12345678903",
+		Status:      models.NewStatus,
+		ProcessedAt: time.Now(),
+	}))
+
+	// повтор того же запроса проходит, после успеха — уже повтор
+	assert.Equal(t, http.StatusOK, send())
+	assert.Equal(t, http.StatusUnauthorized, send())
+
+	order, err := store.GetOrder(ctx, "12345678903")
+	require.NoError(t, err)
+	assert.Equal(t, models.ProcessedStatus, order.Status)
+}
@@ -34,7 +34,7 @@ func WithdrawHandler(balanceService *service.BalanceService) gin.HandlerFunc {
 				log.Warn().Err(err).Msg("Invalid withdrawal data")
 				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			case errors.Is(err, service.ErrInsufficientFunds):
-				log.Warn().Int64("user_id", userID.(int64)).Float64("sum", req.Sum).Msg("Insufficient funds")
+				log.Warn().Int64("user_id", userID.(int64)).Str("sum", req.Sum.String()).Msg("Insufficient funds")
 				c.AbortWithStatusJSON(http.StatusPaymentRequired, gin.H{"error": "Insufficient funds"})
 			default:
 				log.Error().Err(err).Msg("Failed to withdraw")
@@ -43,7 +43,7 @@ func WithdrawHandler(balanceService *service.BalanceService) gin.HandlerFunc {
 			return
 		}
 
-		log.Info().Int64("user_id", userID.(int64)).Float64("sum", req.Sum).Str("order", req.Order).Msg("Withdrawal successful")
+		log.Info().Int64("user_id", userID.(int64)).Str("sum", req.Sum.String()).Str("order", req.Order).Msg("Withdrawal successful")
 		c.Status(http.StatusOK)
 	}
 }
@@ -57,7 +57,14 @@ func GetWithdrawalsHandler(balanceService *service.BalanceService) gin.HandlerFu
 			return
 		}
 
-		withdrawals, err := balanceService.GetWithdrawals(c.Request.Context(), userID.(int64))
+		filter, err := parseListFilter(c)
+		if err != nil {
+			log.Debug().Err(err).Msg("Invalid withdrawals query")
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		withdrawals, next, err := balanceService.GetWithdrawals(c.Request.Context(), userID.(int64), filter)
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to load withdrawals")
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
@@ -72,6 +79,7 @@ func GetWithdrawalsHandler(balanceService *service.BalanceService) gin.HandlerFu
 		}
 
 		log.Info().Int("count", len(withdrawals)).Msg("Withdrawals retrieved")
+		setNextPage(c, next)
 		c.JSON(http.StatusOK, withdrawals)
 	}
 }
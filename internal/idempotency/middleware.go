@@ -0,0 +1,177 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+	"github.com/JSchatten/go-diploma/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// Header заголовок с ключом идемпотентности от клиента
+	Header = "Idempotency-Key"
+	// ReplayedHeader ставится на ответ, повторённый из сохранённого
+	ReplayedHeader = "Idempotent-Replayed"
+
+	// MaxKeyLength предельная длина ключа
+	MaxKeyLength = 255
+	// LockTimeout сколько ключ занят выполняющимся запросом; если экземпляр упал,
+	// не сохранив ответ, после этого запрос можно повторить
+	LockTimeout = time.Minute
+	// CleanupInterval как часто удаляются истёкшие ключи
+	CleanupInterval = time.Hour
+)
+
+// Keys ключи идемпотентности пользователей: ответ на запрос с ключом хранится ttl
+// и повторяется на ретраи клиента вместо повторного выполнения
+type Keys struct {
+	store storage.Storage
+	ttl   time.Duration
+}
+
+func New(store storage.Storage, ttl time.Duration) *Keys {
+	return &Keys{store: store, ttl: ttl}
+}
+
+// Middleware учитывает заголовок Idempotency-Key; запросы без него проходят как есть.
+// Ставится после AuthMiddleware: ключи у каждого пользователя свои.
+// Ответ сохраняется, если он не 5xx: после ошибки сервера запрос можно повторить.
+func (k *Keys) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(Header)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > MaxKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			return
+		}
+
+		userID, exists := c.Get("user_id")
+		if !exists {
+			log.Warn().Msg("User not authenticated")
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		uid := userID.(int64)
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to read request body")
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := c.Request.Context()
+		stored, err := k.store.ReserveIdempotencyKey(ctx, uid, key, fingerprint(c.Request, body), time.Now().Add(LockTimeout))
+		switch {
+		case errors.Is(err, storage.ErrIdempotencyMismatch):
+			log.Warn().Int64("user_id", uid).Str("key", key).Msg("Idempotency key reused with different request")
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, storage.ErrIdempotencyInProgress):
+			log.Debug().Int64("user_id", uid).Str("key", key).Msg("Idempotent request in progress")
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			log.Error().Err(err).Msg("Failed to reserve idempotency key")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal error"})
+			return
+		case stored != nil:
+			log.Info().Int64("user_id", uid).Str("key", key).Int("status", stored.StatusCode).Msg("Idempotent response replayed")
+			replay(c, stored)
+			return
+		}
+
+		// Обёртываем Writer, чтобы сохранить ответ
+		writer := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		// запрос клиента мог уже закончиться, ответ сохраняется всё равно
+		ctx = context.WithoutCancel(ctx)
+		if writer.Status() >= http.StatusInternalServerError {
+			if err := k.store.ReleaseIdempotencyKey(ctx, uid, key); err != nil {
+				log.Error().Err(err).Str("key", key).Msg("Failed to release idempotency key")
+			}
+			return
+		}
+
+		resp := &models.IdempotentResponse{
+			StatusCode:  writer.Status(),
+			ContentType: writer.Header().Get("Content-Type"),
+			Body:        writer.body.Bytes(),
+		}
+		if err := k.store.SaveIdempotentResponse(ctx, uid, key, resp, time.Now().Add(k.ttl)); err != nil {
+			log.Error().Err(err).Str("key", key).Msg("Failed to save idempotent response")
+		}
+	}
+}
+
+// StartCleanup удаляет истёкшие ключи раз в CleanupInterval до отмены ctx
+func (k *Keys) StartCleanup(ctx context.Context) error {
+	ticker := time.NewTicker(CleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			deleted, err := k.store.DeleteExpiredIdempotencyKeys(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to delete expired idempotency keys")
+				continue
+			}
+			log.Debug().Int64("deleted", deleted).Msg("Expired idempotency keys deleted")
+		}
+	}
+}
+
+// fingerprint отпечаток запроса: метод, путь и тело
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// replay отдаёт сохранённый ответ
+func replay(c *gin.Context, resp *models.IdempotentResponse) {
+	c.Header(ReplayedHeader, "true")
+	if resp.ContentType != "" {
+		c.Header("Content-Type", resp.ContentType)
+	}
+	c.Status(resp.StatusCode)
+	if len(resp.Body) > 0 {
+		_, _ = c.Writer.Write(resp.Body)
+	}
+	c.Abort()
+}
+
+// responseRecorder копирует тело ответа для сохранения
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,113 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRouter роутер с ключами идемпотентности поверх счётчика вызовов обработчика
+func newTestRouter(t *testing.T, status int) (*gin.Engine, storage.Storage, int64, *int) {
+	t.Helper()
+
+	store := storage.NewMemoryStorage()
+	userID, err := store.SaveUser(context.Background(), "user", "hash")
+	require.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) { c.Set("user_id", userID) })
+	r.Use(New(store, time.Hour).Middleware())
+
+	calls := new(int)
+	r.POST("/api/user/balance/withdraw", func(c *gin.Context) {
+		*calls++
+		c.JSON(status, gin.H{"call": *calls})
+	})
+	return r, store, userID, calls
+}
+
+func doRequest(r *gin.Engine, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(Header, key)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddleware_ReplaysResponse(t *testing.T) {
+	r, _, _, calls := newTestRouter(t, http.StatusOK)
+
+	first := doRequest(r, "key-1", `{"order":"2377225624","sum":751}`)
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Empty(t, first.Header().Get(ReplayedHeader))
+
+	retry := doRequest(r, "key-1", `{"order":"2377225624","sum":751}`)
+	assert.Equal(t, http.StatusOK, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get(ReplayedHeader))
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, first.Header().Get("Content-Type"), retry.Header().Get("Content-Type"))
+	assert.Equal(t, 1, *calls)
+
+	// другой ключ — другой запрос
+	doRequest(r, "key-2", `{"order":"2377225624","sum":751}`)
+	assert.Equal(t, 2, *calls)
+}
+
+func TestMiddleware_WithoutKey(t *testing.T) {
+	r, _, _, calls := newTestRouter(t, http.StatusOK)
+
+	doRequest(r, "", `{}`)
+	doRequest(r, "", `{}`)
+	assert.Equal(t, 2, *calls)
+}
+
+func TestMiddleware_RejectsDifferentBody(t *testing.T) {
+	r, _, _, calls := newTestRouter(t, http.StatusOK)
+
+	doRequest(r, "key", `{"order":"2377225624","sum":751}`)
+	w := doRequest(r, "key", `{"order":"2377225624","sum":1000}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, 1, *calls)
+}
+
+func TestMiddleware_InProgress(t *testing.T) {
+	r, store, userID, calls := newTestRouter(t, http.StatusOK)
+
+	// тот же запрос ещё выполняется на другом экземпляре
+	req := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", nil)
+	_, err := store.ReserveIdempotencyKey(context.Background(), userID, "key", fingerprint(req, []byte(`{}`)), time.Now().Add(LockTimeout))
+	require.NoError(t, err)
+
+	w := doRequest(r, "key", `{}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Zero(t, *calls)
+}
+
+func TestMiddleware_ServerErrorIsNotSaved(t *testing.T) {
+	r, _, _, calls := newTestRouter(t, http.StatusInternalServerError)
+
+	doRequest(r, "key", `{}`)
+	w := doRequest(r, "key", `{}`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Empty(t, w.Header().Get(ReplayedHeader))
+	assert.Equal(t, 2, *calls)
+}
+
+func TestMiddleware_KeyTooLong(t *testing.T) {
+	r, _, _, calls := newTestRouter(t, http.StatusOK)
+
+	w := doRequest(r, strings.Repeat("k", MaxKeyLength+1), `{}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Zero(t, *calls)
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// LedgerEntry запись журнала двойной записи с проводками, как она выгружается в архив
+type LedgerEntry struct {
+	ID          int64           `json:"id"`
+	EntryType   OperationType   `json:"entry_type"`
+	OrderNumber string          `json:"order_number"`
+	OrderID     int64           `json:"order_id,omitempty"`
+	Reason      string          `json:"reason,omitempty"`
+	PostedAt    time.Time       `json:"posted_at"`
+	Postings    []LedgerPosting `json:"postings"`
+}
+
+// LedgerPosting проводка записи: счёт пользователя (UserID) или системный (Account)
+type LedgerPosting struct {
+	UserID  int64  `json:"user_id,omitempty"`
+	Account string `json:"account,omitempty"`
+	Amount  Money  `json:"amount"`
+}
+
+// ArchivedMonth итог архивации месяца журнала
+type ArchivedMonth struct {
+	Month   time.Time `json:"month"`
+	Entries int       `json:"entries"`
+	File    string    `json:"file"`
+}
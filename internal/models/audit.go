@@ -0,0 +1,86 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// AuditAction действие в журнале аудита
+type AuditAction string
+
+const (
+	AuditUserRegistered     AuditAction = "user.registered"
+	AuditLoginSucceeded     AuditAction = "auth.login"
+	AuditLoginFailed        AuditAction = "auth.login_failed"
+	AuditWithdrawal         AuditAction = "balance.withdraw"
+	AuditWithdrawalRejected AuditAction = "balance.withdraw_rejected"
+	AuditOrderUploaded      AuditAction = "order.uploaded"
+	AuditOrderStatusChanged AuditAction = "order.status_changed"
+	AuditOrderStuck         AuditAction = "order.stuck"
+	AuditOrderRequeued      AuditAction = "order.requeued"
+	AuditOrderStatusForced  AuditAction = "order.status_forced"
+)
+
+// AuditEvent запись журнала аудита. Журнал только дополняется, записи связаны цепочкой
+// хэшей: Hash считается от PrevHash (хэша предыдущей записи) и полей записи, поэтому
+// изменённая или удалённая из середины запись видна при проверке цепочки.
+type AuditEvent struct {
+	ID        int64           `json:"id"`
+	Action    AuditAction     `json:"action"`
+	ActorID   int64           `json:"actor_id,omitempty"`   // пользователь, 0 — система или не вошедший
+	Actor     string          `json:"actor"`                // логин или компонент системы
+	SubjectID int64           `json:"subject_id,omitempty"` // пользователь, чьих данных касается запись
+	Subject   string          `json:"subject,omitempty"`    // объект: номер заказа, логин
+	IP        string          `json:"ip,omitempty"`
+	UserAgent string          `json:"user_agent,omitempty"`
+	RequestID string          `json:"request_id,omitempty"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	CreatedAt time.Time       `json:"created_at"` // с точностью до микросекунд, как в БД
+	PrevHash  string          `json:"prev_hash"`  // пусто у первой записи
+	Hash      string          `json:"hash"`
+}
+
+// ComputeHash SHA-256 (hex) от PrevHash и полей записи кроме ID и Hash.
+// Поля пишутся с длиной, поэтому границы между ними однозначны.
+func (e *AuditEvent) ComputeHash() string {
+	h := sha256.New()
+	for _, field := range []string{
+		e.PrevHash,
+		string(e.Action),
+		strconv.FormatInt(e.ActorID, 10),
+		e.Actor,
+		strconv.FormatInt(e.SubjectID, 10),
+		e.Subject,
+		e.IP,
+		e.UserAgent,
+		e.RequestID,
+		string(e.Before),
+		string(e.After),
+		strconv.FormatInt(e.CreatedAt.UnixMicro(), 10),
+	} {
+		fmt.Fprintf(h, "%d:%s;", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// AuditFilter выборка журнала аудита по возрастанию id
+type AuditFilter struct {
+	UserID  int64     // ActorID или SubjectID, 0 — все пользователи
+	From    time.Time // created_at >= From, нулевое — без границы
+	To      time.Time // created_at < To, нулевое — без границы
+	AfterID int64     // продолжить после этой записи
+	Limit   int       // 0 — без ограничения
+}
+
+// AuditChainReport результат проверки цепочки хэшей журнала аудита
+type AuditChainReport struct {
+	Checked  int    `json:"checked"`
+	Valid    bool   `json:"valid"`
+	BrokenAt int64  `json:"broken_at,omitempty"` // первая запись, которая не сходится с цепочкой
+	Reason   string `json:"reason,omitempty"`
+}
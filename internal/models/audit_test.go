@@ -0,0 +1,53 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditEvent_ComputeHash(t *testing.T) {
+	base := AuditEvent{
+		ID:        7,
+		Action:    AuditWithdrawal,
+		ActorID:   1,
+		Actor:     "user",
+		SubjectID: 1,
+		Subject:   "2377225624",
+		IP:        "10.0.0.1",
+		UserAgent: "curl/8.0",
+		RequestID: "req-1",
+		After:     json.RawMessage(`{"sum":751}`),
+		CreatedAt: time.UnixMicro(1700000000000001),
+		PrevHash:  "abc",
+	}
+	hash := base.ComputeHash()
+	assert.Len(t, hash, 64)
+
+	// id и сохранённый хэш в расчёт не входят
+	same := base
+	same.ID, same.Hash = 8, "other"
+	assert.Equal(t, hash, same.ComputeHash())
+
+	// наносекунды не хранятся в БД и не влияют на хэш
+	same.CreatedAt = base.CreatedAt.Add(999 * time.Nanosecond)
+	assert.Equal(t, hash, same.ComputeHash())
+
+	changes := map[string]func(e *AuditEvent){
+		"prev_hash":  func(e *AuditEvent) { e.PrevHash = "abd" },
+		"action":     func(e *AuditEvent) { e.Action = AuditWithdrawalRejected },
+		"actor_id":   func(e *AuditEvent) { e.ActorID = 2 },
+		"subject":    func(e *AuditEvent) { e.Subject = "12345678903" },
+		"after":      func(e *AuditEvent) { e.After = json.RawMessage(`{"sum":752}`) },
+		"created_at": func(e *AuditEvent) { e.CreatedAt = e.CreatedAt.Add(time.Microsecond) },
+		// перенос символов между соседними полями меняет хэш
+		"boundary": func(e *AuditEvent) { e.Actor, e.IP = "user1", "0.0.0.1" },
+	}
+	for name, change := range changes {
+		changed := base
+		change(&changed)
+		assert.NotEqual(t, hash, changed.ComputeHash(), name)
+	}
+}
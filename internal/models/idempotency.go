@@ -0,0 +1,8 @@
+package models
+
+// IdempotentResponse ответ на запрос с Idempotency-Key, который повторяется на ретраи клиента
+type IdempotentResponse struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
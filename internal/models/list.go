@@ -0,0 +1,70 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListFilter выборка списка заказов или списаний пользователя.
+// Нулевое значение — все строки по возрастанию времени, как раньше.
+// Время строки: у заказов — загрузка (created_at), у списаний и в выписке — processed_at.
+type ListFilter struct {
+	Statuses []Status    // пусто — любой статус
+	From     time.Time   // время строки >= From, нулевое — без границы
+	To       time.Time   // время строки < To, нулевое — без границы
+	Desc     bool        // новые первыми
+	Limit    int         // 0 — без ограничения
+	After    *ListCursor // продолжить после этой строки
+}
+
+// ListCursor позиция в списке: время и id последней выданной строки.
+// Сортировка по (время строки, id), поэтому строки с одинаковым временем не теряются.
+type ListCursor struct {
+	At time.Time
+	ID int64
+}
+
+// CursorAfter курсор на списание или строку выписки op
+func CursorAfter(op *BalanceOperation) *ListCursor {
+	return &ListCursor{At: op.ProcessedAt, ID: op.ID}
+}
+
+// OrderCursorAfter курсор на заказ op: по времени загрузки, которое не меняется со статусом
+func OrderCursorAfter(op *BalanceOperation) *ListCursor {
+	return &ListCursor{At: op.CreatedAt, ID: op.ID}
+}
+
+// Encode непрозрачная строка для клиента; время с точностью до микросекунд, как в БД
+func (c ListCursor) Encode() string {
+	raw := fmt.Sprintf("%d.%d", c.At.UnixMicro(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeListCursor разбирает строку Encode
+func DecodeListCursor(s string) (*ListCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	at, id, ok := strings.Cut(string(raw), ".")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	micro, err := strconv.ParseInt(at, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	opID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || opID <= 0 {
+		return nil, ErrInvalidCursor
+	}
+
+	return &ListCursor{At: time.UnixMicro(micro), ID: opID}, nil
+}
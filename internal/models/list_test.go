@@ -0,0 +1,25 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListCursor(t *testing.T) {
+	at := time.Date(2025, 3, 1, 12, 30, 0, 123456789, time.UTC)
+	encoded := ListCursor{At: at, ID: 42}.Encode()
+
+	got, err := DecodeListCursor(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), got.ID)
+	// в БД время хранится с точностью до микросекунд
+	assert.True(t, got.At.Equal(at.Truncate(time.Microsecond)))
+
+	for _, s := range []string{"", "!!!", "MTIz", "YWJjLjE", "MTIzLjA", "MTIzLi0x"} {
+		_, err := DecodeListCursor(s)
+		assert.ErrorIs(t, err, ErrInvalidCursor, s)
+	}
+}
@@ -0,0 +1,161 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Money сумма баллов в копейках. 1 балл = 1 рубль = 100 копеек.
+// В JSON — число с не более чем двумя знаками после точки, в БД — DECIMAL(10,2).
+type Money int64
+
+var (
+	ErrMoneyFormat    = errors.New("invalid money amount")
+	ErrMoneyPrecision = errors.New("money amount has more than two decimal places")
+)
+
+// ParseMoney строгий разбор суммы из пользовательского ввода:
+// больше двух знаков после точки — ErrMoneyPrecision
+func ParseMoney(s string) (Money, error) {
+	return parseMoney(s, false)
+}
+
+// ParseMoneyRounded разбор суммы от внешних систем (accrual): лишние знаки
+// округляются до копейки, половина — от нуля (0.005 → 0.01, -0.005 → -0.01)
+func ParseMoneyRounded(s string) (Money, error) {
+	return parseMoney(s, true)
+}
+
+func parseMoney(s string, round bool) (Money, error) {
+	neg := false
+	switch {
+	case strings.HasPrefix(s, "-"):
+		neg = true
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+
+	intPart, frac, _ := strings.Cut(s, ".")
+	if intPart == "" || !isDigits(intPart) || !isDigits(frac) {
+		return 0, fmt.Errorf("%w: %q", ErrMoneyFormat, s)
+	}
+
+	roundUp := false
+	if len(frac) > 2 {
+		if !round {
+			// нули в конце точность не добавляют: 1.500 == 1.50
+			if strings.Trim(frac[2:], "0") != "" {
+				return 0, fmt.Errorf("%w: %q", ErrMoneyPrecision, s)
+			}
+		}
+		roundUp = frac[2] >= '5'
+		frac = frac[:2]
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+
+	kop, _ := strconv.ParseInt(frac, 10, 64)
+	if roundUp {
+		kop++
+	}
+
+	// граница по всей сумме в копейках, с копейками и округлением
+	rub, err := strconv.ParseInt(intPart, 10, 64)
+	if err != nil || rub > (math.MaxInt64-kop)/100 {
+		return 0, fmt.Errorf("%w: %q out of range", ErrMoneyFormat, s)
+	}
+
+	v := rub*100 + kop
+	if neg {
+		v = -v
+	}
+	return Money(v), nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String сумма с двумя знаками: "729.98", "-10.00"
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/100, v%100)
+}
+
+// Float64 только для вывода в логи и метрики, не для расчётов
+func (m Money) Float64() float64 {
+	return float64(m) / 100
+}
+
+// MarshalJSON число без лишних нулей: 500, 500.5, 729.98
+func (m Money) MarshalJSON() ([]byte, error) {
+	s := m.String()
+	s = strings.TrimRight(s, "0")
+	s = strings.TrimSuffix(s, ".")
+	return []byte(s), nil
+}
+
+// UnmarshalJSON принимает JSON-число, больше двух знаков после точки — ошибка
+func (m *Money) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if s == "null" {
+		return nil
+	}
+	v, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// ScanNumeric чтение DECIMAL из pgx без промежуточного float64
+func (m *Money) ScanNumeric(n pgtype.Numeric) error {
+	if !n.Valid {
+		return fmt.Errorf("%w: NULL", ErrMoneyFormat)
+	}
+	if n.NaN || n.InfinityModifier != pgtype.Finite {
+		return fmt.Errorf("%w: not a finite number", ErrMoneyFormat)
+	}
+
+	// значение = Int * 10^Exp, переводим в копейки: Int * 10^(Exp+2)
+	v := new(big.Int).Set(n.Int)
+	exp := n.Exp + 2
+	if exp >= 0 {
+		v.Mul(v, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exp)), nil))
+	} else {
+		div := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(-exp)), nil)
+		var rem big.Int
+		v.QuoRem(v, div, &rem)
+		if rem.Sign() != 0 {
+			return fmt.Errorf("%w: %s", ErrMoneyPrecision, n.Int.String())
+		}
+	}
+	if !v.IsInt64() {
+		return fmt.Errorf("%w: out of range", ErrMoneyFormat)
+	}
+
+	*m = Money(v.Int64())
+	return nil
+}
+
+// NumericValue запись в DECIMAL через pgx
+func (m Money) NumericValue() (pgtype.Numeric, error) {
+	return pgtype.Numeric{Int: big.NewInt(int64(m)), Exp: -2, Valid: true}, nil
+}
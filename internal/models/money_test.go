@@ -0,0 +1,130 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+		err  error
+	}{
+		{"0", 0, nil},
+		{"500", 50000, nil},
+		{"500.5", 50050, nil},
+		{"729.98", 72998, nil},
+		{"-10.01", -1001, nil},
+		{"1.500", 150, nil},
+		{"729.985", 0, ErrMoneyPrecision},
+		{"0.001", 0, ErrMoneyPrecision},
+		{"", 0, ErrMoneyFormat},
+		{".5", 0, ErrMoneyFormat},
+		{"1e2", 0, ErrMoneyFormat},
+		{"abc", 0, ErrMoneyFormat},
+		{"99999999999999999999", 0, ErrMoneyFormat},
+		// граница int64 в копейках: 9223372036854775807
+		{"92233720368547758.07", math.MaxInt64, nil},
+		{"-92233720368547758.07", -math.MaxInt64, nil},
+		{"92233720368547758.08", 0, ErrMoneyFormat},
+		{"92233720368547758.99", 0, ErrMoneyFormat},
+		{"92233720368547759", 0, ErrMoneyFormat},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if tt.err != nil {
+			assert.ErrorIs(t, err, tt.err, tt.in)
+			continue
+		}
+		require.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+}
+
+func TestParseMoneyRounded(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+	}{
+		{"729.98", 72998},
+		{"729.984", 72998},
+		{"729.985", 72999},
+		{"0.995", 100},
+		{"-0.005", -1},
+		{"-0.004", 0},
+		{"92233720368547758.065", math.MaxInt64},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseMoneyRounded(tt.in)
+		require.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+
+	// округление вверх выводит за границу int64
+	_, err := ParseMoneyRounded("92233720368547758.075")
+	assert.ErrorIs(t, err, ErrMoneyFormat)
+}
+
+func TestMoneyJSON(t *testing.T) {
+	tests := []struct {
+		m    Money
+		json string
+	}{
+		{0, "0"},
+		{50000, "500"},
+		{50050, "500.5"},
+		{72998, "729.98"},
+		{-1001, "-10.01"},
+	}
+
+	for _, tt := range tests {
+		b, err := json.Marshal(tt.m)
+		require.NoError(t, err)
+		assert.Equal(t, tt.json, string(b))
+
+		var m Money
+		require.NoError(t, json.Unmarshal(b, &m))
+		assert.Equal(t, tt.m, m)
+	}
+
+	var req WithdrawRequest
+	assert.ErrorIs(t, json.Unmarshal([]byte(`{"order":"1","sum":10.001}`), &req), ErrMoneyPrecision)
+	assert.Error(t, json.Unmarshal([]byte(`{"order":"1","sum":"10"}`), &req))
+}
+
+func TestMoneyNumeric(t *testing.T) {
+	tests := []struct {
+		n    pgtype.Numeric
+		want Money
+	}{
+		{pgtype.Numeric{Int: big.NewInt(72998), Exp: -2, Valid: true}, 72998},
+		{pgtype.Numeric{Int: big.NewInt(5), Exp: 2, Valid: true}, 50000},
+		{pgtype.Numeric{Int: big.NewInt(-1001), Exp: -2, Valid: true}, -1001},
+		{pgtype.Numeric{Int: big.NewInt(7299800), Exp: -4, Valid: true}, 72998},
+	}
+
+	for _, tt := range tests {
+		var m Money
+		require.NoError(t, m.ScanNumeric(tt.n))
+		assert.Equal(t, tt.want, m)
+
+		n, err := m.NumericValue()
+		require.NoError(t, err)
+		var back Money
+		require.NoError(t, back.ScanNumeric(n))
+		assert.Equal(t, m, back)
+	}
+
+	var m Money
+	assert.ErrorIs(t, m.ScanNumeric(pgtype.Numeric{Int: big.NewInt(729985), Exp: -3, Valid: true}), ErrMoneyPrecision)
+	assert.Error(t, m.ScanNumeric(pgtype.Numeric{}))
+}
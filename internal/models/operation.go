@@ -10,6 +10,8 @@ type OperationType string
 const (
 	AccrualOp    OperationType = "accrual"
 	WithdrawalOp OperationType = "withdrawal"
+	// AdjustmentOp корректировка начисления по результатам сверки с accrual
+	AdjustmentOp OperationType = "adjustment"
 )
 
 // Status статус обработки операции
@@ -22,38 +24,107 @@ const (
 	ProcessedStatus  Status = "PROCESSED"
 )
 
+// IsFinal INVALID и PROCESSED окончательные, дальше заказ не опрашиваем
+func (s Status) IsFinal() bool {
+	return s == InvalidStatus || s == ProcessedStatus
+}
+
+// CanTransition допустим ли переход статуса заказа from -> to.
+// Повтор того же незавершённого статуса допустим (accrual ещё считает).
+func CanTransition(from, to Status) bool {
+	switch from {
+	case NewStatus:
+		return to == NewStatus || to == ProcessingStatus || to.IsFinal()
+	case ProcessingStatus:
+		return to == ProcessingStatus || to.IsFinal()
+	default:
+		return false
+	}
+}
+
 // BalanceOperation — внутренняя модель операции в БД
 type BalanceOperation struct {
 	ID            int64         `json:"-"`           // не в JSON
 	UserID        int64         `json:"-"`           // не в JSON
 	OrderNumber   string        `json:"-"`           // не в JSON напрямую
-	Amount        float64       `json:"-"`           // хранит знак: + для начислений, - для списаний
+	Amount        Money         `json:"-"`           // хранит знак: + для начислений, - для списаний
 	OperationType OperationType `json:"-"`           // тип операции
 	Status        Status        `json:"status"`      // статус
 	ProcessedAt   time.Time     `json:"uploaded_at"` // RFC3339
 
+	// расписание опроса accrual
+	NextPollAt    time.Time `json:"-"`
+	PollAttempts  int       `json:"-"`
+	LastPollError string    `json:"-"`
+
+	// заказ завис: окончательного статуса нет дольше допустимого, не опрашивается
+	CreatedAt   time.Time  `json:"-"`
+	StuckAt     *time.Time `json:"-"`
+	StuckReason string     `json:"-"`
+
+	// баланс после операции, только в выписке
+	Balance Money `json:"-"`
+
 	//  только для JSON-сериализации
-	Accrual float64 `json:"accrual,omitempty"` // только если начисление > 0
-	Sum     float64 `json:"sum,omitempty"`     // только если списание
+	Accrual Money `json:"accrual,omitempty"` // только если начисление > 0
+	Sum     Money `json:"sum,omitempty"`     // только если списание
 }
 
 // GET /api/user/orders
 type OrderResponse struct {
 	Number     string    `json:"number"`
 	Status     Status    `json:"status"`
-	Accrual    float64   `json:"accrual,omitempty"`
+	Accrual    Money     `json:"accrual,omitempty"`
 	UploadedAt time.Time `json:"uploaded_at"`
 }
 
+// GET /api/user/operations
+type OperationResponse struct {
+	Type        OperationType `json:"type"`
+	Order       string        `json:"order"`
+	Amount      Money         `json:"amount"` // со знаком: + начисление, - списание
+	Status      Status        `json:"status"`
+	Balance     Money         `json:"balance"` // баланс после операции
+	ProcessedAt time.Time     `json:"processed_at"`
+}
+
 // GET /api/user/withdrawals
 type WithdrawalResponse struct {
 	Order       string    `json:"order"`
-	Sum         float64   `json:"sum"`
+	Sum         Money     `json:"sum"`
 	ProcessedAt time.Time `json:"processed_at"`
 }
 
+// GET /api/admin/orders/stuck
+type StuckOrderResponse struct {
+	Number        string    `json:"number"`
+	UserID        int64     `json:"user_id"`
+	Status        Status    `json:"status"`
+	UploadedAt    time.Time `json:"uploaded_at"`
+	StuckAt       time.Time `json:"stuck_at"`
+	Reason        string    `json:"reason"`
+	PollAttempts  int       `json:"poll_attempts"`
+	LastPollError string    `json:"last_poll_error,omitempty"`
+}
+
+// POST /api/admin/orders/{number}/status
+type ForceStatusRequest struct {
+	Status  Status `json:"status"`
+	Accrual Money  `json:"accrual"`
+	Reason  string `json:"reason"`
+}
+
+// BalanceDrift расхождение user_balances с суммой операций по балансу
+type BalanceDrift struct {
+	UserID          int64 `json:"user_id"`
+	Current         Money `json:"current"`
+	Withdrawn       Money `json:"withdrawn"`
+	LedgerCurrent   Money `json:"ledger_current"`
+	LedgerWithdrawn Money `json:"ledger_withdrawn"`
+}
+
 // для POST /api/user/balance/withdraw
 type WithdrawRequest struct {
-	Order string  `json:"order"`
-	Sum   float64 `json:"sum"`
+	Order string `json:"order"`
+	Sum   Money  `json:"sum"`
 }
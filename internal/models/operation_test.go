@@ -0,0 +1,31 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to Status
+		expected bool
+	}{
+		{NewStatus, NewStatus, true},
+		{NewStatus, ProcessingStatus, true},
+		{NewStatus, ProcessedStatus, true},
+		{NewStatus, InvalidStatus, true},
+		{ProcessingStatus, ProcessingStatus, true},
+		{ProcessingStatus, ProcessedStatus, true},
+		{ProcessingStatus, InvalidStatus, true},
+		{ProcessingStatus, NewStatus, false},
+		{ProcessedStatus, ProcessedStatus, false},
+		{ProcessedStatus, ProcessingStatus, false},
+		{InvalidStatus, ProcessedStatus, false},
+		{NewStatus, Status("REGISTERED"), false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, CanTransition(tt.from, tt.to), "%s -> %s", tt.from, tt.to)
+	}
+}
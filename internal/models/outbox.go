@@ -0,0 +1,58 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// EventType тип доменного события
+type EventType string
+
+const (
+	UserRegisteredEvent     EventType = "user.registered"
+	OrderStatusChangedEvent EventType = "order.status_changed"
+	BalanceWithdrawnEvent   EventType = "balance.withdrawn"
+)
+
+// OutboxEvent доменное событие из outbox: пишется в одной транзакции с изменением,
+// которое его вызвало, и доставляется получателям не меньше одного раза
+type OutboxEvent struct {
+	ID        int64           `json:"id"` // по возрастанию в порядке коммитов
+	Type      EventType       `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// EventPayload данные события, тип определяется структурой
+type EventPayload interface {
+	EventType() EventType
+}
+
+// UserRegistered зарегистрирован пользователь
+type UserRegistered struct {
+	UserID int64  `json:"user_id"`
+	Login  string `json:"login"`
+}
+
+func (UserRegistered) EventType() EventType { return UserRegisteredEvent }
+
+// OrderStatusChanged заказ загружен (From пустой) или сменил статус
+type OrderStatusChanged struct {
+	UserID  int64  `json:"user_id"`
+	Order   string `json:"order"`
+	From    Status `json:"from,omitempty"`
+	Status  Status `json:"status"`
+	Accrual Money  `json:"accrual,omitempty"`
+	Reason  string `json:"reason,omitempty"` // ручное завершение администратором
+}
+
+func (OrderStatusChanged) EventType() EventType { return OrderStatusChangedEvent }
+
+// BalanceWithdrawn списание баллов в счёт заказа
+type BalanceWithdrawn struct {
+	UserID int64  `json:"user_id"`
+	Order  string `json:"order"`
+	Sum    Money  `json:"sum"`
+}
+
+func (BalanceWithdrawn) EventType() EventType { return BalanceWithdrawnEvent }
@@ -0,0 +1,166 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/storage"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// DefaultBatchSize сколько событий читается и доставляется за раз
+	DefaultBatchSize = 100
+	// PollInterval пауза, когда новых событий нет
+	PollInterval = time.Second
+	// RetryMax верхняя граница паузы между повторами недоставленной пачки
+	RetryMax = time.Minute
+)
+
+// Relay доставляет события из outbox каждому получателю не меньше одного раза.
+// У каждого получателя своё смещение в БД: он читает события после него, доставляет
+// пачку и только потом сдвигает смещение. Падение между доставкой и записью смещения
+// приводит к повтору пачки.
+type Relay struct {
+	store     storage.Storage
+	sinks     []Sink
+	batchSize int
+}
+
+// NewRelay создаёт relay, batchSize <= 0 — DefaultBatchSize
+func NewRelay(store storage.Storage, sinks []Sink, batchSize int) *Relay {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	return &Relay{store: store, sinks: sinks, batchSize: batchSize}
+}
+
+// Start доставляет события до отмены ctx, каждому получателю — в своей горутине,
+// поэтому недоступный получатель не задерживает остальных
+func (r *Relay) Start(ctx context.Context) error {
+	g, ctx := errgroup.WithContext(ctx)
+	for _, sink := range r.sinks {
+		g.Go(func() error {
+			r.run(ctx, sink)
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// Close закрывает получателей
+func (r *Relay) Close() error {
+	for _, sink := range r.sinks {
+		if err := sink.Close(); err != nil {
+			log.Error().Err(err).Str("sink", sink.Name()).Msg("Failed to close outbox sink")
+		}
+	}
+	return nil
+}
+
+func (r *Relay) run(ctx context.Context, sink Sink) {
+	log.Info().Str("sink", sink.Name()).Msg("Outbox relay started")
+
+	failures := 0
+	for {
+		delivered, err := r.deliverNext(ctx, sink)
+		if ctx.Err() != nil {
+			return
+		}
+
+		var wait time.Duration
+		switch {
+		case err != nil:
+			failures++
+			wait = retryDelay(failures)
+			log.Error().Err(err).Str("sink", sink.Name()).Int("failures", failures).Dur("retry_in", wait).Msg("Outbox delivery failed")
+		case delivered < r.batchSize:
+			failures = 0
+			wait = PollInterval
+		default:
+			// полная пачка — возможно, есть ещё
+			failures = 0
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// deliverNext доставляет получателю следующую пачку после его смещения
+func (r *Relay) deliverNext(ctx context.Context, sink Sink) (int, error) {
+	offset, err := r.store.GetOutboxOffset(ctx, sink.Name())
+	if err != nil {
+		return 0, err
+	}
+
+	events, err := r.store.GetOutboxEvents(ctx, offset, r.batchSize)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	if err := sink.Deliver(ctx, events); err != nil {
+		return 0, err
+	}
+
+	last := events[len(events)-1].ID
+	if err := r.store.SaveOutboxOffset(ctx, sink.Name(), last); err != nil {
+		return 0, err
+	}
+
+	log.Debug().Str("sink", sink.Name()).Int("events", len(events)).Int64("offset", last).Msg("Outbox events delivered")
+	return len(events), nil
+}
+
+// Replay доставляет получателю события с id от from до to включительно (to = 0 — до
+// последнего) пачками по batchSize. Смещение получателя не меняется. Возвращает
+// число доставленных событий.
+func Replay(ctx context.Context, store storage.Storage, sink Sink, from, to int64, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	delivered := 0
+	after := from - 1
+	for {
+		events, err := store.GetOutboxEvents(ctx, after, batchSize)
+		if err != nil {
+			return delivered, err
+		}
+
+		if to > 0 {
+			for i, e := range events {
+				if e.ID > to {
+					events = events[:i]
+					break
+				}
+			}
+		}
+		if len(events) == 0 {
+			return delivered, nil
+		}
+
+		if err := sink.Deliver(ctx, events); err != nil {
+			return delivered, err
+		}
+		delivered += len(events)
+		after = events[len(events)-1].ID
+	}
+}
+
+// retryDelay экспоненциальная пауза: PollInterval * 2^(failures-1), не больше RetryMax
+func retryDelay(failures int) time.Duration {
+	d := PollInterval
+	for i := 1; i < failures; i++ {
+		d *= 2
+		if d >= RetryMax {
+			return RetryMax
+		}
+	}
+	return d
+}
@@ -0,0 +1,145 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+	"github.com/JSchatten/go-diploma/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingSink запоминает доставленные события, fail — ошибка на следующую доставку
+type recordingSink struct {
+	name string
+
+	mu     sync.Mutex
+	events []*models.OutboxEvent
+	fail   error
+}
+
+func (s *recordingSink) Name() string { return s.name }
+
+func (s *recordingSink) Deliver(ctx context.Context, events []*models.OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.fail; err != nil {
+		s.fail = nil
+		return err
+	}
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func (s *recordingSink) Close() error { return nil }
+
+func (s *recordingSink) ids() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]int64, 0, len(s.events))
+	for _, e := range s.events {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+// newStoreWithEvents хранилище с событиями 1..5: регистрация и загрузка четырёх заказов
+func newStoreWithEvents(t *testing.T) storage.Storage {
+	t.Helper()
+	ctx := context.Background()
+
+	store := storage.NewMemoryStorage()
+	userID, err := store.SaveUser(ctx, "user", "hash")
+	require.NoError(t, err)
+	for _, number := range []string{"12345678903", "9278923470", "2377225624", "346436439"} {
+		require.NoError(t, store.UploadOrder(ctx, &models.BalanceOperation{
+			UserID:      userID,
+			OrderNumber: number,
+			Status:      models.NewStatus,
+			ProcessedAt: time.Now(),
+		}))
+	}
+	return store
+}
+
+func TestRelay_DeliverNext(t *testing.T) {
+	ctx := context.Background()
+	store := newStoreWithEvents(t)
+	sink := &recordingSink{name: "test"}
+	relay := NewRelay(store, []Sink{sink}, 2)
+
+	delivered, err := relay.deliverNext(ctx, sink)
+	require.NoError(t, err)
+	assert.Equal(t, 2, delivered)
+
+	// недоставленная пачка не сдвигает смещение и доставляется повторно
+	sink.fail = errors.New("sink is down")
+	_, err = relay.deliverNext(ctx, sink)
+	require.Error(t, err)
+	offset, err := store.GetOutboxOffset(ctx, "test")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), offset)
+
+	for delivered != 0 {
+		delivered, err = relay.deliverNext(ctx, sink)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []int64{1, 2, 3, 4, 5}, sink.ids())
+
+	offset, err = store.GetOutboxOffset(ctx, "test")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), offset)
+}
+
+func TestRelay_StartDeliversToEachSink(t *testing.T) {
+	store := newStoreWithEvents(t)
+	first := &recordingSink{name: "first"}
+	second := &recordingSink{name: "second"}
+
+	// второй получатель уже получил часть событий раньше
+	require.NoError(t, store.SaveOutboxOffset(context.Background(), "second", 3))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- NewRelay(store, []Sink{first, second}, 0).Start(ctx) }()
+
+	assert.Eventually(t, func() bool { return len(first.ids()) == 5 && len(second.ids()) == 2 },
+		3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []int64{4, 5}, second.ids())
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestReplay(t *testing.T) {
+	ctx := context.Background()
+	store := newStoreWithEvents(t)
+
+	sink := &recordingSink{name: "replay"}
+	n, err := Replay(ctx, store, sink, 2, 4, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []int64{2, 3, 4}, sink.ids())
+
+	sink = &recordingSink{name: "replay"}
+	n, err = Replay(ctx, store, sink, 4, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	// смещение получателя не меняется
+	offset, err := store.GetOutboxOffset(ctx, "replay")
+	require.NoError(t, err)
+	assert.Zero(t, offset)
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, PollInterval, retryDelay(1))
+	assert.Equal(t, 4*PollInterval, retryDelay(3))
+	assert.Equal(t, RetryMax, retryDelay(100))
+}
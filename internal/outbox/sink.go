@@ -0,0 +1,164 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+)
+
+// Sink получатель событий. Пачка доставляется целиком или возвращается ошибка,
+// тогда relay повторит её: получатель должен переносить повторы (id события уникален).
+type Sink interface {
+	// Name ключ смещения получателя в outbox_offsets
+	Name() string
+	Deliver(ctx context.Context, events []*models.OutboxEvent) error
+	Close() error
+}
+
+// WebhookTimeout таймаут одного запроса к webhook-получателю
+const WebhookTimeout = 10 * time.Second
+
+// NewSink получатель по строке конфигурации, она же его имя:
+//   - stdout — NDJSON в стандартный вывод;
+//   - file:<путь> — NDJSON, дописывается в файл;
+//   - http(s)://... — POST пачки в NDJSON, успех — ответ 2xx.
+func NewSink(spec string) (Sink, error) {
+	switch {
+	case spec == "stdout":
+		return &WriterSink{name: spec, w: os.Stdout}, nil
+	case strings.HasPrefix(spec, "file:"):
+		path := strings.TrimPrefix(spec, "file:")
+		if path == "" {
+			return nil, fmt.Errorf("outbox sink %q: empty file path", spec)
+		}
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("outbox sink %q: %w", spec, err)
+		}
+		return &FileSink{name: spec, f: f}, nil
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return &WebhookSink{
+			name:   spec,
+			url:    spec,
+			client: &http.Client{Timeout: WebhookTimeout},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown outbox sink %q: want stdout, file:<path> or http(s) URL", spec)
+	}
+}
+
+// NewSinks получатели по списку строк конфигурации
+func NewSinks(specs []string) ([]Sink, error) {
+	sinks := make([]Sink, 0, len(specs))
+	for _, spec := range specs {
+		sink, err := NewSink(spec)
+		if err != nil {
+			for _, s := range sinks {
+				_ = s.Close()
+			}
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// encodeNDJSON пачка событий по одному JSON на строку
+func encodeNDJSON(events []*models.OutboxEvent) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// WriterSink NDJSON в io.Writer (stdout)
+type WriterSink struct {
+	name string
+	w    io.Writer
+}
+
+func NewWriterSink(name string, w io.Writer) *WriterSink {
+	return &WriterSink{name: name, w: w}
+}
+
+func (s *WriterSink) Name() string { return s.name }
+
+func (s *WriterSink) Deliver(ctx context.Context, events []*models.OutboxEvent) error {
+	data, err := encodeNDJSON(events)
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(data)
+	return err
+}
+
+func (s *WriterSink) Close() error { return nil }
+
+// FileSink NDJSON в конец файла; пачка считается доставленной после fsync
+type FileSink struct {
+	name string
+	f    *os.File
+}
+
+func (s *FileSink) Name() string { return s.name }
+
+func (s *FileSink) Deliver(ctx context.Context, events []*models.OutboxEvent) error {
+	data, err := encodeNDJSON(events)
+	if err != nil {
+		return err
+	}
+	if _, err := s.f.Write(data); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+func (s *FileSink) Close() error { return s.f.Close() }
+
+// WebhookSink POST пачки событий в NDJSON
+type WebhookSink struct {
+	name   string
+	url    string
+	client *http.Client
+}
+
+func (s *WebhookSink) Name() string { return s.name }
+
+func (s *WebhookSink) Deliver(ctx context.Context, events []*models.OutboxEvent) error {
+	data, err := encodeNDJSON(events)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s responded %d", s.url, resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *WebhookSink) Close() error { return nil }
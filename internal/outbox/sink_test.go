@@ -0,0 +1,88 @@
+package outbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEvents() []*models.OutboxEvent {
+	return []*models.OutboxEvent{
+		{ID: 1, Type: models.UserRegisteredEvent, Payload: json.RawMessage(`{"user_id":1,"login":"user"}`), CreatedAt: time.Now()},
+		{ID: 2, Type: models.BalanceWithdrawnEvent, Payload: json.RawMessage(`{"user_id":1,"order":"2377225624","sum":751}`), CreatedAt: time.Now()},
+	}
+}
+
+func TestNewSink(t *testing.T) {
+	for _, spec := range []string{"stdout", "http://localhost:9000/events", "https://crm.example.com/hook"} {
+		sink, err := NewSink(spec)
+		require.NoError(t, err, spec)
+		assert.Equal(t, spec, sink.Name())
+	}
+
+	for _, spec := range []string{"", "file:", "kafka://broker:9092", "stderr"} {
+		_, err := NewSink(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestFileSink_AppendsNDJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	ctx := context.Background()
+
+	for range 2 {
+		sink, err := NewSink("file:" + path)
+		require.NoError(t, err)
+		require.NoError(t, sink.Deliver(ctx, testEvents()))
+		require.NoError(t, sink.Close())
+	}
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var ids []int64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e models.OutboxEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		ids = append(ids, e.ID)
+	}
+	require.NoError(t, scanner.Err())
+	assert.Equal(t, []int64{1, 2, 1, 2}, ids)
+}
+
+func TestWebhookSink(t *testing.T) {
+	status := http.StatusOK
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-ndjson", r.Header.Get("Content-Type"))
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	sink, err := NewSink(srv.URL)
+	require.NoError(t, err)
+	defer sink.Close()
+
+	events := testEvents()
+	require.NoError(t, sink.Deliver(context.Background(), events))
+	expected, err := encodeNDJSON(events)
+	require.NoError(t, err)
+	assert.Equal(t, expected, body)
+
+	status = http.StatusServiceUnavailable
+	assert.Error(t, sink.Deliver(context.Background(), events))
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"time"
 
+	"github.com/JSchatten/go-diploma/internal/audit"
 	"github.com/JSchatten/go-diploma/internal/models"
 	"github.com/JSchatten/go-diploma/internal/storage"
 	"github.com/JSchatten/go-diploma/internal/utils"
@@ -18,14 +19,22 @@ var (
 
 type BalanceService struct {
 	storage storage.Storage
+	audit   *audit.Recorder
 }
 
-func NewBalanceService(store storage.Storage) *BalanceService {
-	return &BalanceService{storage: store}
+func NewBalanceService(store storage.Storage, auditLog *audit.Recorder) *BalanceService {
+	return &BalanceService{storage: store, audit: auditLog}
+}
+
+// withdrawalState списание в журнале аудита
+type withdrawalState struct {
+	Order  string       `json:"order"`
+	Sum    models.Money `json:"sum"`
+	Reason string       `json:"reason,omitempty"`
 }
 
 // списывает средства, если достаточно баллов
-func (s *BalanceService) Withdraw(ctx context.Context, userID int64, order string, sum float64) error {
+func (s *BalanceService) Withdraw(ctx context.Context, userID int64, order string, sum models.Money) error {
 	if sum <= 0 {
 		return ErrInvalidSum
 	}
@@ -34,15 +43,6 @@ func (s *BalanceService) Withdraw(ctx context.Context, userID int64, order strin
 		return ErrInvalidOrder
 	}
 
-	current, _, err := s.storage.GetBalance(ctx, userID)
-	if err != nil {
-		return err
-	}
-
-	if current < sum {
-		return ErrInsufficientFunds
-	}
-
 	op := &models.BalanceOperation{
 		UserID:        userID,
 		OrderNumber:   order,
@@ -52,22 +52,46 @@ func (s *BalanceService) Withdraw(ctx context.Context, userID int64, order strin
 		ProcessedAt:   time.Now(),
 	}
 
-	return s.storage.CreateOperation(ctx, op)
+	// баланс проверяется в storage в одной транзакции со списанием
+	err := s.storage.Withdraw(ctx, op)
+	switch {
+	case errors.Is(err, storage.ErrNoMoney):
+		s.audit.Record(ctx, audit.Entry{
+			Action:    models.AuditWithdrawalRejected,
+			SubjectID: userID,
+			Subject:   order,
+			After:     withdrawalState{Order: order, Sum: sum, Reason: "insufficient funds"},
+		})
+		return ErrInsufficientFunds
+	case err != nil:
+		return err
+	}
+
+	s.audit.Record(ctx, audit.Entry{
+		Action:    models.AuditWithdrawal,
+		SubjectID: userID,
+		Subject:   order,
+		After:     withdrawalState{Order: order, Sum: sum},
+	})
+	return nil
 }
 
 // текущий баланс
-func (s *BalanceService) GetBalance(ctx context.Context, userID int64) (float64, float64, error) {
+func (s *BalanceService) GetBalance(ctx context.Context, userID int64) (models.Money, models.Money, error) {
 	return s.storage.GetBalance(ctx, userID)
 }
 
-func (s *BalanceService) GetWithdrawals(ctx context.Context, userID int64) ([]models.WithdrawalResponse, error) {
-	ops, err := s.storage.GetWithdrawalsByUser(ctx, userID)
+// GetWithdrawals списания пользователя по фильтру и курсор следующей страницы
+func (s *BalanceService) GetWithdrawals(ctx context.Context, userID int64, filter models.ListFilter) ([]models.WithdrawalResponse, string, error) {
+	ops, next, err := fetchPage(filter, models.CursorAfter, func(f models.ListFilter) ([]*models.BalanceOperation, error) {
+		return s.storage.GetWithdrawalsByUser(ctx, userID, f)
+	})
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	if len(ops) == 0 {
-		return nil, nil
+		return nil, "", nil
 	}
 
 	var result []models.WithdrawalResponse
@@ -78,5 +102,32 @@ func (s *BalanceService) GetWithdrawals(ctx context.Context, userID int64) ([]mo
 			ProcessedAt: op.ProcessedAt,
 		})
 	}
-	return result, nil
+	return result, next, nil
+}
+
+// GetOperations история операций пользователя с балансом после каждой и курсор следующей страницы
+func (s *BalanceService) GetOperations(ctx context.Context, userID int64, filter models.ListFilter) ([]models.OperationResponse, string, error) {
+	ops, next, err := fetchPage(filter, models.CursorAfter, func(f models.ListFilter) ([]*models.BalanceOperation, error) {
+		return s.storage.GetOperationsByUser(ctx, userID, f)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(ops) == 0 {
+		return nil, "", nil
+	}
+
+	result := make([]models.OperationResponse, 0, len(ops))
+	for _, op := range ops {
+		result = append(result, models.OperationResponse{
+			Type:        op.OperationType,
+			Order:       op.OrderNumber,
+			Amount:      op.Amount,
+			Status:      op.Status,
+			Balance:     op.Balance,
+			ProcessedAt: op.ProcessedAt,
+		})
+	}
+	return result, next, nil
 }
@@ -0,0 +1,27 @@
+package service
+
+import "github.com/JSchatten/go-diploma/internal/models"
+
+// fetchPage выборка страницы по фильтру. С Limit запрашивает на строку больше:
+// лишняя строка есть — есть и следующая страница, курсор указывает на последнюю выданную.
+// Без Limit возвращает все строки и пустой курсор. cursor — курсор на строку списка.
+func fetchPage(filter models.ListFilter, cursor func(*models.BalanceOperation) *models.ListCursor,
+	fetch func(models.ListFilter) ([]*models.BalanceOperation, error)) ([]*models.BalanceOperation, string, error) {
+	if filter.Limit <= 0 {
+		ops, err := fetch(filter)
+		return ops, "", err
+	}
+
+	limit := filter.Limit
+	filter.Limit++
+	ops, err := fetch(filter)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(ops) <= limit {
+		return ops, "", nil
+	}
+
+	ops = ops[:limit]
+	return ops, cursor(ops[limit-1]).Encode(), nil
+}
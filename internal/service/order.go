@@ -3,8 +3,10 @@ package service
 import (
 	"context"
 	"errors"
+	"strings"
 	"time"
 
+	"github.com/JSchatten/go-diploma/internal/audit"
 	"github.com/JSchatten/go-diploma/internal/models"
 	"github.com/JSchatten/go-diploma/internal/storage"
 	"github.com/JSchatten/go-diploma/internal/utils"
@@ -14,14 +16,18 @@ var (
 	ErrInvalidOrderFormat = errors.New("invalid order number format")
 	ErrOrderExists        = errors.New("order already uploaded")
 	ErrOrderBelongsToUser = errors.New("order already uploaded by current user")
+
+	ErrInvalidForcedStatus = errors.New("forced status must be INVALID or PROCESSED")
+	ErrReasonRequired      = errors.New("reason is required")
 )
 
 type OrderService struct {
 	storage storage.Storage
+	audit   *audit.Recorder
 }
 
-func NewOrderService(store storage.Storage) *OrderService {
-	return &OrderService{storage: store}
+func NewOrderService(store storage.Storage, auditLog *audit.Recorder) *OrderService {
+	return &OrderService{storage: store, audit: auditLog}
 }
 
 // UploadOrder загружает номер заказа
@@ -34,18 +40,6 @@ func (s *OrderService) UploadOrder(ctx context.Context, userID int64, number str
 		return ErrInvalidOrderFormat
 	}
 
-	existing, err := s.storage.GetOrder(ctx, number)
-	if err != nil && !errors.Is(err, storage.ErrOrderNotFound) {
-		return err
-	}
-
-	if existing != nil {
-		if existing.UserID == userID {
-			return ErrOrderBelongsToUser
-		}
-		return ErrOrderExists
-	}
-
 	op := &models.BalanceOperation{
 		UserID:        userID,
 		OrderNumber:   number,
@@ -55,18 +49,38 @@ func (s *OrderService) UploadOrder(ctx context.Context, userID int64, number str
 		ProcessedAt:   time.Now(),
 	}
 
-	return s.storage.CreateOperation(ctx, op)
+	// уникальность номера проверяет БД, владелец существующего заказа приходит тем же запросом
+	err := s.storage.UploadOrder(ctx, op)
+	switch {
+	case errors.Is(err, storage.ErrOrderExists):
+		return ErrOrderBelongsToUser
+	case errors.Is(err, storage.ErrOrderMine):
+		return ErrOrderExists
+	case err != nil:
+		return err
+	}
+
+	s.audit.Record(ctx, audit.Entry{
+		Action:    models.AuditOrderUploaded,
+		SubjectID: userID,
+		Subject:   number,
+		After:     audit.OrderState{Status: op.Status},
+	})
+	return nil
 }
 
-// GetOrders возвращает список начислений пользователя
-func (s *OrderService) GetOrders(ctx context.Context, userID int64) ([]models.OrderResponse, error) {
-	ops, err := s.storage.GetAccrualsByUser(ctx, userID)
+// GetOrders возвращает начисления пользователя по фильтру и курсор следующей страницы,
+// пустой — страница последняя
+func (s *OrderService) GetOrders(ctx context.Context, userID int64, filter models.ListFilter) ([]models.OrderResponse, string, error) {
+	ops, next, err := fetchPage(filter, models.OrderCursorAfter, func(f models.ListFilter) ([]*models.BalanceOperation, error) {
+		return s.storage.GetAccrualsByUser(ctx, userID, f)
+	})
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	if len(ops) == 0 {
-		return nil, nil
+		return nil, "", nil
 	}
 
 	var result []models.OrderResponse
@@ -75,8 +89,90 @@ func (s *OrderService) GetOrders(ctx context.Context, userID int64) ([]models.Or
 			Number:     op.OrderNumber,
 			Status:     op.Status,
 			Accrual:    op.Accrual,
-			UploadedAt: op.ProcessedAt,
+			UploadedAt: op.CreatedAt,
 		})
 	}
+	return result, next, nil
+}
+
+// GetStuckOrders зависшие заказы для администратора
+func (s *OrderService) GetStuckOrders(ctx context.Context) ([]models.StuckOrderResponse, error) {
+	ops, err := s.storage.GetStuckOrders(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]models.StuckOrderResponse, 0, len(ops))
+	for _, op := range ops {
+		r := models.StuckOrderResponse{
+			Number:        op.OrderNumber,
+			UserID:        op.UserID,
+			Status:        op.Status,
+			UploadedAt:    op.CreatedAt,
+			Reason:        op.StuckReason,
+			PollAttempts:  op.PollAttempts,
+			LastPollError: op.LastPollError,
+		}
+		if op.StuckAt != nil {
+			r.StuckAt = *op.StuckAt
+		}
+		result = append(result, r)
+	}
 	return result, nil
 }
+
+// RequeueOrder возвращает зависший заказ в опрос
+func (s *OrderService) RequeueOrder(ctx context.Context, number string) error {
+	before := s.auditedOrder(ctx, number)
+	if err := s.storage.RequeueOrder(ctx, number); err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, audit.Entry{
+		Action:    models.AuditOrderRequeued,
+		SubjectID: before.UserID,
+		Subject:   number,
+		Before:    audit.OrderState{Status: before.Status},
+	})
+	return nil
+}
+
+// ForceOrderStatus вручную завершает заказ: только INVALID или PROCESSED, причина обязательна
+func (s *OrderService) ForceOrderStatus(ctx context.Context, number string, req models.ForceStatusRequest) error {
+	if !req.Status.IsFinal() {
+		return ErrInvalidForcedStatus
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		return ErrReasonRequired
+	}
+	if req.Accrual < 0 {
+		return ErrInvalidSum
+	}
+
+	before := s.auditedOrder(ctx, number)
+	if err := s.storage.ForceOrderStatus(ctx, number, req.Status, req.Accrual, req.Reason); err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, audit.Entry{
+		Action:    models.AuditOrderStatusForced,
+		SubjectID: before.UserID,
+		Subject:   number,
+		Before:    audit.OrderState{Status: before.Status, Accrual: before.Accrual},
+		After:     audit.OrderState{Status: req.Status, Accrual: req.Accrual, Reason: req.Reason},
+	})
+	return nil
+}
+
+// auditedOrder заказ до изменения для журнала аудита; не нашёлся — пустой, ошибку
+// вернёт само изменение
+func (s *OrderService) auditedOrder(ctx context.Context, number string) *models.BalanceOperation {
+	if s.audit == nil {
+		return &models.BalanceOperation{}
+	}
+	op, err := s.storage.GetOrder(ctx, number)
+	if err != nil {
+		return &models.BalanceOperation{}
+	}
+	return op
+}
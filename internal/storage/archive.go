@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"strings"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+)
+
+// Архив журнала. Журнал делится на месяцы по posted_at (UTC), в PostgreSQL — партициями
+// journal_entries_pYYYYMM и postings_pYYYYMM. Архивируется самый старый месяц целиком:
+// суммы его проводок переносятся во входящие остатки счетов (ledger_openings),
+// начисленное по заказам — в orders.archived_credit, после чего записи месяца
+// удаляются из журнала (партиции отсоединяются). Балансы и начисления не меняются,
+// выписка начинается с первого неархивного месяца — границы архива (ledger_archive).
+
+// monthRange границы месяца month в UTC: [from, to)
+func monthRange(month time.Time) (from, to time.Time) {
+	month = month.UTC()
+	from = time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return from, from.AddDate(0, 1, 0)
+}
+
+// partitionSuffix суффикс партиций месяца: 202401
+func partitionSuffix(month time.Time) string {
+	from, _ := monthRange(month)
+	return from.Format("200601")
+}
+
+// Префиксы имён партиций журнала в PostgreSQL
+const (
+	journalPartitionPrefix  = "journal_entries_p"
+	postingsPartitionPrefix = "postings_p"
+)
+
+// partitionMonth месяц партиции journal_entries_pYYYYMM; другое имя (партиция по умолчанию) — false
+func partitionMonth(name string) (time.Time, bool) {
+	suffix, ok := strings.CutPrefix(name, journalPartitionPrefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	month, err := time.Parse("200601", suffix)
+	return month, err == nil
+}
+
+// archivedSQL строка o (processed_at) старше границы архива. Месяцы архивируются
+// подряд с самого старого, поэтому граница — конец любого архивного месяца после неё.
+const archivedSQL = `EXISTS (SELECT 1 FROM ledger_archive la WHERE la.archived_until > o.processed_at)`
+
+// finalArchivedSQL заказ o с окончательным статусом старше границы архива. Незавершённый
+// заказ в архив не попадает, сколько бы ему ни было лет: начисление по нему ещё впереди.
+const finalArchivedSQL = `o.status IN ('INVALID', 'PROCESSED') AND ` + archivedSQL
+
+// ledgerEntryRow строка выборки записей журнала с проводками: одна строка на проводку
+type ledgerEntryRow struct {
+	entry   models.LedgerEntry
+	posting models.LedgerPosting
+}
+
+// groupLedgerEntries собирает проводки по записям, строки отсортированы по id записи
+func groupLedgerEntries(rows []ledgerEntryRow) []*models.LedgerEntry {
+	var entries []*models.LedgerEntry
+	for _, r := range rows {
+		if len(entries) == 0 || entries[len(entries)-1].ID != r.entry.ID {
+			e := r.entry
+			entries = append(entries, &e)
+		}
+		last := entries[len(entries)-1]
+		last.Postings = append(last.Postings, r.posting)
+	}
+	return entries
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+)
+
+// auditLockID ключ advisory-блокировки PostgreSQL, под которой дописывается журнал
+// аудита: чтение хэша последней записи и вставка следующей идут по очереди
+const auditLockID = 7301002
+
+// prepareAuditEvent дописывает запись к цепочке после записи с хэшем prevHash:
+// время с точностью БД, PrevHash и Hash
+func prepareAuditEvent(e *models.AuditEvent, prevHash string) {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	e.CreatedAt = e.CreatedAt.Truncate(time.Microsecond)
+	e.PrevHash = prevHash
+	e.Hash = e.ComputeHash()
+}
+
+// nullableID id пользователя для колонки, 0 — NULL
+func nullableID(id int64) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// nullableJSON значение before/after для колонки, пусто — NULL
+func nullableJSON(v json.RawMessage) any {
+	if len(v) == 0 {
+		return nil
+	}
+	return string(v)
+}
+
+// auditJSON before/after из колонки, пусто — нет значения
+func auditJSON(v string) json.RawMessage {
+	if v == "" {
+		return nil
+	}
+	return json.RawMessage(v)
+}
+
+// auditFilter условия выборки журнала аудита после "WHERE id > (AfterID)", начиная с AND,
+// сортировка по id и LIMIT, если задан
+func (q *listQuery) auditFilter(f models.AuditFilter) string {
+	var b strings.Builder
+
+	if f.UserID != 0 {
+		id := q.arg(f.UserID)
+		fmt.Fprintf(&b, " AND (actor_id = %[1]s OR subject_id = %[1]s)", id)
+	}
+	if !f.From.IsZero() {
+		fmt.Fprintf(&b, " AND created_at >= %s", q.arg(q.timeArg(f.From)))
+	}
+	if !f.To.IsZero() {
+		fmt.Fprintf(&b, " AND created_at < %s", q.arg(q.timeArg(f.To)))
+	}
+	b.WriteString(" ORDER BY id")
+	if f.Limit > 0 {
+		b.WriteString(" LIMIT " + q.arg(f.Limit))
+	}
+	return b.String()
+}
@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"github.com/JSchatten/go-diploma/internal/models"
+)
+
+// storedIdempotencyKey живой ключ идемпотентности: пока запрос выполняется, ответа нет
+type storedIdempotencyKey struct {
+	fingerprint string
+	statusCode  *int
+	contentType *string
+	body        []byte
+}
+
+// replay ответ на повтор запроса с занятым ключом
+func (k storedIdempotencyKey) replay(fingerprint string) (*models.IdempotentResponse, error) {
+	if k.fingerprint != fingerprint {
+		return nil, ErrIdempotencyMismatch
+	}
+	if k.statusCode == nil {
+		return nil, ErrIdempotencyInProgress
+	}
+
+	resp := &models.IdempotentResponse{StatusCode: *k.statusCode, Body: k.body}
+	if k.contentType != nil {
+		resp.ContentType = *k.contentType
+	}
+	return resp, nil
+}
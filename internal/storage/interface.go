@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/JSchatten/go-diploma/internal/models"
 )
@@ -13,26 +14,117 @@ type Storage interface {
 	// Пользователи
 	SaveUser(ctx context.Context, login, hash string) (int64, error)
 	GetUserByLogin(ctx context.Context, login string) (int64, string, error)
+	IsAdmin(ctx context.Context, userID int64) (bool, error)
+	// Выдать или снять права администратора, нет пользователя с таким логином — ErrUserNotFound
+	SetAdmin(ctx context.Context, login string, isAdmin bool) error
 
 	// Операции
+	// Записать заказ (OperationType accrual) или списание; рассчитанный заказ сразу начисляется
 	CreateOperation(ctx context.Context, op *models.BalanceOperation) error
-	GetOperationsByUser(ctx context.Context, userID int64) ([]*models.BalanceOperation, error)
+	// Списание: проверка баланса и запись атомарны, параллельные списания пользователя
+	// выполняются по очереди. Не хватает баллов — ErrNoMoney
+	Withdraw(ctx context.Context, op *models.BalanceOperation) error
+	// Выписка: проводки по счёту пользователя (начисления, списания, корректировки) и ещё
+	// не начисленные заказы по тому же фильтру, что и списки. Balance — баланс после
+	// операции по всей истории пользователя; архивные месяцы в выписку не входят,
+	// их итог — входящий остаток
+	GetOperationsByUser(ctx context.Context, userID int64, filter models.ListFilter) ([]*models.BalanceOperation, error)
 
 	// Заказы
+	// Загрузить заказ: nil — новый заказ, ErrOrderExists — уже загружен
+	// этим пользователем, ErrOrderMine — загружен другим пользователем
+	UploadOrder(ctx context.Context, op *models.BalanceOperation) error
 	GetOrder(ctx context.Context, number string) (*models.BalanceOperation, error)
 
 	// Получение
-	GetAccrualsByUser(ctx context.Context, userID int64) ([]*models.BalanceOperation, error)
-	GetWithdrawalsByUser(ctx context.Context, userID int64) ([]*models.BalanceOperation, error)
+	// Заказы и списания пользователя по фильтру: статусы, период, курсор, порядок и LIMIT
+	// выполняются в БД. Нулевой фильтр — все строки по возрастанию processed_at.
+	GetAccrualsByUser(ctx context.Context, userID int64, filter models.ListFilter) ([]*models.BalanceOperation, error)
+	GetWithdrawalsByUser(ctx context.Context, userID int64, filter models.ListFilter) ([]*models.BalanceOperation, error)
 
 	// Баланс
-	GetBalance(ctx context.Context, userID int64) (current, withdrawn float64, err error)
+	// Баланс — проекция проводок журнала, меняется в одной транзакции с ними
+	GetBalance(ctx context.Context, userID int64) (current, withdrawn models.Money, err error)
+	// Пересчитать балансы по проводкам журнала и вернуть расхождения с хранимыми
+	VerifyBalances(ctx context.Context) ([]models.BalanceDrift, error)
 
 	// Для accrual-сервиса
-	// заказы со статусом NEW
-	GetNewOrders(ctx context.Context) ([]*models.BalanceOperation, error)
-	// Обновить статус заказа и начисление
-	UpdateOrderStatus(ctx context.Context, orderNumber string, status models.Status, accrual float64) error
+	// Взять в аренду до limit незавершённых заказов (NEW и PROCESSING), у которых подошло
+	// время опроса. Заказ, арендованный другим экземпляром, не выдаётся, пока не истечёт lease.
+	LeasePendingOrders(ctx context.Context, owner string, limit int, lease time.Duration) ([]*models.BalanceOperation, error)
+	// Записать попытку опроса и снять аренду: счётчик попыток +1, следующий опрос не раньше nextPollAt
+	SchedulePoll(ctx context.Context, orderNumber string, nextPollAt time.Time, lastErr string) error
+	// Снять аренду owner без попытки опроса: провайдер на паузе или poller остановлен
+	ReleaseOrderLease(ctx context.Context, orderNumber, owner string) error
+	// Обновить статус заказа и начисление, переход пишется в историю.
+	// Недопустимый переход (например, из окончательного статуса) — ErrIllegalTransition.
+	// Аренда не снимается: до SchedulePoll время опроса ещё в прошлом, и заказ
+	// взял бы другой экземпляр
+	UpdateOrderStatus(ctx context.Context, orderNumber string, status models.Status, accrual models.Money) error
+
+	// Зависшие заказы
+	// Пометить незавершённые заказы старше maxAge как зависшие, вернуть помеченные
+	MarkStuckOrders(ctx context.Context, maxAge time.Duration) ([]*models.BalanceOperation, error)
+	GetStuckOrders(ctx context.Context) ([]*models.BalanceOperation, error)
+	// Вернуть зависший заказ в опрос со сброшенным счётчиком попыток
+	RequeueOrder(ctx context.Context, orderNumber string) error
+	// Вручную перевести заказ в окончательный статус, причина пишется в историю
+	ForceOrderStatus(ctx context.Context, orderNumber string, status models.Status, accrual models.Money, reason string) error
+
+	// Сверка с accrual
+	// Заказы в статусе PROCESSED с id > afterID по возрастанию id, не больше limit.
+	// Amount — начислено по журналу с учётом уже сделанных корректировок.
+	GetProcessedAccruals(ctx context.Context, afterID int64, limit int) ([]*models.BalanceOperation, error)
+	// Записать в журнал корректировку начисления по заказу orderID на знаковую сумму delta
+	AdjustAccrual(ctx context.Context, orderID int64, delta models.Money, reason string) error
+
+	// Ключи идемпотентности (заголовок Idempotency-Key)
+	// Занять ключ пользователя под запрос с отпечатком fingerprint до lockUntil. Новый или
+	// истёкший ключ — nil, nil; выполненный запрос с тем же отпечатком — его ответ; другой
+	// отпечаток — ErrIdempotencyMismatch; запрос с этим ключом ещё выполняется — ErrIdempotencyInProgress
+	ReserveIdempotencyKey(ctx context.Context, userID int64, key, fingerprint string, lockUntil time.Time) (*models.IdempotentResponse, error)
+	// Сохранить ответ на запрос с ключом, ключ хранится до expiresAt
+	SaveIdempotentResponse(ctx context.Context, userID int64, key string, resp *models.IdempotentResponse, expiresAt time.Time) error
+	// Освободить занятый ключ без ответа, чтобы запрос можно было повторить
+	ReleaseIdempotencyKey(ctx context.Context, userID int64, key string) error
+	// Удалить истёкшие ключи, вернуть число удалённых
+	DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error)
+
+	// Outbox доменных событий: изменения выше пишут события в той же транзакции
+	// События с id > afterID по возрастанию id, не больше limit
+	GetOutboxEvents(ctx context.Context, afterID int64, limit int) ([]*models.OutboxEvent, error)
+	// Id последнего доставленного получателю sink события, 0 — ещё ничего не доставлено
+	GetOutboxOffset(ctx context.Context, sink string) (int64, error)
+	// Смещение только растёт: запоздавшая запись другого экземпляра relay его не откатит
+	SaveOutboxOffset(ctx context.Context, sink string, eventID int64) error
+
+	// Журнал аудита, только дополняется
+	// Дописать запись к цепочке хэшей: заполняет ID, CreatedAt (если не задано), PrevHash и Hash
+	AppendAuditEvent(ctx context.Context, e *models.AuditEvent) error
+	// Записи по фильтру по возрастанию id
+	GetAuditEvents(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEvent, error)
+
+	// Архив журнала по месяцам (UTC): итоги месяца переносятся во входящие остатки счетов
+	// и в начисленное по заказам, балансы не меняются, выписка начинается после архива
+	// Месяцы журнала по возрастанию: в PostgreSQL — партиции, в том числе пустые
+	GetLedgerMonths(ctx context.Context) ([]time.Time, error)
+	// Записи месяца month с проводками, id > afterID по возрастанию id, не больше limit
+	GetLedgerEntries(ctx context.Context, month time.Time, afterID int64, limit int) ([]*models.LedgerEntry, error)
+	// Перенести итоги месяца во входящие остатки и убрать его записи из журнала.
+	// В журнале есть записи раньше month — ErrArchiveNotOldest; записей в месяце
+	// не entries (журнал изменился после выгрузки) — ErrArchiveMismatch
+	ArchiveLedgerMonth(ctx context.Context, month time.Time, entries int) error
+	// Создать недостающие партиции журнала на месяц from и months следующих, вернуть
+	// имена созданных. Без партиционирования (SQLite, память) ничего не делает.
+	EnsureLedgerPartitions(ctx context.Context, from time.Time, months int) ([]string, error)
+
+	// Подписи принятых push-уведомлений accrual, общие для всех экземпляров
+	// Запомнить подпись до expiresAt. Подпись уже есть и не истекла — ErrWebhookReplay
+	SaveWebhookSignature(ctx context.Context, signature string, expiresAt time.Time) error
+	// Забыть подпись: уведомление не применилось, повтор от accrual должен пройти
+	DeleteWebhookSignature(ctx context.Context, signature string) error
+	// Удалить истёкшие подписи, вернуть число удалённых
+	DeleteExpiredWebhookSignatures(ctx context.Context) (int64, error)
 
 	// Миграция
 	Migrate(ctx context.Context) error
@@ -46,4 +138,15 @@ var (
 	ErrOrderExists   = errors.New("order already exists")
 	ErrOrderMine     = errors.New("order belongs to another user")
 	ErrOrderNotFound = errors.New("order not found")
+
+	ErrIllegalTransition = errors.New("illegal order status transition")
+	ErrAccrualExists     = errors.New("order already has an accrual")
+
+	ErrWebhookReplay = errors.New("webhook signature already seen")
+
+	ErrIdempotencyMismatch   = errors.New("idempotency key reused with different request")
+	ErrIdempotencyInProgress = errors.New("request with idempotency key is in progress")
+
+	ErrArchiveNotOldest = errors.New("ledger has entries before the archived month")
+	ErrArchiveMismatch  = errors.New("ledger month changed since export")
 )
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+)
+
+// Журнал двойной записи. Каждое движение баллов — запись journal_entries и две проводки
+// postings на одну сумму с разными знаками: по счёту пользователя и по системному счёту.
+// Сумма проводок записи равна нулю, баланс счёта — сумма его проводок, user_balances —
+// их проекция, которая обновляется в той же транзакции. Журнал только дополняется:
+// расхождение исправляется новой записью (корректировкой), а не изменением старой.
+
+// Системные счета
+const (
+	// accrualSourceAccount источник начислений: в минусе на всё, что начислено пользователям
+	accrualSourceAccount = "accrual_source"
+	// redemptionSinkAccount сток списаний: всё, что пользователи потратили
+	redemptionSinkAccount = "redemption_sink"
+)
+
+// ledgerEntry запись журнала: amount переходит на счёт пользователя с системного счёта,
+// отрицательная сумма — со счёта пользователя на системный
+type ledgerEntry struct {
+	entryType   models.OperationType
+	userID      int64
+	orderNumber string
+	orderID     int64 // заказ начисления или корректировки, 0 у списания
+	reason      string
+	amount      models.Money
+	postedAt    time.Time
+}
+
+// counterAccount системный счёт второй проводки
+func (e ledgerEntry) counterAccount() string {
+	if e.entryType == models.WithdrawalOp {
+		return redemptionSinkAccount
+	}
+	return accrualSourceAccount
+}
+
+// withdrawn изменение суммы списаний пользователя в user_balances
+func (e ledgerEntry) withdrawn() models.Money {
+	if e.entryType == models.WithdrawalOp {
+		return -e.amount
+	}
+	return 0
+}
+
+// orderCreditedSQL начислено по заказу o с учётом корректировок: сумма проводок
+// по счёту владельца и перенесённое из архива. Пока заказ не рассчитан, проводок нет — 0.
+const orderCreditedSQL = `o.archived_credit + COALESCE((
+	SELECT SUM(p.amount) FROM journal_entries e
+	JOIN postings p ON p.entry_id = e.id
+	JOIN accounts a ON a.id = p.account_id
+	WHERE e.order_id = o.id AND a.user_id = o.user_id
+), 0)`
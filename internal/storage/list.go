@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+)
+
+// Колонки времени, по которым фильтруются, сортируются и листаются списки
+const (
+	// listByProcessedAt время записи журнала: списания и выписка
+	listByProcessedAt = "processed_at"
+	// listByCreatedAt время загрузки заказа: processed_at заказа меняется при каждой
+	// смене статуса, и заказ сдвигался бы относительно курсора
+	listByCreatedAt = "created_at"
+)
+
+// listQuery дописывает к выборке условия, сортировку и LIMIT по models.ListFilter.
+// Плейсхолдеры нумерованные: $N в PostgreSQL, ?N в SQLite.
+type listQuery struct {
+	prefix     string
+	args       []any
+	timeArg    func(time.Time) any // время в том виде, в котором хранится в БД
+	timeColumn string              // колонка времени списка, listByProcessedAt или listByCreatedAt
+}
+
+func newPSQLListQuery(args ...any) *listQuery {
+	return &listQuery{prefix: "$", args: args, timeArg: func(t time.Time) any { return t }, timeColumn: listByProcessedAt}
+}
+
+func newSQLiteListQuery(args ...any) *listQuery {
+	return &listQuery{prefix: "?", args: args, timeArg: func(t time.Time) any { return unixMicro(t) }, timeColumn: listByProcessedAt}
+}
+
+// by список по колонке времени column
+func (q *listQuery) by(column string) *listQuery {
+	q.timeColumn = column
+	return q
+}
+
+func (q *listQuery) arg(v any) string {
+	q.args = append(q.args, v)
+	return fmt.Sprintf("%s%d", q.prefix, len(q.args))
+}
+
+// where условия фильтра и курсора, начиная с AND; alias — префикс колонок таблицы операций
+func (q *listQuery) where(f models.ListFilter, alias string) string {
+	var b strings.Builder
+
+	if len(f.Statuses) > 0 {
+		placeholders := make([]string, len(f.Statuses))
+		for i, status := range f.Statuses {
+			placeholders[i] = q.arg(string(status))
+		}
+		fmt.Fprintf(&b, " AND %sstatus IN (%s)", alias, strings.Join(placeholders, ", "))
+	}
+	if !f.From.IsZero() {
+		fmt.Fprintf(&b, " AND %s%s >= %s", alias, q.timeColumn, q.arg(q.timeArg(f.From)))
+	}
+	if !f.To.IsZero() {
+		fmt.Fprintf(&b, " AND %s%s < %s", alias, q.timeColumn, q.arg(q.timeArg(f.To)))
+	}
+	if f.After != nil {
+		cmp := ">"
+		if f.Desc {
+			cmp = "<"
+		}
+		at := q.arg(q.timeArg(f.After.At))
+		fmt.Fprintf(&b, " AND (%[1]s%[5]s %[2]s %[3]s OR (%[1]s%[5]s = %[3]s AND %[1]sid %[2]s %[4]s))",
+			alias, cmp, at, q.arg(f.After.ID), q.timeColumn)
+	}
+	return b.String()
+}
+
+// orderLimit сортировка по (колонка времени, id) и LIMIT, если задан
+func (q *listQuery) orderLimit(f models.ListFilter, alias string) string {
+	dir := "ASC"
+	if f.Desc {
+		dir = "DESC"
+	}
+	s := fmt.Sprintf(" ORDER BY %[1]s%[3]s %[2]s, %[1]sid %[2]s", alias, dir, q.timeColumn)
+	if f.Limit > 0 {
+		s += " LIMIT " + q.arg(f.Limit)
+	}
+	return s
+}
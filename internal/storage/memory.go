@@ -0,0 +1,1099 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+)
+
+// MemoryStorage хранилище в памяти для тестов и локальных демо.
+// Семантика та же, что у PSQLStorage: ошибки, фильтры по статусу, порядок выдачи.
+// Все операции выполняются под одним мьютексом, поэтому атомарны.
+type MemoryStorage struct {
+	mu sync.Mutex
+
+	nextUserID int64
+	users      map[int64]*memUser
+	logins     map[string]int64
+
+	nextOpID int64                // общий для заказов и записей журнала, как operation_id_seq
+	orders   []*memOrder          // по возрастанию id
+	byNumber map[string]*memOrder // заказы по номеру
+	entries  []*memEntry          // журнал, только дополняется
+	balances map[int64]*memBalance
+
+	openings      map[int64]*memBalance // входящие остатки счетов пользователей из архива
+	archivedUntil time.Time             // граница архива журнала
+
+	idempotencyKeys   map[memIdempotencyID]*memIdempotencyKey
+	webhookSignatures map[string]time.Time // подпись -> до какого момента помним
+
+	events        []*models.OutboxEvent // outbox, по возрастанию id
+	outboxOffsets map[string]int64
+
+	auditEvents []*models.AuditEvent // журнал аудита, только дополняется
+}
+
+type memUser struct {
+	login   string
+	hash    string
+	isAdmin bool
+}
+
+// memOrder строка orders; op.Amount — начисление по данным accrual
+type memOrder struct {
+	op models.BalanceOperation
+
+	leaseOwner string
+	leaseUntil time.Time
+	requeuedAt time.Time
+
+	archivedCredit  models.Money // начислено в архивных месяцах
+	accrualArchived bool
+}
+
+// memEntry запись journal_entries с её проводками
+type memEntry struct {
+	id        int64
+	entryType models.OperationType
+	orderNum  string
+	orderID   int64
+	reason    string
+	postedAt  time.Time
+	postings  []memPosting
+}
+
+// memPosting проводка: по счёту пользователя (userID) или системному (code)
+type memPosting struct {
+	userID int64
+	code   string
+	amount models.Money
+}
+
+type memIdempotencyID struct {
+	userID int64
+	key    string
+}
+
+// memIdempotencyKey строка idempotency_keys; resp nil, пока запрос выполняется
+type memIdempotencyKey struct {
+	fingerprint string
+	resp        *models.IdempotentResponse
+	expiresAt   time.Time
+}
+
+type memBalance struct {
+	current   models.Money
+	withdrawn models.Money
+	version   int64
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		users:    make(map[int64]*memUser),
+		logins:   make(map[string]int64),
+		byNumber: make(map[string]*memOrder),
+		balances: make(map[int64]*memBalance),
+		openings: make(map[int64]*memBalance),
+
+		idempotencyKeys:   make(map[memIdempotencyID]*memIdempotencyKey),
+		webhookSignatures: make(map[string]time.Time),
+		outboxOffsets:     make(map[string]int64),
+	}
+}
+
+func (s *MemoryStorage) Close() error {
+	return nil
+}
+
+// Migrate схема в памяти не нужна
+func (s *MemoryStorage) Migrate(ctx context.Context) error {
+	return nil
+}
+
+// --- Users ---
+
+func (s *MemoryStorage) SaveUser(ctx context.Context, login, hash string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.logins[login]; exists {
+		return 0, ErrUserExists
+	}
+
+	s.nextUserID++
+	s.users[s.nextUserID] = &memUser{login: login, hash: hash}
+	s.logins[login] = s.nextUserID
+	s.emit(models.UserRegistered{UserID: s.nextUserID, Login: login})
+	return s.nextUserID, nil
+}
+
+func (s *MemoryStorage) GetUserByLogin(ctx context.Context, login string) (int64, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.logins[login]
+	if !ok {
+		return 0, "", ErrUserNotFound
+	}
+	return id, s.users[id].hash, nil
+}
+
+func (s *MemoryStorage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return false, ErrUserNotFound
+	}
+	return u.isAdmin, nil
+}
+
+func (s *MemoryStorage) SetAdmin(ctx context.Context, login string, isAdmin bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.logins[login]
+	if !ok {
+		return ErrUserNotFound
+	}
+	s.users[id].isAdmin = isAdmin
+	return nil
+}
+
+// --- Operations ---
+
+func (s *MemoryStorage) CreateOperation(ctx context.Context, op *models.BalanceOperation) error {
+	switch op.OperationType {
+	case models.WithdrawalOp:
+		return s.Withdraw(ctx, op)
+	case models.AccrualOp:
+	default:
+		return fmt.Errorf("create operation: unsupported type %q", op.OperationType)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[op.UserID]; !ok {
+		return ErrUserNotFound
+	}
+	if _, exists := s.byNumber[op.OrderNumber]; exists {
+		return ErrOrderExists
+	}
+
+	row := s.insertOrder(op)
+	if op.Status == models.ProcessedStatus {
+		s.post(ledgerEntry{
+			entryType:   models.AccrualOp,
+			userID:      op.UserID,
+			orderNumber: op.OrderNumber,
+			orderID:     row.op.ID,
+			amount:      op.Amount,
+			postedAt:    op.ProcessedAt,
+		})
+	}
+	s.emit(orderEvent(op.UserID, op.OrderNumber, "", op.Status, op.Amount, ""))
+	return nil
+}
+
+func (s *MemoryStorage) UploadOrder(ctx context.Context, op *models.BalanceOperation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.byNumber[op.OrderNumber]; ok {
+		if existing.op.UserID == op.UserID {
+			return ErrOrderExists
+		}
+		return ErrOrderMine
+	}
+	if _, ok := s.users[op.UserID]; !ok {
+		return ErrUserNotFound
+	}
+
+	order := *op
+	order.Amount = 0
+	s.insertOrder(&order)
+	op.ID = order.ID
+	s.emit(orderEvent(op.UserID, op.OrderNumber, "", op.Status, 0, ""))
+	return nil
+}
+
+func (s *MemoryStorage) Withdraw(ctx context.Context, op *models.BalanceOperation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[op.UserID]; !ok {
+		return ErrUserNotFound
+	}
+
+	var current models.Money
+	if b, ok := s.balances[op.UserID]; ok {
+		current = b.current
+	}
+	if current < -op.Amount { // op.Amount отрицательное
+		return ErrNoMoney
+	}
+
+	op.ID = s.post(ledgerEntry{
+		entryType:   models.WithdrawalOp,
+		userID:      op.UserID,
+		orderNumber: op.OrderNumber,
+		amount:      op.Amount,
+		postedAt:    op.ProcessedAt,
+	})
+	s.emit(models.BalanceWithdrawn{UserID: op.UserID, Order: op.OrderNumber, Sum: -op.Amount})
+	return nil
+}
+
+// insertOrder добавляет заказ, как INSERT с умолчаниями колонок. Вызывать под s.mu
+func (s *MemoryStorage) insertOrder(op *models.BalanceOperation) *memOrder {
+	s.nextOpID++
+	op.ID = s.nextOpID
+
+	now := time.Now()
+	row := &memOrder{op: *op}
+	row.op.OperationType = models.AccrualOp
+	row.op.CreatedAt = now
+	row.op.NextPollAt = now
+	row.op.Accrual, row.op.Sum = 0, 0
+
+	s.orders = append(s.orders, row)
+	s.byNumber[op.OrderNumber] = row
+	return row
+}
+
+// --- Ledger ---
+
+// post записывает запись журнала с двумя проводками и обновляет баланс.
+// Нулевая сумма не пишется. Вызывать под s.mu
+func (s *MemoryStorage) post(e ledgerEntry) int64 {
+	if e.amount == 0 {
+		return 0
+	}
+
+	s.nextOpID++
+	s.entries = append(s.entries, &memEntry{
+		id:        s.nextOpID,
+		entryType: e.entryType,
+		orderNum:  e.orderNumber,
+		orderID:   e.orderID,
+		reason:    e.reason,
+		postedAt:  e.postedAt,
+		postings: []memPosting{
+			{userID: e.userID, amount: e.amount},
+			{code: e.counterAccount(), amount: -e.amount},
+		},
+	})
+	s.addBalance(e.userID, e.amount, e.withdrawn())
+	return s.nextOpID
+}
+
+// addBalance изменение user_balances. Вызывать под s.mu
+func (s *MemoryStorage) addBalance(userID int64, current, withdrawn models.Money) {
+	if current == 0 && withdrawn == 0 {
+		return
+	}
+	b, ok := s.balances[userID]
+	if !ok {
+		b = &memBalance{}
+		s.balances[userID] = b
+	}
+	b.current += current
+	b.withdrawn += withdrawn
+	b.version++
+}
+
+// credited начислено по заказу с учётом корректировок — сумма проводок по счёту владельца
+// и перенесённое из архива. Второй результат — есть ли запись начисления. Вызывать под s.mu
+func (s *MemoryStorage) credited(row *memOrder) (models.Money, bool) {
+	amount := row.archivedCredit
+	accrued := row.accrualArchived
+	for _, e := range s.entries {
+		if e.orderID != row.op.ID {
+			continue
+		}
+		if e.entryType == models.AccrualOp {
+			accrued = true
+		}
+		for _, p := range e.postings {
+			if p.userID == row.op.UserID {
+				amount += p.amount
+			}
+		}
+	}
+	return amount, accrued
+}
+
+// userMoves проводки по счёту пользователя как операции выписки. Вызывать под s.mu
+func (s *MemoryStorage) userMoves(userID int64, entryType models.OperationType) []*models.BalanceOperation {
+	var ops []*models.BalanceOperation
+	for _, e := range s.entries {
+		if entryType != "" && e.entryType != entryType {
+			continue
+		}
+		for _, p := range e.postings {
+			if p.userID != userID {
+				continue
+			}
+			ops = append(ops, withJSONFields(models.BalanceOperation{
+				ID:            e.id,
+				UserID:        userID,
+				OrderNumber:   e.orderNum,
+				Amount:        p.amount,
+				OperationType: e.entryType,
+				Status:        models.ProcessedStatus,
+				ProcessedAt:   e.postedAt,
+			}))
+		}
+	}
+	return ops
+}
+
+// withJSONFields копия операции с заполненными Accrual/Sum, как при чтении из БД
+func withJSONFields(op models.BalanceOperation) *models.BalanceOperation {
+	if op.Amount > 0 {
+		op.Accrual = op.Amount
+	} else if op.Amount < 0 {
+		op.Sum = -op.Amount
+	}
+	return &op
+}
+
+// GetOperationsByUser проводки пользователя и заказы без начисления с нулевой суммой
+// по времени загрузки; баланс после каждой операции считается по всей истории до фильтра
+// от входящего остатка
+func (s *MemoryStorage) GetOperationsByUser(ctx context.Context, userID int64, filter models.ListFilter) ([]*models.BalanceOperation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ops := s.userMoves(userID, "")
+	for _, row := range s.orders {
+		if row.op.UserID != userID {
+			continue
+		}
+		if _, accrued := s.credited(row); accrued || row.op.Status.IsFinal() && row.op.ProcessedAt.Before(s.archivedUntil) {
+			continue
+		}
+		op := row.op
+		op.Amount = 0
+		op.ProcessedAt = op.CreatedAt
+		ops = append(ops, &op)
+	}
+
+	var balance models.Money
+	if b, ok := s.openings[userID]; ok {
+		balance = b.current
+	}
+	for _, op := range applyListFilter(ops, models.ListFilter{}, processedAt) {
+		balance += op.Amount
+		op.Balance = balance
+	}
+	return applyListFilter(ops, filter, processedAt), nil
+}
+
+// GetOrder Amount — начисление по данным accrual без корректировок
+func (s *MemoryStorage) GetOrder(ctx context.Context, number string) (*models.BalanceOperation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, ok := s.byNumber[number]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	return withJSONFields(row.op), nil
+}
+
+func (s *MemoryStorage) GetAccrualsByUser(ctx context.Context, userID int64, filter models.ListFilter) ([]*models.BalanceOperation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ops []*models.BalanceOperation
+	for _, row := range s.orders {
+		if row.op.UserID != userID {
+			continue
+		}
+		op := row.op
+		op.Amount, _ = s.credited(row)
+		op = *withJSONFields(op)
+		op.Sum = 0
+		ops = append(ops, &op)
+	}
+	return applyListFilter(ops, filter, createdAt), nil
+}
+
+func (s *MemoryStorage) GetWithdrawalsByUser(ctx context.Context, userID int64, filter models.ListFilter) ([]*models.BalanceOperation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ops := s.userMoves(userID, models.WithdrawalOp)
+	for _, op := range ops {
+		op.Accrual = 0
+	}
+	return applyListFilter(ops, filter, processedAt), nil
+}
+
+// Время строки списка, как колонки listByProcessedAt и listByCreatedAt
+func processedAt(op *models.BalanceOperation) time.Time { return op.ProcessedAt }
+func createdAt(op *models.BalanceOperation) time.Time   { return op.CreatedAt }
+
+// applyListFilter фильтр, курсор, сортировка и limit по времени строки timeOf как в
+// SQL-реализациях. Время сравнивается с точностью до микросекунд — как в БД и в курсоре.
+func applyListFilter(ops []*models.BalanceOperation, f models.ListFilter, timeOf func(*models.BalanceOperation) time.Time) []*models.BalanceOperation {
+	// less строка a раньше b в порядке (время, id) по возрастанию
+	less := func(at time.Time, id int64, bt time.Time, bid int64) bool {
+		if am, bm := at.UnixMicro(), bt.UnixMicro(); am != bm {
+			return am < bm
+		}
+		return id < bid
+	}
+
+	result := ops[:0]
+	for _, op := range ops {
+		at := timeOf(op)
+		if len(f.Statuses) > 0 && !slices.Contains(f.Statuses, op.Status) {
+			continue
+		}
+		if !f.From.IsZero() && at.Before(f.From) {
+			continue
+		}
+		if !f.To.IsZero() && !at.Before(f.To) {
+			continue
+		}
+		if f.After != nil {
+			after := less(f.After.At, f.After.ID, at, op.ID)
+			if f.Desc {
+				after = less(at, op.ID, f.After.At, f.After.ID)
+			}
+			if !after {
+				continue
+			}
+		}
+		result = append(result, op)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if f.Desc {
+			i, j = j, i
+		}
+		return less(timeOf(result[i]), result[i].ID, timeOf(result[j]), result[j].ID)
+	})
+
+	if f.Limit > 0 && len(result) > f.Limit {
+		result = result[:f.Limit]
+	}
+	return result
+}
+
+// --- Balance ---
+
+func (s *MemoryStorage) GetBalance(ctx context.Context, userID int64) (current, withdrawn models.Money, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if b, ok := s.balances[userID]; ok {
+		return b.current, b.withdrawn, nil
+	}
+	return 0, 0, nil
+}
+
+func (s *MemoryStorage) VerifyBalances(ctx context.Context) ([]models.BalanceDrift, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ledger := make(map[int64]*memBalance)
+	for id, b := range s.openings {
+		ledger[id] = &memBalance{current: b.current, withdrawn: b.withdrawn}
+	}
+	for _, e := range s.entries {
+		for _, p := range e.postings {
+			if p.userID == 0 {
+				continue
+			}
+			b, ok := ledger[p.userID]
+			if !ok {
+				b = &memBalance{}
+				ledger[p.userID] = b
+			}
+			b.current += p.amount
+			if e.entryType == models.WithdrawalOp {
+				b.withdrawn -= p.amount
+			}
+		}
+	}
+
+	userIDs := make(map[int64]struct{})
+	for id := range ledger {
+		userIDs[id] = struct{}{}
+	}
+	for id := range s.balances {
+		userIDs[id] = struct{}{}
+	}
+
+	drifts := []models.BalanceDrift{}
+	for id := range userIDs {
+		d := models.BalanceDrift{UserID: id}
+		if b, ok := s.balances[id]; ok {
+			d.Current, d.Withdrawn = b.current, b.withdrawn
+		}
+		if b, ok := ledger[id]; ok {
+			d.LedgerCurrent, d.LedgerWithdrawn = b.current, b.withdrawn
+		}
+		if d.Current != d.LedgerCurrent || d.Withdrawn != d.LedgerWithdrawn {
+			drifts = append(drifts, d)
+		}
+	}
+	sort.Slice(drifts, func(i, j int) bool { return drifts[i].UserID < drifts[j].UserID })
+	return drifts, nil
+}
+
+// --- Accrual polling ---
+
+func (s *MemoryStorage) LeasePendingOrders(ctx context.Context, owner string, limit int, lease time.Duration) ([]*models.BalanceOperation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var pending []*memOrder
+	for _, row := range s.orders {
+		if row.op.Status.IsFinal() {
+			continue
+		}
+		if row.op.StuckAt != nil || row.op.NextPollAt.After(now) {
+			continue
+		}
+		if !row.leaseUntil.IsZero() && !row.leaseUntil.Before(now) {
+			continue
+		}
+		pending = append(pending, row)
+	}
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].op.NextPollAt.Before(pending[j].op.NextPollAt)
+	})
+	if len(pending) > limit {
+		pending = pending[:limit]
+	}
+
+	ops := make([]*models.BalanceOperation, 0, len(pending))
+	for _, row := range pending {
+		row.leaseOwner = owner
+		row.leaseUntil = now.Add(lease)
+		op := row.op
+		ops = append(ops, &op)
+	}
+	return ops, nil
+}
+
+func (s *MemoryStorage) SchedulePoll(ctx context.Context, orderNumber string, nextPollAt time.Time, lastErr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, ok := s.byNumber[orderNumber]
+	if !ok {
+		return ErrOrderNotFound
+	}
+	row.op.PollAttempts++
+	row.op.NextPollAt = nextPollAt
+	row.op.LastPollError = lastErr
+	row.leaseOwner, row.leaseUntil = "", time.Time{}
+	return nil
+}
+
+func (s *MemoryStorage) ReleaseOrderLease(ctx context.Context, orderNumber, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if row, ok := s.byNumber[orderNumber]; ok && row.leaseOwner == owner {
+		row.leaseOwner, row.leaseUntil = "", time.Time{}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) UpdateOrderStatus(ctx context.Context, orderNumber string, status models.Status, accrual models.Money) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, ok := s.byNumber[orderNumber]
+	if !ok {
+		return ErrOrderNotFound
+	}
+
+	current := row.op.Status
+	if !models.CanTransition(current, status) {
+		return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, current, status)
+	}
+	if current == status {
+		return nil
+	}
+
+	row.op.Status = status
+	row.op.ProcessedAt = time.Now()
+	if accrual > 0 {
+		row.op.Amount = accrual
+	}
+	row.op.StuckAt = nil
+	row.op.StuckReason = ""
+
+	if status == models.ProcessedStatus {
+		s.post(ledgerEntry{
+			entryType:   models.AccrualOp,
+			userID:      row.op.UserID,
+			orderNumber: orderNumber,
+			orderID:     row.op.ID,
+			amount:      row.op.Amount,
+			postedAt:    row.op.ProcessedAt,
+		})
+	}
+	s.emit(orderEvent(row.op.UserID, orderNumber, current, status, row.op.Amount, ""))
+	return nil
+}
+
+// --- Stuck orders ---
+
+func (s *MemoryStorage) MarkStuckOrders(ctx context.Context, maxAge time.Duration) ([]*models.BalanceOperation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var ops []*models.BalanceOperation
+	for _, row := range s.orders {
+		if row.op.Status.IsFinal() || row.op.StuckAt != nil {
+			continue
+		}
+		since := row.op.CreatedAt
+		if !row.requeuedAt.IsZero() {
+			since = row.requeuedAt
+		}
+		if !since.Before(now.Add(-maxAge)) {
+			continue
+		}
+
+		stuckAt := now
+		row.op.StuckAt = &stuckAt
+		row.op.StuckReason = "no final status after " + maxAge.String()
+		row.leaseOwner, row.leaseUntil = "", time.Time{}
+
+		op := row.op
+		ops = append(ops, &op)
+	}
+	return ops, nil
+}
+
+func (s *MemoryStorage) GetStuckOrders(ctx context.Context) ([]*models.BalanceOperation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ops []*models.BalanceOperation
+	for _, row := range s.orders {
+		if row.op.StuckAt != nil {
+			op := row.op
+			ops = append(ops, &op)
+		}
+	}
+	sort.SliceStable(ops, func(i, j int) bool {
+		return ops[i].StuckAt.Before(*ops[j].StuckAt)
+	})
+	return ops, nil
+}
+
+func (s *MemoryStorage) RequeueOrder(ctx context.Context, orderNumber string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, ok := s.byNumber[orderNumber]
+	if !ok || row.op.StuckAt == nil {
+		return ErrOrderNotFound
+	}
+
+	now := time.Now()
+	row.op.StuckAt = nil
+	row.op.StuckReason = ""
+	row.requeuedAt = now
+	row.op.PollAttempts = 0
+	row.op.LastPollError = ""
+	row.op.NextPollAt = now
+	return nil
+}
+
+func (s *MemoryStorage) ForceOrderStatus(ctx context.Context, orderNumber string, status models.Status, accrual models.Money, reason string) error {
+	if !status.IsFinal() {
+		return fmt.Errorf("%w: forced status must be final, got %s", ErrIllegalTransition, status)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, ok := s.byNumber[orderNumber]
+	if !ok {
+		return ErrOrderNotFound
+	}
+	if !models.CanTransition(row.op.Status, status) {
+		return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, row.op.Status, status)
+	}
+
+	current := row.op.Status
+	if status != models.ProcessedStatus {
+		accrual = 0
+	}
+	row.op.Status = status
+	row.op.Amount = accrual
+	row.op.ProcessedAt = time.Now()
+	row.op.StuckAt = nil
+	row.op.StuckReason = ""
+	row.leaseOwner, row.leaseUntil = "", time.Time{}
+
+	if status == models.ProcessedStatus {
+		s.post(ledgerEntry{
+			entryType:   models.AccrualOp,
+			userID:      row.op.UserID,
+			orderNumber: orderNumber,
+			orderID:     row.op.ID,
+			amount:      accrual,
+			postedAt:    row.op.ProcessedAt,
+		})
+	}
+	s.emit(orderEvent(row.op.UserID, orderNumber, current, status, accrual, reason))
+	return nil
+}
+
+// --- Reconciliation ---
+
+func (s *MemoryStorage) GetProcessedAccruals(ctx context.Context, afterID int64, limit int) ([]*models.BalanceOperation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ops []*models.BalanceOperation
+	for _, row := range s.orders {
+		if len(ops) == limit {
+			break
+		}
+		if row.op.ID <= afterID || row.op.Status != models.ProcessedStatus {
+			continue
+		}
+		op := row.op
+		op.Amount, _ = s.credited(row)
+		op.Accrual = op.Amount
+		ops = append(ops, &op)
+	}
+	return ops, nil
+}
+
+func (s *MemoryStorage) AdjustAccrual(ctx context.Context, orderID int64, delta models.Money, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.orders, func(row *memOrder) bool { return row.op.ID == orderID })
+	if i < 0 {
+		return ErrOrderNotFound
+	}
+	target := s.orders[i]
+
+	s.post(ledgerEntry{
+		entryType:   models.AdjustmentOp,
+		userID:      target.op.UserID,
+		orderNumber: target.op.OrderNumber,
+		orderID:     orderID,
+		reason:      reason,
+		amount:      delta,
+		postedAt:    time.Now(),
+	})
+	return nil
+}
+
+// --- Outbox ---
+
+// emit пишет событие в outbox. Вызывать под s.mu вместе с изменением
+func (s *MemoryStorage) emit(payload models.EventPayload) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		panic(fmt.Sprintf("marshal %s event: %v", payload.EventType(), err))
+	}
+	s.events = append(s.events, &models.OutboxEvent{
+		ID:        int64(len(s.events)) + 1,
+		Type:      payload.EventType(),
+		Payload:   data,
+		CreatedAt: time.Now(),
+	})
+}
+
+func (s *MemoryStorage) GetOutboxEvents(ctx context.Context, afterID int64, limit int) ([]*models.OutboxEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []*models.OutboxEvent
+	for _, e := range s.events {
+		if len(events) == limit {
+			break
+		}
+		if e.ID > afterID {
+			event := *e
+			events = append(events, &event)
+		}
+	}
+	return events, nil
+}
+
+func (s *MemoryStorage) GetOutboxOffset(ctx context.Context, sink string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.outboxOffsets[sink], nil
+}
+
+func (s *MemoryStorage) SaveOutboxOffset(ctx context.Context, sink string, eventID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.outboxOffsets[sink] = max(s.outboxOffsets[sink], eventID)
+	return nil
+}
+
+// --- Audit ---
+
+func (s *MemoryStorage) AppendAuditEvent(ctx context.Context, e *models.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var prevHash string
+	if n := len(s.auditEvents); n > 0 {
+		prevHash = s.auditEvents[n-1].Hash
+	}
+	prepareAuditEvent(e, prevHash)
+	e.ID = int64(len(s.auditEvents)) + 1
+
+	stored := *e
+	s.auditEvents = append(s.auditEvents, &stored)
+	return nil
+}
+
+func (s *MemoryStorage) GetAuditEvents(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []*models.AuditEvent
+	for _, e := range s.auditEvents {
+		if filter.Limit > 0 && len(events) == filter.Limit {
+			break
+		}
+		if e.ID <= filter.AfterID {
+			continue
+		}
+		if filter.UserID != 0 && e.ActorID != filter.UserID && e.SubjectID != filter.UserID {
+			continue
+		}
+		if !filter.From.IsZero() && e.CreatedAt.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !e.CreatedAt.Before(filter.To) {
+			continue
+		}
+		event := *e
+		events = append(events, &event)
+	}
+	return events, nil
+}
+
+// --- Ledger archive ---
+
+// GetLedgerMonths месяцы, в которых есть записи журнала
+func (s *MemoryStorage) GetLedgerMonths(ctx context.Context) ([]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var months []time.Time
+	for _, e := range s.entries {
+		month, _ := monthRange(e.postedAt)
+		if !slices.ContainsFunc(months, month.Equal) {
+			months = append(months, month)
+		}
+	}
+	slices.SortFunc(months, time.Time.Compare)
+	return months, nil
+}
+
+func (s *MemoryStorage) GetLedgerEntries(ctx context.Context, month time.Time, afterID int64, limit int) ([]*models.LedgerEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from, to := monthRange(month)
+	var entries []*models.LedgerEntry
+	for _, e := range s.entries {
+		if e.id <= afterID || e.postedAt.Before(from) || !e.postedAt.Before(to) {
+			continue
+		}
+		if len(entries) == limit {
+			break
+		}
+		le := &models.LedgerEntry{
+			ID:          e.id,
+			EntryType:   e.entryType,
+			OrderNumber: e.orderNum,
+			OrderID:     e.orderID,
+			Reason:      e.reason,
+			PostedAt:    e.postedAt,
+		}
+		for _, p := range e.postings {
+			le.Postings = append(le.Postings, models.LedgerPosting{UserID: p.userID, Account: p.code, Amount: p.amount})
+		}
+		entries = append(entries, le)
+	}
+	return entries, nil
+}
+
+// ArchiveLedgerMonth переносит проводки месяца во входящие остатки и начисленное
+// по заказам и удаляет записи месяца из журнала
+func (s *MemoryStorage) ArchiveLedgerMonth(ctx context.Context, month time.Time, entries int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from, to := monthRange(month)
+	var archived, kept []*memEntry
+	for _, e := range s.entries {
+		switch {
+		case e.postedAt.Before(from):
+			return ErrArchiveNotOldest
+		case e.postedAt.Before(to):
+			archived = append(archived, e)
+		default:
+			kept = append(kept, e)
+		}
+	}
+	if len(archived) != entries {
+		return fmt.Errorf("%w: %s has %d entries, exported %d", ErrArchiveMismatch, partitionSuffix(from), len(archived), entries)
+	}
+
+	for _, e := range archived {
+		for _, p := range e.postings {
+			if p.userID == 0 {
+				continue
+			}
+			b, ok := s.openings[p.userID]
+			if !ok {
+				b = &memBalance{}
+				s.openings[p.userID] = b
+			}
+			b.current += p.amount
+			if e.entryType == models.WithdrawalOp {
+				b.withdrawn -= p.amount
+			}
+
+			if row, ok := s.byNumber[e.orderNum]; ok && e.orderID != 0 {
+				row.archivedCredit += p.amount
+				row.accrualArchived = row.accrualArchived || e.entryType == models.AccrualOp
+			}
+		}
+	}
+
+	s.entries = kept
+	if to.After(s.archivedUntil) {
+		s.archivedUntil = to
+	}
+	return nil
+}
+
+// EnsureLedgerPartitions журнал в памяти не партиционирован
+func (s *MemoryStorage) EnsureLedgerPartitions(ctx context.Context, from time.Time, months int) ([]string, error) {
+	return nil, nil
+}
+
+// --- Idempotency ---
+
+func (s *MemoryStorage) ReserveIdempotencyKey(ctx context.Context, userID int64, key, fingerprint string, lockUntil time.Time) (*models.IdempotentResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := memIdempotencyID{userID: userID, key: key}
+	if k, ok := s.idempotencyKeys[id]; ok && k.expiresAt.After(time.Now()) {
+		stored := storedIdempotencyKey{fingerprint: k.fingerprint}
+		if k.resp != nil {
+			stored.statusCode = &k.resp.StatusCode
+			stored.contentType = &k.resp.ContentType
+			stored.body = k.resp.Body
+		}
+		return stored.replay(fingerprint)
+	}
+
+	s.idempotencyKeys[id] = &memIdempotencyKey{fingerprint: fingerprint, expiresAt: lockUntil}
+	return nil, nil
+}
+
+func (s *MemoryStorage) SaveIdempotentResponse(ctx context.Context, userID int64, key string, resp *models.IdempotentResponse, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if k, ok := s.idempotencyKeys[memIdempotencyID{userID: userID, key: key}]; ok && k.resp == nil {
+		saved := *resp
+		k.resp = &saved
+		k.expiresAt = expiresAt
+	}
+	return nil
+}
+
+func (s *MemoryStorage) ReleaseIdempotencyKey(ctx context.Context, userID int64, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := memIdempotencyID{userID: userID, key: key}
+	if k, ok := s.idempotencyKeys[id]; ok && k.resp == nil {
+		delete(s.idempotencyKeys, id)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var deleted int64
+	for id, k := range s.idempotencyKeys {
+		if !k.expiresAt.After(now) {
+			delete(s.idempotencyKeys, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// --- Webhook signatures ---
+
+func (s *MemoryStorage) SaveWebhookSignature(ctx context.Context, signature string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if until, ok := s.webhookSignatures[signature]; ok && until.After(time.Now()) {
+		return ErrWebhookReplay
+	}
+	s.webhookSignatures[signature] = expiresAt
+	return nil
+}
+
+func (s *MemoryStorage) DeleteWebhookSignature(ctx context.Context, signature string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.webhookSignatures, signature)
+	return nil
+}
+
+func (s *MemoryStorage) DeleteExpiredWebhookSignatures(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var deleted int64
+	for sig, until := range s.webhookSignatures {
+		if !until.After(now) {
+			delete(s.webhookSignatures, sig)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
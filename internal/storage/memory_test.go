@@ -0,0 +1,9 @@
+package storage
+
+import "testing"
+
+func TestMemoryStorage(t *testing.T) {
+	testStorage(t, func(t *testing.T) Storage {
+		return NewMemoryStorage()
+	})
+}
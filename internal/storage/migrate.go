@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/JSchatten/go-diploma/migrations"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	pgxMigrate "github.com/golang-migrate/migrate/v4/database/pgx"
+	sqliteMigrate "github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+var (
+	// ErrSchemaAhead схема БД новее, чем миграции в бинарнике
+	ErrSchemaAhead = errors.New("database schema is newer than this binary")
+	// ErrSchemaBehind к схеме БД не применены миграции из бинарника
+	ErrSchemaBehind = errors.New("database schema is older than this binary")
+	// ErrSchemaDirty миграция упала на середине, схему нужно поправить вручную
+	ErrSchemaDirty = errors.New("database schema is dirty")
+)
+
+// Migrations управление версией схемы по миграциям, встроенным в бинарник
+type Migrations struct {
+	m      *migrate.Migrate
+	db     *sql.DB
+	latest uint
+}
+
+// NewMigrations миграции для DATABASE_URI: sqlite:// — SQLite, иначе PostgreSQL
+func NewMigrations(databaseURI string) (*Migrations, error) {
+	if path, ok := strings.CutPrefix(databaseURI, sqliteScheme); ok {
+		return newSQLiteMigrations(sqliteDSN(path))
+	}
+	return newPSQLMigrations(databaseURI)
+}
+
+func newPSQLMigrations(dsn string) (*Migrations, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database for migration: %w", err)
+	}
+
+	driver, err := pgxMigrate.WithInstance(db, &pgxMigrate.Config{})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create migrate driver instance: %w", err)
+	}
+
+	return newMigrations(db, driver, "pgx", ".")
+}
+
+func newSQLiteMigrations(dsn string) (*Migrations, error) {
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database for migration: %w", err)
+	}
+
+	driver, err := sqliteMigrate.WithInstance(db, &sqliteMigrate.Config{})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create migrate driver instance: %w", err)
+	}
+
+	return newMigrations(db, driver, "sqlite", migrations.SQLiteDir)
+}
+
+func newMigrations(db *sql.DB, driver database.Driver, driverName, dir string) (*Migrations, error) {
+	src, err := iofs.New(migrations.FS, dir)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to read embedded migrations: %w", err)
+	}
+
+	latest, err := latestVersion(src)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	m, err := migrate.NewWithInstance("iofs", src, driverName, driver)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create migrate instance: %w", err)
+	}
+
+	return &Migrations{m: m, db: db, latest: latest}, nil
+}
+
+// latestVersion последняя версия среди встроенных миграций
+func latestVersion(src source.Driver) (uint, error) {
+	v, err := src.First()
+	if err != nil {
+		return 0, fmt.Errorf("no embedded migrations: %w", err)
+	}
+	for {
+		next, err := src.Next(v)
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, fs.ErrNotExist) {
+			return v, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		v = next
+	}
+}
+
+func (m *Migrations) Close() error {
+	srcErr, dbErr := m.m.Close()
+	return errors.Join(srcErr, dbErr, m.db.Close())
+}
+
+// Latest версия схемы, которую ожидает бинарник
+func (m *Migrations) Latest() uint {
+	return m.latest
+}
+
+// Version текущая версия схемы; миграций ещё не было — 0
+func (m *Migrations) Version() (version uint, dirty bool, err error) {
+	version, dirty, err = m.m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// Check схема ровно той версии, что ожидает бинарник, и не грязная
+func (m *Migrations) Check() error {
+	if err := m.checkApplicable(); err != nil {
+		return err
+	}
+
+	version, _, err := m.Version()
+	if err != nil {
+		return err
+	}
+	if version < m.latest {
+		return fmt.Errorf("%w: database is at version %d, binary expects %d; run `gophermart migrate up`",
+			ErrSchemaBehind, version, m.latest)
+	}
+	return nil
+}
+
+// checkApplicable схема не новее бинарника и не грязная: иначе применять миграции нельзя
+func (m *Migrations) checkApplicable() error {
+	version, dirty, err := m.Version()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case dirty:
+		return fmt.Errorf("%w: migration %d failed midway; fix the schema and run `gophermart migrate force N`",
+			ErrSchemaDirty, version)
+	case version > m.latest:
+		return fmt.Errorf("%w: database is at version %d, binary supports up to %d; upgrade gophermart "+
+			"or roll back with `gophermart migrate goto %d` using the newer binary", ErrSchemaAhead, version, m.latest, m.latest)
+	}
+	return nil
+}
+
+// Up применяет все недостающие миграции
+func (m *Migrations) Up() error {
+	if err := m.checkApplicable(); err != nil {
+		return err
+	}
+	return ignoreNoChange(m.m.Up())
+}
+
+// Down откатывает steps последних миграций
+func (m *Migrations) Down(steps int) error {
+	if err := m.checkApplicable(); err != nil {
+		return err
+	}
+	return ignoreNoChange(m.m.Steps(-steps))
+}
+
+// Goto переводит схему на версию version вверх или вниз, 0 — откат всех миграций
+func (m *Migrations) Goto(version uint) error {
+	if err := m.checkApplicable(); err != nil {
+		return err
+	}
+	if version > m.latest {
+		return fmt.Errorf("%w: version %d, binary supports up to %d", ErrSchemaAhead, version, m.latest)
+	}
+	if version == 0 {
+		return ignoreNoChange(m.m.Down())
+	}
+	return ignoreNoChange(m.m.Migrate(version))
+}
+
+// Force записывает версию схемы без выполнения миграций и снимает признак dirty.
+// Только после ручного исправления схемы; -1 — миграций не было.
+func (m *Migrations) Force(version int) error {
+	return m.m.Force(version)
+}
+
+func ignoreNoChange(err error) error {
+	if errors.Is(err, migrate.ErrNoChange) {
+		return nil
+	}
+	return err
+}
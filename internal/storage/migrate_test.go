@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Встроенные миграции проверяются на SQLite: она есть всегда, файлы читаются из бинарника
+func TestMigrations(t *testing.T) {
+	uri := sqliteScheme + filepath.Join(t.TempDir(), "gophermart.db")
+
+	m, err := NewMigrations(uri)
+	require.NoError(t, err)
+	defer m.Close()
+
+	require.Positive(t, m.Latest())
+	assert.ErrorIs(t, m.Check(), ErrSchemaBehind)
+
+	version, dirty, err := m.Version()
+	require.NoError(t, err)
+	assert.Zero(t, version)
+	assert.False(t, dirty)
+
+	// хранилище работает на схеме, поднятой командой migrate up
+	require.NoError(t, m.Up())
+	require.NoError(t, m.Up())
+	require.NoError(t, m.Check())
+
+	ctx := context.Background()
+	s, err := NewStorage(ctx, uri)
+	require.NoError(t, err)
+	defer s.Close()
+	_, err = s.SaveUser(ctx, "migrated", "hash")
+	require.NoError(t, err)
+
+	require.NoError(t, m.Goto(0))
+	version, _, err = m.Version()
+	require.NoError(t, err)
+	assert.Zero(t, version)
+
+	require.NoError(t, m.Goto(m.Latest()))
+	require.NoError(t, m.Down(1))
+	require.NoError(t, m.Up())
+	assert.ErrorIs(t, m.Goto(m.Latest()+1), ErrSchemaAhead)
+
+	// схему обновил более новый бинарник
+	require.NoError(t, m.Force(int(m.Latest())+1))
+	assert.ErrorIs(t, m.Check(), ErrSchemaAhead)
+	assert.ErrorIs(t, m.Up(), ErrSchemaAhead)
+	assert.ErrorIs(t, s.Migrate(ctx), ErrSchemaAhead)
+
+	require.NoError(t, m.Force(int(m.Latest())))
+	require.NoError(t, s.Migrate(ctx))
+}
+
+// Переход на журнал переносит заказы, начисления, списания и корректировки
+// из balance_operations без расхождения балансов, откат возвращает строки обратно
+func TestLedgerMigration(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "gophermart.db")
+
+	m, err := NewMigrations(sqliteScheme + path)
+	require.NoError(t, err)
+	defer m.Close()
+	require.NoError(t, m.Goto(1))
+
+	s, err := NewSQLiteStorage(ctx, path)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// схема до журнала: заказ 1 рассчитан и скорректирован, 3 ждёт расчёта, 2 — списание
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO users (id, login, password_hash, created_at) VALUES (1, 'legacy', 'hash', 1);
+		INSERT INTO balance_operations (id, user_id, order_number, amount, operation_type, status,
+			processed_at, created_at, next_poll_at, adjusts_id, adjust_reason)
+		VALUES (1, 1, '12345678903', 10000, 'accrual', 'PROCESSED', 10, 1, 1, NULL, NULL),
+			(2, 1, '2377225624', -2500, 'withdrawal', 'PROCESSED', 20, 20, 20, NULL, NULL),
+			(3, 1, '9278923470', 0, 'accrual', 'NEW', 30, 30, 30, NULL, NULL),
+			(4, 1, '12345678903', -500, 'adjustment', 'PROCESSED', 40, 40, 40, 1, 'reconciliation');
+		INSERT INTO order_status_history (operation_id, order_number, from_status, to_status, changed_at)
+		VALUES (1, '12345678903', NULL, 'NEW', 1), (1, '12345678903', 'NEW', 'PROCESSED', 10),
+			(3, '9278923470', NULL, 'NEW', 30);
+		INSERT INTO user_balances (user_id, current, withdrawn, version, updated_at)
+		VALUES (1, 7000, 2500, 3, 40);
+	`)
+	require.NoError(t, err)
+
+	// хранилище читает схему последней версии
+	require.NoError(t, m.Up())
+
+	drifts, err := s.VerifyBalances(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, drifts)
+
+	ops, err := s.GetOperationsByUser(ctx, 1, models.ListFilter{})
+	require.NoError(t, err)
+	var ids []int64
+	for _, op := range ops {
+		ids = append(ids, op.ID)
+	}
+	// начисление получило новый id после всех старых, время у него прежнее
+	assert.Equal(t, []int64{5, 2, 3, 4}, ids)
+	assert.Equal(t, models.Money(7000), ops[3].Balance)
+
+	orders, err := s.GetAccrualsByUser(ctx, 1, models.ListFilter{})
+	require.NoError(t, err)
+	require.Len(t, orders, 2)
+	assert.Equal(t, models.Money(9500), orders[0].Accrual)
+
+	// новые заказы и записи продолжают общий счётчик
+	order := newOrder(1, "4561261212345467")
+	require.NoError(t, s.UploadOrder(ctx, order))
+	assert.Equal(t, int64(6), order.ID)
+
+	require.NoError(t, m.Goto(1))
+
+	var count int
+	require.NoError(t, s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM balance_operations
+	`).Scan(&count))
+	assert.Equal(t, 5, count)
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"encoding/json"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+)
+
+// outboxLockID ключ advisory-блокировки PostgreSQL, под которой событиям outbox
+// присваиваются номера. Её берёт только чтение событий, транзакции изменений пишут
+// события без неё и друг друга не ждут.
+const outboxLockID = 7301001
+
+// orderEvent событие смены статуса заказа; начисление — только у рассчитанного
+func orderEvent(userID int64, number string, from, to models.Status, accrual models.Money, reason string) models.OrderStatusChanged {
+	if to != models.ProcessedStatus {
+		accrual = 0
+	}
+	return models.OrderStatusChanged{
+		UserID:  userID,
+		Order:   number,
+		From:    from,
+		Status:  to,
+		Accrual: accrual,
+		Reason:  reason,
+	}
+}
+
+func marshalEvent(payload models.EventPayload) (string, error) {
+	data, err := json.Marshal(payload)
+	return string(data), err
+}
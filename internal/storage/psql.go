@@ -2,15 +2,15 @@ package storage
 
 import (
 	"context"
-	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/JSchatten/go-diploma/internal/models"
 
-	"github.com/golang-migrate/migrate/v4"
-	pgxMigrate "github.com/golang-migrate/migrate/v4/database/pgx"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	_ "github.com/jackc/pgx/v5/stdlib" // активация драйвера дл миграции
@@ -40,7 +40,7 @@ func (s *PSQLStorage) Close() error {
 	return nil
 }
 
-// --- Balance ---
+// Migrate применяет встроенные миграции PostgreSQL; схема новее бинарника — ErrSchemaAhead
 func (s *PSQLStorage) Migrate(ctx context.Context) error {
 	log.Logger.Info().Msg("PSQLStorage.Migrate")
 
@@ -49,36 +49,14 @@ func (s *PSQLStorage) Migrate(ctx context.Context) error {
 		return err
 	}
 
-	db, err := sql.Open("pgx", s.dsn)
-	if err != nil {
-		log.Logger.Error().Err(err).Msg("Failed to open database for migration")
-		return err
-	}
-	defer db.Close()
-
-	if err := db.Ping(); err != nil {
-		log.Logger.Error().Err(err).Msg("Failed to ping database")
-		return err
-	}
-
-	driver, err := pgxMigrate.WithInstance(db, &pgxMigrate.Config{})
-	if err != nil {
-		log.Logger.Error().Err(err).Msg("Failed to create migrate driver instance")
-		return err
-	}
-
-	m, err := migrate.NewWithDatabaseInstance(
-		"file://migrations",
-		"pgx",
-		driver,
-	)
+	m, err := newPSQLMigrations(s.dsn)
 	if err != nil {
 		log.Logger.Error().Err(err).Msg("Failed to create migrate instance")
 		return err
 	}
+	defer m.Close()
 
-	err = m.Up()
-	if err != nil && err != migrate.ErrNoChange {
+	if err := m.Up(); err != nil {
 		log.Logger.Error().Err(err).Msg("Migration failed")
 		return err
 	}
@@ -87,20 +65,71 @@ func (s *PSQLStorage) Migrate(ctx context.Context) error {
 
 // --- Users ---
 
+// SaveUser создаёт пользователя и его счёт в журнале одним запросом
 func (s *PSQLStorage) SaveUser(ctx context.Context, login, hash string) (int64, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
 	var id int64
-	err := s.db.QueryRow(ctx, `
-        INSERT INTO users (login, password_hash)
-        VALUES ($1, $2)
-        ON CONFLICT (login) DO NOTHING
-        RETURNING id
+	err = tx.QueryRow(ctx, `
+        WITH u AS (
+            INSERT INTO users (login, password_hash)
+            VALUES ($1, $2)
+            ON CONFLICT (login) DO NOTHING
+            RETURNING id
+        ), account AS (
+            INSERT INTO accounts (user_id) SELECT id FROM u
+        )
+        SELECT id FROM u
     `, login, hash).Scan(&id)
 
 	if err != nil {
+		// ON CONFLICT DO NOTHING не возвращает строку — логин занят
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrUserExists
+		}
+		return 0, err
+	}
+
+	if err := writeEvent(ctx, tx, models.UserRegistered{UserID: id, Login: login}); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
 		return 0, err
 	}
 	return id, nil
 }
+func (s *PSQLStorage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
+	var isAdmin bool
+	err := s.db.QueryRow(ctx, `
+        SELECT is_admin FROM users WHERE id = $1
+    `, userID).Scan(&isAdmin)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, ErrUserNotFound
+		}
+		return false, err
+	}
+	return isAdmin, nil
+}
+
+func (s *PSQLStorage) SetAdmin(ctx context.Context, login string, isAdmin bool) error {
+	tag, err := s.db.Exec(ctx, `
+		UPDATE users SET is_admin = $1 WHERE login = $2
+	`, isAdmin, login)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
 
 func (s *PSQLStorage) GetUserByLogin(ctx context.Context, login string) (int64, string, error) {
 	var id int64
@@ -110,7 +139,7 @@ func (s *PSQLStorage) GetUserByLogin(ctx context.Context, login string) (int64,
     `, login).Scan(&id, &hash)
 
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, pgx.ErrNoRows) {
 			return 0, "", ErrUserNotFound
 		}
 		return 0, "", err
@@ -120,49 +149,227 @@ func (s *PSQLStorage) GetUserByLogin(ctx context.Context, login string) (int64,
 
 // --- Operations ---
 
+// CreateOperation записывает заказ или списание. Заказ, загруженный сразу в статусе
+// PROCESSED, начисляется записью журнала в той же транзакции.
 func (s *PSQLStorage) CreateOperation(ctx context.Context, op *models.BalanceOperation) error {
-	// Проверка: если это начисление и такой номер уже есть — ошибка
-	if op.OperationType == models.AccrualOp {
-		var count int
-		err := s.db.QueryRow(ctx, `
-            SELECT COUNT(*) FROM balance_operations
-            WHERE order_number = $1 AND operation_type = 'accrual'
-        `, op.OrderNumber).Scan(&count)
+	switch op.OperationType {
+	case models.WithdrawalOp:
+		// Списание — только через проверку баланса под блокировкой пользователя
+		return s.Withdraw(ctx, op)
+	case models.AccrualOp:
+	default:
+		return fmt.Errorf("create operation: unsupported type %q", op.OperationType)
+	}
 
-		if err != nil {
-			return err
-		}
-		if count > 0 {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `
+        INSERT INTO orders (user_id, order_number, status, accrual, processed_at)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id
+    `, op.UserID, op.OrderNumber, string(op.Status), op.Amount, op.ProcessedAt).Scan(&op.ID)
+	if err != nil {
+		// номер заказа уже есть — уникальный индекс orders.order_number
+		if isUniqueViolation(err) {
 			return ErrOrderExists
 		}
+		return err
+	}
+
+	// Первая запись истории статусов заказа
+	_, err = tx.Exec(ctx, `
+        INSERT INTO order_status_history (order_id, order_number, from_status, to_status, changed_at)
+        VALUES ($1, $2, NULL, $3, $4)
+    `, op.ID, op.OrderNumber, string(op.Status), op.ProcessedAt)
+	if err != nil {
+		return err
 	}
 
-	// Для списания — проверим баланс
-	if op.OperationType == models.WithdrawalOp {
-		current, _, err := s.GetBalance(ctx, op.UserID)
+	// Сразу рассчитанный заказ начисляется
+	if op.Status == models.ProcessedStatus {
+		_, err = postEntry(ctx, tx, ledgerEntry{
+			entryType:   models.AccrualOp,
+			userID:      op.UserID,
+			orderNumber: op.OrderNumber,
+			orderID:     op.ID,
+			amount:      op.Amount,
+			postedAt:    op.ProcessedAt,
+		})
 		if err != nil {
 			return err
 		}
-		if current < -op.Amount { // op.Amount отрицательное, но -op.Amount = положительная сумма
-			return ErrNoMoney
+	}
+
+	err = writeEvent(ctx, tx, orderEvent(op.UserID, op.OrderNumber, "", op.Status, op.Amount, ""))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// UploadOrder одним запросом вставляет заказ или возвращает владельца уже загруженного.
+// При гонке вставку выигрывает одна транзакция, остальные видят конфликт по
+// уникальному orders.order_number. Если конфликтующая строка закоммичена после
+// снимка запроса, она в нём не видна — тогда запрос повторяется.
+func (s *PSQLStorage) UploadOrder(ctx context.Context, op *models.BalanceOperation) error {
+	for attempt := 0; attempt < uploadOrderAttempts; attempt++ {
+		err := s.uploadOrder(ctx, op)
+		if errors.Is(err, errConflictNotVisible) {
+			continue
 		}
+		return err
 	}
+	return fmt.Errorf("upload order %s: conflicting row is not visible", op.OrderNumber)
+}
 
-	_, err := s.db.Exec(ctx, `
-        INSERT INTO balance_operations (user_id, order_number, amount, operation_type, status, processed_at)
-        VALUES ($1, $2, $3, $4, $5, $6)
-    `, op.UserID, op.OrderNumber, op.Amount, string(op.OperationType), op.Status, op.ProcessedAt)
+// errConflictNotVisible конфликтующая строка закоммичена после снимка запроса
+var errConflictNotVisible = errors.New("conflicting row is not visible")
 
-	return err
+// uploadOrder одна попытка UploadOrder; новый заказ и событие о нём — в одной транзакции
+func (s *PSQLStorage) uploadOrder(ctx context.Context, op *models.BalanceOperation) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var id, ownerID int64
+	var inserted bool
+	err = tx.QueryRow(ctx, `
+		WITH ins AS (
+			INSERT INTO orders (user_id, order_number, status, processed_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (order_number) DO NOTHING
+			RETURNING id, user_id
+		), hist AS (
+			INSERT INTO order_status_history (order_id, order_number, from_status, to_status, changed_at)
+			SELECT id, $2, NULL, $3, $4 FROM ins
+		)
+		SELECT id, user_id, TRUE FROM ins
+		UNION ALL
+		SELECT id, user_id, FALSE FROM orders
+		WHERE order_number = $2
+			AND NOT EXISTS (SELECT 1 FROM ins)
+	`, op.UserID, op.OrderNumber, string(op.Status), op.ProcessedAt).Scan(&id, &ownerID, &inserted)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errConflictNotVisible
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !inserted && ownerID == op.UserID:
+		return ErrOrderExists
+	case !inserted:
+		return ErrOrderMine
+	}
+
+	err = writeEvent(ctx, tx, orderEvent(op.UserID, op.OrderNumber, "", op.Status, 0, ""))
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	op.ID = id
+	return nil
+}
+
+// uploadOrderAttempts повторы UploadOrder, если конфликтующая строка не видна в снимке
+const uploadOrderAttempts = 3
+
+// Withdraw проверяет баланс и записывает списание в журнал в одной транзакции.
+// Строка пользователя блокируется FOR UPDATE, поэтому параллельные списания
+// одного пользователя выполняются по очереди и не уводят баланс в минус.
+// Строки user_balances у пользователя может ещё не быть, поэтому блокируем users.
+func (s *PSQLStorage) Withdraw(ctx context.Context, op *models.BalanceOperation) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int64
+	err = tx.QueryRow(ctx, `
+		SELECT id FROM users WHERE id = $1 FOR UPDATE
+	`, op.UserID).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	// баланс читается уже после блокировки и видит все завершённые списания
+	var current models.Money
+	err = tx.QueryRow(ctx, `
+		SELECT COALESCE((SELECT current FROM user_balances WHERE user_id = $1), 0)
+	`, op.UserID).Scan(&current)
+	if err != nil {
+		return err
+	}
+	if current < -op.Amount { // op.Amount отрицательное, но -op.Amount = положительная сумма
+		return ErrNoMoney
+	}
+
+	op.ID, err = postEntry(ctx, tx, ledgerEntry{
+		entryType:   models.WithdrawalOp,
+		userID:      op.UserID,
+		orderNumber: op.OrderNumber,
+		amount:      op.Amount,
+		postedAt:    op.ProcessedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = writeEvent(ctx, tx, models.BalanceWithdrawn{UserID: op.UserID, Order: op.OrderNumber, Sum: -op.Amount})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
 }
 
-func (s *PSQLStorage) GetOperationsByUser(ctx context.Context, userID int64) ([]*models.BalanceOperation, error) {
+// GetOperationsByUser выписка: проводки по счёту пользователя и заказы, по которым
+// ещё ничего не начислено. Баланс считается оконной функцией по всей истории от
+// входящего остатка архива, фильтр применяется после, поэтому он не зависит от страницы.
+// Заказ без начисления стоит в выписке по времени загрузки, как в GetAccrualsByUser.
+func (s *PSQLStorage) GetOperationsByUser(ctx context.Context, userID int64, filter models.ListFilter) ([]*models.BalanceOperation, error) {
+	q := newPSQLListQuery(userID)
 	rows, err := s.db.Query(ctx, `
-        SELECT order_number, amount, operation_type, status, processed_at
-        FROM balance_operations
-        WHERE user_id = $1
-        ORDER BY processed_at DESC
-    `, userID)
+		SELECT o.id, o.order_number, o.amount, o.operation_type, o.status, o.processed_at, o.balance
+		FROM (
+			SELECT f.*, SUM(f.amount) OVER (ORDER BY f.processed_at, f.id) + COALESCE((
+				SELECT lo.amount FROM ledger_openings lo
+				JOIN accounts a ON a.id = lo.account_id
+				WHERE a.user_id = $1
+			), 0) AS balance
+			FROM (
+				SELECT e.id, e.order_number, p.amount, e.entry_type AS operation_type,
+					'PROCESSED' AS status, e.posted_at AS processed_at
+				FROM journal_entries e
+				JOIN postings p ON p.entry_id = e.id
+				JOIN accounts a ON a.id = p.account_id
+				WHERE a.user_id = $1
+				UNION ALL
+				SELECT id, order_number, 0, 'accrual', status, created_at
+				FROM orders o
+				WHERE user_id = $1 AND NOT o.accrual_archived AND NOT (`+finalArchivedSQL+`) AND NOT EXISTS (
+					SELECT 1 FROM journal_entries e
+					WHERE e.order_id = o.id AND e.entry_type = 'accrual'
+				)
+			) f
+		) o
+		WHERE TRUE`+q.where(filter, "o.")+q.orderLimit(filter, "o."),
+		q.args...)
 	if err != nil {
 		return nil, err
 	}
@@ -170,9 +377,9 @@ func (s *PSQLStorage) GetOperationsByUser(ctx context.Context, userID int64) ([]
 
 	var ops []*models.BalanceOperation
 	for rows.Next() {
-		op := &models.BalanceOperation{}
+		op := &models.BalanceOperation{UserID: userID}
 		var opType string
-		if err := rows.Scan(&op.OrderNumber, &op.Amount, &opType, &op.Status, &op.ProcessedAt); err != nil {
+		if err := rows.Scan(&op.ID, &op.OrderNumber, &op.Amount, &opType, &op.Status, &op.ProcessedAt, &op.Balance); err != nil {
 			return nil, err
 		}
 		op.OperationType = models.OperationType(opType)
@@ -183,44 +390,153 @@ func (s *PSQLStorage) GetOperationsByUser(ctx context.Context, userID int64) ([]
 		} else if op.Amount < 0 {
 			op.Sum = -op.Amount // делаем положительным для вывода
 		}
-		// если 0 — ничего не заполняем (редко, но возможно)
+		// если 0 — ничего не заполняем (заказ ещё не рассчитан)
 
 		ops = append(ops, op)
 	}
-	return ops, nil
+	return ops, rows.Err()
 }
 
-// --- Balance ---
+// --- Ledger ---
+
+// postEntry записывает запись журнала с проводками по счёту пользователя и системному
+// счёту и обновляет user_balances в той же транзакции. Нулевая сумма не пишется.
+func postEntry(ctx context.Context, tx pgx.Tx, e ledgerEntry) (int64, error) {
+	if e.amount == 0 {
+		return 0, nil
+	}
+
+	var id int64
+	var postedAt time.Time
+	err := tx.QueryRow(ctx, `
+		INSERT INTO journal_entries (entry_type, order_number, order_id, reason, posted_at)
+		VALUES ($1, $2, NULLIF($3::BIGINT, 0), NULLIF($4, ''), $5)
+		RETURNING id, posted_at
+	`, string(e.entryType), e.orderNumber, e.orderID, e.reason, e.postedAt).Scan(&id, &postedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	// одно начисление на заказ, см. accrual_entries в 0014_ledger_partitions
+	if e.entryType == models.AccrualOp {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO accrual_entries (order_id, entry_id) VALUES ($1, $2)
+		`, e.orderID, id)
+		if isUniqueViolation(err) {
+			return 0, ErrAccrualExists
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// сумма проводок записи равна нулю, это проверяет и триггер postings_balanced при коммите;
+	// проводки лежат в партиции месяца своей записи
+	_, err = tx.Exec(ctx, `
+		INSERT INTO postings (entry_id, posted_at, account_id, amount)
+		VALUES ($1, $2, (SELECT id FROM accounts WHERE user_id = $3), $4),
+			($1, $2, (SELECT id FROM accounts WHERE code = $5), $6)
+	`, id, postedAt, e.userID, e.amount, e.counterAccount(), -e.amount)
+	if err != nil {
+		return 0, err
+	}
 
-func (s *PSQLStorage) GetBalance(ctx context.Context, userID int64) (current, withdrawn float64, err error) {
-	// err = s.db.QueryRow(ctx, `
-	//     SELECT
-	//         COALESCE(SUM(CASE WHEN amount > 0 THEN amount ELSE 0 END), 0),
-	//         COALESCE(SUM(CASE WHEN amount < 0 THEN -amount ELSE 0 END), 0)
-	//     FROM balance_operations
-	//     WHERE user_id = $1 AND status = 'PROCESSED'
-	// `, userID).Scan(&current, &withdrawn)
+	return id, addUserBalance(ctx, tx, e.userID, e.amount, e.withdrawn())
+}
+
+// --- Balance ---
 
-	// return current, withdrawn, err
+// GetBalance баланс из user_balances; нет строки — движений по счёту ещё не было
+func (s *PSQLStorage) GetBalance(ctx context.Context, userID int64) (current, withdrawn models.Money, err error) {
 	err = s.db.QueryRow(ctx, `
-		SELECT
-			COALESCE(SUM(amount), 0), -- сумма всех начислений, как будто ту была ошибка
-			COALESCE(SUM(CASE WHEN amount < 0 THEN -amount ELSE 0 END), 0)
-		FROM balance_operations
-		WHERE user_id = $1 AND status = 'PROCESSED'
+		SELECT current, withdrawn FROM user_balances WHERE user_id = $1
 	`, userID).Scan(&current, &withdrawn)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, 0, nil
+	}
 
 	return current, withdrawn, err
 }
 
-func (s *PSQLStorage) GetAccrualsByUser(ctx context.Context, userID int64) ([]*models.BalanceOperation, error) {
+// addUserBalance меняет баланс пользователя в транзакции записи журнала
+func addUserBalance(ctx context.Context, tx pgx.Tx, userID int64, current, withdrawn models.Money) error {
+	if current == 0 && withdrawn == 0 {
+		return nil
+	}
+	_, err := tx.Exec(ctx, `
+		INSERT INTO user_balances (user_id, current, withdrawn, version, updated_at)
+		VALUES ($1, $2, $3, 1, NOW())
+		ON CONFLICT (user_id) DO UPDATE
+		SET current = user_balances.current + EXCLUDED.current,
+			withdrawn = user_balances.withdrawn + EXCLUDED.withdrawn,
+			version = user_balances.version + 1,
+			updated_at = NOW()
+	`, userID, current, withdrawn)
+	return err
+}
+
+// VerifyBalances пересчитывает балансы по проводкам журнала и входящим остаткам архива
+// и возвращает пользователей, у которых user_balances расходится с журналом.
+// Оба чтения — из одного снимка.
+func (s *PSQLStorage) VerifyBalances(ctx context.Context) ([]models.BalanceDrift, error) {
+	tx, err := s.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		WITH moves AS (
+			SELECT a.user_id, p.amount AS current,
+				CASE WHEN e.entry_type = 'withdrawal' THEN -p.amount ELSE 0 END AS withdrawn
+			FROM postings p
+			JOIN accounts a ON a.id = p.account_id
+			JOIN journal_entries e ON e.id = p.entry_id AND e.posted_at = p.posted_at
+			WHERE a.user_id IS NOT NULL
+			UNION ALL
+			SELECT a.user_id, lo.amount, lo.withdrawn
+			FROM ledger_openings lo
+			JOIN accounts a ON a.id = lo.account_id
+			WHERE a.user_id IS NOT NULL
+		), ledger AS (
+			SELECT user_id, SUM(current) AS current, SUM(withdrawn) AS withdrawn
+			FROM moves
+			GROUP BY user_id
+		)
+		SELECT COALESCE(b.user_id, l.user_id),
+			COALESCE(b.current, 0), COALESCE(b.withdrawn, 0),
+			COALESCE(l.current, 0), COALESCE(l.withdrawn, 0)
+		FROM user_balances b
+		FULL OUTER JOIN ledger l ON l.user_id = b.user_id
+		WHERE COALESCE(b.current, 0) <> COALESCE(l.current, 0)
+			OR COALESCE(b.withdrawn, 0) <> COALESCE(l.withdrawn, 0)
+		ORDER BY 1
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	drifts := []models.BalanceDrift{}
+	for rows.Next() {
+		var d models.BalanceDrift
+		if err := rows.Scan(&d.UserID, &d.Current, &d.Withdrawn, &d.LedgerCurrent, &d.LedgerWithdrawn); err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, d)
+	}
+	return drifts, rows.Err()
+}
+
+func (s *PSQLStorage) GetAccrualsByUser(ctx context.Context, userID int64, filter models.ListFilter) ([]*models.BalanceOperation, error) {
+	q := newPSQLListQuery(userID).by(listByCreatedAt)
 
+	// начисление — по журналу, с учётом корректировок сверки; список — по времени загрузки
 	rows, err := s.db.Query(ctx, `
-		SELECT order_number, amount, operation_type, status, processed_at
-		FROM balance_operations
-		WHERE user_id = $1 AND operation_type = 'accrual'
-		ORDER BY processed_at ASC
-	`, userID)
+		SELECT o.id, o.order_number, `+orderCreditedSQL+`, o.status, o.processed_at, o.created_at
+		FROM orders o
+		WHERE o.user_id = $1`+q.where(filter, "o.")+q.orderLimit(filter, "o."),
+		q.args...)
 	if err != nil {
 		return nil, err
 	}
@@ -228,29 +544,32 @@ func (s *PSQLStorage) GetAccrualsByUser(ctx context.Context, userID int64) ([]*m
 
 	var ops []*models.BalanceOperation
 	for rows.Next() {
-		op := &models.BalanceOperation{}
-		var opType string
-		if err := rows.Scan(&op.OrderNumber, &op.Amount, &opType, &op.Status, &op.ProcessedAt); err != nil {
+		op := &models.BalanceOperation{UserID: userID, OperationType: models.AccrualOp}
+		if err := rows.Scan(&op.ID, &op.OrderNumber, &op.Amount, &op.Status, &op.ProcessedAt, &op.CreatedAt); err != nil {
 			return nil, err
 		}
-		op.OperationType = models.OperationType(opType)
-		op.UserID = userID
 
 		if op.Amount > 0 {
 			op.Accrual = op.Amount
 		}
 		ops = append(ops, op)
 	}
-	return ops, nil
+	return ops, rows.Err()
 
 }
-func (s *PSQLStorage) GetWithdrawalsByUser(ctx context.Context, userID int64) ([]*models.BalanceOperation, error) {
+func (s *PSQLStorage) GetWithdrawalsByUser(ctx context.Context, userID int64, filter models.ListFilter) ([]*models.BalanceOperation, error) {
+	q := newPSQLListQuery(userID)
 	rows, err := s.db.Query(ctx, `
-		SELECT order_number, amount, operation_type, status, processed_at
-		FROM balance_operations
-		WHERE user_id = $1 AND operation_type = 'withdrawal'
-		ORDER BY processed_at ASC
-	`, userID)
+		SELECT w.id, w.order_number, w.amount, w.status, w.processed_at
+		FROM (
+			SELECT e.id, e.order_number, p.amount, 'PROCESSED' AS status, e.posted_at AS processed_at
+			FROM journal_entries e
+			JOIN postings p ON p.entry_id = e.id
+			JOIN accounts a ON a.id = p.account_id
+			WHERE a.user_id = $1 AND e.entry_type = 'withdrawal'
+		) w
+		WHERE TRUE`+q.where(filter, "w.")+q.orderLimit(filter, "w."),
+		q.args...)
 	if err != nil {
 		return nil, err
 	}
@@ -258,61 +577,64 @@ func (s *PSQLStorage) GetWithdrawalsByUser(ctx context.Context, userID int64) ([
 
 	var ops []*models.BalanceOperation
 	for rows.Next() {
-		op := &models.BalanceOperation{}
-		var opType string
-		if err := rows.Scan(&op.OrderNumber, &op.Amount, &opType, &op.Status, &op.ProcessedAt); err != nil {
+		op := &models.BalanceOperation{UserID: userID, OperationType: models.WithdrawalOp}
+		if err := rows.Scan(&op.ID, &op.OrderNumber, &op.Amount, &op.Status, &op.ProcessedAt); err != nil {
 			return nil, err
 		}
-		op.OperationType = models.OperationType(opType)
-		op.UserID = userID
 
 		if op.Amount < 0 {
 			op.Sum = -op.Amount
 		}
 		ops = append(ops, op)
 	}
-	return ops, nil
+	return ops, rows.Err()
 }
 
+// GetOrder заказ; Amount — начисление по данным accrual без корректировок
 func (s *PSQLStorage) GetOrder(ctx context.Context, number string) (*models.BalanceOperation, error) {
-	var op models.BalanceOperation
-	var opType string
-	var amount float64
+	op := models.BalanceOperation{OrderNumber: number, OperationType: models.AccrualOp}
 
 	err := s.db.QueryRow(ctx, `
-		SELECT user_id, amount, operation_type, status, processed_at
-		FROM balance_operations
-		WHERE order_number = $1 AND operation_type = 'accrual'
-	`, number).Scan(&op.UserID, &amount, &opType, &op.Status, &op.ProcessedAt)
+		SELECT id, user_id, accrual, status, processed_at
+		FROM orders
+		WHERE order_number = $1
+	`, number).Scan(&op.ID, &op.UserID, &op.Amount, &op.Status, &op.ProcessedAt)
 
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrOrderNotFound
 		}
 		log.Error().Err(err).Str("number", number).Msg("Database error in GetOrder")
 		return nil, err
 	}
 
-	op.OrderNumber = number
-	op.OperationType = models.OperationType(opType)
-	op.Amount = amount
-
 	if op.Amount > 0 {
 		op.Accrual = op.Amount
-	} else if op.Amount < 0 {
-		op.Sum = -op.Amount
 	}
 
 	return &op, nil
 }
 
-// GetNewOrders возвращает все заказы со статусом NEW
-func (s *PSQLStorage) GetNewOrders(ctx context.Context) ([]*models.BalanceOperation, error) {
+// LeasePendingOrders выдаёт пачку незавершённых заказов одному экземпляру poller.
+// Строки выбираются с FOR UPDATE SKIP LOCKED, поэтому параллельные экземпляры
+// получают разные заказы; аренда истекает сама, если экземпляр упал.
+func (s *PSQLStorage) LeasePendingOrders(ctx context.Context, owner string, limit int, lease time.Duration) ([]*models.BalanceOperation, error) {
 	rows, err := s.db.Query(ctx, `
-		SELECT order_number, user_id, amount, operation_type, status, processed_at
-		FROM balance_operations
-		WHERE operation_type = 'accrual' AND status = 'NEW'
-	`)
+		UPDATE orders
+		SET lease_owner = $1, lease_until = NOW() + make_interval(secs => $3)
+		WHERE id IN (
+			SELECT id FROM orders
+			WHERE status IN ('NEW', 'PROCESSING')
+				AND stuck_at IS NULL
+				AND next_poll_at <= NOW()
+				AND (lease_until IS NULL OR lease_until < NOW())
+			ORDER BY next_poll_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING order_number, user_id, accrual, status, processed_at,
+			next_poll_at, poll_attempts, COALESCE(last_poll_error, '')
+	`, owner, limit, lease.Seconds())
 	if err != nil {
 		return nil, err
 	}
@@ -320,39 +642,817 @@ func (s *PSQLStorage) GetNewOrders(ctx context.Context) ([]*models.BalanceOperat
 
 	var ops []*models.BalanceOperation
 	for rows.Next() {
-		op := &models.BalanceOperation{}
-		var opType string
-		if err := rows.Scan(&op.OrderNumber, &op.UserID, &op.Amount, &opType, &op.Status, &op.ProcessedAt); err != nil {
+		op := &models.BalanceOperation{OperationType: models.AccrualOp}
+		if err := rows.Scan(&op.OrderNumber, &op.UserID, &op.Amount, &op.Status, &op.ProcessedAt,
+			&op.NextPollAt, &op.PollAttempts, &op.LastPollError); err != nil {
 			return nil, err
 		}
-		op.OperationType = models.OperationType(opType)
 		ops = append(ops, op)
 	}
-	return ops, nil
+	return ops, rows.Err()
 }
 
-// UpdateOrderStatus обновляет статус и начисление
-func (s *PSQLStorage) UpdateOrderStatus(ctx context.Context, orderNumber string, status models.Status, accrual float64) error {
-	// Обновляем статус и начисление (если есть)
-	var query string
-	var args []interface{}
+// SchedulePoll записывает попытку опроса и время следующей
+func (s *PSQLStorage) SchedulePoll(ctx context.Context, orderNumber string, nextPollAt time.Time, lastErr string) error {
+	tag, err := s.db.Exec(ctx, `
+		UPDATE orders
+		SET poll_attempts = poll_attempts + 1,
+			next_poll_at = $1,
+			last_poll_error = NULLIF($2, ''),
+			lease_owner = NULL,
+			lease_until = NULL
+		WHERE order_number = $3
+	`, nextPollAt, lastErr, orderNumber)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrOrderNotFound
+	}
+	return nil
+}
+
+// ReleaseOrderLease снимает только свою аренду: истёкшую мог уже взять другой экземпляр
+func (s *PSQLStorage) ReleaseOrderLease(ctx context.Context, orderNumber, owner string) error {
+	_, err := s.db.Exec(ctx, `
+		UPDATE orders
+		SET lease_owner = NULL, lease_until = NULL
+		WHERE order_number = $1 AND lease_owner = $2
+	`, orderNumber, owner)
+	return err
+}
+
+// UpdateOrderStatus обновляет статус и начисление.
+// Текущий статус читается под блокировкой строки, переход проверяется
+// по жизненному циклу заказа и записывается в order_status_history.
+// Переход в PROCESSED начисляет баллы записью журнала. Пометка зависшего снимается:
+// статус пришёл, заказ больше не ждёт разбора администратором.
+func (s *PSQLStorage) UpdateOrderStatus(ctx context.Context, orderNumber string, status models.Status, accrual models.Money) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
 
+	var id, userID int64
+	var current models.Status
+	var amount models.Money
+	err = tx.QueryRow(ctx, `
+		SELECT id, user_id, status, accrual FROM orders
+		WHERE order_number = $1
+		FOR UPDATE
+	`, orderNumber).Scan(&id, &userID, &current, &amount)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrOrderNotFound
+		}
+		return err
+	}
+
+	if !models.CanTransition(current, status) {
+		return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, current, status)
+	}
+
+	// Статус не изменился — писать нечего
+	if current == status {
+		return tx.Commit(ctx)
+	}
+
+	// Обновляем статус и начисление (если есть)
 	if accrual > 0 {
-		query = `
-			UPDATE balance_operations
-			SET status = $1, processed_at = NOW(), amount = $2
-			WHERE order_number = $3 AND operation_type = 'accrual'
-		`
-		args = []interface{}{string(status), accrual, orderNumber}
-	} else {
-		query = `
-			UPDATE balance_operations
-			SET status = $1, processed_at = NOW()
-			WHERE order_number = $2 AND operation_type = 'accrual'
-		`
-		args = []interface{}{string(status), orderNumber}
-	}
-
-	_, err := s.db.Exec(ctx, query, args...)
+		amount = accrual
+	}
+	var processedAt time.Time
+	err = tx.QueryRow(ctx, `
+		UPDATE orders
+		SET status = $1, processed_at = NOW(), accrual = $2,
+			stuck_at = NULL, stuck_reason = NULL
+		WHERE id = $3
+		RETURNING processed_at
+	`, string(status), amount, id).Scan(&processedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO order_status_history (order_id, order_number, from_status, to_status)
+		VALUES ($1, $2, $3, $4)
+	`, id, orderNumber, string(current), string(status))
+	if err != nil {
+		return err
+	}
+
+	// до PROCESSED заказ в балансе не учитывался
+	if status == models.ProcessedStatus {
+		_, err = postEntry(ctx, tx, ledgerEntry{
+			entryType:   models.AccrualOp,
+			userID:      userID,
+			orderNumber: orderNumber,
+			orderID:     id,
+			amount:      amount,
+			postedAt:    processedAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeEvent(ctx, tx, orderEvent(userID, orderNumber, current, status, amount, ""))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// --- Stuck orders ---
+
+// MarkStuckOrders помечает незавершённые заказы, которые не получили окончательный
+// статус за maxAge (с загрузки или с ручного возврата в опрос)
+func (s *PSQLStorage) MarkStuckOrders(ctx context.Context, maxAge time.Duration) ([]*models.BalanceOperation, error) {
+	rows, err := s.db.Query(ctx, `
+		UPDATE orders
+		SET stuck_at = NOW(),
+			stuck_reason = 'no final status after ' || $1::TEXT,
+			lease_owner = NULL,
+			lease_until = NULL
+		WHERE status IN ('NEW', 'PROCESSING')
+			AND stuck_at IS NULL
+			AND COALESCE(requeued_at, created_at) < NOW() - make_interval(secs => $2)
+		RETURNING order_number, user_id, status, created_at, stuck_at, stuck_reason,
+			poll_attempts, COALESCE(last_poll_error, '')
+	`, maxAge.String(), maxAge.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanStuckOrders(rows)
+}
+
+func (s *PSQLStorage) GetStuckOrders(ctx context.Context) ([]*models.BalanceOperation, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT order_number, user_id, status, created_at, stuck_at, stuck_reason,
+			poll_attempts, COALESCE(last_poll_error, '')
+		FROM orders
+		WHERE stuck_at IS NOT NULL
+		ORDER BY stuck_at
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanStuckOrders(rows)
+}
+func scanStuckOrders(rows pgx.Rows) ([]*models.BalanceOperation, error) {
+	var ops []*models.BalanceOperation
+	for rows.Next() {
+		op := &models.BalanceOperation{OperationType: models.AccrualOp}
+		if err := rows.Scan(&op.OrderNumber, &op.UserID, &op.Status, &op.CreatedAt, &op.StuckAt, &op.StuckReason,
+			&op.PollAttempts, &op.LastPollError); err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	return ops, rows.Err()
+}
+
+// RequeueOrder возвращает зависший заказ в опрос
+func (s *PSQLStorage) RequeueOrder(ctx context.Context, orderNumber string) error {
+	tag, err := s.db.Exec(ctx, `
+		UPDATE orders
+		SET stuck_at = NULL,
+			stuck_reason = NULL,
+			requeued_at = NOW(),
+			poll_attempts = 0,
+			last_poll_error = NULL,
+			next_poll_at = NOW()
+		WHERE order_number = $1 AND stuck_at IS NOT NULL
+	`, orderNumber)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrOrderNotFound
+	}
+	return nil
+}
+
+// ForceOrderStatus вручную переводит заказ в окончательный статус с указанием причины
+func (s *PSQLStorage) ForceOrderStatus(ctx context.Context, orderNumber string, status models.Status, accrual models.Money, reason string) error {
+	if !status.IsFinal() {
+		return fmt.Errorf("%w: forced status must be final, got %s", ErrIllegalTransition, status)
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var id, userID int64
+	var current models.Status
+	err = tx.QueryRow(ctx, `
+		SELECT id, user_id, status FROM orders
+		WHERE order_number = $1
+		FOR UPDATE
+	`, orderNumber).Scan(&id, &userID, &current)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrOrderNotFound
+		}
+		return err
+	}
+
+	if !models.CanTransition(current, status) {
+		return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, current, status)
+	}
+
+	if status != models.ProcessedStatus {
+		accrual = 0
+	}
+
+	var processedAt time.Time
+	err = tx.QueryRow(ctx, `
+		UPDATE orders
+		SET status = $1, accrual = $2, processed_at = NOW(),
+			stuck_at = NULL, stuck_reason = NULL,
+			lease_owner = NULL, lease_until = NULL
+		WHERE id = $3
+		RETURNING processed_at
+	`, string(status), accrual, id).Scan(&processedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO order_status_history (order_id, order_number, from_status, to_status, reason)
+		VALUES ($1, $2, $3, $4, $5)
+	`, id, orderNumber, string(current), string(status), reason)
+	if err != nil {
+		return err
+	}
+
+	if status == models.ProcessedStatus {
+		_, err = postEntry(ctx, tx, ledgerEntry{
+			entryType:   models.AccrualOp,
+			userID:      userID,
+			orderNumber: orderNumber,
+			orderID:     id,
+			amount:      accrual,
+			postedAt:    processedAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeEvent(ctx, tx, orderEvent(userID, orderNumber, current, status, accrual, reason))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// --- Reconciliation ---
+
+// GetProcessedAccruals пачка рассчитанных заказов для сверки, постранично по id
+func (s *PSQLStorage) GetProcessedAccruals(ctx context.Context, afterID int64, limit int) ([]*models.BalanceOperation, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT o.id, o.user_id, o.order_number, o.status, o.processed_at, `+orderCreditedSQL+`
+		FROM orders o
+		WHERE o.status = 'PROCESSED' AND o.id > $1
+		ORDER BY o.id
+		LIMIT $2
+	`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ops []*models.BalanceOperation
+	for rows.Next() {
+		op := &models.BalanceOperation{OperationType: models.AccrualOp}
+		if err := rows.Scan(&op.ID, &op.UserID, &op.OrderNumber, &op.Status, &op.ProcessedAt, &op.Amount); err != nil {
+			return nil, err
+		}
+		op.Accrual = op.Amount
+		ops = append(ops, op)
+	}
+	return ops, rows.Err()
+}
+
+// AdjustAccrual записывает корректировку начисления по заказу новой записью журнала.
+// Исходное начисление не меняется, поэтому история расхождения сохраняется.
+func (s *PSQLStorage) AdjustAccrual(ctx context.Context, orderID int64, delta models.Money, reason string) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var userID int64
+	var orderNumber string
+	err = tx.QueryRow(ctx, `
+		SELECT user_id, order_number FROM orders WHERE id = $1
+	`, orderID).Scan(&userID, &orderNumber)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrOrderNotFound
+		}
+		return err
+	}
+
+	_, err = postEntry(ctx, tx, ledgerEntry{
+		entryType:   models.AdjustmentOp,
+		userID:      userID,
+		orderNumber: orderNumber,
+		orderID:     orderID,
+		reason:      reason,
+		amount:      delta,
+		postedAt:    time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// --- Outbox ---
+
+// writeEvent пишет событие в outbox в транзакции изменения. Номер событию присвоит
+// numberOutboxEvents после коммита, см. outboxLockID.
+func writeEvent(ctx context.Context, tx pgx.Tx, payload models.EventPayload) error {
+	data, err := marshalEvent(payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO outbox_events (event_type, payload) VALUES ($1, $2)
+	`, string(payload.EventType()), data)
 	return err
 }
+
+// numberOutboxEvents присваивает position событиям транзакций, завершившихся раньше
+// самой старой из идущих: новых событий с такими txid уже не появится. Номера идут
+// после уже выданных, поэтому получатель, прочитавший до N, ничего до N не пропустит.
+func (s *PSQLStorage) numberOutboxEvents(ctx context.Context) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, outboxLockID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE outbox_events e
+		SET position = n.base + n.rn
+		FROM (
+			SELECT id, ROW_NUMBER() OVER (ORDER BY id) AS rn,
+				(SELECT COALESCE(MAX(position), 0) FROM outbox_events) AS base
+			FROM outbox_events
+			WHERE position IS NULL AND txid < pg_snapshot_xmin(pg_current_snapshot())
+		) n
+		WHERE e.id = n.id
+	`)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// GetOutboxEvents события по номеру position, он же id события для получателей
+func (s *PSQLStorage) GetOutboxEvents(ctx context.Context, afterID int64, limit int) ([]*models.OutboxEvent, error) {
+	if err := s.numberOutboxEvents(ctx); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT position, event_type, payload::TEXT, created_at
+		FROM outbox_events
+		WHERE position > $1
+		ORDER BY position
+		LIMIT $2
+	`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*models.OutboxEvent
+	for rows.Next() {
+		e := &models.OutboxEvent{}
+		var payload string
+		if err := rows.Scan(&e.ID, &e.Type, &payload, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Payload = json.RawMessage(payload)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (s *PSQLStorage) GetOutboxOffset(ctx context.Context, sink string) (int64, error) {
+	var offset int64
+	err := s.db.QueryRow(ctx, `
+		SELECT last_event_id FROM outbox_offsets WHERE sink = $1
+	`, sink).Scan(&offset)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	return offset, err
+}
+
+func (s *PSQLStorage) SaveOutboxOffset(ctx context.Context, sink string, eventID int64) error {
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO outbox_offsets (sink, last_event_id, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (sink) DO UPDATE
+		SET last_event_id = GREATEST(outbox_offsets.last_event_id, EXCLUDED.last_event_id), updated_at = NOW()
+	`, sink, eventID)
+	return err
+}
+
+// --- Audit ---
+
+// AppendAuditEvent дописывает запись к цепочке. Advisory-блокировка держится до коммита,
+// поэтому следующая запись читает хэш уже закоммиченной.
+func (s *PSQLStorage) AppendAuditEvent(ctx context.Context, e *models.AuditEvent) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, auditLockID); err != nil {
+		return err
+	}
+
+	var prevHash string
+	err = tx.QueryRow(ctx, `
+		SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1
+	`).Scan(&prevHash)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	prepareAuditEvent(e, prevHash)
+	err = tx.QueryRow(ctx, `
+		INSERT INTO audit_events (action, actor_id, actor, subject_id, subject, ip, user_agent,
+			request_id, before, after, created_at, prev_hash, hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING id
+	`, string(e.Action), nullableID(e.ActorID), e.Actor, nullableID(e.SubjectID), e.Subject, e.IP, e.UserAgent,
+		e.RequestID, nullableJSON(e.Before), nullableJSON(e.After), e.CreatedAt, e.PrevHash, e.Hash).Scan(&e.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *PSQLStorage) GetAuditEvents(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEvent, error) {
+	q := newPSQLListQuery(filter.AfterID)
+	rows, err := s.db.Query(ctx, `
+		SELECT id, action, COALESCE(actor_id, 0), actor, COALESCE(subject_id, 0), subject, ip, user_agent,
+			request_id, COALESCE(before, ''), COALESCE(after, ''), created_at, prev_hash, hash
+		FROM audit_events
+		WHERE id > $1`+q.auditFilter(filter),
+		q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*models.AuditEvent
+	for rows.Next() {
+		e := &models.AuditEvent{}
+		var before, after string
+		err := rows.Scan(&e.ID, &e.Action, &e.ActorID, &e.Actor, &e.SubjectID, &e.Subject, &e.IP, &e.UserAgent,
+			&e.RequestID, &before, &after, &e.CreatedAt, &e.PrevHash, &e.Hash)
+		if err != nil {
+			return nil, err
+		}
+		e.Before, e.After = auditJSON(before), auditJSON(after)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// --- Ledger archive ---
+
+// GetLedgerMonths месяцы партиций журнала, включая созданные заранее
+func (s *PSQLStorage) GetLedgerMonths(ctx context.Context) ([]time.Time, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'journal_entries'::regclass
+		ORDER BY c.relname
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var months []time.Time
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if month, ok := partitionMonth(name); ok {
+			months = append(months, month)
+		}
+	}
+	return months, rows.Err()
+}
+
+func (s *PSQLStorage) GetLedgerEntries(ctx context.Context, month time.Time, afterID int64, limit int) ([]*models.LedgerEntry, error) {
+	from, to := monthRange(month)
+	rows, err := s.db.Query(ctx, `
+		SELECT e.id, e.entry_type, e.order_number, COALESCE(e.order_id, 0), COALESCE(e.reason, ''), e.posted_at,
+			COALESCE(a.user_id, 0), COALESCE(a.code, ''), p.amount
+		FROM (
+			SELECT * FROM journal_entries
+			WHERE posted_at >= $1 AND posted_at < $2 AND id > $3
+			ORDER BY id
+			LIMIT $4
+		) e
+		JOIN postings p ON p.entry_id = e.id AND p.posted_at = e.posted_at
+		JOIN accounts a ON a.id = p.account_id
+		ORDER BY e.id, p.id
+	`, from, to, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []ledgerEntryRow
+	for rows.Next() {
+		var r ledgerEntryRow
+		err := rows.Scan(&r.entry.ID, &r.entry.EntryType, &r.entry.OrderNumber, &r.entry.OrderID, &r.entry.Reason,
+			&r.entry.PostedAt, &r.posting.UserID, &r.posting.Account, &r.posting.Amount)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return groupLedgerEntries(list), nil
+}
+
+// ArchiveLedgerMonth переносит итоги партиций месяца во входящие остатки, отсоединяет
+// и удаляет их. Журнал блокируется целиком в том же порядке, в каком его пишет postEntry:
+// отсоединение берёт исключительную блокировку родительских таблиц, и повышение
+// блокировки посреди транзакции привело бы к взаимоблокировке с записью проводок.
+func (s *PSQLStorage) ArchiveLedgerMonth(ctx context.Context, month time.Time, entries int) error {
+	from, to := monthRange(month)
+	suffix := partitionSuffix(from)
+	journal := pgx.Identifier{journalPartitionPrefix + suffix}.Sanitize()
+	postings := pgx.Identifier{postingsPartitionPrefix + suffix}.Sanitize()
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `LOCK TABLE journal_entries, postings IN ACCESS EXCLUSIVE MODE`); err != nil {
+		return err
+	}
+
+	var older bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM journal_entries WHERE posted_at < $1)
+	`, from).Scan(&older)
+	if err != nil {
+		return err
+	}
+	if older {
+		return ErrArchiveNotOldest
+	}
+
+	var partitioned bool
+	err = tx.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL`, journalPartitionPrefix+suffix).Scan(&partitioned)
+	if err != nil {
+		return err
+	}
+
+	// без партиции записи месяца лежат в партиции по умолчанию, отсоединить их нельзя
+	var count int
+	err = tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM journal_entries WHERE posted_at >= $1 AND posted_at < $2
+	`, from, to).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if !partitioned && count > 0 {
+		return fmt.Errorf("ledger month %s has no partition", suffix)
+	}
+	if count != entries {
+		return fmt.Errorf("%w: %s has %d entries, exported %d", ErrArchiveMismatch, suffix, count, entries)
+	}
+
+	if partitioned {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO ledger_openings (account_id, amount, withdrawn)
+			SELECT p.account_id, SUM(p.amount),
+				SUM(CASE WHEN e.entry_type = 'withdrawal' AND a.user_id IS NOT NULL THEN -p.amount ELSE 0 END)
+			FROM `+postings+` p
+			JOIN `+journal+` e ON e.id = p.entry_id
+			JOIN accounts a ON a.id = p.account_id
+			GROUP BY p.account_id
+			ON CONFLICT (account_id) DO UPDATE
+			SET amount = ledger_openings.amount + EXCLUDED.amount,
+				withdrawn = ledger_openings.withdrawn + EXCLUDED.withdrawn
+		`)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `
+			UPDATE orders o
+			SET archived_credit = o.archived_credit + c.amount,
+				accrual_archived = o.accrual_archived OR c.accrual
+			FROM (
+				SELECT e.order_id, SUM(p.amount) AS amount, BOOL_OR(e.entry_type = 'accrual') AS accrual
+				FROM `+journal+` e
+				JOIN `+postings+` p ON p.entry_id = e.id
+				JOIN accounts a ON a.id = p.account_id
+				WHERE e.order_id IS NOT NULL AND a.user_id IS NOT NULL
+				GROUP BY e.order_id
+			) c
+			WHERE o.id = c.order_id
+		`)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO ledger_archive (month, archived_until, entries, archived_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (month) DO UPDATE
+		SET entries = ledger_archive.entries + EXCLUDED.entries, archived_at = NOW()
+	`, from, to, count)
+	if err != nil {
+		return err
+	}
+
+	if partitioned {
+		// внешний ключ проводок на записи остаётся и у отсоединённой партиции, поэтому
+		// партиция проводок удаляется целиком до того, как отсоединяются записи
+		_, err = tx.Exec(ctx, `
+			ALTER TABLE postings DETACH PARTITION `+postings+`;
+			DROP TABLE `+postings+`;
+			ALTER TABLE journal_entries DETACH PARTITION `+journal+`;
+			DROP TABLE `+journal+`;
+		`)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// EnsureLedgerPartitions создаёт партиции месяцев функцией create_ledger_partition
+func (s *PSQLStorage) EnsureLedgerPartitions(ctx context.Context, from time.Time, months int) ([]string, error) {
+	start, _ := monthRange(from)
+
+	var created []string
+	for i := 0; i <= months; i++ {
+		month := start.AddDate(0, i, 0)
+		var ok bool
+		err := s.db.QueryRow(ctx, `SELECT create_ledger_partition($1::DATE)`, month.Format(time.DateOnly)).Scan(&ok)
+		if err != nil {
+			return created, err
+		}
+		if ok {
+			created = append(created, journalPartitionPrefix+partitionSuffix(month))
+		}
+	}
+	return created, nil
+}
+
+// --- Idempotency ---
+
+// ReserveIdempotencyKey занимает новый или истёкший ключ. ON CONFLICT DO UPDATE блокирует
+// живой ключ, даже когда WHERE не выполнено, поэтому он читается под блокировкой.
+func (s *PSQLStorage) ReserveIdempotencyKey(ctx context.Context, userID int64, key, fingerprint string, lockUntil time.Time) (*models.IdempotentResponse, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO idempotency_keys (user_id, idempotency_key, fingerprint, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, idempotency_key) DO UPDATE
+		SET fingerprint = EXCLUDED.fingerprint,
+			status_code = NULL,
+			content_type = NULL,
+			body = NULL,
+			created_at = NOW(),
+			expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= NOW()
+	`, userID, key, fingerprint, lockUntil)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 1 {
+		return nil, tx.Commit(ctx)
+	}
+
+	var stored storedIdempotencyKey
+	err = tx.QueryRow(ctx, `
+		SELECT fingerprint, status_code, content_type, body
+		FROM idempotency_keys
+		WHERE user_id = $1 AND idempotency_key = $2
+	`, userID, key).Scan(&stored.fingerprint, &stored.statusCode, &stored.contentType, &stored.body)
+	if err != nil {
+		return nil, err
+	}
+	return stored.replay(fingerprint)
+}
+
+func (s *PSQLStorage) SaveIdempotentResponse(ctx context.Context, userID int64, key string, resp *models.IdempotentResponse, expiresAt time.Time) error {
+	_, err := s.db.Exec(ctx, `
+		UPDATE idempotency_keys
+		SET status_code = $3, content_type = $4, body = $5, expires_at = $6
+		WHERE user_id = $1 AND idempotency_key = $2 AND status_code IS NULL
+	`, userID, key, resp.StatusCode, resp.ContentType, resp.Body, expiresAt)
+	return err
+}
+
+func (s *PSQLStorage) ReleaseIdempotencyKey(ctx context.Context, userID int64, key string) error {
+	_, err := s.db.Exec(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND idempotency_key = $2 AND status_code IS NULL
+	`, userID, key)
+	return err
+}
+
+func (s *PSQLStorage) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	tag, err := s.db.Exec(ctx, `
+		DELETE FROM idempotency_keys WHERE expires_at <= NOW()
+	`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// --- Webhook signatures ---
+
+// SaveWebhookSignature вставляет новую или занимает истёкшую подпись. Живая подпись
+// не обновляется, и вставка не затрагивает строк — это повтор.
+func (s *PSQLStorage) SaveWebhookSignature(ctx context.Context, signature string, expiresAt time.Time) error {
+	tag, err := s.db.Exec(ctx, `
+		INSERT INTO webhook_signatures (signature, expires_at)
+		VALUES ($1, $2)
+		ON CONFLICT (signature) DO UPDATE
+		SET expires_at = EXCLUDED.expires_at
+		WHERE webhook_signatures.expires_at <= NOW()
+	`, signature, expiresAt)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrWebhookReplay
+	}
+	return nil
+}
+
+func (s *PSQLStorage) DeleteWebhookSignature(ctx context.Context, signature string) error {
+	_, err := s.db.Exec(ctx, `
+		DELETE FROM webhook_signatures WHERE signature = $1
+	`, signature)
+	return err
+}
+
+func (s *PSQLStorage) DeleteExpiredWebhookSignatures(ctx context.Context) (int64, error) {
+	tag, err := s.db.Exec(ctx, `
+		DELETE FROM webhook_signatures WHERE expires_at <= NOW()
+	`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPSQLStorage хранилище на TEST_DATABASE_URI, без неё тест пропускается
+func newTestPSQLStorage(t *testing.T) Storage {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+
+	ctx := context.Background()
+	s, err := NewPSQLStorage(ctx, dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	require.NoError(t, s.Migrate(ctx))
+
+	return s
+}
+
+func TestPSQLStorage(t *testing.T) {
+	testStorage(t, newTestPSQLStorage)
+}
+
+// Событие транзакции, которая ещё идёт, не получает номер раньше событий, закоммиченных
+// после её начала: иначе relay сдвинул бы смещение за него и оно бы потерялось
+func TestPSQLOutboxCommitOrder(t *testing.T) {
+	ctx := context.Background()
+	s := newTestPSQLStorage(t)
+	db := s.(*PSQLStorage).db
+	start := lastEventID(t, s)
+
+	// eventIDs номера событий регистрации логинов из logins
+	eventIDs := func(logins ...string) map[string]int64 {
+		events, err := s.GetOutboxEvents(ctx, start, 1000)
+		require.NoError(t, err)
+		ids := make(map[string]int64)
+		for _, e := range events {
+			var p models.UserRegistered
+			require.NoError(t, json.Unmarshal(e.Payload, &p))
+			if slices.Contains(logins, p.Login) {
+				ids[p.Login] = e.ID
+			}
+		}
+		return ids
+	}
+
+	slowLogin, fastLogin := uniq("slow"), uniq("fast")
+	slow, err := db.Begin(ctx)
+	require.NoError(t, err)
+	defer slow.Rollback(ctx)
+	require.NoError(t, writeEvent(ctx, slow, models.UserRegistered{Login: slowLogin}))
+
+	_, err = s.SaveUser(ctx, fastLogin, "hash")
+	require.NoError(t, err)
+
+	// быстрая транзакция закоммичена, но начатая раньше медленная ещё идёт
+	assert.Empty(t, eventIDs(slowLogin, fastLogin))
+
+	require.NoError(t, slow.Commit(ctx))
+	ids := eventIDs(slowLogin, fastLogin)
+	require.Len(t, ids, 2)
+	assert.Greater(t, ids[fastLogin], start)
+	assert.Greater(t, ids[slowLogin], start)
+}
+
+// Архив месяца удаляет обе его партиции, переносит итоги во входящие остатки и не снимает
+// запрет на второе начисление по заказу. Месяц старше, чем в testArchive: в общей базе
+// он остаётся самым старым при любом порядке тестов.
+func TestPSQLArchiveLedgerMonth(t *testing.T) {
+	ctx := context.Background()
+	s := newTestPSQLStorage(t)
+	db := s.(*PSQLStorage).db
+
+	month := time.Date(2000, time.December, 1, 0, 0, 0, 0, time.UTC)
+	order := archiveAccruedOrder(t, s, month)
+
+	for _, name := range []string{journalPartitionPrefix + "200012", postingsPartitionPrefix + "200012"} {
+		var exists bool
+		require.NoError(t, db.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL`, name).Scan(&exists))
+		assert.False(t, exists, name)
+	}
+
+	// итоги месяца: входящий остаток пользователя и начисленное по заказу
+	var opening, credit models.Money
+	require.NoError(t, db.QueryRow(ctx, `
+		SELECT lo.amount FROM ledger_openings lo
+		JOIN accounts a ON a.id = lo.account_id
+		WHERE a.user_id = $1
+	`, order.UserID).Scan(&opening))
+	assert.Equal(t, models.Money(10000), opening)
+	require.NoError(t, db.QueryRow(ctx, `
+		SELECT archived_credit FROM orders WHERE id = $1
+	`, order.ID).Scan(&credit))
+	assert.Equal(t, models.Money(10000), credit)
+
+	current, _, err := s.GetBalance(ctx, order.UserID)
+	require.NoError(t, err)
+	assert.Equal(t, models.Money(10000), current)
+	drifts, err := s.VerifyBalances(ctx)
+	require.NoError(t, err)
+	for _, d := range drifts {
+		assert.NotEqual(t, order.UserID, d.UserID)
+	}
+
+	tx, err := db.Begin(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback(ctx)
+	_, err = postEntry(ctx, tx, ledgerEntry{
+		entryType:   models.AccrualOp,
+		userID:      order.UserID,
+		orderNumber: order.OrderNumber,
+		orderID:     order.ID,
+		amount:      10000,
+		postedAt:    time.Now(),
+	})
+	assert.ErrorIs(t, err, ErrAccrualExists)
+}
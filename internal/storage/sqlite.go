@@ -0,0 +1,1407 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+
+	"github.com/rs/zerolog/log"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLiteStorage хранилище во встроенном файле SQLite для установок в один экземпляр.
+// Транзакции начинаются с BEGIN IMMEDIATE: запись в файл идёт по очереди, поэтому
+// проверка баланса и списание атомарны без блокировок строк.
+// Суммы хранятся в копейках, время — в микросекундах Unix.
+type SQLiteStorage struct {
+	db  *sql.DB
+	dsn string
+}
+
+// sqliteBusyTimeout сколько соединение ждёт, пока файл занят другой транзакцией
+const sqliteBusyTimeout = 5 * time.Second
+
+func NewSQLiteStorage(ctx context.Context, path string) (*SQLiteStorage, error) {
+	dsn := sqliteDSN(path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database: %w", err)
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	return &SQLiteStorage{db: db, dsn: dsn}, nil
+}
+
+// sqliteDSN строка открытия файла: транзакции BEGIN IMMEDIATE, внешние ключи, WAL
+func sqliteDSN(path string) string {
+	return fmt.Sprintf("file:%s?_txlock=immediate&_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(%d)",
+		path, sqliteBusyTimeout.Milliseconds())
+}
+
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+// Migrate применяет встроенные миграции SQLite; схема новее бинарника — ErrSchemaAhead
+func (s *SQLiteStorage) Migrate(ctx context.Context) error {
+	log.Logger.Info().Msg("SQLiteStorage.Migrate")
+
+	m, err := newSQLiteMigrations(s.dsn)
+	if err != nil {
+		log.Logger.Error().Err(err).Msg("Failed to create migrate instance")
+		return err
+	}
+	defer m.Close()
+
+	if err := m.Up(); err != nil {
+		log.Logger.Error().Err(err).Msg("Migration failed")
+		return err
+	}
+	return nil
+}
+
+// --- Users ---
+
+// SaveUser создаёт пользователя и его счёт в журнале в одной транзакции
+func (s *SQLiteStorage) SaveUser(ctx context.Context, login, hash string) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO users (login, password_hash, created_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT (login) DO NOTHING
+		RETURNING id
+	`, login, hash, unixMicro(time.Now())).Scan(&id)
+
+	if err != nil {
+		// ON CONFLICT DO NOTHING не возвращает строку — логин занят
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrUserExists
+		}
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO accounts (user_id) VALUES (?)
+	`, id)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := writeSQLiteEvent(ctx, tx, models.UserRegistered{UserID: id, Login: login}); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *SQLiteStorage) IsAdmin(ctx context.Context, userID int64) (bool, error) {
+	var isAdmin bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT is_admin FROM users WHERE id = ?
+	`, userID).Scan(&isAdmin)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrUserNotFound
+		}
+		return false, err
+	}
+	return isAdmin, nil
+}
+
+func (s *SQLiteStorage) SetAdmin(ctx context.Context, login string, isAdmin bool) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE users SET is_admin = ? WHERE login = ?
+	`, isAdmin, login)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) GetUserByLogin(ctx context.Context, login string) (int64, string, error) {
+	var id int64
+	var hash string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, password_hash FROM users WHERE login = ?
+	`, login).Scan(&id, &hash)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, "", ErrUserNotFound
+		}
+		return 0, "", err
+	}
+	return id, hash, nil
+}
+
+// --- Operations ---
+
+// CreateOperation записывает заказ или списание. Заказ, загруженный сразу в статусе
+// PROCESSED, начисляется записью журнала в той же транзакции.
+func (s *SQLiteStorage) CreateOperation(ctx context.Context, op *models.BalanceOperation) error {
+	switch op.OperationType {
+	case models.WithdrawalOp:
+		// Списание — только через проверку баланса
+		return s.Withdraw(ctx, op)
+	case models.AccrualOp:
+	default:
+		return fmt.Errorf("create operation: unsupported type %q", op.OperationType)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	op.ID, err = nextSQLiteOperationID(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	now := unixMicro(time.Now())
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO orders (id, user_id, order_number, status, accrual, processed_at, created_at, next_poll_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, op.ID, op.UserID, op.OrderNumber, string(op.Status), op.Amount, unixMicro(op.ProcessedAt), now, now)
+	if err != nil {
+		// номер заказа уже есть — уникальный индекс orders.order_number
+		if isSQLiteUniqueViolation(err) {
+			return ErrOrderExists
+		}
+		return err
+	}
+
+	// Первая запись истории статусов заказа
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO order_status_history (order_id, order_number, from_status, to_status, changed_at)
+		VALUES (?, ?, NULL, ?, ?)
+	`, op.ID, op.OrderNumber, string(op.Status), unixMicro(op.ProcessedAt))
+	if err != nil {
+		return err
+	}
+
+	// Сразу рассчитанный заказ начисляется
+	if op.Status == models.ProcessedStatus {
+		_, err = postSQLiteEntry(ctx, tx, ledgerEntry{
+			entryType:   models.AccrualOp,
+			userID:      op.UserID,
+			orderNumber: op.OrderNumber,
+			orderID:     op.ID,
+			amount:      op.Amount,
+			postedAt:    op.ProcessedAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeSQLiteEvent(ctx, tx, orderEvent(op.UserID, op.OrderNumber, "", op.Status, op.Amount, ""))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UploadOrder вставляет заказ или возвращает владельца уже загруженного.
+// Транзакции записи идут по очереди, поэтому конфликтующая строка всегда видна.
+func (s *SQLiteStorage) UploadOrder(ctx context.Context, op *models.BalanceOperation) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := nextSQLiteOperationID(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	now := unixMicro(time.Now())
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO orders (id, user_id, order_number, status, processed_at, created_at, next_poll_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (order_number) DO NOTHING
+		RETURNING id
+	`, id, op.UserID, op.OrderNumber, string(op.Status), unixMicro(op.ProcessedAt), now, now).Scan(&op.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		var ownerID int64
+		err = tx.QueryRowContext(ctx, `
+			SELECT user_id FROM orders WHERE order_number = ?
+		`, op.OrderNumber).Scan(&ownerID)
+		if err != nil {
+			return err
+		}
+		if ownerID == op.UserID {
+			return ErrOrderExists
+		}
+		return ErrOrderMine
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO order_status_history (order_id, order_number, from_status, to_status, changed_at)
+		VALUES (?, ?, NULL, ?, ?)
+	`, op.ID, op.OrderNumber, string(op.Status), unixMicro(op.ProcessedAt))
+	if err != nil {
+		return err
+	}
+
+	err = writeSQLiteEvent(ctx, tx, orderEvent(op.UserID, op.OrderNumber, "", op.Status, 0, ""))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Withdraw проверяет баланс и записывает списание в журнал в одной транзакции.
+// BEGIN IMMEDIATE сразу берёт блокировку записи, поэтому параллельное списание
+// ждёт коммита и читает уже обновлённый баланс.
+func (s *SQLiteStorage) Withdraw(ctx context.Context, op *models.BalanceOperation) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current models.Money
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE((SELECT current FROM user_balances WHERE user_id = u.id), 0)
+		FROM users u WHERE u.id = ?
+	`, op.UserID).Scan(&current)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	if current < -op.Amount { // op.Amount отрицательное, но -op.Amount = положительная сумма
+		return ErrNoMoney
+	}
+
+	op.ID, err = postSQLiteEntry(ctx, tx, ledgerEntry{
+		entryType:   models.WithdrawalOp,
+		userID:      op.UserID,
+		orderNumber: op.OrderNumber,
+		amount:      op.Amount,
+		postedAt:    op.ProcessedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = writeSQLiteEvent(ctx, tx, models.BalanceWithdrawn{UserID: op.UserID, Order: op.OrderNumber, Sum: -op.Amount})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetOperationsByUser выписка с балансом после каждой операции от входящего остатка
+// архива, как в PostgreSQL
+func (s *SQLiteStorage) GetOperationsByUser(ctx context.Context, userID int64, filter models.ListFilter) ([]*models.BalanceOperation, error) {
+	q := newSQLiteListQuery(userID)
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT o.id, o.order_number, o.amount, o.operation_type, o.status, o.processed_at, o.balance
+		FROM (
+			SELECT f.*, SUM(f.amount) OVER (ORDER BY f.processed_at, f.id) + COALESCE((
+				SELECT lo.amount FROM ledger_openings lo
+				JOIN accounts a ON a.id = lo.account_id
+				WHERE a.user_id = ?1
+			), 0) AS balance
+			FROM (
+				SELECT e.id, e.order_number, p.amount, e.entry_type AS operation_type,
+					'PROCESSED' AS status, e.posted_at AS processed_at
+				FROM journal_entries e
+				JOIN postings p ON p.entry_id = e.id
+				JOIN accounts a ON a.id = p.account_id
+				WHERE a.user_id = ?1
+				UNION ALL
+				SELECT id, order_number, 0, 'accrual', status, created_at
+				FROM orders o
+				WHERE user_id = ?1 AND NOT o.accrual_archived AND NOT (`+finalArchivedSQL+`) AND NOT EXISTS (
+					SELECT 1 FROM journal_entries e
+					WHERE e.order_id = o.id AND e.entry_type = 'accrual'
+				)
+			) f
+		) o
+		WHERE TRUE`+q.where(filter, "o.")+q.orderLimit(filter, "o."),
+		q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ops []*models.BalanceOperation
+	for rows.Next() {
+		op := &models.BalanceOperation{UserID: userID}
+		if err := rows.Scan(&op.ID, &op.OrderNumber, &op.Amount, &op.OperationType, &op.Status,
+			sqliteTime{&op.ProcessedAt}, &op.Balance); err != nil {
+			return nil, err
+		}
+
+		if op.Amount > 0 {
+			op.Accrual = op.Amount
+		} else if op.Amount < 0 {
+			op.Sum = -op.Amount
+		}
+		ops = append(ops, op)
+	}
+	return ops, rows.Err()
+}
+
+// --- Ledger ---
+
+// nextSQLiteOperationID id заказа или записи журнала из общего счётчика operation_ids,
+// как последовательность operation_id_seq в PostgreSQL
+func nextSQLiteOperationID(ctx context.Context, tx *sql.Tx) (int64, error) {
+	var id int64
+	err := tx.QueryRowContext(ctx, `
+		UPDATE operation_ids SET last = last + 1 RETURNING last
+	`).Scan(&id)
+	return id, err
+}
+
+// postSQLiteEntry записывает запись журнала с проводками по счёту пользователя и системному
+// счёту и обновляет user_balances в той же транзакции. Нулевая сумма не пишется.
+func postSQLiteEntry(ctx context.Context, tx *sql.Tx, e ledgerEntry) (int64, error) {
+	if e.amount == 0 {
+		return 0, nil
+	}
+
+	id, err := nextSQLiteOperationID(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	// одно начисление на заказ и после архива месяца, см. accrual_entries;
+	// отмечается до записи, иначе повтор отсечёт idx_journal_entries_accrual с другой ошибкой
+	if e.entryType == models.AccrualOp {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO accrual_entries (order_id, entry_id) VALUES (?, ?)
+		`, e.orderID, id)
+		if isSQLiteUniqueViolation(err) {
+			return 0, ErrAccrualExists
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO journal_entries (id, entry_type, order_number, order_id, reason, posted_at)
+		VALUES (?, ?, ?, NULLIF(?, 0), NULLIF(?, ''), ?)
+	`, id, string(e.entryType), e.orderNumber, e.orderID, e.reason, unixMicro(e.postedAt))
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO postings (entry_id, account_id, amount)
+		VALUES (?1, (SELECT id FROM accounts WHERE user_id = ?2), ?3),
+			(?1, (SELECT id FROM accounts WHERE code = ?4), ?5)
+	`, id, e.userID, e.amount, e.counterAccount(), -e.amount)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, addSQLiteUserBalance(ctx, tx, e.userID, e.amount, e.withdrawn())
+}
+
+// --- Balance ---
+
+// GetBalance баланс из user_balances; нет строки — движений по счёту ещё не было
+func (s *SQLiteStorage) GetBalance(ctx context.Context, userID int64) (current, withdrawn models.Money, err error) {
+	err = s.db.QueryRowContext(ctx, `
+		SELECT current, withdrawn FROM user_balances WHERE user_id = ?
+	`, userID).Scan(&current, &withdrawn)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, nil
+	}
+
+	return current, withdrawn, err
+}
+
+// addSQLiteUserBalance меняет баланс пользователя в транзакции записи журнала
+func addSQLiteUserBalance(ctx context.Context, tx *sql.Tx, userID int64, current, withdrawn models.Money) error {
+	if current == 0 && withdrawn == 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO user_balances (user_id, current, withdrawn, version, updated_at)
+		VALUES (?1, ?2, ?3, 1, ?4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET current = user_balances.current + excluded.current,
+			withdrawn = user_balances.withdrawn + excluded.withdrawn,
+			version = user_balances.version + 1,
+			updated_at = ?4
+	`, userID, current, withdrawn, unixMicro(time.Now()))
+	return err
+}
+
+// VerifyBalances пересчитывает балансы по проводкам журнала и входящим остаткам архива
+// и возвращает пользователей, у которых user_balances расходится с журналом.
+// Чтение в одной транзакции.
+func (s *SQLiteStorage) VerifyBalances(ctx context.Context) ([]models.BalanceDrift, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		WITH moves AS (
+			SELECT a.user_id, p.amount AS current,
+				CASE WHEN e.entry_type = 'withdrawal' THEN -p.amount ELSE 0 END AS withdrawn
+			FROM postings p
+			JOIN accounts a ON a.id = p.account_id
+			JOIN journal_entries e ON e.id = p.entry_id
+			WHERE a.user_id IS NOT NULL
+			UNION ALL
+			SELECT a.user_id, lo.amount, lo.withdrawn
+			FROM ledger_openings lo
+			JOIN accounts a ON a.id = lo.account_id
+			WHERE a.user_id IS NOT NULL
+		), ledger AS (
+			SELECT user_id, SUM(current) AS current, SUM(withdrawn) AS withdrawn
+			FROM moves
+			GROUP BY user_id
+		)
+		SELECT COALESCE(b.user_id, l.user_id),
+			COALESCE(b.current, 0), COALESCE(b.withdrawn, 0),
+			COALESCE(l.current, 0), COALESCE(l.withdrawn, 0)
+		FROM user_balances b
+		FULL OUTER JOIN ledger l ON l.user_id = b.user_id
+		WHERE COALESCE(b.current, 0) <> COALESCE(l.current, 0)
+			OR COALESCE(b.withdrawn, 0) <> COALESCE(l.withdrawn, 0)
+		ORDER BY 1
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	drifts := []models.BalanceDrift{}
+	for rows.Next() {
+		var d models.BalanceDrift
+		if err := rows.Scan(&d.UserID, &d.Current, &d.Withdrawn, &d.LedgerCurrent, &d.LedgerWithdrawn); err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, d)
+	}
+	return drifts, rows.Err()
+}
+
+func (s *SQLiteStorage) GetAccrualsByUser(ctx context.Context, userID int64, filter models.ListFilter) ([]*models.BalanceOperation, error) {
+	q := newSQLiteListQuery(userID).by(listByCreatedAt)
+
+	// начисление — по журналу, с учётом корректировок сверки; список — по времени загрузки
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT o.id, o.order_number, `+orderCreditedSQL+`, o.status, o.processed_at, o.created_at
+		FROM orders o
+		WHERE o.user_id = ?1`+q.where(filter, "o.")+q.orderLimit(filter, "o."),
+		q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ops []*models.BalanceOperation
+	for rows.Next() {
+		op := &models.BalanceOperation{UserID: userID, OperationType: models.AccrualOp}
+		if err := rows.Scan(&op.ID, &op.OrderNumber, &op.Amount, &op.Status, sqliteTime{&op.ProcessedAt}, sqliteTime{&op.CreatedAt}); err != nil {
+			return nil, err
+		}
+
+		if op.Amount > 0 {
+			op.Accrual = op.Amount
+		}
+		ops = append(ops, op)
+	}
+	return ops, rows.Err()
+}
+
+func (s *SQLiteStorage) GetWithdrawalsByUser(ctx context.Context, userID int64, filter models.ListFilter) ([]*models.BalanceOperation, error) {
+	q := newSQLiteListQuery(userID)
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT w.id, w.order_number, w.amount, w.status, w.processed_at
+		FROM (
+			SELECT e.id, e.order_number, p.amount, 'PROCESSED' AS status, e.posted_at AS processed_at
+			FROM journal_entries e
+			JOIN postings p ON p.entry_id = e.id
+			JOIN accounts a ON a.id = p.account_id
+			WHERE a.user_id = ?1 AND e.entry_type = 'withdrawal'
+		) w
+		WHERE TRUE`+q.where(filter, "w.")+q.orderLimit(filter, "w."),
+		q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ops []*models.BalanceOperation
+	for rows.Next() {
+		op := &models.BalanceOperation{UserID: userID, OperationType: models.WithdrawalOp}
+		if err := rows.Scan(&op.ID, &op.OrderNumber, &op.Amount, &op.Status, sqliteTime{&op.ProcessedAt}); err != nil {
+			return nil, err
+		}
+
+		if op.Amount < 0 {
+			op.Sum = -op.Amount
+		}
+		ops = append(ops, op)
+	}
+	return ops, rows.Err()
+}
+
+// GetOrder заказ; Amount — начисление по данным accrual без корректировок
+func (s *SQLiteStorage) GetOrder(ctx context.Context, number string) (*models.BalanceOperation, error) {
+	op := &models.BalanceOperation{OrderNumber: number, OperationType: models.AccrualOp}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, user_id, accrual, status, processed_at
+		FROM orders
+		WHERE order_number = ?
+	`, number).Scan(&op.ID, &op.UserID, &op.Amount, &op.Status, sqliteTime{&op.ProcessedAt})
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOrderNotFound
+		}
+		log.Error().Err(err).Str("number", number).Msg("Database error in GetOrder")
+		return nil, err
+	}
+
+	if op.Amount > 0 {
+		op.Accrual = op.Amount
+	}
+
+	return op, nil
+}
+
+// LeasePendingOrders выдаёт пачку незавершённых заказов одному экземпляру poller.
+// Выбор и аренда — один UPDATE, под блокировкой записи файла.
+func (s *SQLiteStorage) LeasePendingOrders(ctx context.Context, owner string, limit int, lease time.Duration) ([]*models.BalanceOperation, error) {
+	now := time.Now()
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE orders
+		SET lease_owner = ?1, lease_until = ?2
+		WHERE id IN (
+			SELECT id FROM orders
+			WHERE status IN ('NEW', 'PROCESSING')
+				AND stuck_at IS NULL
+				AND next_poll_at <= ?3
+				AND (lease_until IS NULL OR lease_until < ?3)
+			ORDER BY next_poll_at
+			LIMIT ?4
+		)
+		RETURNING order_number, user_id, accrual, status, processed_at,
+			next_poll_at, poll_attempts, COALESCE(last_poll_error, '')
+	`, owner, unixMicro(now.Add(lease)), unixMicro(now), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ops []*models.BalanceOperation
+	for rows.Next() {
+		op := &models.BalanceOperation{OperationType: models.AccrualOp}
+		if err := rows.Scan(&op.OrderNumber, &op.UserID, &op.Amount, &op.Status,
+			sqliteTime{&op.ProcessedAt}, sqliteTime{&op.NextPollAt}, &op.PollAttempts, &op.LastPollError); err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	return ops, rows.Err()
+}
+
+// SchedulePoll записывает попытку опроса и время следующей
+func (s *SQLiteStorage) SchedulePoll(ctx context.Context, orderNumber string, nextPollAt time.Time, lastErr string) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE orders
+		SET poll_attempts = poll_attempts + 1,
+			next_poll_at = ?,
+			last_poll_error = NULLIF(?, ''),
+			lease_owner = NULL,
+			lease_until = NULL
+		WHERE order_number = ?
+	`, unixMicro(nextPollAt), lastErr, orderNumber)
+	return rowsAffectedOrNotFound(res, err)
+}
+
+func (s *SQLiteStorage) ReleaseOrderLease(ctx context.Context, orderNumber, owner string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE orders
+		SET lease_owner = NULL, lease_until = NULL
+		WHERE order_number = ? AND lease_owner = ?
+	`, orderNumber, owner)
+	return err
+}
+
+// UpdateOrderStatus обновляет статус и начисление, переход проверяется
+// по жизненному циклу заказа и записывается в order_status_history.
+// Переход в PROCESSED начисляет баллы записью журнала.
+func (s *SQLiteStorage) UpdateOrderStatus(ctx context.Context, orderNumber string, status models.Status, accrual models.Money) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, userID, current, amount, err := selectSQLiteOrder(ctx, tx, orderNumber)
+	if err != nil {
+		return err
+	}
+
+	if !models.CanTransition(current, status) {
+		return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, current, status)
+	}
+
+	// Статус не изменился — писать нечего
+	if current == status {
+		return tx.Commit()
+	}
+
+	// Обновляем статус и начисление (если есть)
+	if accrual > 0 {
+		amount = accrual
+	}
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, `
+		UPDATE orders
+		SET status = ?, processed_at = ?, accrual = ?,
+			stuck_at = NULL, stuck_reason = NULL
+		WHERE id = ?
+	`, string(status), unixMicro(now), amount, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO order_status_history (order_id, order_number, from_status, to_status, changed_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, id, orderNumber, string(current), string(status), unixMicro(now))
+	if err != nil {
+		return err
+	}
+
+	// до PROCESSED заказ в балансе не учитывался
+	if status == models.ProcessedStatus {
+		_, err = postSQLiteEntry(ctx, tx, ledgerEntry{
+			entryType:   models.AccrualOp,
+			userID:      userID,
+			orderNumber: orderNumber,
+			orderID:     id,
+			amount:      amount,
+			postedAt:    now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeSQLiteEvent(ctx, tx, orderEvent(userID, orderNumber, current, status, amount, ""))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// selectSQLiteOrder текущее состояние заказа внутри транзакции
+func selectSQLiteOrder(ctx context.Context, tx *sql.Tx, orderNumber string) (id, userID int64, status models.Status, accrual models.Money, err error) {
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, user_id, status, accrual FROM orders
+		WHERE order_number = ?
+	`, orderNumber).Scan(&id, &userID, &status, &accrual)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrOrderNotFound
+	}
+	return id, userID, status, accrual, err
+}
+
+// --- Stuck orders ---
+
+// MarkStuckOrders помечает незавершённые заказы, которые не получили окончательный
+// статус за maxAge (с загрузки или с ручного возврата в опрос)
+func (s *SQLiteStorage) MarkStuckOrders(ctx context.Context, maxAge time.Duration) ([]*models.BalanceOperation, error) {
+	now := time.Now()
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE orders
+		SET stuck_at = ?,
+			stuck_reason = ?,
+			lease_owner = NULL,
+			lease_until = NULL
+		WHERE status IN ('NEW', 'PROCESSING')
+			AND stuck_at IS NULL
+			AND COALESCE(requeued_at, created_at) < ?
+		RETURNING order_number, user_id, status, created_at, stuck_at, stuck_reason,
+			poll_attempts, COALESCE(last_poll_error, '')
+	`, unixMicro(now), "no final status after "+maxAge.String(), unixMicro(now.Add(-maxAge)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanSQLiteStuckOrders(rows)
+}
+
+func (s *SQLiteStorage) GetStuckOrders(ctx context.Context) ([]*models.BalanceOperation, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT order_number, user_id, status, created_at, stuck_at, stuck_reason,
+			poll_attempts, COALESCE(last_poll_error, '')
+		FROM orders
+		WHERE stuck_at IS NOT NULL
+		ORDER BY stuck_at
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanSQLiteStuckOrders(rows)
+}
+
+func scanSQLiteStuckOrders(rows *sql.Rows) ([]*models.BalanceOperation, error) {
+	var ops []*models.BalanceOperation
+	for rows.Next() {
+		op := &models.BalanceOperation{OperationType: models.AccrualOp}
+		var stuckAt time.Time
+		if err := rows.Scan(&op.OrderNumber, &op.UserID, &op.Status, sqliteTime{&op.CreatedAt}, sqliteTime{&stuckAt},
+			&op.StuckReason, &op.PollAttempts, &op.LastPollError); err != nil {
+			return nil, err
+		}
+		op.StuckAt = &stuckAt
+		ops = append(ops, op)
+	}
+	return ops, rows.Err()
+}
+
+// RequeueOrder возвращает зависший заказ в опрос
+func (s *SQLiteStorage) RequeueOrder(ctx context.Context, orderNumber string) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE orders
+		SET stuck_at = NULL,
+			stuck_reason = NULL,
+			requeued_at = ?1,
+			poll_attempts = 0,
+			last_poll_error = NULL,
+			next_poll_at = ?1
+		WHERE order_number = ?2 AND stuck_at IS NOT NULL
+	`, unixMicro(time.Now()), orderNumber)
+	return rowsAffectedOrNotFound(res, err)
+}
+
+// ForceOrderStatus вручную переводит заказ в окончательный статус с указанием причины
+func (s *SQLiteStorage) ForceOrderStatus(ctx context.Context, orderNumber string, status models.Status, accrual models.Money, reason string) error {
+	if !status.IsFinal() {
+		return fmt.Errorf("%w: forced status must be final, got %s", ErrIllegalTransition, status)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, userID, current, _, err := selectSQLiteOrder(ctx, tx, orderNumber)
+	if err != nil {
+		return err
+	}
+
+	if !models.CanTransition(current, status) {
+		return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, current, status)
+	}
+
+	if status != models.ProcessedStatus {
+		accrual = 0
+	}
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, `
+		UPDATE orders
+		SET status = ?, accrual = ?, processed_at = ?,
+			stuck_at = NULL, stuck_reason = NULL,
+			lease_owner = NULL, lease_until = NULL
+		WHERE id = ?
+	`, string(status), accrual, unixMicro(now), id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO order_status_history (order_id, order_number, from_status, to_status, changed_at, reason)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, id, orderNumber, string(current), string(status), unixMicro(now), reason)
+	if err != nil {
+		return err
+	}
+
+	if status == models.ProcessedStatus {
+		_, err = postSQLiteEntry(ctx, tx, ledgerEntry{
+			entryType:   models.AccrualOp,
+			userID:      userID,
+			orderNumber: orderNumber,
+			orderID:     id,
+			amount:      accrual,
+			postedAt:    now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeSQLiteEvent(ctx, tx, orderEvent(userID, orderNumber, current, status, accrual, reason))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// --- Reconciliation ---
+
+// GetProcessedAccruals пачка рассчитанных заказов для сверки, постранично по id
+func (s *SQLiteStorage) GetProcessedAccruals(ctx context.Context, afterID int64, limit int) ([]*models.BalanceOperation, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT o.id, o.user_id, o.order_number, o.status, o.processed_at, `+orderCreditedSQL+`
+		FROM orders o
+		WHERE o.status = 'PROCESSED' AND o.id > ?
+		ORDER BY o.id
+		LIMIT ?
+	`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ops []*models.BalanceOperation
+	for rows.Next() {
+		op := &models.BalanceOperation{OperationType: models.AccrualOp}
+		if err := rows.Scan(&op.ID, &op.UserID, &op.OrderNumber, &op.Status, sqliteTime{&op.ProcessedAt}, &op.Amount); err != nil {
+			return nil, err
+		}
+		op.Accrual = op.Amount
+		ops = append(ops, op)
+	}
+	return ops, rows.Err()
+}
+
+// AdjustAccrual записывает корректировку начисления по заказу новой записью журнала.
+// Исходное начисление не меняется, поэтому история расхождения сохраняется.
+func (s *SQLiteStorage) AdjustAccrual(ctx context.Context, orderID int64, delta models.Money, reason string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	var orderNumber string
+	err = tx.QueryRowContext(ctx, `
+		SELECT user_id, order_number FROM orders WHERE id = ?
+	`, orderID).Scan(&userID, &orderNumber)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrOrderNotFound
+		}
+		return err
+	}
+
+	_, err = postSQLiteEntry(ctx, tx, ledgerEntry{
+		entryType:   models.AdjustmentOp,
+		userID:      userID,
+		orderNumber: orderNumber,
+		orderID:     orderID,
+		reason:      reason,
+		amount:      delta,
+		postedAt:    time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// --- Outbox ---
+
+// writeSQLiteEvent пишет событие в outbox в транзакции изменения
+func writeSQLiteEvent(ctx context.Context, tx *sql.Tx, payload models.EventPayload) error {
+	data, err := marshalEvent(payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO outbox_events (event_type, payload, created_at) VALUES (?, ?, ?)
+	`, string(payload.EventType()), data, unixMicro(time.Now()))
+	return err
+}
+
+func (s *SQLiteStorage) GetOutboxEvents(ctx context.Context, afterID int64, limit int) ([]*models.OutboxEvent, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, event_type, payload, created_at
+		FROM outbox_events
+		WHERE id > ?
+		ORDER BY id
+		LIMIT ?
+	`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*models.OutboxEvent
+	for rows.Next() {
+		e := &models.OutboxEvent{}
+		var payload string
+		if err := rows.Scan(&e.ID, &e.Type, &payload, sqliteTime{&e.CreatedAt}); err != nil {
+			return nil, err
+		}
+		e.Payload = json.RawMessage(payload)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (s *SQLiteStorage) GetOutboxOffset(ctx context.Context, sink string) (int64, error) {
+	var offset int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT last_event_id FROM outbox_offsets WHERE sink = ?
+	`, sink).Scan(&offset)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return offset, err
+}
+
+func (s *SQLiteStorage) SaveOutboxOffset(ctx context.Context, sink string, eventID int64) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO outbox_offsets (sink, last_event_id, updated_at)
+		VALUES (?1, ?2, ?3)
+		ON CONFLICT (sink) DO UPDATE
+		SET last_event_id = MAX(outbox_offsets.last_event_id, excluded.last_event_id), updated_at = ?3
+	`, sink, eventID, unixMicro(time.Now()))
+	return err
+}
+
+// --- Audit ---
+
+// AppendAuditEvent дописывает запись к цепочке; транзакция записи держит блокировку
+// файла, поэтому хэш последней записи не меняется до коммита
+func (s *SQLiteStorage) AppendAuditEvent(ctx context.Context, e *models.AuditEvent) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var prevHash string
+	err = tx.QueryRowContext(ctx, `
+		SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1
+	`).Scan(&prevHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	prepareAuditEvent(e, prevHash)
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO audit_events (action, actor_id, actor, subject_id, subject, ip, user_agent,
+			request_id, before, after, created_at, prev_hash, hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, string(e.Action), nullableID(e.ActorID), e.Actor, nullableID(e.SubjectID), e.Subject, e.IP, e.UserAgent,
+		e.RequestID, nullableJSON(e.Before), nullableJSON(e.After), unixMicro(e.CreatedAt), e.PrevHash, e.Hash)
+	if err != nil {
+		return err
+	}
+	if e.ID, err = res.LastInsertId(); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) GetAuditEvents(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEvent, error) {
+	q := newSQLiteListQuery(filter.AfterID)
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, action, COALESCE(actor_id, 0), actor, COALESCE(subject_id, 0), subject, ip, user_agent,
+			request_id, COALESCE(before, ''), COALESCE(after, ''), created_at, prev_hash, hash
+		FROM audit_events
+		WHERE id > ?1`+q.auditFilter(filter),
+		q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*models.AuditEvent
+	for rows.Next() {
+		e := &models.AuditEvent{}
+		var before, after string
+		err := rows.Scan(&e.ID, &e.Action, &e.ActorID, &e.Actor, &e.SubjectID, &e.Subject, &e.IP, &e.UserAgent,
+			&e.RequestID, &before, &after, sqliteTime{&e.CreatedAt}, &e.PrevHash, &e.Hash)
+		if err != nil {
+			return nil, err
+		}
+		e.Before, e.After = auditJSON(before), auditJSON(after)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// --- Ledger archive ---
+
+// GetLedgerMonths месяцы, в которых есть записи журнала
+func (s *SQLiteStorage) GetLedgerMonths(ctx context.Context) ([]time.Time, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT DISTINCT strftime('%Y%m', posted_at / 1000000, 'unixepoch')
+		FROM journal_entries
+		ORDER BY 1
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var months []time.Time
+	for rows.Next() {
+		var suffix string
+		if err := rows.Scan(&suffix); err != nil {
+			return nil, err
+		}
+		month, err := time.Parse("200601", suffix)
+		if err != nil {
+			return nil, err
+		}
+		months = append(months, month)
+	}
+	return months, rows.Err()
+}
+
+func (s *SQLiteStorage) GetLedgerEntries(ctx context.Context, month time.Time, afterID int64, limit int) ([]*models.LedgerEntry, error) {
+	from, to := monthRange(month)
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT e.id, e.entry_type, e.order_number, COALESCE(e.order_id, 0), COALESCE(e.reason, ''), e.posted_at,
+			COALESCE(a.user_id, 0), COALESCE(a.code, ''), p.amount
+		FROM (
+			SELECT * FROM journal_entries
+			WHERE posted_at >= ?1 AND posted_at < ?2 AND id > ?3
+			ORDER BY id
+			LIMIT ?4
+		) e
+		JOIN postings p ON p.entry_id = e.id
+		JOIN accounts a ON a.id = p.account_id
+		ORDER BY e.id, p.id
+	`, unixMicro(from), unixMicro(to), afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []ledgerEntryRow
+	for rows.Next() {
+		var r ledgerEntryRow
+		err := rows.Scan(&r.entry.ID, &r.entry.EntryType, &r.entry.OrderNumber, &r.entry.OrderID, &r.entry.Reason,
+			sqliteTime{&r.entry.PostedAt}, &r.posting.UserID, &r.posting.Account, &r.posting.Amount)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return groupLedgerEntries(list), nil
+}
+
+// ArchiveLedgerMonth переносит итоги месяца во входящие остатки и удаляет его записи.
+// Триггеры журнала разрешают удаление только после записи месяца в ledger_archive.
+func (s *SQLiteStorage) ArchiveLedgerMonth(ctx context.Context, month time.Time, entries int) error {
+	from, to := monthRange(month)
+	fromMicro, toMicro := unixMicro(from), unixMicro(to)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var older bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM journal_entries WHERE posted_at < ?)
+	`, fromMicro).Scan(&older)
+	if err != nil {
+		return err
+	}
+	if older {
+		return ErrArchiveNotOldest
+	}
+
+	var count int
+	err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM journal_entries WHERE posted_at >= ? AND posted_at < ?
+	`, fromMicro, toMicro).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count != entries {
+		return fmt.Errorf("%w: %s has %d entries, exported %d", ErrArchiveMismatch, partitionSuffix(from), count, entries)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO ledger_openings (account_id, amount, withdrawn)
+		SELECT p.account_id, SUM(p.amount),
+			SUM(CASE WHEN e.entry_type = 'withdrawal' AND a.user_id IS NOT NULL THEN -p.amount ELSE 0 END)
+		FROM postings p
+		JOIN journal_entries e ON e.id = p.entry_id
+		JOIN accounts a ON a.id = p.account_id
+		WHERE e.posted_at >= ?1 AND e.posted_at < ?2
+		GROUP BY p.account_id
+		ON CONFLICT (account_id) DO UPDATE
+		SET amount = ledger_openings.amount + excluded.amount,
+			withdrawn = ledger_openings.withdrawn + excluded.withdrawn
+	`, fromMicro, toMicro)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE orders
+		SET archived_credit = orders.archived_credit + c.amount,
+			accrual_archived = MAX(orders.accrual_archived, c.accrual)
+		FROM (
+			SELECT e.order_id, SUM(p.amount) AS amount, MAX(e.entry_type = 'accrual') AS accrual
+			FROM journal_entries e
+			JOIN postings p ON p.entry_id = e.id
+			JOIN accounts a ON a.id = p.account_id
+			WHERE e.posted_at >= ?1 AND e.posted_at < ?2
+				AND e.order_id IS NOT NULL AND a.user_id IS NOT NULL
+			GROUP BY e.order_id
+		) c
+		WHERE orders.id = c.order_id
+	`, fromMicro, toMicro)
+	if err != nil {
+		return err
+	}
+
+	now := unixMicro(time.Now())
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO ledger_archive (month, archived_until, entries, archived_at)
+		VALUES (?1, ?2, ?3, ?4)
+		ON CONFLICT (month) DO UPDATE
+		SET entries = ledger_archive.entries + excluded.entries, archived_at = ?4
+	`, fromMicro, toMicro, count, now)
+	if err != nil {
+		return err
+	}
+
+	// проводки ссылаются на записи, поэтому удаляются первыми
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM postings WHERE entry_id IN (
+			SELECT id FROM journal_entries WHERE posted_at >= ?1 AND posted_at < ?2
+		)
+	`, fromMicro, toMicro)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM journal_entries WHERE posted_at >= ?1 AND posted_at < ?2
+	`, fromMicro, toMicro)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// EnsureLedgerPartitions журнал SQLite не партиционирован
+func (s *SQLiteStorage) EnsureLedgerPartitions(ctx context.Context, from time.Time, months int) ([]string, error) {
+	return nil, nil
+}
+
+// --- Idempotency ---
+
+// ReserveIdempotencyKey занимает новый или истёкший ключ; живой ключ читается в той же
+// транзакции записи, поэтому параллельный запрос с тем же ключом ждёт её коммита
+func (s *SQLiteStorage) ReserveIdempotencyKey(ctx context.Context, userID int64, key, fingerprint string, lockUntil time.Time) (*models.IdempotentResponse, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := unixMicro(time.Now())
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO idempotency_keys (user_id, idempotency_key, fingerprint, created_at, expires_at)
+		VALUES (?1, ?2, ?3, ?4, ?5)
+		ON CONFLICT (user_id, idempotency_key) DO UPDATE
+		SET fingerprint = excluded.fingerprint,
+			status_code = NULL,
+			content_type = NULL,
+			body = NULL,
+			created_at = excluded.created_at,
+			expires_at = excluded.expires_at
+		WHERE idempotency_keys.expires_at <= ?4
+	`, userID, key, fingerprint, now, unixMicro(lockUntil))
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 1 {
+		return nil, tx.Commit()
+	}
+
+	var stored storedIdempotencyKey
+	err = tx.QueryRowContext(ctx, `
+		SELECT fingerprint, status_code, content_type, body
+		FROM idempotency_keys
+		WHERE user_id = ? AND idempotency_key = ?
+	`, userID, key).Scan(&stored.fingerprint, &stored.statusCode, &stored.contentType, &stored.body)
+	if err != nil {
+		return nil, err
+	}
+	return stored.replay(fingerprint)
+}
+
+func (s *SQLiteStorage) SaveIdempotentResponse(ctx context.Context, userID int64, key string, resp *models.IdempotentResponse, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE idempotency_keys
+		SET status_code = ?, content_type = ?, body = ?, expires_at = ?
+		WHERE user_id = ? AND idempotency_key = ? AND status_code IS NULL
+	`, resp.StatusCode, resp.ContentType, resp.Body, unixMicro(expiresAt), userID, key)
+	return err
+}
+
+func (s *SQLiteStorage) ReleaseIdempotencyKey(ctx context.Context, userID int64, key string) error {
+	_, err := s.db.ExecContext(ctx, `
+		DELETE FROM idempotency_keys
+		WHERE user_id = ? AND idempotency_key = ? AND status_code IS NULL
+	`, userID, key)
+	return err
+}
+
+func (s *SQLiteStorage) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM idempotency_keys WHERE expires_at <= ?
+	`, unixMicro(time.Now()))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// --- Webhook signatures ---
+
+// SaveWebhookSignature вставляет новую или занимает истёкшую подпись, см. PSQLStorage
+func (s *SQLiteStorage) SaveWebhookSignature(ctx context.Context, signature string, expiresAt time.Time) error {
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO webhook_signatures (signature, expires_at)
+		VALUES (?1, ?2)
+		ON CONFLICT (signature) DO UPDATE
+		SET expires_at = excluded.expires_at
+		WHERE webhook_signatures.expires_at <= ?3
+	`, signature, unixMicro(expiresAt), unixMicro(time.Now()))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrWebhookReplay
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) DeleteWebhookSignature(ctx context.Context, signature string) error {
+	_, err := s.db.ExecContext(ctx, `
+		DELETE FROM webhook_signatures WHERE signature = ?
+	`, signature)
+	return err
+}
+
+func (s *SQLiteStorage) DeleteExpiredWebhookSignatures(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM webhook_signatures WHERE expires_at <= ?
+	`, unixMicro(time.Now()))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// unixMicro время для записи в SQLite
+func unixMicro(t time.Time) int64 {
+	return t.UnixMicro()
+}
+
+// sqliteTime читает время, записанное unixMicro
+type sqliteTime struct{ t *time.Time }
+
+func (st sqliteTime) Scan(src any) error {
+	v, ok := src.(int64)
+	if !ok {
+		return fmt.Errorf("sqlite time: unexpected %T", src)
+	}
+	*st.t = time.UnixMicro(v)
+	return nil
+}
+
+func rowsAffectedOrNotFound(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrOrderNotFound
+	}
+	return nil
+}
+
+// isSQLiteUniqueViolation нарушение уникального индекса или первичного ключа:
+// у INTEGER PRIMARY KEY SQLite возвращает свой код
+func isSQLiteUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code()
+	return code == sqlite3.SQLITE_CONSTRAINT_UNIQUE || code == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSQLiteStorage хранилище на новом файле SQLite во временном каталоге
+func newTestSQLiteStorage(t *testing.T) Storage {
+	t.Helper()
+
+	ctx := context.Background()
+	s, err := NewStorage(ctx, sqliteScheme+filepath.Join(t.TempDir(), "gophermart.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	require.NoError(t, s.Migrate(ctx))
+
+	return s
+}
+
+func TestSQLiteStorage(t *testing.T) {
+	testStorage(t, newTestSQLiteStorage)
+}
+
+// Журнал сбалансирован и только дополняется: триггеры запрещают UPDATE и DELETE
+func TestSQLiteLedgerAppendOnly(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLiteStorage(t)
+	db := s.(*SQLiteStorage).db
+
+	userID := newTestUser(t, s)
+	newProcessedOrder(t, s, userID, 10000)
+	require.NoError(t, s.Withdraw(ctx, newWithdrawal(userID, uniq("withdrawal"), 2500)))
+
+	var unbalanced int
+	require.NoError(t, db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM (
+			SELECT entry_id FROM postings GROUP BY entry_id HAVING SUM(amount) <> 0
+		)
+	`).Scan(&unbalanced))
+	assert.Zero(t, unbalanced)
+
+	var source models.Money
+	require.NoError(t, db.QueryRowContext(ctx, `
+		SELECT SUM(p.amount) FROM postings p
+		JOIN accounts a ON a.id = p.account_id
+		WHERE a.code = ?
+	`, accrualSourceAccount).Scan(&source))
+	assert.Equal(t, models.Money(-10000), source)
+
+	for _, stmt := range []string{
+		`UPDATE journal_entries SET reason = 'edit'`,
+		`DELETE FROM journal_entries`,
+		`UPDATE postings SET amount = amount * 2`,
+		`DELETE FROM postings`,
+	} {
+		_, err := db.ExecContext(ctx, stmt)
+		assert.ErrorContains(t, err, "append-only", stmt)
+	}
+}
+
+// Второе начисление по заказу отклоняется и после того, как его месяц ушёл в архив
+func TestSQLiteAccrualOncePerOrder(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSQLiteStorage(t)
+	db := s.(*SQLiteStorage).db
+
+	order := archiveAccruedOrder(t, s, time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC))
+
+	tx, err := db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	_, err = postSQLiteEntry(ctx, tx, ledgerEntry{
+		entryType:   models.AccrualOp,
+		userID:      order.UserID,
+		orderNumber: order.OrderNumber,
+		orderID:     order.ID,
+		amount:      10000,
+		postedAt:    time.Now(),
+	})
+	assert.ErrorIs(t, err, ErrAccrualExists)
+}
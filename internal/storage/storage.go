@@ -0,0 +1,18 @@
+package storage
+
+import (
+	"context"
+	"strings"
+)
+
+// sqliteScheme префикс DATABASE_URI для встроенной SQLite: sqlite://path/to/gophermart.db
+const sqliteScheme = "sqlite://"
+
+// NewStorage выбирает реализацию по схеме DATABASE_URI: sqlite:// — файл SQLite,
+// иначе строка подключения к PostgreSQL
+func NewStorage(ctx context.Context, databaseURI string) (Storage, error) {
+	if path, ok := strings.CutPrefix(databaseURI, sqliteScheme); ok {
+		return NewSQLiteStorage(ctx, path)
+	}
+	return NewPSQLStorage(ctx, databaseURI)
+}
@@ -0,0 +1,1100 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JSchatten/go-diploma/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStorage общий набор проверок, который проходят все реализации Storage.
+// PostgreSQL-база для тестов общая, поэтому логины и номера заказов уникальны
+// в пределах запуска, а проверки касаются только своих записей.
+func testStorage(t *testing.T, newStorage func(t *testing.T) Storage) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s Storage)
+	}{
+		{"Users", testUsers},
+		{"UploadOrder", testUploadOrder},
+		{"ConcurrentUploadOrder", testConcurrentUploadOrder},
+		{"OrderLifecycle", testOrderLifecycle},
+		{"Withdraw", testWithdraw},
+		{"ConcurrentWithdrawals", testConcurrentWithdrawals},
+		{"PollSchedule", testPollSchedule},
+		{"StuckOrders", testStuckOrders},
+		{"WebhookSignatures", testWebhookSignatures},
+		{"Reconciliation", testReconciliation},
+		{"ListFilter", testListFilter},
+		{"Statement", testStatement},
+		{"Ledger", testLedger},
+		{"IdempotencyKeys", testIdempotencyKeys},
+		{"Outbox", testOutbox},
+		{"Audit", testAudit},
+		{"Archive", testArchive},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStorage(t))
+		})
+	}
+}
+
+var uniqSeq atomic.Int64
+
+// uniq уникальный в пределах запуска суффикс логина или номера заказа
+func uniq(prefix string) string {
+	return fmt.Sprintf("%s-%d-%d", prefix, time.Now().UnixNano(), uniqSeq.Add(1))
+}
+
+func newTestUser(t *testing.T, s Storage) int64 {
+	t.Helper()
+	id, err := s.SaveUser(context.Background(), uniq("user"), "hash")
+	require.NoError(t, err)
+	return id
+}
+
+// newProcessedOrder загруженный и рассчитанный заказ на amount
+func newProcessedOrder(t *testing.T, s Storage, userID int64, amount models.Money) string {
+	t.Helper()
+	ctx := context.Background()
+	number := uniq("order")
+	require.NoError(t, s.UploadOrder(ctx, newOrder(userID, number)))
+	require.NoError(t, s.UpdateOrderStatus(ctx, number, models.ProcessedStatus, amount))
+	return number
+}
+
+func newOrder(userID int64, number string) *models.BalanceOperation {
+	return &models.BalanceOperation{
+		UserID:        userID,
+		OrderNumber:   number,
+		OperationType: models.AccrualOp,
+		Status:        models.NewStatus,
+		ProcessedAt:   time.Now(),
+	}
+}
+
+func newWithdrawal(userID int64, number string, sum models.Money) *models.BalanceOperation {
+	return &models.BalanceOperation{
+		UserID:        userID,
+		OrderNumber:   number,
+		Amount:        -sum,
+		OperationType: models.WithdrawalOp,
+		Status:        models.ProcessedStatus,
+		ProcessedAt:   time.Now(),
+	}
+}
+
+func findOrder(ops []*models.BalanceOperation, number string) *models.BalanceOperation {
+	for _, op := range ops {
+		if op.OrderNumber == number {
+			return op
+		}
+	}
+	return nil
+}
+
+func testUsers(t *testing.T, s Storage) {
+	ctx := context.Background()
+	login := uniq("login")
+
+	id, err := s.SaveUser(ctx, login, "hash")
+	require.NoError(t, err)
+
+	_, err = s.SaveUser(ctx, login, "other")
+	assert.ErrorIs(t, err, ErrUserExists)
+
+	gotID, hash, err := s.GetUserByLogin(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, id, gotID)
+	assert.Equal(t, "hash", hash)
+
+	_, _, err = s.GetUserByLogin(ctx, uniq("missing"))
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	isAdmin, err := s.IsAdmin(ctx, id)
+	require.NoError(t, err)
+	assert.False(t, isAdmin)
+
+	_, err = s.IsAdmin(ctx, -1)
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	// права выдаются и снимаются по логину
+	require.NoError(t, s.SetAdmin(ctx, login, true))
+	isAdmin, err = s.IsAdmin(ctx, id)
+	require.NoError(t, err)
+	assert.True(t, isAdmin)
+
+	require.NoError(t, s.SetAdmin(ctx, login, false))
+	isAdmin, err = s.IsAdmin(ctx, id)
+	require.NoError(t, err)
+	assert.False(t, isAdmin)
+
+	assert.ErrorIs(t, s.SetAdmin(ctx, uniq("missing"), true), ErrUserNotFound)
+}
+
+func testUploadOrder(t *testing.T, s Storage) {
+	ctx := context.Background()
+	owner := newTestUser(t, s)
+	other := newTestUser(t, s)
+	number := uniq("order")
+
+	require.NoError(t, s.UploadOrder(ctx, newOrder(owner, number)))
+	assert.ErrorIs(t, s.UploadOrder(ctx, newOrder(owner, number)), ErrOrderExists)
+	assert.ErrorIs(t, s.UploadOrder(ctx, newOrder(other, number)), ErrOrderMine)
+	assert.ErrorIs(t, s.CreateOperation(ctx, newOrder(other, number)), ErrOrderExists)
+
+	order, err := s.GetOrder(ctx, number)
+	require.NoError(t, err)
+	assert.Equal(t, owner, order.UserID)
+	assert.Equal(t, models.NewStatus, order.Status)
+	assert.Equal(t, models.Money(0), order.Amount)
+
+	_, err = s.GetOrder(ctx, uniq("missing"))
+	assert.ErrorIs(t, err, ErrOrderNotFound)
+}
+
+func testConcurrentUploadOrder(t *testing.T, s Storage) {
+	ctx := context.Background()
+	number := uniq("order")
+
+	// несколько пользователей одновременно загружают один номер
+	const users = 10
+	userIDs := make([]int64, users)
+	for i := range userIDs {
+		userIDs[i] = newTestUser(t, s)
+	}
+
+	errs := make([]error, users)
+	var wg sync.WaitGroup
+	for i, userID := range userIDs {
+		wg.Add(1)
+		go func(i int, userID int64) {
+			defer wg.Done()
+			errs[i] = s.UploadOrder(ctx, newOrder(userID, number))
+		}(i, userID)
+	}
+	wg.Wait()
+
+	winner := -1
+	for i, err := range errs {
+		if err == nil {
+			require.Equal(t, -1, winner, "order uploaded twice")
+			winner = i
+			continue
+		}
+		assert.ErrorIs(t, err, ErrOrderMine)
+	}
+	require.NotEqual(t, -1, winner)
+
+	// повтор от владельца — ErrOrderExists
+	assert.ErrorIs(t, s.UploadOrder(ctx, newOrder(userIDs[winner], number)), ErrOrderExists)
+}
+
+func testOrderLifecycle(t *testing.T, s Storage) {
+	ctx := context.Background()
+	userID := newTestUser(t, s)
+	first, second := uniq("order"), uniq("order")
+
+	require.NoError(t, s.UploadOrder(ctx, newOrder(userID, first)))
+	require.NoError(t, s.UploadOrder(ctx, newOrder(userID, second)))
+
+	// повтор незавершённого статуса допустим
+	require.NoError(t, s.UpdateOrderStatus(ctx, first, models.ProcessingStatus, 0))
+	require.NoError(t, s.UpdateOrderStatus(ctx, first, models.ProcessingStatus, 0))
+	require.NoError(t, s.UpdateOrderStatus(ctx, first, models.ProcessedStatus, 50050))
+	require.NoError(t, s.UpdateOrderStatus(ctx, second, models.InvalidStatus, 0))
+
+	err := s.UpdateOrderStatus(ctx, first, models.ProcessingStatus, 0)
+	assert.ErrorIs(t, err, ErrIllegalTransition)
+	assert.ErrorIs(t, s.UpdateOrderStatus(ctx, uniq("missing"), models.ProcessedStatus, 1), ErrOrderNotFound)
+
+	current, withdrawn, err := s.GetBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, models.Money(50050), current)
+	assert.Equal(t, models.Money(0), withdrawn)
+
+	// по возрастанию времени обработки
+	orders, err := s.GetAccrualsByUser(ctx, userID, models.ListFilter{})
+	require.NoError(t, err)
+	require.Len(t, orders, 2)
+	assert.Equal(t, first, orders[0].OrderNumber)
+	assert.Equal(t, models.ProcessedStatus, orders[0].Status)
+	assert.Equal(t, models.Money(50050), orders[0].Accrual)
+	assert.Equal(t, second, orders[1].OrderNumber)
+	assert.Equal(t, models.InvalidStatus, orders[1].Status)
+	assert.Equal(t, models.Money(0), orders[1].Accrual)
+}
+
+func testWithdraw(t *testing.T, s Storage) {
+	ctx := context.Background()
+	userID := newTestUser(t, s)
+
+	assert.ErrorIs(t, s.Withdraw(ctx, newWithdrawal(userID, uniq("wd"), 100)), ErrNoMoney)
+
+	accrual := newProcessedOrder(t, s, userID, 10000)
+	first, second := uniq("wd"), uniq("wd")
+	require.NoError(t, s.Withdraw(ctx, newWithdrawal(userID, first, 3000)))
+	require.NoError(t, s.CreateOperation(ctx, newWithdrawal(userID, second, 2000)))
+	assert.ErrorIs(t, s.Withdraw(ctx, newWithdrawal(userID, uniq("wd"), 5001)), ErrNoMoney)
+
+	current, withdrawn, err := s.GetBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, models.Money(5000), current)
+	assert.Equal(t, models.Money(5000), withdrawn)
+
+	withdrawals, err := s.GetWithdrawalsByUser(ctx, userID, models.ListFilter{})
+	require.NoError(t, err)
+	require.Len(t, withdrawals, 2)
+	assert.Equal(t, first, withdrawals[0].OrderNumber)
+	assert.Equal(t, models.Money(3000), withdrawals[0].Sum)
+	assert.Equal(t, second, withdrawals[1].OrderNumber)
+
+	// все операции, новые первыми
+	ops, err := s.GetOperationsByUser(ctx, userID, models.ListFilter{Desc: true})
+	require.NoError(t, err)
+	require.Len(t, ops, 3)
+	assert.Equal(t, second, ops[0].OrderNumber)
+	assert.Equal(t, accrual, ops[2].OrderNumber)
+	assert.Equal(t, models.Money(10000), ops[2].Accrual)
+}
+
+func testStatement(t *testing.T, s Storage) {
+	ctx := context.Background()
+	userID := newTestUser(t, s)
+
+	accrual := newProcessedOrder(t, s, userID, 10000)
+	time.Sleep(time.Millisecond)
+	pending := uniq("order")
+	require.NoError(t, s.UploadOrder(ctx, newOrder(userID, pending)))
+	time.Sleep(time.Millisecond)
+	withdrawal := uniq("wd")
+	require.NoError(t, s.Withdraw(ctx, newWithdrawal(userID, withdrawal, 2500)))
+	time.Sleep(time.Millisecond)
+
+	order, err := s.GetOrder(ctx, accrual)
+	require.NoError(t, err)
+	require.NoError(t, s.AdjustAccrual(ctx, order.ID, -500, "reconciliation"))
+
+	ops, err := s.GetOperationsByUser(ctx, userID, models.ListFilter{})
+	require.NoError(t, err)
+	require.Len(t, ops, 4)
+
+	type entry struct {
+		number  string
+		opType  models.OperationType
+		amount  models.Money
+		balance models.Money
+	}
+	got := make([]entry, 0, len(ops))
+	for _, op := range ops {
+		got = append(got, entry{op.OrderNumber, op.OperationType, op.Amount, op.Balance})
+	}
+	// незавершённый заказ баланс не меняет
+	assert.Equal(t, []entry{
+		{accrual, models.AccrualOp, 10000, 10000},
+		{pending, models.AccrualOp, 0, 10000},
+		{withdrawal, models.WithdrawalOp, -2500, 7500},
+		{accrual, models.AdjustmentOp, -500, 7000},
+	}, got)
+
+	current, _, err := s.GetBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, current, ops[len(ops)-1].Balance)
+
+	// баланс на странице считается по всей истории, а не от начала страницы
+	page, err := s.GetOperationsByUser(ctx, userID, models.ListFilter{Limit: 2, After: models.CursorAfter(ops[1])})
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, models.Money(7500), page[0].Balance)
+	assert.Equal(t, models.Money(7000), page[1].Balance)
+
+	page, err = s.GetOperationsByUser(ctx, userID, models.ListFilter{Desc: true, Statuses: []models.Status{models.NewStatus}})
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, pending, page[0].OrderNumber)
+	assert.Equal(t, models.Money(10000), page[0].Balance)
+
+	// незавершённый заказ стоит по времени загрузки: смена статуса его не сдвигает
+	require.NoError(t, s.UpdateOrderStatus(ctx, pending, models.ProcessingStatus, 0))
+	page, err = s.GetOperationsByUser(ctx, userID, models.ListFilter{Limit: 1, After: models.CursorAfter(ops[0])})
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, pending, page[0].OrderNumber)
+	assert.True(t, ops[1].ProcessedAt.Equal(page[0].ProcessedAt))
+}
+
+func testConcurrentWithdrawals(t *testing.T, s Storage) {
+	ctx := context.Background()
+	userID := newTestUser(t, s)
+	newProcessedOrder(t, s, userID, 10000) // 100 баллов
+
+	// 50 списаний по 10 при балансе 100: пройти должны ровно 10
+	const attempts = 50
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		ok      int
+		noMoney int
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.Withdraw(ctx, newWithdrawal(userID, uniq("wd"), 1000))
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				ok++
+			case errors.Is(err, ErrNoMoney):
+				noMoney++
+			default:
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 10, ok)
+	assert.Equal(t, attempts-10, noMoney)
+
+	current, withdrawn, err := s.GetBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, models.Money(0), current)
+	assert.Equal(t, models.Money(10000), withdrawn)
+
+	// хранимый баланс совпадает с операциями
+	drifts, err := s.VerifyBalances(ctx)
+	require.NoError(t, err)
+	for _, d := range drifts {
+		assert.NotEqual(t, userID, d.UserID, "balance drifted: %+v", d)
+	}
+}
+
+func testPollSchedule(t *testing.T, s Storage) {
+	ctx := context.Background()
+	userID := newTestUser(t, s)
+	number := uniq("order")
+	require.NoError(t, s.UploadOrder(ctx, newOrder(userID, number)))
+
+	leased, err := s.LeasePendingOrders(ctx, "a", 1000, time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, findOrder(leased, number))
+
+	// арендован другим экземпляром
+	leased, err = s.LeasePendingOrders(ctx, "b", 1000, time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, findOrder(leased, number))
+
+	// опрос отложен — аренда снята, но время не подошло
+	require.NoError(t, s.SchedulePoll(ctx, number, time.Now().Add(time.Hour), "boom"))
+	leased, err = s.LeasePendingOrders(ctx, "b", 1000, time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, findOrder(leased, number))
+
+	require.NoError(t, s.SchedulePoll(ctx, number, time.Now().Add(-time.Second), ""))
+	leased, err = s.LeasePendingOrders(ctx, "b", 1000, time.Minute)
+	require.NoError(t, err)
+	order := findOrder(leased, number)
+	require.NotNil(t, order)
+	assert.Equal(t, 2, order.PollAttempts)
+
+	// смена статуса аренду не снимает: до SchedulePoll время опроса в прошлом
+	require.NoError(t, s.UpdateOrderStatus(ctx, number, models.ProcessingStatus, 0))
+	leased, err = s.LeasePendingOrders(ctx, "c", 1000, time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, findOrder(leased, number))
+
+	// снять аренду может только её владелец, попытка опроса не считается
+	require.NoError(t, s.ReleaseOrderLease(ctx, number, "c"))
+	leased, err = s.LeasePendingOrders(ctx, "c", 1000, time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, findOrder(leased, number))
+
+	require.NoError(t, s.ReleaseOrderLease(ctx, number, "b"))
+	leased, err = s.LeasePendingOrders(ctx, "c", 1000, time.Minute)
+	require.NoError(t, err)
+	order = findOrder(leased, number)
+	require.NotNil(t, order)
+	assert.Equal(t, 2, order.PollAttempts)
+
+	// окончательный статус — больше не опрашивается
+	require.NoError(t, s.UpdateOrderStatus(ctx, number, models.InvalidStatus, 0))
+	require.NoError(t, s.SchedulePoll(ctx, number, time.Now().Add(-time.Second), ""))
+	leased, err = s.LeasePendingOrders(ctx, "c", 1000, time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, findOrder(leased, number))
+
+	assert.ErrorIs(t, s.SchedulePoll(ctx, uniq("missing"), time.Now(), ""), ErrOrderNotFound)
+}
+
+func testStuckOrders(t *testing.T, s Storage) {
+	ctx := context.Background()
+	userID := newTestUser(t, s)
+	number := uniq("order")
+	require.NoError(t, s.UploadOrder(ctx, newOrder(userID, number)))
+	time.Sleep(10 * time.Millisecond)
+
+	marked, err := s.MarkStuckOrders(ctx, time.Millisecond)
+	require.NoError(t, err)
+	require.NotNil(t, findOrder(marked, number))
+
+	stuck, err := s.GetStuckOrders(ctx)
+	require.NoError(t, err)
+	order := findOrder(stuck, number)
+	require.NotNil(t, order)
+	assert.NotNil(t, order.StuckAt)
+	assert.NotEmpty(t, order.StuckReason)
+
+	// зависший заказ не опрашивается
+	leased, err := s.LeasePendingOrders(ctx, "a", 1000, time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, findOrder(leased, number))
+
+	require.NoError(t, s.RequeueOrder(ctx, number))
+	assert.ErrorIs(t, s.RequeueOrder(ctx, number), ErrOrderNotFound)
+
+	err = s.ForceOrderStatus(ctx, number, models.ProcessingStatus, 0, "manual")
+	assert.ErrorIs(t, err, ErrIllegalTransition)
+	require.NoError(t, s.ForceOrderStatus(ctx, number, models.ProcessedStatus, 1234, "manual"))
+	err = s.ForceOrderStatus(ctx, number, models.InvalidStatus, 0, "manual")
+	assert.ErrorIs(t, err, ErrIllegalTransition)
+
+	current, _, err := s.GetBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, models.Money(1234), current)
+
+	// окончательный статус из опроса или push-уведомления снимает пометку
+	late := uniq("order")
+	require.NoError(t, s.UploadOrder(ctx, newOrder(userID, late)))
+	time.Sleep(10 * time.Millisecond)
+	marked, err = s.MarkStuckOrders(ctx, time.Millisecond)
+	require.NoError(t, err)
+	require.NotNil(t, findOrder(marked, late))
+
+	require.NoError(t, s.UpdateOrderStatus(ctx, late, models.ProcessedStatus, 500))
+	stuck, err = s.GetStuckOrders(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, findOrder(stuck, late))
+	assert.ErrorIs(t, s.RequeueOrder(ctx, late), ErrOrderNotFound)
+}
+
+func testWebhookSignatures(t *testing.T, s Storage) {
+	ctx := context.Background()
+	sig := uniq("sig")
+
+	require.NoError(t, s.SaveWebhookSignature(ctx, sig, time.Now().Add(time.Minute)))
+	assert.ErrorIs(t, s.SaveWebhookSignature(ctx, sig, time.Now().Add(time.Minute)), ErrWebhookReplay)
+	require.NoError(t, s.SaveWebhookSignature(ctx, uniq("sig"), time.Now().Add(time.Minute)))
+
+	// истёкшая подпись — как новая, а затем удаляется
+	expired := uniq("sig")
+	require.NoError(t, s.SaveWebhookSignature(ctx, expired, time.Now().Add(-time.Second)))
+	require.NoError(t, s.SaveWebhookSignature(ctx, expired, time.Now().Add(-time.Second)))
+
+	deleted, err := s.DeleteExpiredWebhookSignatures(ctx)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, deleted, int64(1))
+
+	assert.ErrorIs(t, s.SaveWebhookSignature(ctx, sig, time.Now().Add(time.Minute)), ErrWebhookReplay,
+		"live signature survives cleanup")
+
+	// забытая подпись принимается снова
+	require.NoError(t, s.DeleteWebhookSignature(ctx, sig))
+	require.NoError(t, s.SaveWebhookSignature(ctx, sig, time.Now().Add(time.Minute)))
+	require.NoError(t, s.DeleteWebhookSignature(ctx, uniq("sig")), "unknown signature is not an error")
+}
+
+func testReconciliation(t *testing.T, s Storage) {
+	ctx := context.Background()
+	userID := newTestUser(t, s)
+	number := newProcessedOrder(t, s, userID, 10000)
+
+	order, err := s.GetOrder(ctx, number)
+	require.NoError(t, err)
+
+	// начисления сверяются постранично по id, начиная с нашего
+	accruals, err := s.GetProcessedAccruals(ctx, order.ID-1, 1)
+	require.NoError(t, err)
+	require.Len(t, accruals, 1)
+	assert.Equal(t, number, accruals[0].OrderNumber)
+	assert.Equal(t, models.Money(10000), accruals[0].Amount)
+
+	require.NoError(t, s.AdjustAccrual(ctx, order.ID, -2550, "reconciliation"))
+	assert.ErrorIs(t, s.AdjustAccrual(ctx, -1, 1, "reconciliation"), ErrOrderNotFound)
+
+	accruals, err = s.GetProcessedAccruals(ctx, order.ID-1, 1)
+	require.NoError(t, err)
+	require.Len(t, accruals, 1)
+	assert.Equal(t, models.Money(7450), accruals[0].Amount)
+
+	orders, err := s.GetAccrualsByUser(ctx, userID, models.ListFilter{})
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, models.Money(7450), orders[0].Accrual)
+
+	current, _, err := s.GetBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, models.Money(7450), current)
+}
+
+func testListFilter(t *testing.T, s Storage) {
+	ctx := context.Background()
+	userID := newTestUser(t, s)
+
+	// шаги разнесены во времени, чтобы created_at и processed_at различались и в микросекундах
+	numbers := make([]string, 5)
+	for i := range numbers {
+		numbers[i] = uniq("order")
+		require.NoError(t, s.UploadOrder(ctx, newOrder(userID, numbers[i])))
+		time.Sleep(time.Millisecond)
+	}
+	for i, status := range []models.Status{models.ProcessedStatus, models.InvalidStatus, models.ProcessedStatus} {
+		require.NoError(t, s.UpdateOrderStatus(ctx, numbers[i+1], status, 100))
+		time.Sleep(time.Millisecond)
+	}
+
+	all, err := s.GetAccrualsByUser(ctx, userID, models.ListFilter{})
+	require.NoError(t, err)
+	require.Len(t, all, 5)
+	// порядок загрузки, смена статуса его не меняет
+	assert.Equal(t, numbers, orderNumbers(all))
+
+	// страницы по 2 в обе стороны дают весь список без повторов
+	pages := func(desc bool) []string {
+		var got []string
+		filter := models.ListFilter{Limit: 2, Desc: desc}
+		for {
+			page, err := s.GetAccrualsByUser(ctx, userID, filter)
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(page), 2)
+			if len(page) == 0 {
+				return got
+			}
+			got = append(got, orderNumbers(page)...)
+			filter.After = models.OrderCursorAfter(page[len(page)-1])
+		}
+	}
+	assert.Equal(t, orderNumbers(all), pages(false))
+	desc := orderNumbers(all)
+	slices.Reverse(desc)
+	assert.Equal(t, desc, pages(true))
+
+	processed, err := s.GetAccrualsByUser(ctx, userID, models.ListFilter{Statuses: []models.Status{models.ProcessedStatus}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{numbers[1], numbers[3]}, orderNumbers(processed))
+
+	// период [From, To)
+	period, err := s.GetAccrualsByUser(ctx, userID, models.ListFilter{From: all[1].CreatedAt, To: all[3].CreatedAt})
+	require.NoError(t, err)
+	assert.Equal(t, orderNumbers(all[1:3]), orderNumbers(period))
+
+	// курсор, закодированный для клиента, продолжает с той же строки
+	cursor, err := models.DecodeListCursor(models.OrderCursorAfter(all[1]).Encode())
+	require.NoError(t, err)
+	rest, err := s.GetAccrualsByUser(ctx, userID, models.ListFilter{After: cursor})
+	require.NoError(t, err)
+	assert.Equal(t, orderNumbers(all[2:]), orderNumbers(rest))
+
+	// смена статуса между страницами не теряет и не повторяет заказы
+	page, err := s.GetAccrualsByUser(ctx, userID, models.ListFilter{Limit: 2})
+	require.NoError(t, err)
+	require.NoError(t, s.UpdateOrderStatus(ctx, numbers[0], models.ProcessingStatus, 0))
+	require.NoError(t, s.UpdateOrderStatus(ctx, numbers[4], models.ProcessedStatus, 100))
+	got := orderNumbers(page)
+	for len(page) > 0 {
+		page, err = s.GetAccrualsByUser(ctx, userID, models.ListFilter{Limit: 2, After: models.OrderCursorAfter(page[len(page)-1])})
+		require.NoError(t, err)
+		got = append(got, orderNumbers(page)...)
+	}
+	assert.Equal(t, numbers, got)
+
+	newProcessedOrder(t, s, userID, 10000)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, s.Withdraw(ctx, newWithdrawal(userID, fmt.Sprintf("wd-%d-%s", i, uniq("")), 100)))
+		time.Sleep(time.Millisecond)
+	}
+	withdrawals, err := s.GetWithdrawalsByUser(ctx, userID, models.ListFilter{Desc: true, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, withdrawals, 2)
+	assert.Contains(t, withdrawals[0].OrderNumber, "wd-2-")
+	assert.Contains(t, withdrawals[1].OrderNumber, "wd-1-")
+
+	withdrawals, err = s.GetWithdrawalsByUser(ctx, userID, models.ListFilter{Desc: true, After: models.CursorAfter(withdrawals[1])})
+	require.NoError(t, err)
+	require.Len(t, withdrawals, 1)
+	assert.Contains(t, withdrawals[0].OrderNumber, "wd-0-")
+}
+
+func orderNumbers(ops []*models.BalanceOperation) []string {
+	numbers := make([]string, 0, len(ops))
+	for _, op := range ops {
+		numbers = append(numbers, op.OrderNumber)
+	}
+	return numbers
+}
+
+// testLedger начисление, списание и корректировка — записи журнала с общим с заказами
+// пространством id; user_balances совпадает с журналом
+func testLedger(t *testing.T, s Storage) {
+	ctx := context.Background()
+	userID := newTestUser(t, s)
+
+	number := newProcessedOrder(t, s, userID, 10000)
+	require.NoError(t, s.Withdraw(ctx, newWithdrawal(userID, uniq("withdrawal"), 2500)))
+	order, err := s.GetOrder(ctx, number)
+	require.NoError(t, err)
+	require.NoError(t, s.AdjustAccrual(ctx, order.ID, -500, "reconciliation"))
+	pending := uniq("order")
+	require.NoError(t, s.UploadOrder(ctx, newOrder(userID, pending)))
+
+	// заказ не меняется корректировкой, начисление по нему — меняется
+	order, err = s.GetOrder(ctx, number)
+	require.NoError(t, err)
+	assert.Equal(t, models.Money(10000), order.Accrual)
+
+	ops, err := s.GetOperationsByUser(ctx, userID, models.ListFilter{})
+	require.NoError(t, err)
+	require.Len(t, ops, 4)
+
+	ids := make(map[int64]struct{})
+	var types []models.OperationType
+	for _, op := range ops {
+		ids[op.ID] = struct{}{}
+		types = append(types, op.OperationType)
+	}
+	assert.Len(t, ids, 4, "orders and journal entries share an id space")
+	assert.Equal(t, []models.OperationType{models.AccrualOp, models.WithdrawalOp, models.AdjustmentOp, models.AccrualOp}, types)
+	assert.Equal(t, pending, ops[3].OrderNumber)
+	assert.Zero(t, ops[3].Amount)
+	assert.Equal(t, models.Money(7000), ops[3].Balance)
+
+	orders, err := s.GetAccrualsByUser(ctx, userID, models.ListFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, models.Money(9500), findOrder(orders, number).Accrual)
+	assert.Zero(t, findOrder(orders, pending).Accrual)
+
+	withdrawals, err := s.GetWithdrawalsByUser(ctx, userID, models.ListFilter{})
+	require.NoError(t, err)
+	require.Len(t, withdrawals, 1)
+	assert.Equal(t, models.Money(2500), withdrawals[0].Sum)
+
+	current, withdrawn, err := s.GetBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, models.Money(7000), current)
+	assert.Equal(t, models.Money(2500), withdrawn)
+
+	drifts, err := s.VerifyBalances(ctx)
+	require.NoError(t, err)
+	for _, d := range drifts {
+		assert.NotEqual(t, userID, d.UserID)
+	}
+}
+
+func testIdempotencyKeys(t *testing.T, s Storage) {
+	ctx := context.Background()
+	userID := newTestUser(t, s)
+	otherID := newTestUser(t, s)
+	key := uniq("key")
+	lockUntil := time.Now().Add(time.Minute)
+
+	resp, err := s.ReserveIdempotencyKey(ctx, userID, key, "fp", lockUntil)
+	require.NoError(t, err)
+	assert.Nil(t, resp)
+
+	// пока запрос выполняется, повтор ждёт, другой запрос с тем же ключом отклоняется
+	_, err = s.ReserveIdempotencyKey(ctx, userID, key, "fp", lockUntil)
+	assert.ErrorIs(t, err, ErrIdempotencyInProgress)
+	_, err = s.ReserveIdempotencyKey(ctx, userID, key, "other", lockUntil)
+	assert.ErrorIs(t, err, ErrIdempotencyMismatch)
+
+	// ключи у каждого пользователя свои
+	resp, err = s.ReserveIdempotencyKey(ctx, otherID, key, "other", lockUntil)
+	require.NoError(t, err)
+	assert.Nil(t, resp)
+
+	saved := &models.IdempotentResponse{StatusCode: 202, ContentType: "application/json", Body: []byte(`{"ok":true}`)}
+	require.NoError(t, s.SaveIdempotentResponse(ctx, userID, key, saved, time.Now().Add(time.Hour)))
+	require.NoError(t, s.ReleaseIdempotencyKey(ctx, userID, key), "saved response is not released")
+
+	resp, err = s.ReserveIdempotencyKey(ctx, userID, key, "fp", lockUntil)
+	require.NoError(t, err)
+	assert.Equal(t, saved, resp)
+	_, err = s.ReserveIdempotencyKey(ctx, userID, key, "other", lockUntil)
+	assert.ErrorIs(t, err, ErrIdempotencyMismatch)
+
+	// освобождённый ключ занимается заново
+	require.NoError(t, s.ReleaseIdempotencyKey(ctx, otherID, key))
+	resp, err = s.ReserveIdempotencyKey(ctx, otherID, key, "fp", lockUntil)
+	require.NoError(t, err)
+	assert.Nil(t, resp)
+
+	// истёкший ключ — как новый, а затем удаляется
+	expired := uniq("key")
+	_, err = s.ReserveIdempotencyKey(ctx, userID, expired, "fp", time.Now().Add(-time.Second))
+	require.NoError(t, err)
+	resp, err = s.ReserveIdempotencyKey(ctx, userID, expired, "other", time.Now().Add(-time.Second))
+	require.NoError(t, err)
+	assert.Nil(t, resp)
+
+	deleted, err := s.DeleteExpiredIdempotencyKeys(ctx)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, deleted, int64(1))
+
+	resp, err = s.ReserveIdempotencyKey(ctx, userID, key, "fp", lockUntil)
+	require.NoError(t, err)
+	assert.Equal(t, saved, resp, "live key survives cleanup")
+}
+
+// lastEventID id последнего события в outbox
+func lastEventID(t *testing.T, s Storage) int64 {
+	t.Helper()
+	var last int64
+	for {
+		events, err := s.GetOutboxEvents(context.Background(), last, 1000)
+		require.NoError(t, err)
+		if len(events) == 0 {
+			return last
+		}
+		last = events[len(events)-1].ID
+	}
+}
+
+// testOutbox изменения пишут события в той же транзакции, неудачные — не пишут
+func testOutbox(t *testing.T, s Storage) {
+	ctx := context.Background()
+	start := lastEventID(t, s)
+
+	login := uniq("user")
+	userID, err := s.SaveUser(ctx, login, "hash")
+	require.NoError(t, err)
+	number := newProcessedOrder(t, s, userID, 50000)
+	assert.ErrorIs(t, s.UploadOrder(ctx, newOrder(userID, number)), ErrOrderExists)
+	assert.ErrorIs(t, s.Withdraw(ctx, newWithdrawal(userID, uniq("withdrawal"), 60000)), ErrNoMoney)
+	withdrawal := uniq("withdrawal")
+	require.NoError(t, s.Withdraw(ctx, newWithdrawal(userID, withdrawal, 10000)))
+	invalid := uniq("order")
+	require.NoError(t, s.UploadOrder(ctx, newOrder(userID, invalid)))
+	require.NoError(t, s.ForceOrderStatus(ctx, invalid, models.InvalidStatus, 0, "rejected by partner"))
+
+	events, err := s.GetOutboxEvents(ctx, start, 1000)
+	require.NoError(t, err)
+
+	var payloads []any
+	lastID := start
+	for _, e := range events {
+		assert.Greater(t, e.ID, lastID, "events are ordered by id")
+		lastID = e.ID
+
+		var owner struct {
+			UserID int64 `json:"user_id"`
+		}
+		require.NoError(t, json.Unmarshal(e.Payload, &owner))
+		if owner.UserID != userID {
+			continue
+		}
+
+		switch e.Type {
+		case models.UserRegisteredEvent:
+			var p models.UserRegistered
+			require.NoError(t, json.Unmarshal(e.Payload, &p))
+			payloads = append(payloads, p)
+		case models.OrderStatusChangedEvent:
+			var p models.OrderStatusChanged
+			require.NoError(t, json.Unmarshal(e.Payload, &p))
+			payloads = append(payloads, p)
+		case models.BalanceWithdrawnEvent:
+			var p models.BalanceWithdrawn
+			require.NoError(t, json.Unmarshal(e.Payload, &p))
+			payloads = append(payloads, p)
+		}
+	}
+
+	assert.Equal(t, []any{
+		models.UserRegistered{UserID: userID, Login: login},
+		models.OrderStatusChanged{UserID: userID, Order: number, Status: models.NewStatus},
+		models.OrderStatusChanged{UserID: userID, Order: number, From: models.NewStatus, Status: models.ProcessedStatus, Accrual: 50000},
+		models.BalanceWithdrawn{UserID: userID, Order: withdrawal, Sum: 10000},
+		models.OrderStatusChanged{UserID: userID, Order: invalid, Status: models.NewStatus},
+		models.OrderStatusChanged{UserID: userID, Order: invalid, From: models.NewStatus, Status: models.InvalidStatus, Reason: "rejected by partner"},
+	}, payloads)
+
+	// смещения получателей
+	sink := uniq("sink")
+	offset, err := s.GetOutboxOffset(ctx, sink)
+	require.NoError(t, err)
+	assert.Zero(t, offset)
+
+	require.NoError(t, s.SaveOutboxOffset(ctx, sink, start))
+	require.NoError(t, s.SaveOutboxOffset(ctx, sink, lastID))
+	offset, err = s.GetOutboxOffset(ctx, sink)
+	require.NoError(t, err)
+	assert.Equal(t, lastID, offset)
+
+	// запоздавшее меньшее смещение не откатывает доставку назад
+	require.NoError(t, s.SaveOutboxOffset(ctx, sink, start))
+	offset, err = s.GetOutboxOffset(ctx, sink)
+	require.NoError(t, err)
+	assert.Equal(t, lastID, offset)
+}
+
+// lastAuditEvent последняя запись журнала аудита, nil — журнал пуст
+func lastAuditEvent(t *testing.T, s Storage) *models.AuditEvent {
+	t.Helper()
+	var last *models.AuditEvent
+	for {
+		filter := models.AuditFilter{Limit: 1000}
+		if last != nil {
+			filter.AfterID = last.ID
+		}
+		events, err := s.GetAuditEvents(context.Background(), filter)
+		require.NoError(t, err)
+		if len(events) == 0 {
+			return last
+		}
+		last = events[len(events)-1]
+	}
+}
+
+// testAudit записи связаны цепочкой хэшей и читаются по пользователю и времени
+func testAudit(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	var start int64
+	prevHash := ""
+	if last := lastAuditEvent(t, s); last != nil {
+		start, prevHash = last.ID, last.Hash
+	}
+
+	admin, err := s.SaveUser(ctx, uniq("admin"), "hash")
+	require.NoError(t, err)
+	user, err := s.SaveUser(ctx, uniq("user"), "hash")
+	require.NoError(t, err)
+
+	at := time.Now().Add(-time.Hour)
+	appended := []*models.AuditEvent{
+		{
+			Action: models.AuditLoginSucceeded, ActorID: user, Actor: "user", SubjectID: user, Subject: "user",
+			IP: "10.0.0.1", UserAgent: "curl/8.0", RequestID: "req-1", CreatedAt: at,
+		},
+		{
+			Action: models.AuditOrderStatusForced, ActorID: admin, Actor: "admin", SubjectID: user, Subject: "12345678903",
+			Before:    json.RawMessage(`{"status":"NEW"}`),
+			After:     json.RawMessage(`{"status":"INVALID","reason":"rejected"}`),
+			CreatedAt: at.Add(time.Minute),
+		},
+		{Action: models.AuditOrderStuck, Actor: "accrual-poller", Subject: uniq("order")},
+	}
+	for _, e := range appended {
+		require.NoError(t, s.AppendAuditEvent(ctx, e))
+		assert.Greater(t, e.ID, start)
+		assert.Equal(t, prevHash, e.PrevHash)
+		assert.Equal(t, e.ComputeHash(), e.Hash)
+		start, prevHash = e.ID, e.Hash
+	}
+	assert.False(t, appended[2].CreatedAt.IsZero(), "time is set on append")
+
+	// записи читаются такими же, какими были посчитаны хэши
+	events, err := s.GetAuditEvents(ctx, models.AuditFilter{AfterID: appended[0].ID - 1})
+	require.NoError(t, err)
+	require.Len(t, events, len(appended))
+	for i, e := range events {
+		want := *appended[i]
+		assert.True(t, want.CreatedAt.Equal(e.CreatedAt))
+		want.CreatedAt = e.CreatedAt
+		assert.Equal(t, &want, e)
+		assert.Equal(t, e.Hash, e.ComputeHash())
+	}
+
+	// по пользователю — и как исполнитель, и как тот, кого касается запись
+	events, err = s.GetAuditEvents(ctx, models.AuditFilter{UserID: user})
+	require.NoError(t, err)
+	assert.Len(t, events, 2)
+
+	events, err = s.GetAuditEvents(ctx, models.AuditFilter{UserID: admin})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, models.AuditOrderStatusForced, events[0].Action)
+
+	events, err = s.GetAuditEvents(ctx, models.AuditFilter{UserID: user, From: at.Add(time.Second), To: at.Add(2 * time.Minute)})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, appended[1].ID, events[0].ID)
+
+	events, err = s.GetAuditEvents(ctx, models.AuditFilter{UserID: user, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, appended[0].ID, events[0].ID)
+
+	// параллельные записи не разрывают цепочку
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e := &models.AuditEvent{Action: models.AuditLoginFailed, Actor: fmt.Sprintf("guest-%d", i)}
+			assert.NoError(t, s.AppendAuditEvent(ctx, e))
+		}()
+	}
+	wg.Wait()
+
+	events, err = s.GetAuditEvents(ctx, models.AuditFilter{AfterID: appended[2].ID})
+	require.NoError(t, err)
+	require.Len(t, events, 8)
+	for _, e := range events {
+		assert.Equal(t, prevHash, e.PrevHash)
+		assert.Equal(t, e.Hash, e.ComputeHash())
+		prevHash = e.Hash
+	}
+}
+
+// testArchive архивирует месяц далеко в прошлом: в общей PostgreSQL-базе он старше
+// записей других тестов, повторный запуск архивирует его заново
+func testArchive(t *testing.T, s Storage) {
+	ctx := context.Background()
+	userID := newTestUser(t, s)
+
+	jan := time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC)
+	feb := jan.AddDate(0, 1, 0)
+	_, err := s.EnsureLedgerPartitions(ctx, jan, 1)
+	require.NoError(t, err)
+
+	create := func(op *models.BalanceOperation, at time.Time) {
+		op.ProcessedAt = at
+		require.NoError(t, s.CreateOperation(ctx, op))
+	}
+	archived := newOrder(userID, uniq("order"))
+	archived.Status, archived.Amount = models.ProcessedStatus, 10000
+	create(archived, jan.Add(10*24*time.Hour))
+	invalid := newOrder(userID, uniq("order"))
+	invalid.Status = models.InvalidStatus
+	create(invalid, jan.Add(5*24*time.Hour))
+	create(newWithdrawal(userID, uniq("wd"), 2500), jan.Add(20*24*time.Hour))
+	kept := newOrder(userID, uniq("order"))
+	kept.Status, kept.Amount = models.ProcessedStatus, 3000
+	create(kept, feb.Add(2*24*time.Hour))
+	pending := uniq("order")
+	require.NoError(t, s.UploadOrder(ctx, newOrder(userID, pending)))
+	time.Sleep(time.Millisecond)
+	// заказ ещё не рассчитан, хотя статус последний раз менялся в архивном месяце
+	stale := newOrder(userID, uniq("order"))
+	create(stale, jan.Add(3*24*time.Hour))
+	time.Sleep(time.Millisecond)
+
+	entries, err := s.GetLedgerEntries(ctx, jan, 0, 1000)
+	require.NoError(t, err)
+	var mine []*models.LedgerEntry
+	for _, e := range entries {
+		for _, p := range e.Postings {
+			if p.UserID == userID {
+				mine = append(mine, e)
+			}
+		}
+	}
+	require.Len(t, mine, 2)
+	assert.Equal(t, archived.OrderNumber, mine[0].OrderNumber)
+	assert.Equal(t, models.AccrualOp, mine[0].EntryType)
+	require.Len(t, mine[0].Postings, 2)
+	assert.Equal(t, models.Money(0), mine[0].Postings[0].Amount+mine[0].Postings[1].Amount)
+	assert.Equal(t, models.WithdrawalOp, mine[1].EntryType)
+
+	page, err := s.GetLedgerEntries(ctx, jan, entries[0].ID, 1)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, entries[1].ID, page[0].ID)
+
+	months, err := s.GetLedgerMonths(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, months)
+	assert.True(t, months[0].Equal(jan))
+	assert.True(t, months[1].Equal(feb))
+
+	// архивируется только самый старый месяц и только в том виде, в каком выгружен
+	assert.ErrorIs(t, s.ArchiveLedgerMonth(ctx, feb, 1), ErrArchiveNotOldest)
+	assert.ErrorIs(t, s.ArchiveLedgerMonth(ctx, jan, len(entries)+1), ErrArchiveMismatch)
+	require.NoError(t, s.ArchiveLedgerMonth(ctx, jan, len(entries)))
+
+	months, err = s.GetLedgerMonths(ctx)
+	require.NoError(t, err)
+	assert.True(t, months[0].Equal(feb))
+	entries, err = s.GetLedgerEntries(ctx, jan, 0, 1000)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// баланс и начисления не изменились, журнал с входящими остатками сходится
+	current, withdrawn, err := s.GetBalance(ctx, userID)
+	require.NoError(t, err)
+	assert.Equal(t, models.Money(10500), current)
+	assert.Equal(t, models.Money(2500), withdrawn)
+
+	drifts, err := s.VerifyBalances(ctx)
+	require.NoError(t, err)
+	for _, d := range drifts {
+		assert.NotEqual(t, userID, d.UserID)
+	}
+
+	orders, err := s.GetAccrualsByUser(ctx, userID, models.ListFilter{})
+	require.NoError(t, err)
+	require.Len(t, orders, 5)
+	assert.Equal(t, models.Money(10000), findOrder(orders, archived.OrderNumber).Accrual)
+	assert.Equal(t, models.Money(3000), findOrder(orders, kept.OrderNumber).Accrual)
+
+	order, err := s.GetOrder(ctx, archived.OrderNumber)
+	require.NoError(t, err)
+	require.NoError(t, s.AdjustAccrual(ctx, order.ID, -500, "reconciliation"))
+	orders, err = s.GetAccrualsByUser(ctx, userID, models.ListFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, models.Money(9500), findOrder(orders, archived.OrderNumber).Accrual)
+
+	withdrawals, err := s.GetWithdrawalsByUser(ctx, userID, models.ListFilter{})
+	require.NoError(t, err)
+	assert.Empty(t, withdrawals)
+
+	// выписка начинается после архива, баланс — от входящего остатка; незавершённые
+	// заказы остаются в ней, окончательный invalid ушёл в архив
+	ops, err := s.GetOperationsByUser(ctx, userID, models.ListFilter{})
+	require.NoError(t, err)
+	require.Len(t, ops, 4)
+	assert.Equal(t, kept.OrderNumber, ops[0].OrderNumber)
+	assert.Equal(t, models.Money(10500), ops[0].Balance)
+	assert.Equal(t, pending, ops[1].OrderNumber)
+	assert.Equal(t, models.Money(10500), ops[1].Balance)
+	assert.Equal(t, stale.OrderNumber, ops[2].OrderNumber)
+	assert.Equal(t, models.Money(10500), ops[2].Balance)
+	assert.Equal(t, models.AdjustmentOp, ops[3].OperationType)
+	assert.Equal(t, models.Money(10000), ops[3].Balance)
+}
+
+// archiveAccruedOrder заказ нового пользователя с начислением 100.00 в месяце month,
+// который затем архивируется; month должен быть старше остальных записей журнала
+func archiveAccruedOrder(t *testing.T, s Storage, month time.Time) *models.BalanceOperation {
+	t.Helper()
+	ctx := context.Background()
+	userID := newTestUser(t, s)
+
+	_, err := s.EnsureLedgerPartitions(ctx, month, 0)
+	require.NoError(t, err)
+	op := newOrder(userID, uniq("order"))
+	op.Status, op.Amount, op.ProcessedAt = models.ProcessedStatus, 10000, month.Add(10*24*time.Hour)
+	require.NoError(t, s.CreateOperation(ctx, op))
+
+	entries, err := s.GetLedgerEntries(ctx, month, 0, 1000)
+	require.NoError(t, err)
+	require.NoError(t, s.ArchiveLedgerMonth(ctx, month, len(entries)))
+
+	order, err := s.GetOrder(ctx, op.OrderNumber)
+	require.NoError(t, err)
+	return order
+}
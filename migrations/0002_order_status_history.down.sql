@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_balance_operations_pending;
+DROP TABLE IF EXISTS order_status_history;
@@ -0,0 +1,18 @@
+-- История переходов статусов заказов
+CREATE TABLE IF NOT EXISTS order_status_history (
+    id BIGSERIAL PRIMARY KEY,
+    operation_id BIGINT NOT NULL REFERENCES balance_operations(id) ON DELETE CASCADE,
+    order_number TEXT NOT NULL,
+    from_status TEXT                        -- NULL для первой записи (загрузка заказа)
+        CHECK (from_status IN ('NEW', 'PROCESSING', 'INVALID', 'PROCESSED')),
+    to_status TEXT NOT NULL
+        CHECK (to_status IN ('NEW', 'PROCESSING', 'INVALID', 'PROCESSED')),
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_status_history_order ON order_status_history(order_number, changed_at);
+
+-- Незавершённые заказы, которые опрашивает poller
+CREATE INDEX IF NOT EXISTS idx_balance_operations_pending
+    ON balance_operations(status)
+    WHERE operation_type = 'accrual' AND status IN ('NEW', 'PROCESSING');
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_balance_operations_pending;
+CREATE INDEX IF NOT EXISTS idx_balance_operations_pending
+    ON balance_operations(status)
+    WHERE operation_type = 'accrual' AND status IN ('NEW', 'PROCESSING');
+
+ALTER TABLE balance_operations
+    DROP COLUMN IF EXISTS last_poll_error,
+    DROP COLUMN IF EXISTS poll_attempts,
+    DROP COLUMN IF EXISTS next_poll_at;
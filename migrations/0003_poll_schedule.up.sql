@@ -0,0 +1,10 @@
+-- Расписание опроса accrual по каждому заказу
+ALTER TABLE balance_operations
+    ADD COLUMN IF NOT EXISTS next_poll_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    ADD COLUMN IF NOT EXISTS poll_attempts INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS last_poll_error TEXT;
+
+DROP INDEX IF EXISTS idx_balance_operations_pending;
+CREATE INDEX IF NOT EXISTS idx_balance_operations_pending
+    ON balance_operations(next_poll_at)
+    WHERE operation_type = 'accrual' AND status IN ('NEW', 'PROCESSING');
@@ -0,0 +1,8 @@
+-- Подписи принятых push-уведомлений accrual: повтор отклоняется на любом экземпляре,
+-- пока подпись не истекла (окно допуска времени отправки).
+CREATE TABLE IF NOT EXISTS webhook_signatures (
+    signature TEXT PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_signatures_expires ON webhook_signatures(expires_at);
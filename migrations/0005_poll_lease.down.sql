@@ -0,0 +1,3 @@
+ALTER TABLE balance_operations
+    DROP COLUMN IF EXISTS lease_until,
+    DROP COLUMN IF EXISTS lease_owner;
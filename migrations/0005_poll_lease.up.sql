@@ -0,0 +1,4 @@
+-- Аренда заказа одним экземпляром poller (SELECT ... FOR UPDATE SKIP LOCKED)
+ALTER TABLE balance_operations
+    ADD COLUMN IF NOT EXISTS lease_owner TEXT,
+    ADD COLUMN IF NOT EXISTS lease_until TIMESTAMPTZ;
@@ -0,0 +1,11 @@
+ALTER TABLE users DROP COLUMN IF EXISTS is_admin;
+
+ALTER TABLE order_status_history DROP COLUMN IF EXISTS reason;
+
+DROP INDEX IF EXISTS idx_balance_operations_stuck;
+
+ALTER TABLE balance_operations
+    DROP COLUMN IF EXISTS requeued_at,
+    DROP COLUMN IF EXISTS stuck_reason,
+    DROP COLUMN IF EXISTS stuck_at,
+    DROP COLUMN IF EXISTS created_at;
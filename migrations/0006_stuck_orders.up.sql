@@ -0,0 +1,27 @@
+-- Время загрузки заказа: processed_at меняется при каждом обновлении статуса
+ALTER TABLE balance_operations
+    ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+UPDATE balance_operations b
+SET created_at = COALESCE(
+    (SELECT MIN(h.changed_at) FROM order_status_history h WHERE h.operation_id = b.id),
+    b.processed_at
+);
+
+-- Зависшие заказы: не получили окончательный статус за отведённое время, poller их не опрашивает
+ALTER TABLE balance_operations
+    ADD COLUMN IF NOT EXISTS stuck_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS stuck_reason TEXT,
+    ADD COLUMN IF NOT EXISTS requeued_at TIMESTAMPTZ;   -- возраст после ручного возврата считается от него
+
+CREATE INDEX IF NOT EXISTS idx_balance_operations_stuck
+    ON balance_operations(stuck_at)
+    WHERE stuck_at IS NOT NULL;
+
+-- Причина ручного перевода статуса администратором
+ALTER TABLE order_status_history
+    ADD COLUMN IF NOT EXISTS reason TEXT;
+
+-- Доступ к административному API
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,13 @@
+DELETE FROM balance_operations WHERE operation_type = 'adjustment';
+
+DROP INDEX IF EXISTS idx_balance_operations_adjusts;
+
+ALTER TABLE balance_operations
+    DROP COLUMN IF EXISTS adjust_reason,
+    DROP COLUMN IF EXISTS adjusts_id;
+
+ALTER TABLE balance_operations
+    DROP CONSTRAINT IF EXISTS balance_operations_operation_type_check;
+ALTER TABLE balance_operations
+    ADD CONSTRAINT balance_operations_operation_type_check
+        CHECK (operation_type IN ('accrual', 'withdrawal'));
@@ -0,0 +1,14 @@
+-- Корректирующие проводки сверки с accrual: знаковая разница к исходному начислению
+ALTER TABLE balance_operations
+    DROP CONSTRAINT IF EXISTS balance_operations_operation_type_check;
+ALTER TABLE balance_operations
+    ADD CONSTRAINT balance_operations_operation_type_check
+        CHECK (operation_type IN ('accrual', 'withdrawal', 'adjustment'));
+
+ALTER TABLE balance_operations
+    ADD COLUMN IF NOT EXISTS adjusts_id BIGINT REFERENCES balance_operations(id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS adjust_reason TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_balance_operations_adjusts
+    ON balance_operations(adjusts_id)
+    WHERE adjusts_id IS NOT NULL;
@@ -0,0 +1,5 @@
+-- Номер заказа загружается один раз: уникальность обеспечивает БД, а не проверка перед вставкой.
+-- Если в таблице уже есть дубли, миграция упадёт — их нужно разобрать вручную.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_balance_operations_accrual_order
+    ON balance_operations(order_number)
+    WHERE operation_type = 'accrual';
@@ -0,0 +1,19 @@
+-- Баланс пользователя, обновляется в одной транзакции с каждой операцией по балансу.
+-- Источник истины — balance_operations, сверка: gophermart verify-balances
+CREATE TABLE IF NOT EXISTS user_balances (
+    user_id BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    current DECIMAL(12,2) NOT NULL DEFAULT 0,
+    withdrawn DECIMAL(12,2) NOT NULL DEFAULT 0,
+    version BIGINT NOT NULL DEFAULT 0,      -- +1 на каждое изменение
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO user_balances (user_id, current, withdrawn, version)
+SELECT user_id,
+    SUM(amount),
+    SUM(CASE WHEN operation_type = 'withdrawal' THEN -amount ELSE 0 END),
+    COUNT(*)
+FROM balance_operations
+WHERE status = 'PROCESSED'
+GROUP BY user_id
+ON CONFLICT (user_id) DO NOTHING;
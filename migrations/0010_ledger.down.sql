@@ -0,0 +1,78 @@
+-- Обратно в единую таблицу balance_operations: заказ и его начисление — одна строка,
+-- списания и корректировки — строки с суммой проводки по счёту пользователя
+DROP TRIGGER IF EXISTS postings_balanced ON postings;
+DROP TRIGGER IF EXISTS postings_append_only ON postings;
+DROP TRIGGER IF EXISTS journal_entries_append_only ON journal_entries;
+DROP FUNCTION IF EXISTS ledger_entry_balanced();
+DROP FUNCTION IF EXISTS ledger_append_only();
+
+CREATE TABLE balance_operations (
+    id BIGINT PRIMARY KEY DEFAULT nextval('operation_id_seq'),
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    order_number TEXT NOT NULL,
+    amount DECIMAL(10,2) NOT NULL,
+    operation_type TEXT NOT NULL
+        CONSTRAINT balance_operations_operation_type_check
+        CHECK (operation_type IN ('accrual', 'withdrawal', 'adjustment')),
+    status TEXT NOT NULL
+        CHECK (status IN ('NEW', 'PROCESSING', 'INVALID', 'PROCESSED')),
+    processed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    next_poll_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    poll_attempts INTEGER NOT NULL DEFAULT 0,
+    last_poll_error TEXT,
+    lease_owner TEXT,
+    lease_until TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    stuck_at TIMESTAMPTZ,
+    stuck_reason TEXT,
+    requeued_at TIMESTAMPTZ,
+    adjusts_id BIGINT REFERENCES balance_operations(id) ON DELETE CASCADE,
+    adjust_reason TEXT
+);
+
+INSERT INTO balance_operations (id, user_id, order_number, amount, operation_type, status, processed_at,
+    next_poll_at, poll_attempts, last_poll_error, lease_owner, lease_until, created_at,
+    stuck_at, stuck_reason, requeued_at)
+SELECT id, user_id, order_number, accrual, 'accrual', status, processed_at,
+    next_poll_at, poll_attempts, last_poll_error, lease_owner, lease_until, created_at,
+    stuck_at, stuck_reason, requeued_at
+FROM orders;
+
+INSERT INTO balance_operations (id, user_id, order_number, amount, operation_type, status, processed_at,
+    adjusts_id, adjust_reason)
+SELECT e.id, a.user_id, e.order_number, p.amount, e.entry_type, 'PROCESSED', e.posted_at,
+    e.order_id, e.reason
+FROM journal_entries e
+JOIN postings p ON p.entry_id = e.id
+JOIN accounts a ON a.id = p.account_id AND a.user_id IS NOT NULL
+WHERE e.entry_type IN ('withdrawal', 'adjustment');
+
+ALTER SEQUENCE operation_id_seq RENAME TO balance_operations_id_seq;
+ALTER SEQUENCE balance_operations_id_seq OWNED BY balance_operations.id;
+
+CREATE INDEX IF NOT EXISTS idx_balance_operations_user ON balance_operations(user_id);
+CREATE INDEX IF NOT EXISTS idx_balance_operations_user_status ON balance_operations(user_id, status);
+CREATE INDEX IF NOT EXISTS idx_balance_operations_order ON balance_operations(order_number);
+CREATE INDEX IF NOT EXISTS idx_balance_operations_pending
+    ON balance_operations(next_poll_at)
+    WHERE operation_type = 'accrual' AND status IN ('NEW', 'PROCESSING');
+CREATE INDEX IF NOT EXISTS idx_balance_operations_stuck
+    ON balance_operations(stuck_at)
+    WHERE stuck_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_balance_operations_adjusts
+    ON balance_operations(adjusts_id)
+    WHERE adjusts_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_balance_operations_accrual_order
+    ON balance_operations(order_number)
+    WHERE operation_type = 'accrual';
+
+ALTER TABLE order_status_history DROP CONSTRAINT order_status_history_order_id_fkey;
+ALTER TABLE order_status_history RENAME COLUMN order_id TO operation_id;
+ALTER TABLE order_status_history
+    ADD CONSTRAINT order_status_history_operation_id_fkey
+        FOREIGN KEY (operation_id) REFERENCES balance_operations(id) ON DELETE CASCADE;
+
+DROP TABLE IF EXISTS postings;
+DROP TABLE IF EXISTS journal_entries;
+DROP TABLE IF EXISTS accounts;
+DROP TABLE IF EXISTS orders;
@@ -0,0 +1,153 @@
+-- Заказы отдельно от движения баллов, баллы — в журнале двойной записи.
+-- Каждое движение — запись journal_entries и проводки postings по счетам с нулевой суммой:
+-- начисление переводит баллы со счёта accrual_source на счёт пользователя,
+-- списание — со счёта пользователя на redemption_sink. Баланс счёта — сумма его проводок.
+
+-- Заказы и записи журнала берут id из одной последовательности: выписка сортирует
+-- их вместе по (время, id). Последовательность остаётся от balance_operations, id сохраняются.
+ALTER SEQUENCE balance_operations_id_seq OWNED BY NONE;
+ALTER SEQUENCE balance_operations_id_seq RENAME TO operation_id_seq;
+
+CREATE TABLE orders (
+    id BIGINT PRIMARY KEY DEFAULT nextval('operation_id_seq'),
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    order_number TEXT NOT NULL UNIQUE,
+    status TEXT NOT NULL
+        CHECK (status IN ('NEW', 'PROCESSING', 'INVALID', 'PROCESSED')),
+    accrual DECIMAL(12,2) NOT NULL DEFAULT 0,  -- по данным accrual; в балансе — проводками журнала
+    processed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    -- расписание опроса accrual и аренда заказа экземпляром poller
+    next_poll_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    poll_attempts INTEGER NOT NULL DEFAULT 0,
+    last_poll_error TEXT,
+    lease_owner TEXT,
+    lease_until TIMESTAMPTZ,
+
+    -- зависшие заказы
+    stuck_at TIMESTAMPTZ,
+    stuck_reason TEXT,
+    requeued_at TIMESTAMPTZ
+);
+
+INSERT INTO orders (id, user_id, order_number, status, accrual, processed_at, created_at,
+    next_poll_at, poll_attempts, last_poll_error, lease_owner, lease_until,
+    stuck_at, stuck_reason, requeued_at)
+SELECT id, user_id, order_number, status, amount, processed_at, created_at,
+    next_poll_at, poll_attempts, last_poll_error, lease_owner, lease_until,
+    stuck_at, stuck_reason, requeued_at
+FROM balance_operations
+WHERE operation_type = 'accrual';
+
+-- список заказов листается по времени загрузки: processed_at меняется с каждым статусом
+CREATE INDEX idx_orders_user ON orders(user_id, created_at, id);
+
+CREATE INDEX idx_orders_pending
+    ON orders(next_poll_at)
+    WHERE status IN ('NEW', 'PROCESSING');
+
+CREATE INDEX idx_orders_stuck
+    ON orders(stuck_at)
+    WHERE stuck_at IS NOT NULL;
+
+-- История статусов ссылается на заказ
+ALTER TABLE order_status_history RENAME COLUMN operation_id TO order_id;
+ALTER TABLE order_status_history DROP CONSTRAINT order_status_history_operation_id_fkey;
+ALTER TABLE order_status_history
+    ADD CONSTRAINT order_status_history_order_id_fkey
+        FOREIGN KEY (order_id) REFERENCES orders(id) ON DELETE CASCADE;
+
+-- Счета: по одному на пользователя и системные по коду
+CREATE TABLE accounts (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT UNIQUE REFERENCES users(id),
+    code TEXT UNIQUE,
+    CHECK ((user_id IS NULL) <> (code IS NULL))
+);
+
+INSERT INTO accounts (code) VALUES ('accrual_source'), ('redemption_sink');
+INSERT INTO accounts (user_id) SELECT id FROM users;
+
+CREATE TABLE journal_entries (
+    id BIGINT PRIMARY KEY DEFAULT nextval('operation_id_seq'),
+    entry_type TEXT NOT NULL CHECK (entry_type IN ('accrual', 'withdrawal', 'adjustment')),
+    order_number TEXT NOT NULL,
+    order_id BIGINT REFERENCES orders(id),      -- начисление и корректировки по заказу
+    reason TEXT,                                -- причина корректировки
+    posted_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Одно начисление на заказ, корректировки — отдельными записями
+CREATE UNIQUE INDEX idx_journal_entries_accrual ON journal_entries(order_id) WHERE entry_type = 'accrual';
+CREATE INDEX idx_journal_entries_order ON journal_entries(order_id) WHERE order_id IS NOT NULL;
+
+CREATE TABLE postings (
+    id BIGSERIAL PRIMARY KEY,
+    entry_id BIGINT NOT NULL REFERENCES journal_entries(id),
+    account_id BIGINT NOT NULL REFERENCES accounts(id),
+    amount DECIMAL(12,2) NOT NULL CHECK (amount <> 0)  -- со знаком: + на счёт, - со счёта
+);
+
+CREATE INDEX idx_postings_account ON postings(account_id, entry_id);
+CREATE INDEX idx_postings_entry ON postings(entry_id);
+
+-- Списания и корректировки сохраняют id, начисления рассчитанных заказов получают новые
+INSERT INTO journal_entries (id, entry_type, order_number, order_id, reason, posted_at)
+SELECT id, operation_type, order_number, adjusts_id, adjust_reason, processed_at
+FROM balance_operations
+WHERE operation_type IN ('withdrawal', 'adjustment') AND status = 'PROCESSED' AND amount <> 0;
+
+INSERT INTO journal_entries (entry_type, order_number, order_id, posted_at)
+SELECT 'accrual', order_number, id, processed_at
+FROM orders
+WHERE status = 'PROCESSED' AND accrual <> 0
+ORDER BY id;
+
+WITH moves AS (
+    SELECT e.id AS entry_id, o.user_id, o.accrual AS amount, 'accrual_source' AS counter
+    FROM journal_entries e
+    JOIN orders o ON o.id = e.order_id
+    WHERE e.entry_type = 'accrual'
+    UNION ALL
+    SELECT e.id, b.user_id, b.amount,
+        CASE e.entry_type WHEN 'withdrawal' THEN 'redemption_sink' ELSE 'accrual_source' END
+    FROM journal_entries e
+    JOIN balance_operations b ON b.id = e.id
+)
+INSERT INTO postings (entry_id, account_id, amount)
+SELECT m.entry_id, a.id, m.amount FROM moves m JOIN accounts a ON a.user_id = m.user_id
+UNION ALL
+SELECT m.entry_id, a.id, -m.amount FROM moves m JOIN accounts a ON a.code = m.counter;
+
+DROP TABLE balance_operations;
+
+-- Журнал только дополняется: исправление — новая запись, а не UPDATE или DELETE
+CREATE FUNCTION ledger_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'ledger is append-only: % on %', TG_OP, TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER journal_entries_append_only
+    BEFORE UPDATE OR DELETE ON journal_entries
+    FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
+
+CREATE TRIGGER postings_append_only
+    BEFORE UPDATE OR DELETE ON postings
+    FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
+
+-- Сумма проводок записи равна нулю; проверяется при коммите, когда записаны обе проводки
+CREATE FUNCTION ledger_entry_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT SUM(amount) FROM postings WHERE entry_id = NEW.entry_id) <> 0 THEN
+        RAISE EXCEPTION 'journal entry % is not balanced', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER postings_balanced
+    AFTER INSERT ON postings
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION ledger_entry_balanced();
@@ -0,0 +1,25 @@
+-- Доменные события пишутся в одной транзакции с изменением и доставляются relay.
+-- Вставка идёт без блокировок, событие помнит транзакцию (txid). Номер position,
+-- по которому читают получатели, relay присваивает под advisory-блокировкой только
+-- событиям уже завершённых транзакций, поэтому position растёт в порядке фиксации
+-- и смещение получателя не перескакивает незакоммиченные события.
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id BIGSERIAL PRIMARY KEY,
+    event_type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    txid XID8 NOT NULL DEFAULT pg_current_xact_id(),
+    position BIGINT UNIQUE
+);
+
+-- События, которым ещё не присвоен номер
+CREATE INDEX IF NOT EXISTS idx_outbox_events_unnumbered
+    ON outbox_events(id)
+    WHERE position IS NULL;
+
+-- Последнее доставленное событие по каждому получателю
+CREATE TABLE IF NOT EXISTS outbox_offsets (
+    sink TEXT PRIMARY KEY,
+    last_event_id BIGINT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,34 @@
+-- Журнал аудита: кто и что сделал с учётными записями, баллами и заказами.
+-- Записи связаны цепочкой хэшей (см. models.AuditEvent), вставка идёт под
+-- advisory-блокировкой, поэтому порядок id совпадает с порядком цепочки.
+-- before и after — TEXT, а не JSONB: хэш считается от байтов, которые были записаны.
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    action TEXT NOT NULL,
+    actor_id BIGINT,
+    actor TEXT NOT NULL,
+    subject_id BIGINT,
+    subject TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    request_id TEXT NOT NULL DEFAULT '',
+    before TEXT,
+    after TEXT,
+    created_at TIMESTAMPTZ NOT NULL,
+    prev_hash TEXT NOT NULL,
+    hash TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events(actor_id, created_at) WHERE actor_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_audit_events_subject ON audit_events(subject_id, created_at) WHERE subject_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_audit_events_created ON audit_events(created_at);
+
+CREATE FUNCTION audit_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit log is append-only: % on %', TG_OP, TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_append_only();
@@ -0,0 +1,83 @@
+-- Архивные месяцы удалены из журнала, без них балансы не пересчитать
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM ledger_archive) THEN
+        RAISE EXCEPTION 'ledger has archived months, rollback would lose them';
+    END IF;
+END;
+$$;
+
+ALTER TABLE orders
+    DROP COLUMN archived_credit,
+    DROP COLUMN accrual_archived;
+
+DROP TABLE ledger_openings;
+DROP TABLE ledger_archive;
+DROP TABLE accrual_entries;
+
+ALTER TABLE journal_entries RENAME TO journal_entries_parted;
+ALTER TABLE postings RENAME TO postings_parted;
+ALTER SEQUENCE postings_id_seq OWNED BY NONE;
+
+DROP INDEX idx_journal_entries_order;
+DROP INDEX idx_postings_account;
+DROP INDEX idx_postings_entry;
+ALTER TABLE journal_entries_parted RENAME CONSTRAINT journal_entries_pkey TO journal_entries_parted_pkey;
+ALTER TABLE postings_parted RENAME CONSTRAINT postings_pkey TO postings_parted_pkey;
+
+CREATE TABLE journal_entries (
+    id BIGINT PRIMARY KEY DEFAULT nextval('operation_id_seq'),
+    entry_type TEXT NOT NULL CHECK (entry_type IN ('accrual', 'withdrawal', 'adjustment')),
+    order_number TEXT NOT NULL,
+    order_id BIGINT REFERENCES orders(id),
+    reason TEXT,
+    posted_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE postings (
+    id BIGINT PRIMARY KEY DEFAULT nextval('postings_id_seq'),
+    entry_id BIGINT NOT NULL REFERENCES journal_entries(id),
+    account_id BIGINT NOT NULL REFERENCES accounts(id),
+    amount DECIMAL(12,2) NOT NULL CHECK (amount <> 0)
+);
+
+ALTER SEQUENCE postings_id_seq OWNED BY postings.id;
+
+INSERT INTO journal_entries (id, entry_type, order_number, order_id, reason, posted_at)
+SELECT id, entry_type, order_number, order_id, reason, posted_at
+FROM journal_entries_parted;
+
+INSERT INTO postings (id, entry_id, account_id, amount)
+SELECT id, entry_id, account_id, amount
+FROM postings_parted;
+
+DROP TABLE postings_parted;
+DROP TABLE journal_entries_parted;
+DROP FUNCTION create_ledger_partition(DATE);
+
+CREATE UNIQUE INDEX idx_journal_entries_accrual ON journal_entries(order_id) WHERE entry_type = 'accrual';
+CREATE INDEX idx_journal_entries_order ON journal_entries(order_id) WHERE order_id IS NOT NULL;
+CREATE INDEX idx_postings_account ON postings(account_id, entry_id);
+CREATE INDEX idx_postings_entry ON postings(entry_id);
+
+CREATE TRIGGER journal_entries_append_only
+    BEFORE UPDATE OR DELETE ON journal_entries
+    FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
+
+CREATE TRIGGER postings_append_only
+    BEFORE UPDATE OR DELETE ON postings
+    FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
+
+CREATE OR REPLACE FUNCTION ledger_entry_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT SUM(amount) FROM postings WHERE entry_id = NEW.entry_id) <> 0 THEN
+        RAISE EXCEPTION 'journal entry % is not balanced', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER postings_balanced
+    AFTER INSERT ON postings
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION ledger_entry_balanced();
@@ -0,0 +1,151 @@
+-- Журнал делится на месяцы по posted_at (UTC): партиции journal_entries_pYYYYMM
+-- и postings_pYYYYMM. Партиции на месяцы вперёд создаёт сервер (create_ledger_partition),
+-- старые месяцы архивирует команда archive: итоги месяца переносятся во входящие
+-- остатки ledger_openings и в orders.archived_credit, партиции отсоединяются и удаляются.
+-- Ключ партиции входит в первичный ключ, поэтому у проводки есть posted_at её записи.
+
+ALTER TABLE journal_entries RENAME TO journal_entries_old;
+ALTER TABLE journal_entries_old RENAME CONSTRAINT journal_entries_pkey TO journal_entries_old_pkey;
+ALTER TABLE postings RENAME TO postings_old;
+ALTER TABLE postings_old RENAME CONSTRAINT postings_pkey TO postings_old_pkey;
+ALTER SEQUENCE postings_id_seq OWNED BY NONE;
+
+DROP INDEX idx_journal_entries_accrual;
+DROP INDEX idx_journal_entries_order;
+DROP INDEX idx_postings_account;
+DROP INDEX idx_postings_entry;
+
+CREATE TABLE journal_entries (
+    id BIGINT NOT NULL DEFAULT nextval('operation_id_seq'),
+    entry_type TEXT NOT NULL CHECK (entry_type IN ('accrual', 'withdrawal', 'adjustment')),
+    order_number TEXT NOT NULL,
+    order_id BIGINT REFERENCES orders(id),      -- начисление и корректировки по заказу
+    reason TEXT,                                -- причина корректировки
+    posted_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id, posted_at)
+) PARTITION BY RANGE (posted_at);
+
+CREATE TABLE postings (
+    id BIGINT NOT NULL DEFAULT nextval('postings_id_seq'),
+    entry_id BIGINT NOT NULL,
+    posted_at TIMESTAMPTZ NOT NULL,             -- время записи, ключ партиции
+    account_id BIGINT NOT NULL REFERENCES accounts(id),
+    amount DECIMAL(12,2) NOT NULL CHECK (amount <> 0),  -- со знаком: + на счёт, - со счёта
+    PRIMARY KEY (id, posted_at),
+    FOREIGN KEY (entry_id, posted_at) REFERENCES journal_entries(id, posted_at)
+) PARTITION BY RANGE (posted_at);
+
+ALTER SEQUENCE postings_id_seq OWNED BY postings.id;
+
+-- Записи вне созданных месяцев попадают в партицию по умолчанию. Она должна быть пустой:
+-- месяц с записями в ней не создать, пока они не перенесены.
+CREATE TABLE journal_entries_default PARTITION OF journal_entries DEFAULT;
+CREATE TABLE postings_default PARTITION OF postings DEFAULT;
+
+-- Партиции месяца, в который попадает дата month; уже есть — FALSE.
+-- Экземпляры сервера создают партиции одновременно, поэтому — под advisory-блокировкой.
+CREATE FUNCTION create_ledger_partition(month DATE) RETURNS BOOLEAN AS $$
+DECLARE
+    month_start DATE := month - (EXTRACT(DAY FROM month)::INT - 1);
+    from_ts TIMESTAMPTZ := month_start::TIMESTAMP AT TIME ZONE 'UTC';
+    to_ts TIMESTAMPTZ := (month_start + INTERVAL '1 month') AT TIME ZONE 'UTC';
+    suffix TEXT := to_char(month_start, 'YYYYMM');
+BEGIN
+    PERFORM pg_advisory_xact_lock(7301003);
+    IF to_regclass('journal_entries_p' || suffix) IS NOT NULL THEN
+        RETURN FALSE;
+    END IF;
+
+    EXECUTE format('CREATE TABLE %I PARTITION OF journal_entries FOR VALUES FROM (%L) TO (%L)',
+        'journal_entries_p' || suffix, from_ts, to_ts);
+    EXECUTE format('CREATE TABLE %I PARTITION OF postings FOR VALUES FROM (%L) TO (%L)',
+        'postings_p' || suffix, from_ts, to_ts);
+    RETURN TRUE;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Месяцы с первой записи журнала и два вперёд
+DO $$
+DECLARE
+    m DATE;
+BEGIN
+    SELECT COALESCE(MIN(posted_at), NOW()) AT TIME ZONE 'UTC' INTO m FROM journal_entries_old;
+    WHILE m < ((NOW() AT TIME ZONE 'UTC') + INTERVAL '3 months')::DATE LOOP
+        PERFORM create_ledger_partition(m);
+        m := (m - (EXTRACT(DAY FROM m)::INT - 1) + INTERVAL '1 month')::DATE;
+    END LOOP;
+END;
+$$;
+
+INSERT INTO journal_entries (id, entry_type, order_number, order_id, reason, posted_at)
+SELECT id, entry_type, order_number, order_id, reason, posted_at
+FROM journal_entries_old;
+
+INSERT INTO postings (id, entry_id, posted_at, account_id, amount)
+SELECT p.id, p.entry_id, e.posted_at, p.account_id, p.amount
+FROM postings_old p
+JOIN journal_entries_old e ON e.id = p.entry_id;
+
+-- Одно начисление на заказ. Уникальный индекс по order_id на партиционированной таблице
+-- невозможен — ключ партиции должен входить в него, поэтому начисление отмечается здесь,
+-- в той же транзакции, что и запись журнала. Архив месяца эту таблицу не трогает.
+CREATE TABLE accrual_entries (
+    order_id BIGINT PRIMARY KEY REFERENCES orders(id),
+    entry_id BIGINT NOT NULL
+);
+
+INSERT INTO accrual_entries (order_id, entry_id)
+SELECT order_id, id
+FROM journal_entries_old
+WHERE entry_type = 'accrual';
+
+DROP TABLE postings_old;
+DROP TABLE journal_entries_old;
+
+CREATE INDEX idx_journal_entries_order ON journal_entries(order_id) WHERE order_id IS NOT NULL;
+
+CREATE INDEX idx_postings_account ON postings(account_id, entry_id);
+CREATE INDEX idx_postings_entry ON postings(entry_id, posted_at);
+
+CREATE TRIGGER journal_entries_append_only
+    BEFORE UPDATE OR DELETE ON journal_entries
+    FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
+
+CREATE TRIGGER postings_append_only
+    BEFORE UPDATE OR DELETE ON postings
+    FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
+
+CREATE OR REPLACE FUNCTION ledger_entry_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT SUM(amount) FROM postings
+        WHERE entry_id = NEW.entry_id AND posted_at = NEW.posted_at) <> 0 THEN
+        RAISE EXCEPTION 'journal entry % is not balanced', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER postings_balanced
+    AFTER INSERT ON postings
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION ledger_entry_balanced();
+
+-- Архивные месяцы: граница архива — наибольший archived_until
+CREATE TABLE ledger_archive (
+    month DATE PRIMARY KEY,
+    archived_until TIMESTAMPTZ NOT NULL,
+    entries INTEGER NOT NULL,
+    archived_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Входящие остатки счетов: сумма проводок архивных месяцев, withdrawn — списания пользователя
+CREATE TABLE ledger_openings (
+    account_id BIGINT PRIMARY KEY REFERENCES accounts(id),
+    amount DECIMAL(12,2) NOT NULL DEFAULT 0,
+    withdrawn DECIMAL(12,2) NOT NULL DEFAULT 0
+);
+
+-- Начислено по заказу в архивных месяцах и было ли в них само начисление
+ALTER TABLE orders
+    ADD COLUMN archived_credit DECIMAL(12,2) NOT NULL DEFAULT 0,
+    ADD COLUMN accrual_archived BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,11 @@
+// Package migrations миграции схемы, встроенные в бинарник.
+// В корне — PostgreSQL, в sqlite/ — встроенная SQLite.
+package migrations
+
+import "embed"
+
+//go:embed *.sql sqlite/*.sql
+var FS embed.FS
+
+// SQLiteDir каталог миграций SQLite внутри FS
+const SQLiteDir = "sqlite"
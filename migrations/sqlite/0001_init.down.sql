@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS webhook_signatures;
+DROP TABLE IF EXISTS user_balances;
+DROP TABLE IF EXISTS order_status_history;
+DROP TABLE IF EXISTS balance_operations;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,93 @@
+-- Схема SQLite соответствует итоговой схеме PostgreSQL (migrations/0001–0008).
+-- Суммы — INTEGER в копейках, время — INTEGER в микросекундах Unix (UTC).
+
+-- Пользователи
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    login TEXT UNIQUE NOT NULL,
+    password_hash TEXT NOT NULL,
+    is_admin INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL
+);
+
+-- Единая таблица операций по балансу
+CREATE TABLE IF NOT EXISTS balance_operations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    order_number TEXT NOT NULL,
+    amount INTEGER NOT NULL,                -- Положительное — начисление, отрицательное — списание
+    operation_type TEXT NOT NULL CHECK (operation_type IN ('accrual', 'withdrawal', 'adjustment')),
+    status TEXT NOT NULL
+        CHECK (status IN ('NEW', 'PROCESSING', 'INVALID', 'PROCESSED')),
+    processed_at INTEGER NOT NULL,
+    created_at INTEGER NOT NULL,
+
+    -- расписание опроса accrual и аренда заказа экземпляром poller
+    next_poll_at INTEGER NOT NULL,
+    poll_attempts INTEGER NOT NULL DEFAULT 0,
+    last_poll_error TEXT,
+    lease_owner TEXT,
+    lease_until INTEGER,
+
+    -- зависшие заказы
+    stuck_at INTEGER,
+    stuck_reason TEXT,
+    requeued_at INTEGER,
+
+    -- корректировки сверки с accrual
+    adjusts_id INTEGER REFERENCES balance_operations(id) ON DELETE CASCADE,
+    adjust_reason TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_balance_operations_user ON balance_operations(user_id);
+CREATE INDEX IF NOT EXISTS idx_balance_operations_user_status ON balance_operations(user_id, status);
+CREATE INDEX IF NOT EXISTS idx_balance_operations_order ON balance_operations(order_number);
+
+CREATE INDEX IF NOT EXISTS idx_balance_operations_pending
+    ON balance_operations(next_poll_at)
+    WHERE operation_type = 'accrual' AND status IN ('NEW', 'PROCESSING');
+
+CREATE INDEX IF NOT EXISTS idx_balance_operations_stuck
+    ON balance_operations(stuck_at)
+    WHERE stuck_at IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_balance_operations_adjusts
+    ON balance_operations(adjusts_id)
+    WHERE adjusts_id IS NOT NULL;
+
+-- Номер заказа загружается один раз
+CREATE UNIQUE INDEX IF NOT EXISTS idx_balance_operations_accrual_order
+    ON balance_operations(order_number)
+    WHERE operation_type = 'accrual';
+
+-- История переходов статусов заказов
+CREATE TABLE IF NOT EXISTS order_status_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    operation_id INTEGER NOT NULL REFERENCES balance_operations(id) ON DELETE CASCADE,
+    order_number TEXT NOT NULL,
+    from_status TEXT                        -- NULL для первой записи (загрузка заказа)
+        CHECK (from_status IN ('NEW', 'PROCESSING', 'INVALID', 'PROCESSED')),
+    to_status TEXT NOT NULL
+        CHECK (to_status IN ('NEW', 'PROCESSING', 'INVALID', 'PROCESSED')),
+    changed_at INTEGER NOT NULL,
+    reason TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_status_history_order ON order_status_history(order_number, changed_at);
+
+-- Баланс пользователя, обновляется в одной транзакции с каждой операцией по балансу
+CREATE TABLE IF NOT EXISTS user_balances (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    current INTEGER NOT NULL DEFAULT 0,
+    withdrawn INTEGER NOT NULL DEFAULT 0,
+    version INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL
+);
+
+-- Подписи принятых push-уведомлений accrual, как в migrations/0004_webhook_signatures.up.sql
+CREATE TABLE IF NOT EXISTS webhook_signatures (
+    signature TEXT PRIMARY KEY,
+    expires_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_signatures_expires ON webhook_signatures(expires_at);
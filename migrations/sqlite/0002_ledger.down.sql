@@ -0,0 +1,87 @@
+-- Обратно в единую таблицу balance_operations, как в migrations/0010_ledger.down.sql
+DROP TRIGGER IF EXISTS journal_entries_no_update;
+DROP TRIGGER IF EXISTS journal_entries_no_delete;
+DROP TRIGGER IF EXISTS postings_no_update;
+DROP TRIGGER IF EXISTS postings_no_delete;
+
+CREATE TABLE balance_operations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    order_number TEXT NOT NULL,
+    amount INTEGER NOT NULL,
+    operation_type TEXT NOT NULL CHECK (operation_type IN ('accrual', 'withdrawal', 'adjustment')),
+    status TEXT NOT NULL
+        CHECK (status IN ('NEW', 'PROCESSING', 'INVALID', 'PROCESSED')),
+    processed_at INTEGER NOT NULL,
+    created_at INTEGER NOT NULL,
+    next_poll_at INTEGER NOT NULL,
+    poll_attempts INTEGER NOT NULL DEFAULT 0,
+    last_poll_error TEXT,
+    lease_owner TEXT,
+    lease_until INTEGER,
+    stuck_at INTEGER,
+    stuck_reason TEXT,
+    requeued_at INTEGER,
+    adjusts_id INTEGER REFERENCES balance_operations(id) ON DELETE CASCADE,
+    adjust_reason TEXT
+);
+
+INSERT INTO balance_operations (id, user_id, order_number, amount, operation_type, status, processed_at,
+    created_at, next_poll_at, poll_attempts, last_poll_error, lease_owner, lease_until,
+    stuck_at, stuck_reason, requeued_at)
+SELECT id, user_id, order_number, accrual, 'accrual', status, processed_at,
+    created_at, next_poll_at, poll_attempts, last_poll_error, lease_owner, lease_until,
+    stuck_at, stuck_reason, requeued_at
+FROM orders;
+
+INSERT INTO balance_operations (id, user_id, order_number, amount, operation_type, status, processed_at,
+    created_at, next_poll_at, adjusts_id, adjust_reason)
+SELECT e.id, a.user_id, e.order_number, p.amount, e.entry_type, 'PROCESSED', e.posted_at,
+    e.posted_at, e.posted_at, e.order_id, e.reason
+FROM journal_entries e
+JOIN postings p ON p.entry_id = e.id
+JOIN accounts a ON a.id = p.account_id AND a.user_id IS NOT NULL
+WHERE e.entry_type IN ('withdrawal', 'adjustment');
+
+CREATE INDEX idx_balance_operations_user ON balance_operations(user_id);
+CREATE INDEX idx_balance_operations_user_status ON balance_operations(user_id, status);
+CREATE INDEX idx_balance_operations_order ON balance_operations(order_number);
+CREATE INDEX idx_balance_operations_pending
+    ON balance_operations(next_poll_at)
+    WHERE operation_type = 'accrual' AND status IN ('NEW', 'PROCESSING');
+CREATE INDEX idx_balance_operations_stuck
+    ON balance_operations(stuck_at)
+    WHERE stuck_at IS NOT NULL;
+CREATE INDEX idx_balance_operations_adjusts
+    ON balance_operations(adjusts_id)
+    WHERE adjusts_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_balance_operations_accrual_order
+    ON balance_operations(order_number)
+    WHERE operation_type = 'accrual';
+
+CREATE TABLE order_status_history_old (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    operation_id INTEGER NOT NULL REFERENCES balance_operations(id) ON DELETE CASCADE,
+    order_number TEXT NOT NULL,
+    from_status TEXT
+        CHECK (from_status IN ('NEW', 'PROCESSING', 'INVALID', 'PROCESSED')),
+    to_status TEXT NOT NULL
+        CHECK (to_status IN ('NEW', 'PROCESSING', 'INVALID', 'PROCESSED')),
+    changed_at INTEGER NOT NULL,
+    reason TEXT
+);
+
+INSERT INTO order_status_history_old (id, operation_id, order_number, from_status, to_status, changed_at, reason)
+SELECT id, order_id, order_number, from_status, to_status, changed_at, reason
+FROM order_status_history;
+
+DROP TABLE order_status_history;
+ALTER TABLE order_status_history_old RENAME TO order_status_history;
+
+CREATE INDEX idx_order_status_history_order ON order_status_history(order_number, changed_at);
+
+DROP TABLE postings;
+DROP TABLE journal_entries;
+DROP TABLE accounts;
+DROP TABLE orders;
+DROP TABLE operation_ids;
@@ -0,0 +1,158 @@
+-- Заказы отдельно от движения баллов, баллы — в журнале двойной записи,
+-- как в migrations/0010_ledger.up.sql
+
+-- Общий счётчик id заказов и записей журнала вместо последовательности operation_id_seq
+CREATE TABLE operation_ids (
+    last INTEGER NOT NULL
+);
+INSERT INTO operation_ids (last) SELECT COALESCE(MAX(id), 0) FROM balance_operations;
+
+CREATE TABLE orders (
+    id INTEGER PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    order_number TEXT NOT NULL UNIQUE,
+    status TEXT NOT NULL
+        CHECK (status IN ('NEW', 'PROCESSING', 'INVALID', 'PROCESSED')),
+    accrual INTEGER NOT NULL DEFAULT 0,     -- по данным accrual; в балансе — проводками журнала
+    processed_at INTEGER NOT NULL,
+    created_at INTEGER NOT NULL,
+
+    -- расписание опроса accrual и аренда заказа экземпляром poller
+    next_poll_at INTEGER NOT NULL,
+    poll_attempts INTEGER NOT NULL DEFAULT 0,
+    last_poll_error TEXT,
+    lease_owner TEXT,
+    lease_until INTEGER,
+
+    -- зависшие заказы
+    stuck_at INTEGER,
+    stuck_reason TEXT,
+    requeued_at INTEGER
+);
+
+INSERT INTO orders (id, user_id, order_number, status, accrual, processed_at, created_at,
+    next_poll_at, poll_attempts, last_poll_error, lease_owner, lease_until,
+    stuck_at, stuck_reason, requeued_at)
+SELECT id, user_id, order_number, status, amount, processed_at, created_at,
+    next_poll_at, poll_attempts, last_poll_error, lease_owner, lease_until,
+    stuck_at, stuck_reason, requeued_at
+FROM balance_operations
+WHERE operation_type = 'accrual';
+
+-- список заказов листается по времени загрузки: processed_at меняется с каждым статусом
+CREATE INDEX idx_orders_user ON orders(user_id, created_at, id);
+
+CREATE INDEX idx_orders_pending
+    ON orders(next_poll_at)
+    WHERE status IN ('NEW', 'PROCESSING');
+
+CREATE INDEX idx_orders_stuck
+    ON orders(stuck_at)
+    WHERE stuck_at IS NOT NULL;
+
+-- История статусов ссылается на заказ: внешний ключ в SQLite меняется только пересозданием
+CREATE TABLE order_status_history_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    order_number TEXT NOT NULL,
+    from_status TEXT
+        CHECK (from_status IN ('NEW', 'PROCESSING', 'INVALID', 'PROCESSED')),
+    to_status TEXT NOT NULL
+        CHECK (to_status IN ('NEW', 'PROCESSING', 'INVALID', 'PROCESSED')),
+    changed_at INTEGER NOT NULL,
+    reason TEXT
+);
+
+INSERT INTO order_status_history_new (id, order_id, order_number, from_status, to_status, changed_at, reason)
+SELECT id, operation_id, order_number, from_status, to_status, changed_at, reason
+FROM order_status_history;
+
+DROP TABLE order_status_history;
+ALTER TABLE order_status_history_new RENAME TO order_status_history;
+
+CREATE INDEX idx_order_status_history_order ON order_status_history(order_number, changed_at);
+
+-- Счета: по одному на пользователя и системные по коду
+CREATE TABLE accounts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER UNIQUE REFERENCES users(id),
+    code TEXT UNIQUE,
+    CHECK ((user_id IS NULL) <> (code IS NULL))
+);
+
+INSERT INTO accounts (code) VALUES ('accrual_source'), ('redemption_sink');
+INSERT INTO accounts (user_id) SELECT id FROM users;
+
+CREATE TABLE journal_entries (
+    id INTEGER PRIMARY KEY,
+    entry_type TEXT NOT NULL CHECK (entry_type IN ('accrual', 'withdrawal', 'adjustment')),
+    order_number TEXT NOT NULL,
+    order_id INTEGER REFERENCES orders(id),     -- начисление и корректировки по заказу
+    reason TEXT,                                -- причина корректировки
+    posted_at INTEGER NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_journal_entries_accrual ON journal_entries(order_id) WHERE entry_type = 'accrual';
+CREATE INDEX idx_journal_entries_order ON journal_entries(order_id) WHERE order_id IS NOT NULL;
+
+CREATE TABLE postings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    entry_id INTEGER NOT NULL REFERENCES journal_entries(id),
+    account_id INTEGER NOT NULL REFERENCES accounts(id),
+    amount INTEGER NOT NULL CHECK (amount <> 0)  -- со знаком: + на счёт, - со счёта
+);
+
+CREATE INDEX idx_postings_account ON postings(account_id, entry_id);
+CREATE INDEX idx_postings_entry ON postings(entry_id);
+
+-- Списания и корректировки сохраняют id, начисления рассчитанных заказов получают новые
+INSERT INTO journal_entries (id, entry_type, order_number, order_id, reason, posted_at)
+SELECT id, operation_type, order_number, adjusts_id, adjust_reason, processed_at
+FROM balance_operations
+WHERE operation_type IN ('withdrawal', 'adjustment') AND status = 'PROCESSED' AND amount <> 0;
+
+INSERT INTO journal_entries (id, entry_type, order_number, order_id, posted_at)
+SELECT (SELECT last FROM operation_ids) + ROW_NUMBER() OVER (ORDER BY id), 'accrual', order_number, id, processed_at
+FROM orders
+WHERE status = 'PROCESSED' AND accrual <> 0;
+
+UPDATE operation_ids SET last = MAX(last, COALESCE((SELECT MAX(id) FROM journal_entries), 0));
+
+WITH moves AS (
+    SELECT e.id AS entry_id, o.user_id, o.accrual AS amount, 'accrual_source' AS counter
+    FROM journal_entries e
+    JOIN orders o ON o.id = e.order_id
+    WHERE e.entry_type = 'accrual'
+    UNION ALL
+    SELECT e.id, b.user_id, b.amount,
+        CASE e.entry_type WHEN 'withdrawal' THEN 'redemption_sink' ELSE 'accrual_source' END
+    FROM journal_entries e
+    JOIN balance_operations b ON b.id = e.id
+)
+INSERT INTO postings (entry_id, account_id, amount)
+SELECT m.entry_id, a.id, m.amount FROM moves m JOIN accounts a ON a.user_id = m.user_id
+UNION ALL
+SELECT m.entry_id, a.id, -m.amount FROM moves m JOIN accounts a ON a.code = m.counter;
+
+DROP TABLE balance_operations;
+
+-- Журнал только дополняется
+CREATE TRIGGER journal_entries_no_update BEFORE UPDATE ON journal_entries
+BEGIN
+    SELECT RAISE(ABORT, 'ledger is append-only');
+END;
+
+CREATE TRIGGER journal_entries_no_delete BEFORE DELETE ON journal_entries
+BEGIN
+    SELECT RAISE(ABORT, 'ledger is append-only');
+END;
+
+CREATE TRIGGER postings_no_update BEFORE UPDATE ON postings
+BEGIN
+    SELECT RAISE(ABORT, 'ledger is append-only');
+END;
+
+CREATE TRIGGER postings_no_delete BEFORE DELETE ON postings
+BEGIN
+    SELECT RAISE(ABORT, 'ledger is append-only');
+END;
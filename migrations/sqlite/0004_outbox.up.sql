@@ -0,0 +1,14 @@
+-- Outbox доменных событий, как в migrations/0012_outbox.up.sql.
+-- Запись в файл идёт по очереди, поэтому id и так растут в порядке коммитов.
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    created_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS outbox_offsets (
+    sink TEXT PRIMARY KEY,
+    last_event_id INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL
+);
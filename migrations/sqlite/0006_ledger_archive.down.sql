@@ -0,0 +1,27 @@
+-- Архивные месяцы удалены из журнала, без них балансы не пересчитать:
+-- непустой ledger_archive останавливает откат на проверке CHECK
+CREATE TEMP TABLE ledger_archive_empty (archived INTEGER CHECK (archived = 0));
+INSERT INTO ledger_archive_empty SELECT COUNT(*) FROM ledger_archive;
+DROP TABLE ledger_archive_empty;
+
+DROP TRIGGER journal_entries_no_delete;
+DROP TRIGGER postings_no_delete;
+
+CREATE TRIGGER journal_entries_no_delete BEFORE DELETE ON journal_entries
+BEGIN
+    SELECT RAISE(ABORT, 'ledger is append-only');
+END;
+
+CREATE TRIGGER postings_no_delete BEFORE DELETE ON postings
+BEGIN
+    SELECT RAISE(ABORT, 'ledger is append-only');
+END;
+
+DROP TABLE accrual_entries;
+DROP INDEX idx_journal_entries_posted;
+
+ALTER TABLE orders DROP COLUMN accrual_archived;
+ALTER TABLE orders DROP COLUMN archived_credit;
+
+DROP TABLE ledger_openings;
+DROP TABLE ledger_archive;
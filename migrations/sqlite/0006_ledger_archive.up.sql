@@ -0,0 +1,50 @@
+-- Архив журнала, как в migrations/0014_ledger_partitions.up.sql, но без партиций:
+-- записи архивного месяца удаляются из journal_entries и postings
+
+CREATE TABLE ledger_archive (
+    month INTEGER PRIMARY KEY,                  -- начало месяца (UTC)
+    archived_until INTEGER NOT NULL,
+    entries INTEGER NOT NULL,
+    archived_at INTEGER NOT NULL
+);
+
+CREATE TABLE ledger_openings (
+    account_id INTEGER PRIMARY KEY REFERENCES accounts(id),
+    amount INTEGER NOT NULL DEFAULT 0,
+    withdrawn INTEGER NOT NULL DEFAULT 0
+);
+
+ALTER TABLE orders ADD COLUMN archived_credit INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN accrual_archived INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX idx_journal_entries_posted ON journal_entries(posted_at);
+
+-- Одно начисление на заказ: idx_journal_entries_accrual его не удержит после удаления
+-- архивного месяца, поэтому начисление отмечается ещё и здесь, архив её не трогает
+CREATE TABLE accrual_entries (
+    order_id INTEGER PRIMARY KEY REFERENCES orders(id),
+    entry_id INTEGER NOT NULL
+);
+
+INSERT INTO accrual_entries (order_id, entry_id)
+SELECT order_id, id FROM journal_entries WHERE entry_type = 'accrual';
+
+-- Журнал только дополняется; удалять можно только записи архивных месяцев
+DROP TRIGGER journal_entries_no_delete;
+DROP TRIGGER postings_no_delete;
+
+CREATE TRIGGER journal_entries_no_delete BEFORE DELETE ON journal_entries
+WHEN NOT EXISTS (SELECT 1 FROM ledger_archive WHERE archived_until > OLD.posted_at)
+BEGIN
+    SELECT RAISE(ABORT, 'ledger is append-only');
+END;
+
+CREATE TRIGGER postings_no_delete BEFORE DELETE ON postings
+WHEN NOT EXISTS (
+    SELECT 1 FROM journal_entries e
+    JOIN ledger_archive la ON la.archived_until > e.posted_at
+    WHERE e.id = OLD.entry_id
+)
+BEGIN
+    SELECT RAISE(ABORT, 'ledger is append-only');
+END;